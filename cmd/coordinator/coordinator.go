@@ -0,0 +1,139 @@
+// Coordinator runs load test distributed across many stream-tester workers
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/stream-tester/internal/distributed"
+	"github.com/livepeer/stream-tester/model"
+	"github.com/peterbourgon/ff/v2"
+)
+
+func main() {
+	flag.Set("logtostderr", "true")
+	vFlag := flag.Lookup("v")
+
+	fs := flag.NewFlagSet("coordinator", flag.ExitOnError)
+
+	verbosity := fs.Int("v", 3, "Log verbosity.  {4|5|6}")
+	version := fs.Bool("version", false, "Print out the version")
+	workers := fs.String("workers", "", "Comma-separated list of workers' base URLs (http://10.0.0.1:7934,http://10.0.0.2:7934)")
+	mode := fs.String("mode", "agent", "Workers mode: 'agent' - use /agent endpoints (load tester), 'streamer' - use /start_streams endpoint")
+	syncDelay := fs.Duration("sync-delay", 5*time.Second, "Delay before workers start streaming, so they all start in sync")
+	pollInterval := fs.Duration("poll", 5*time.Second, "How often to poll workers for stats")
+	sim := fs.Uint("sim", 1, "Number of simultaneous streams to stream, split between workers")
+	fileName := fs.String("file", "bbb_sunflower_1080p_30fps_normal_t02.mp4", "File to stream (should exist on workers)")
+	// agent mode
+	rtmpTemplate := fs.String("rtmp-template", "", "Template of RTMP ingest URL (agent mode)")
+	hlsTemplate := fs.String("hls-template", "", "Template of HLS playback URL (agent mode)")
+	loadProfile := fs.String("load-profile", "", "Load profile each worker should follow instead of constant number of streams (agent mode)")
+	mistMode := fs.Bool("mist", false, "Mist mode (remove session query) (agent mode)")
+	testDuration := fs.Duration("test-dur", 0, "How long to run overall test (agent mode)")
+	streamDuration := fs.Duration("stream-dur", 0, "How long to stream each stream (0 to stream whole file)")
+	waitForTarget := fs.Duration("wait-for-target", 30*time.Second, "How long to wait for a new stream to appear before giving up (agent mode)")
+	delayBetweenStreams := fs.Duration("delay-between-streams", 2*time.Second, "Delay between starting streams (agent mode)")
+	// streamer mode
+	host := fs.String("host", "localhost", "Broadcaster's host name (streamer mode)")
+	mediaHost := fs.String("media-host", "", "Host name to read transcoded segments back from (streamer mode)")
+	rtmp := fs.Uint("rtmp", 1935, "RTMP port number (streamer mode)")
+	media := fs.Uint("media", 8935, "Media port number (streamer mode)")
+	profiles := fs.Int("profiles", 2, "Number of transcoded profiles should be in output (streamer mode)")
+	repeat := fs.Uint("repeat", 1, "Number of times to repeat streaming (streamer mode)")
+	latency := fs.Bool("latency", false, "Measure latency (streamer mode)")
+	httpIngest := fs.Bool("http-ingest", false, "Use HTTP push instead of RTMP (streamer mode)")
+	_ = fs.String("config", "", "config file (optional)")
+
+	ff.Parse(fs, os.Args[1:],
+		ff.WithConfigFileFlag("config"),
+		ff.WithConfigFileParser(ff.PlainParser),
+		ff.WithEnvVarPrefix("COORDINATOR"),
+	)
+	flag.CommandLine.Parse(nil)
+	vFlag.Value.Set(fmt.Sprintf("%d", *verbosity))
+
+	hostName, _ := os.Hostname()
+	fmt.Println("Coordinator version: " + model.Version)
+	fmt.Printf("Compiler version: %s %s\n", runtime.Compiler, runtime.Version())
+	if *version {
+		return
+	}
+	if *workers == "" {
+		glog.Fatal("-workers should be specified")
+	}
+	coordinator := distributed.NewCoordinator(strings.Split(*workers, ","), *pollInterval)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	exitc := make(chan os.Signal, 1)
+	signal.Notify(exitc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt, os.Kill)
+	go func() {
+		<-exitc
+		fmt.Println("Got Ctrl-C, cancelling")
+		cancel()
+	}()
+
+	switch *mode {
+	case "agent":
+		if *rtmpTemplate == "" || *hlsTemplate == "" {
+			glog.Fatal("-rtmp-template and -hls-template should be specified")
+		}
+		if *testDuration == 0 {
+			glog.Fatal("-test-dur should be specified")
+		}
+		req := distributed.StartReq{
+			RTMPTemplate:        *rtmpTemplate,
+			HLSTemplate:         *hlsTemplate,
+			FileName:            *fileName,
+			BaseName:            strings.ReplaceAll(hostName, ".", "_"),
+			Sim:                 int(*sim),
+			LoadProfile:         *loadProfile,
+			TestDuration:        testDuration.String(),
+			StreamDuration:      streamDuration.String(),
+			WaitForTarget:       waitForTarget.String(),
+			DelayBetweenStreams: delayBetweenStreams.String(),
+			MistMode:            *mistMode,
+		}
+		report, err := coordinator.RunAgents(ctx, req, *syncDelay)
+		if report != nil {
+			fmt.Println(report.FormatForConsole())
+		}
+		if err != nil {
+			glog.Errorf("Error running distributed load test: %v", err)
+			os.Exit(model.ExitCodeAPIError)
+		}
+	case "streamer":
+		req := model.StartStreamsReq{
+			FileName:       *fileName,
+			Host:           *host,
+			MHost:          *mediaHost,
+			RTMP:           uint16(*rtmp),
+			Media:          uint16(*media),
+			Repeat:         *repeat,
+			Simultaneous:   *sim,
+			ProfilesNum:    *profiles,
+			MeasureLatency: *latency,
+			HTTPIngest:     *httpIngest,
+		}
+		if *streamDuration > 0 {
+			req.Time = streamDuration.String()
+		}
+		report, err := coordinator.RunStreamers(ctx, req, *syncDelay)
+		if report != nil && report.Stats != nil {
+			fmt.Println(report.FormatForConsole())
+		}
+		if err != nil {
+			glog.Errorf("Error running distributed load test: %v", err)
+			os.Exit(model.ExitCodeAPIError)
+		}
+	default:
+		glog.Fatalf("Unknown mode %q", *mode)
+	}
+}
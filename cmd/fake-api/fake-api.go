@@ -0,0 +1,87 @@
+// fake-api runs in-process stand-in for the Livepeer API, along with the mock
+// broadcaster, to run record and VOD testers against local stack
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/stream-tester/internal/fakeapi"
+	"github.com/livepeer/stream-tester/internal/mockbroadcaster"
+	"github.com/livepeer/stream-tester/model"
+	"github.com/peterbourgon/ff"
+)
+
+func main() {
+	flag.Set("logtostderr", "true")
+	vFlag := flag.Lookup("v")
+
+	fs := flag.NewFlagSet("fake-api", flag.ExitOnError)
+
+	verbosity := fs.String("v", "", "Log verbosity.  {4|5|6}")
+	version := fs.Bool("version", false, "Print out the version")
+	addr := fs.String("addr", "localhost:3004", "Address to serve the API on")
+	token := fs.String("api-token", "", "Token the API requests should be authorized with. Any request is accepted if empty")
+	broadcaster := fs.Bool("broadcaster", true, "Start mock broadcaster, which streams are published to")
+	rtmpAddr := fs.String("rtmp-addr", "localhost:1935", "Address of the mock broadcaster to accept RTMP streams on")
+	httpAddr := fs.String("http-addr", "localhost:8935", "Address of the mock broadcaster to serve HLS and accept HTTP push on")
+	script := fs.String("script", "", "Faults to inject into the mock broadcaster (see mock-broadcaster)")
+	taskDuration := fs.Duration("task-duration", 2*time.Second, "Minimal time asset tasks take")
+	recordingReadyAfter := fs.Duration("recording-ready-after", 5*time.Minute, "Time after the end of the session recording becomes ready")
+	_ = fs.String("config", "", "config file (optional)")
+
+	ff.Parse(fs, os.Args[1:],
+		ff.WithConfigFileFlag("config"),
+		ff.WithConfigFileParser(ff.PlainParser),
+		ff.WithEnvVarPrefix("FAKE_API"),
+	)
+	flag.CommandLine.Parse(nil)
+	vFlag.Value.Set(*verbosity)
+
+	if *version {
+		fmt.Println("Fake API version: " + model.Version)
+		fmt.Printf("Compiler version: %s %s\n", runtime.Compiler, runtime.Version())
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	exitc := make(chan os.Signal, 1)
+	signal.Notify(exitc, syscall.SIGINT, syscall.SIGTERM)
+	opts := fakeapi.Options{
+		Addr:                *addr,
+		Token:               *token,
+		TaskDuration:        *taskDuration,
+		RecordingReadyAfter: *recordingReadyAfter,
+	}
+	if *broadcaster {
+		bopts := mockbroadcaster.Options{RTMPAddr: *rtmpAddr, HTTPAddr: *httpAddr}
+		var err error
+		if bopts.Script, err = mockbroadcaster.ParseScript(*script); err != nil {
+			glog.Fatal(err)
+		}
+		opts.Broadcaster = mockbroadcaster.New(bopts)
+	}
+	// hooks are set to the broadcaster before it is started
+	s := fakeapi.New(opts)
+	if opts.Broadcaster != nil {
+		if err := opts.Broadcaster.Start(ctx); err != nil {
+			glog.Fatal(err)
+		}
+	}
+	if err := s.Start(ctx); err != nil {
+		glog.Fatal(err)
+	}
+	fmt.Printf("API: %s\n", s.URL())
+	if opts.Broadcaster != nil {
+		fmt.Printf("RTMP: rtmp://%s/live/<streamKey>\n", opts.Broadcaster.RTMPAddr())
+		fmt.Printf("HLS:  %s\n", opts.Broadcaster.MediaURL(mockbroadcaster.LayoutMist, "<playbackID>"))
+	}
+	<-exitc
+	cancel()
+}
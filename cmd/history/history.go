@@ -0,0 +1,192 @@
+// History lists test runs saved by the testers and plots trends
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/livepeer/stream-tester/internal/history"
+	"github.com/peterbourgon/ff/v2"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+var errRegression = errors.New("regression found")
+
+func main() {
+	flag.Set("logtostderr", "true")
+
+	rootFlags := flag.NewFlagSet("history", flag.ExitOnError)
+	dir := rootFlags.String("history-dir", "history", "Directory of the test runs history")
+
+	var store *history.Store
+	open := func() error {
+		var err error
+		store, err = history.Open(*dir)
+		return err
+	}
+	queryFlags := func(fs *flag.FlagSet) func() history.Query {
+		tool := fs.String("tool", "", "Only runs of this tool (recordtester, loadtester, ...)")
+		name := fs.String("name", "", "Only runs which name contains this string")
+		days := fs.Int("days", 7, "Only runs of that many last days")
+		return func() history.Query {
+			q := history.Query{Tool: *tool, Name: *name}
+			if *days > 0 {
+				q.Since = time.Now().Add(-time.Duration(*days) * 24 * time.Hour)
+			}
+			return q
+		}
+	}
+
+	listFlags := flag.NewFlagSet("list", flag.ExitOnError)
+	listQuery := queryFlags(listFlags)
+	failed := listFlags.Bool("failed", false, "Only failed runs")
+	limit := listFlags.Int("limit", 50, "Show only that many latest runs (0 for all)")
+	asJSON := listFlags.Bool("json", false, "Output runs as JSON")
+	list := &ffcli.Command{
+		Name:       "list",
+		ShortUsage: "history list [flags]",
+		ShortHelp:  "List test runs",
+		FlagSet:    listFlags,
+		Exec: func(ctx context.Context, args []string) error {
+			if err := open(); err != nil {
+				return err
+			}
+			q := listQuery()
+			q.OnlyFailed = *failed
+			q.Limit = *limit
+			recs, err := store.Query(q)
+			if err != nil {
+				return err
+			}
+			if *asJSON {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(recs)
+			}
+			fmt.Println(history.FormatForConsole(recs))
+			return nil
+		},
+	}
+
+	trendFlags := flag.NewFlagSet("trend", flag.ExitOnError)
+	trendQuery := queryFlags(trendFlags)
+	metric := trendFlags.String("metric", history.MetricSuccessRate, "Metric to plot: success_rate, latency_p50, latency_p95 or failed_runs")
+	bucket := trendFlags.Duration("bucket", 24*time.Hour, "Aggregate runs over that period")
+	width := trendFlags.Int("width", 50, "Width of the chart")
+	trend := &ffcli.Command{
+		Name:       "trend",
+		ShortUsage: "history trend [flags]",
+		ShortHelp:  "Plot trend of the metric over time",
+		FlagSet:    trendFlags,
+		Exec: func(ctx context.Context, args []string) error {
+			if err := open(); err != nil {
+				return err
+			}
+			q := trendQuery()
+			recs, err := store.Query(q)
+			if err != nil {
+				return err
+			}
+			if len(recs) == 0 {
+				return errors.New("no runs found")
+			}
+			chart, err := history.FormatTrend(history.Trend(recs, q.Since, *bucket), *metric, *width)
+			if err != nil {
+				return err
+			}
+			fmt.Println(chart)
+			return nil
+		},
+	}
+
+	compareFlags := flag.NewFlagSet("compare", flag.ExitOnError)
+	compareTool := compareFlags.String("tool", "", "Tool of the run to use as latest")
+	compareName := compareFlags.String("name", "", "Name of the run to use as latest")
+	successTol := compareFlags.Float64("success-tolerance", history.DefaultTolerance.SuccessRate, "Allowed drop of the success rate, percentage points")
+	latencyTol := compareFlags.Float64("latency-tolerance", history.DefaultTolerance.Latency, "Allowed relative increase of the latencies (0.1 is 10%)")
+	latencyMin := compareFlags.Duration("latency-min", history.DefaultTolerance.LatencyMin, "Increase of the latency smaller than that is never a regression")
+	errorTol := compareFlags.Float64("error-tolerance", history.DefaultTolerance.ErrorRate, "Allowed increase of the errors of each category, percent of segments")
+	confidence := compareFlags.Float64("confidence", history.DefaultTolerance.Confidence, "z-score change of the rates should exceed to be a regression (0 to not check significance)")
+	compareJSON := compareFlags.Bool("json", false, "Output comparison as JSON")
+	compare := &ffcli.Command{
+		Name:       "compare",
+		ShortUsage: "history compare [flags] <baseline> <current>",
+		ShortHelp:  "Compare run with the baseline, exit with non-zero code on regression",
+		LongHelp: "Runs are specified by id in the history, by path to JSON file with the history record or\n" +
+			"streamer's stats (as returned by /stats endpoint), or as `latest` for the latest run\n" +
+			"matching -tool and -name. Exit code is 2 if regression is found.",
+		FlagSet: compareFlags,
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) != 2 {
+				return errors.New("baseline and current runs should be specified")
+			}
+			if err := open(); err != nil {
+				return err
+			}
+			var runs []*history.Record
+			for _, ref := range args {
+				if ref == "latest" {
+					recs, err := store.Query(history.Query{Tool: *compareTool, Name: *compareName, Limit: 1})
+					if err != nil {
+						return err
+					}
+					if len(recs) == 0 {
+						return errors.New("no runs found")
+					}
+					runs = append(runs, recs[0])
+					continue
+				}
+				r, err := history.Load(store, ref)
+				if err != nil {
+					return err
+				}
+				runs = append(runs, r)
+			}
+			tol := history.Tolerance{
+				SuccessRate: *successTol,
+				Latency:     *latencyTol,
+				LatencyMin:  *latencyMin,
+				ErrorRate:   *errorTol,
+				Confidence:  *confidence,
+			}
+			c := history.Compare(runs[0], runs[1], tol)
+			if *compareJSON {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(c); err != nil {
+					return err
+				}
+			} else {
+				fmt.Println(c.FormatForConsole())
+			}
+			if c.Regressed() {
+				return errRegression
+			}
+			return nil
+		},
+	}
+
+	root := &ffcli.Command{
+		ShortUsage:  "history [-history-dir dir] <subcommand> [flags]",
+		FlagSet:     rootFlags,
+		Options:     []ff.Option{ff.WithEnvVarPrefix("HISTORY")},
+		Subcommands: []*ffcli.Command{list, trend, compare},
+		Exec: func(ctx context.Context, args []string) error {
+			return flag.ErrHelp
+		},
+	}
+	if err := root.ParseAndRun(context.Background(), os.Args[1:]); err != nil {
+		if err == errRegression {
+			os.Exit(2)
+		}
+		if err != flag.ErrHelp {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,72 @@
+// hls-replay serves HLS archive recorded with -capture-dir flag of the testers,
+// with the original timing, so the same verifier can be run against it offline
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/stream-tester/internal/hlsarchive"
+	"github.com/livepeer/stream-tester/model"
+	"github.com/peterbourgon/ff"
+)
+
+func main() {
+	flag.Set("logtostderr", "true")
+	vFlag := flag.Lookup("v")
+
+	fs := flag.NewFlagSet("hls-replay", flag.ExitOnError)
+
+	verbosity := fs.String("v", "", "Log verbosity.  {4|5|6}")
+	version := fs.Bool("version", false, "Print out the version")
+	dir := fs.String("dir", "", "Directory with the recorded archive")
+	addr := fs.String("addr", "localhost:8935", "Address to serve archive on")
+	speed := fs.Float64("speed", 1, "Replay speed. 2 replays twice as fast as recorded")
+	delay := fs.Bool("delay", true, "Delay responses by the time fetches took during recording")
+	_ = fs.String("config", "", "config file (optional)")
+
+	ff.Parse(fs, os.Args[1:],
+		ff.WithConfigFileFlag("config"),
+		ff.WithConfigFileParser(ff.PlainParser),
+		ff.WithEnvVarPrefix("HLS_REPLAY"),
+	)
+	flag.CommandLine.Parse(nil)
+	vFlag.Value.Set(*verbosity)
+
+	if *version {
+		fmt.Println("HLS replay version: " + model.Version)
+		fmt.Printf("Compiler version: %s %s\n", runtime.Compiler, runtime.Version())
+		return
+	}
+	if *dir == "" {
+		glog.Fatal("-dir should be specified")
+	}
+	archive, err := hlsarchive.Open(*dir)
+	if err != nil {
+		glog.Fatalf("Error opening archive dir=%s err=%v", *dir, err)
+	}
+	fmt.Printf("Archive %s: %d fetches recorded during %s\n", *dir, len(archive.Entries), archive.Duration())
+	host := *addr
+	if strings.HasPrefix(host, ":") {
+		host = "localhost" + host
+	}
+	for _, u := range archive.Playlists() {
+		fmt.Printf("  http://%s%s (recorded from %s)\n", host, hlsarchive.Key(u), u)
+	}
+	server := hlsarchive.NewServer(archive, *speed, *delay)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_replay/reset", func(w http.ResponseWriter, r *http.Request) {
+		server.Reset()
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.Handle("/", server)
+	glog.Infof("Replaying archive on http://%s", host)
+	if err := http.ListenAndServe(*addr, mux); err != nil {
+		glog.Fatal(err)
+	}
+}
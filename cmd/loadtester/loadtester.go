@@ -17,8 +17,15 @@ import (
 	"github.com/golang/glog"
 	"github.com/livepeer/joy4/format"
 	"github.com/livepeer/stream-tester/apis/livepeer"
+	"github.com/livepeer/stream-tester/internal/assertions"
+	"github.com/livepeer/stream-tester/internal/events"
+	"github.com/livepeer/stream-tester/internal/history"
 	"github.com/livepeer/stream-tester/internal/metrics"
+	"github.com/livepeer/stream-tester/internal/report"
+	"github.com/livepeer/stream-tester/internal/scenario"
+	"github.com/livepeer/stream-tester/internal/selfmon"
 	"github.com/livepeer/stream-tester/internal/testers"
+	"github.com/livepeer/stream-tester/internal/tracing"
 	"github.com/livepeer/stream-tester/internal/utils"
 	"github.com/livepeer/stream-tester/model"
 	"github.com/peterbourgon/ff/v2"
@@ -35,6 +42,10 @@ type cliArguments struct {
 	APIServer    string
 	APIToken     string
 	Filename     string
+	Scenario     string
+	LoadProfile  string
+	Chaos        string
+	HistoryDir   string
 
 	StreamDuration        time.Duration
 	TestDuration          time.Duration
@@ -58,6 +69,9 @@ func main() {
 	fs.BoolVar(&cliFlags.Version, "version", false, "Print out the version")
 	fs.BoolVar(&cliFlags.MistMode, "mist", false, "Mist mode (remove session query)")
 	fs.BoolVar(&cliFlags.HTTPIngest, "http-ingest", false, "Use Livepeer HTTP HLS ingest")
+	fs.BoolVar(&testers.EmbedTimestamps, "sei-timestamps", true, "Embed wall-clock timestamps into key frames (SEI) to measure latency on renditions keeping them")
+	fs.BoolVar(&testers.FailureBundles, "failure-bundles", false, "Save bundle of playlists, segments, stats and config for every failure and link it in the alerts")
+	fs.StringVar(&testers.BundleDir, "bundle-dir", testers.BundleDir, "Directory to save failure bundles to if Google Storage or Azure is not configured")
 
 	// startDelay := fs.Duration("start-delay", 0*time.Second, "time delay before start")
 	fs.DurationVar(&cliFlags.StreamDuration, "stream-dur", 0, "How long to stream each stream (0 to stream whole file)")
@@ -72,6 +86,15 @@ func main() {
 	fs.StringVar(&cliFlags.APIServer, "api-server", "livepeer.com", "Server of the Livepeer API to be used")
 	fs.StringVar(&cliFlags.RTMPTemplate, "rtmp-template", "", "Template of RTMP ingest URL")
 	fs.StringVar(&cliFlags.HLSTemplate, "hls-template", "", "Template of HLS playback URL")
+	fs.StringVar(&cliFlags.Scenario, "scenario", "", "YAML or JSON file describing multi-phase load test (-sim and -test-dur are ignored)")
+	fs.StringVar(&cliFlags.LoadProfile, "load-profile", "", "Load profile to follow instead of constant -sim streams (ramp:from=0,to=50,over=10m step:start=5,step=5,every=2m,max=50 spike:base=10,peak=100,at=5m,for=1m poisson:rate=0.5,session=3m,dist=exp)")
+	fs.StringVar(&cliFlags.Chaos, "chaos", "", "Randomly disrupt streams during the test (fraction=0.2,every=30s-2m,actions=kill+pause+restart,pause=10s,recover=30s,tolerance=0.05)")
+	asserts := assertions.AddFlags(fs)
+	reportFlags := report.AddFlags(fs)
+	testerThresholds := selfmon.AddFlags(fs)
+	eventsFile := fs.String("events-file", "", "Write events (segments sent, playlists fetched, segments downloaded, latencies, gaps, drifts, errors) to the file as NDJSON")
+	traceExporter := fs.String("trace-exporter", "", "Export traces of the segments lifecycle to (file:spans.jsonl, stdout)")
+	fs.StringVar(&cliFlags.HistoryDir, "history-dir", "", "Directory to save result of the test run to (for later analysis with history tool)")
 	// ignoreNoCodecError := fs.Bool("ignore-no-codec-error", true, "Do not stop streaming if segment without codec's info downloaded")
 
 	_ = fs.String("config", "", "config file (optional)")
@@ -83,6 +106,7 @@ func main() {
 	)
 	flag.CommandLine.Parse(nil)
 	vFlag.Value.Set(fmt.Sprintf("%d", cliFlags.Verbosity))
+	testers.BundleConfig = history.FlagsConfig(fs)
 
 	hostName, _ := os.Hostname()
 	fmt.Println("Loadtester version: " + model.Version)
@@ -94,6 +118,18 @@ func main() {
 		return
 	}
 	metrics.InitCensus(hostName, model.Version, "loadtester")
+	if *traceExporter != "" {
+		if err := tracing.Init(*traceExporter, "loadtester"); err != nil {
+			glog.Fatal(err)
+		}
+	}
+	var eventsSink *events.NDJSONSink
+	if *eventsFile != "" {
+		var err error
+		if eventsSink, err = events.NewNDJSONSink(*eventsFile); err != nil {
+			glog.Fatal(err)
+		}
+	}
 	// testers.IgnoreNoCodecError = *ignoreNoCodecError
 	testers.IgnoreNoCodecError = true
 	testers.IgnoreGaps = true
@@ -113,9 +149,31 @@ func main() {
 	// }
 	// model.ProfilesNum = int(*profiles)
 
-	if cliFlags.TestDuration == 0 {
+	var sc *scenario.Scenario
+	if cliFlags.Scenario != "" {
+		if sc, err = scenario.Load(cliFlags.Scenario); err != nil {
+			glog.Fatal(err)
+		}
+	} else if cliFlags.TestDuration == 0 {
 		glog.Fatalf("-test-dur should be specified")
 	}
+	var profile model.LoadProfile
+	if cliFlags.LoadProfile != "" {
+		if profile, err = testers.ParseLoadProfile(cliFlags.LoadProfile, cliFlags.TestDuration, time.Now().UnixNano()); err != nil {
+			glog.Fatal(err)
+		}
+	} else {
+		profile = testers.NewConstantProfile(int(cliFlags.Simultaneous), cliFlags.TestDuration)
+	}
+	var chaos *testers.ChaosOptions
+	if cliFlags.Chaos != "" {
+		if chaos, err = testers.ParseChaos(cliFlags.Chaos, time.Now().UnixNano()); err != nil {
+			glog.Fatal(err)
+		}
+		if sc != nil {
+			glog.Fatal("-chaos can't be used together with -scenario")
+		}
+	}
 	if cliFlags.APIToken != "" && (cliFlags.RTMPTemplate != "") {
 		glog.Infof("notice: overriding ingest URL returned by %s with %s", cliFlags.APIServer, cliFlags.RTMPTemplate)
 	}
@@ -149,15 +207,40 @@ func main() {
 			}
 		}
 	}
+	var hstore *history.Store
+	if cliFlags.HistoryDir != "" {
+		if hstore, err = history.Open(cliFlags.HistoryDir); err != nil {
+			glog.Fatalf("Error opening history: %v", err)
+		}
+	}
+	runName := cliFlags.RTMPTemplate
+	if cliFlags.APIToken != "" {
+		runName = cliFlags.APIServer
+	}
+	if sc != nil {
+		runName = sc.Name
+	}
+	hrec := history.NewRecord("loadtester", runName)
+	hrec.Config = history.FlagsConfig(fs)
+	suite := reportFlags.NewSuite("loadtester")
 	exit := func(exitCode int, fn, fa string, err error) {
 		cleanup(fn, fa)
+		hrec.Finish(exitCode, err)
+		hstore.Save(hrec)
+		suite.Add("load", "start", time.Since(hrec.Started), err)
+		suite.Finish(exitCode, hrec)
+		suite.Save()
 		if err != nil {
 			glog.Errorf("Error: %v\n", err)
 		}
 		os.Exit(exitCode)
 	}
 	gctx, gcancel := context.WithCancel(context.Background()) // to be used as global parent context, in the future
-	var streamStarter model.StreamStarter
+	if _, err := selfmon.Start(gctx, 2*time.Second, *testerThresholds); err != nil {
+		glog.Warningf("Can't monitor load of the tester err=%v", err)
+	}
+	var newStreamStarter func(httpIngest bool) model.StreamStarter
+	var httpIngestSupported bool
 	var id int
 	var mu sync.Mutex
 	if cliFlags.APIToken != "" {
@@ -166,89 +249,122 @@ func main() {
 		glog.Infof("Choosen server: %s", lapi.GetServer())
 		ingests, err := lapi.Ingest(false)
 		if err != nil {
-			exit(255, fileName, cliFlags.Filename, err)
+			exit(model.ExitCodeAPIError, fileName, cliFlags.Filename, err)
 		}
 		glog.Infof("Got ingests: %+v", ingests)
 		broadcasters, err := lapi.Broadcasters()
 		if err != nil {
-			exit(255, fileName, cliFlags.Filename, err)
+			exit(model.ExitCodeAPIError, fileName, cliFlags.Filename, err)
 		}
 		glog.Infof("Got broadcasters: %+v", broadcasters)
 		httpIngestURLTemplates := make([]string, 0, len(broadcasters))
 		for _, b := range broadcasters {
 			httpIngestURLTemplates = append(httpIngestURLTemplates, fmt.Sprintf("%s/live/%%s", b))
 		}
+		httpIngestSupported = len(httpIngestURLTemplates) > 0
 		if cliFlags.HTTPIngest && len(broadcasters) == 0 {
-			exit(254, fileName, cliFlags.Filename, errors.New("Empty list of broadcasters"))
+			exit(model.ExitCodeNoIngest, fileName, cliFlags.Filename, errors.New("Empty list of broadcasters"))
 		} else if !cliFlags.HTTPIngest && len(ingests) == 0 {
-			exit(254, fileName, cliFlags.Filename, errors.New("Empty list of ingests"))
+			exit(model.ExitCodeNoIngest, fileName, cliFlags.Filename, errors.New("Empty list of ingests"))
 		}
-		streamStarter = func(ctx context.Context, sourceFileName string, waitForTarget, timeToStream time.Duration) (model.OneTestStream, error) {
-			mu.Lock()
-			manifestID := fmt.Sprintf("%s_%d", hostName, id)
-			id++
-			mu.Unlock()
-			stream, err := lapi.CreateStreamEx(manifestID, false, nil)
-			if err != nil {
-				glog.Errorf("Error creating stream using Livepeer API: %v", err)
-				return nil, err
-			}
-			glog.V(model.VERBOSE).Infof("Create Livepeer stream id=%s streamKey=%s playbackId=%s", stream.ID, stream.StreamKey, stream.PlaybackID)
-			createdAPIStreams = append(createdAPIStreams, stream.ID)
-			if cliFlags.HTTPIngest {
-				httpIngestURLTemplate := httpIngestURLTemplates[id%len(httpIngestURLTemplates)]
-				httpIngestURL := fmt.Sprintf(httpIngestURLTemplate, stream.ID)
-				glog.V(model.SHORT).Infof("HTTP ingest: %s", httpIngestURL)
+		newStreamStarter = func(httpIngest bool) model.StreamStarter {
+			return func(ctx context.Context, sourceFileName string, waitForTarget, timeToStream time.Duration) (model.OneTestStream, error) {
+				mu.Lock()
+				manifestID := fmt.Sprintf("%s_%d", hostName, id)
+				id++
+				mu.Unlock()
+				stream, err := lapi.CreateStreamEx(manifestID, false, nil)
+				if err != nil {
+					glog.Errorf("Error creating stream using Livepeer API: %v", err)
+					return nil, err
+				}
+				glog.V(model.VERBOSE).Infof("Create Livepeer stream id=%s streamKey=%s playbackId=%s", stream.ID, stream.StreamKey, stream.PlaybackID)
+				createdAPIStreams = append(createdAPIStreams, stream.ID)
+				if httpIngest {
+					httpIngestURLTemplate := httpIngestURLTemplates[id%len(httpIngestURLTemplates)]
+					httpIngestURL := fmt.Sprintf(httpIngestURLTemplate, stream.ID)
+					glog.V(model.SHORT).Infof("HTTP ingest: %s", httpIngestURL)
 
-				up := testers.NewHTTPStreamer(ctx, false, hostName)
-				go up.StartUpload(sourceFileName, httpIngestURL, manifestID, 0, waitForTarget, timeToStream, 0)
-				return up, nil
-			}
-			var rtmpURL string
-			if cliFlags.RTMPTemplate != "" {
-				rtmpURL = fmt.Sprintf(cliFlags.RTMPTemplate, stream.StreamKey)
-			} else {
-				rtmpURL = fmt.Sprintf("%s/%s", ingests[0].Ingest, stream.StreamKey)
-			}
+					up := testers.NewHTTPStreamer(ctx, false, hostName)
+					go up.StartUpload(sourceFileName, httpIngestURL, manifestID, 0, waitForTarget, timeToStream, 0)
+					return up, nil
+				}
+				var rtmpURL string
+				if cliFlags.RTMPTemplate != "" {
+					rtmpURL = fmt.Sprintf(cliFlags.RTMPTemplate, stream.StreamKey)
+				} else {
+					rtmpURL = fmt.Sprintf("%s/%s", ingests[0].Ingest, stream.StreamKey)
+				}
 
-			var mediaURL string
-			if cliFlags.HLSTemplate != "" {
-				mediaURL = fmt.Sprintf(cliFlags.HLSTemplate, stream.PlaybackID)
-			} else {
-				mediaURL = fmt.Sprintf("%s/%s/index.m3u8", ingests[0].Playback, stream.PlaybackID)
+				var mediaURL string
+				if cliFlags.HLSTemplate != "" {
+					mediaURL = fmt.Sprintf(cliFlags.HLSTemplate, stream.PlaybackID)
+				} else {
+					mediaURL = fmt.Sprintf("%s/%s/index.m3u8", ingests[0].Playback, stream.PlaybackID)
+				}
+				glog.V(model.SHORT).Infof("RTMP: %s", rtmpURL)
+				glog.V(model.SHORT).Infof("MEDIA: %s", mediaURL)
+				sr2 := testers.NewStreamer2(ctx, testers.Streamer2Options{MistMode: cliFlags.MistMode})
+				go sr2.StartStreaming(sourceFileName, rtmpURL, mediaURL, waitForTarget, timeToStream)
+				go func() {
+					<-sr2.Done()
+					lapi.DeleteStream(stream.ID)
+				}()
+				return sr2, nil
 			}
-			glog.V(model.SHORT).Infof("RTMP: %s", rtmpURL)
-			glog.V(model.SHORT).Infof("MEDIA: %s", mediaURL)
-			sr2 := testers.NewStreamer2(ctx, testers.Streamer2Options{MistMode: cliFlags.MistMode})
-			go sr2.StartStreaming(sourceFileName, rtmpURL, mediaURL, waitForTarget, timeToStream)
-			go func() {
-				<-sr2.Done()
-				lapi.DeleteStream(stream.ID)
-			}()
-			return sr2, nil
 		}
 	} else {
 		baseName := strings.ReplaceAll(hostName, ".", "_") + "_" + randName()
-		streamStarter = func(ctx context.Context, sourceFileName string, waitForTarget, timeToStream time.Duration) (model.OneTestStream, error) {
-			mu.Lock()
-			manifestID := fmt.Sprintf("%s_%d", baseName, id)
-			id++
-			mu.Unlock()
-			rtmpURL := fmt.Sprintf(cliFlags.RTMPTemplate, manifestID)
-			mediaURL := fmt.Sprintf(cliFlags.HLSTemplate, manifestID)
-			glog.V(model.SHORT).Infof("RTMP: %s", rtmpURL)
-			glog.V(model.SHORT).Infof("MEDIA: %s", mediaURL)
-			if err := utils.WaitForTCP(waitForTarget, rtmpURL); err != nil {
-				// glog.Error(err)
-				return nil, err
+		// only RTMP ingest can be used with templates
+		templateStarter := testers.NewTemplateStreamStarter(cliFlags.RTMPTemplate, cliFlags.HLSTemplate, baseName, testers.Streamer2Options{MistMode: cliFlags.MistMode})
+		newStreamStarter = func(httpIngest bool) model.StreamStarter {
+			return templateStarter
+		}
+	}
+
+	if sc != nil {
+		res := runScenario(gctx, gcancel, sc, newStreamStarter, httpIngestSupported, fileName, hostName, suite)
+		cleanup(fileName, cliFlags.Filename)
+		if res != nil {
+			hrec.Timings = make(map[string]time.Duration)
+			for _, pres := range res.Phases {
+				hrec.Timings[pres.Name] = pres.Duration
+				var perr error
+				if !pres.Passed {
+					failures := pres.Failures
+					if pres.Error != "" {
+						failures = append(failures, pres.Error)
+					}
+					perr = errors.New(strings.Join(failures, "; "))
+				}
+				suite.Add("scenario", pres.Name, pres.Duration, perr)
 			}
-			sr2 := testers.NewStreamer2(ctx, testers.Streamer2Options{MistMode: cliFlags.MistMode})
-			go sr2.StartStreaming(sourceFileName, rtmpURL, mediaURL, waitForTarget, timeToStream)
-			return sr2, nil
+			// history and assertions get stats of all the phases, not
+			// only of the last one (usually ramp-down)
+			hrec.SetStatsMany(res.Stats())
+			model.ExitCode = asserts.Apply(hrec, model.ExitCode)
+		}
+		if terr := checkTesterLoad(selfmon.Report()); terr != nil {
+			suite.Add("load", "tester load", hrec.Duration, terr)
 		}
+		tracing.Shutdown(context.Background())
+		eventsSink.Close()
+		hrec.Finish(model.ExitCode, nil)
+		hstore.Save(hrec)
+		suite.Finish(model.ExitCode, hrec)
+		suite.Save()
+		if model.ExitCode != 0 {
+			os.Exit(model.ExitCode)
+		}
+		return
 	}
 
-	loadTester := testers.NewLoadTester(gctx, streamStarter, cliFlags.StartDelayDuration)
+	var loadTester model.ILoadTester
+	if chaos != nil {
+		loadTester = testers.NewChaosLoadTester(gctx, newStreamStarter(cliFlags.HTTPIngest), cliFlags.StartDelayDuration, *chaos)
+	} else {
+		loadTester = testers.NewLoadTester(gctx, newStreamStarter(cliFlags.HTTPIngest), cliFlags.StartDelayDuration)
+	}
 	exitc := make(chan os.Signal, 1)
 	signal.Notify(exitc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt, os.Kill)
 	go func(fn, fa string) {
@@ -262,17 +378,58 @@ func main() {
 		// exit(0, fn, fa, nil)
 	}(fileName, cliFlags.Filename)
 
-	err = loadTester.Start(fileName, cliFlags.WaitForTargetDuration, cliFlags.StreamDuration, cliFlags.TestDuration, int(cliFlags.Simultaneous))
+	// StartProfile blocks until the whole profile is played, so success
+	// rate has to be tracked from before it is called
+	tctx, tcancel := context.WithCancel(gctx)
+	suite.TrackSuccessRate(tctx, 10*time.Second, func() float64 {
+		stats, _ := loadTester.Stats()
+		return stats.SuccessRate * 100
+	})
+	err = loadTester.StartProfile(fileName, cliFlags.WaitForTargetDuration, cliFlags.StreamDuration, profile)
 	if err != nil {
+		tcancel()
 		glog.Errorf("Error starting test: %v", err)
-		exit(255, fileName, cliFlags.Filename, err)
+		exit(model.ExitCodeAPIError, fileName, cliFlags.Filename, err)
 	}
 	<-loadTester.Done()
+	tcancel()
 	glog.Infof("Testing finished")
 	cleanup(fileName, cliFlags.Filename)
 	stats, _ := loadTester.Stats()
 	glog.Info(stats.FormatForConsole())
-	// exit(255, fileName, cliFlags.Filename, err)
+	hrec.SetStatsMany(&stats)
+	model.ExitCode = asserts.Apply(hrec, model.ExitCode)
+	var cerr error
+	if chaos != nil {
+		if cerr = testers.VerifyChaos(stats.Chaos, chaos.Tolerance); cerr != nil {
+			glog.Error(cerr)
+			if model.ExitCode == model.ExitCodeOK {
+				model.ExitCode = model.ExitCodeAssertionsFailed
+			}
+		}
+	}
+	terr := checkTesterLoad(stats.TesterLoad)
+	tracing.Shutdown(context.Background())
+	eventsSink.Close()
+	hrec.Finish(model.ExitCode, nil)
+	hstore.Save(hrec)
+	var lerr error
+	if model.ExitCode != 0 {
+		lerr = fmt.Errorf("exit code %d, success rate %.2f%%", model.ExitCode, hrec.SuccessRate)
+	}
+	suite.Add("load", "load test", hrec.Duration, lerr)
+	if chaos != nil {
+		suite.Add("load", "chaos", hrec.Duration, cerr)
+	}
+	if stats.TesterLoad != nil {
+		suite.Add("load", "tester load", hrec.Duration, terr)
+	}
+	suite.Finish(model.ExitCode, hrec)
+	suite.Save()
+	if model.ExitCode != 0 {
+		os.Exit(model.ExitCode)
+	}
+	// exit(model.ExitCodeAPIError, fileName, cliFlags.Filename, err)
 
 	/*
 		lapi := livepeer.NewLivepeer(cliFlags.APIToken, cliFlags.APIServer, nil)
@@ -280,12 +437,12 @@ func main() {
 		glog.Infof("Choosen server: %s", lapi.GetServer())
 		ingests, err := lapi.Ingest(false)
 		if err != nil {
-			exit(255, fileName, cliFlags.Filename, err)
+			exit(model.ExitCodeAPIError, fileName, cliFlags.Filename, err)
 		}
 		glog.Infof("Got ingests: %+v", ingests)
 		broadcasters, err := lapi.Broadcasters()
 		if err != nil {
-			exit(255, fileName, cliFlags.Filename, err)
+			exit(model.ExitCodeAPIError, fileName, cliFlags.Filename, err)
 		}
 		glog.Infof("Got broadcasters: %+v", broadcasters)
 
@@ -294,13 +451,13 @@ func main() {
 		// sr := testers.NewHTTPLoadTester(gctx, gcancel, lapi, 0)
 		var sr model.Streamer
 		if !cliFlags.HTTPIngest {
-			sr = testers.NewStreamer(gctx, gcancel, false, true, nil, lapi)
+			sr = testers.NewStreamer(gctx, gcancel, false, true, nil, lapi, model.ProfilesNum)
 		} else {
-			sr = testers.NewHTTPLoadTester(gctx, gcancel, lapi, 0)
+			sr = testers.NewHTTPLoadTester(gctx, gcancel, lapi, 0, model.ProfilesNum)
 		}
 		baseManifesID, err := sr.StartStreams(fileName, "", "1935", "", "443", *sim, 1, cliFlags.StreamDuration, false, true, true, 2, 5*time.Second, 0)
 		if err != nil {
-			exit(255, fileName, cliFlags.Filename, err)
+			exit(model.ExitCodeAPIError, fileName, cliFlags.Filename, err)
 		}
 		glog.Infof("Base manfiest id: %s", baseManifesID)
 		exitc := make(chan os.Signal, 1)
@@ -329,6 +486,69 @@ func main() {
 	*/
 }
 
+// runScenario runs multi-phase load test described by scenario file
+func runScenario(ctx context.Context, cancel context.CancelFunc, sc *scenario.Scenario, newStreamStarter func(httpIngest bool) model.StreamStarter,
+	httpIngestSupported bool, defaultFileName, hostName string, suite *report.Suite) *scenario.Result {
+
+	downloaded := make(map[string]string)
+	defer func() {
+		for source, fn := range downloaded {
+			if fn != source {
+				os.Remove(fn)
+			}
+		}
+	}()
+	for i := range sc.Phases {
+		ph := &sc.Phases[i]
+		if ph.Source == "" {
+			ph.Source = defaultFileName
+			continue
+		}
+		if _, has := downloaded[ph.Source]; !has {
+			fn, err := utils.GetFile(ph.Source, strings.ReplaceAll(hostName, ".", "_"))
+			if err != nil {
+				glog.Errorf("Error getting file %s for phase %q: %v", ph.Source, ph.Name, err)
+				model.ExitCode = model.ExitCodeAPIError
+				return nil
+			}
+			downloaded[ph.Source] = fn
+		}
+		ph.Source = downloaded[ph.Source]
+	}
+	starters := func(protocol string) (model.StreamStarter, error) {
+		switch protocol {
+		case scenario.ProtocolRTMP:
+			return newStreamStarter(false), nil
+		case scenario.ProtocolHTTP:
+			if !httpIngestSupported {
+				return nil, errors.New("HTTP ingest needs -api-token and non-empty list of broadcasters")
+			}
+			return newStreamStarter(true), nil
+		}
+		return nil, fmt.Errorf("unsupported protocol %q", protocol)
+	}
+	exitc := make(chan os.Signal, 1)
+	signal.Notify(exitc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt, os.Kill)
+	go func() {
+		<-exitc
+		fmt.Println("Got Ctrl-C, cancelling")
+		cancel()
+	}()
+	runner := scenario.NewRunner(ctx, sc, starters)
+	tctx, tcancel := context.WithCancel(ctx)
+	suite.TrackSuccessRate(tctx, 10*time.Second, func() float64 {
+		stats, _ := runner.Stats()
+		return stats.SuccessRate * 100
+	})
+	res := runner.Run()
+	tcancel()
+	fmt.Println(res.FormatForConsole())
+	if !res.Passed {
+		model.ExitCode = model.ExitCodeFailure
+	}
+	return res
+}
+
 func randName() string {
 	x := make([]byte, 10, 10)
 	for i := 0; i < len(x); i++ {
@@ -336,3 +556,18 @@ func randName() string {
 	}
 	return fmt.Sprintf("%x", x)
 }
+
+// checkTesterLoad returns error if the tester itself was overloaded during the
+// run. Failed run is reported with ExitCodeTesterOverloaded then, so it is not
+// blamed on the service
+func checkTesterLoad(load *model.TesterLoad) error {
+	if load == nil || !load.Overloaded {
+		return nil
+	}
+	err := fmt.Errorf("tester overloaded, results are not valid: %s", strings.Join(load.Reasons, "; "))
+	glog.Warning(err)
+	if model.ExitCode != model.ExitCodeOK {
+		model.ExitCode = model.ExitCodeTesterOverloaded
+	}
+	return err
+}
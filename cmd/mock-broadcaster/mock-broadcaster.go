@@ -0,0 +1,89 @@
+// mock-broadcaster runs in-process mock of the media server, to run testers
+// against it without real go-livepeer, Mist or Wowza
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/stream-tester/internal/mockbroadcaster"
+	"github.com/livepeer/stream-tester/model"
+	"github.com/peterbourgon/ff"
+)
+
+func main() {
+	flag.Set("logtostderr", "true")
+	vFlag := flag.Lookup("v")
+
+	fs := flag.NewFlagSet("mock-broadcaster", flag.ExitOnError)
+
+	verbosity := fs.String("v", "", "Log verbosity.  {4|5|6}")
+	version := fs.Bool("version", false, "Print out the version")
+	rtmpAddr := fs.String("rtmp-addr", "localhost:1935", "Address to accept RTMP streams on")
+	httpAddr := fs.String("http-addr", "localhost:8935", "Address to serve HLS and accept HTTP push on")
+	renditions := fs.String("renditions", "", "Transcoded renditions to serve, in form name:WxH:bandwidth, separated by comma")
+	segmentDuration := fs.Duration("segment-duration", 2*time.Second, "Target duration of the segments cut from RTMP stream")
+	playlistSize := fs.Int("playlist-size", 6, "Number of segments in media playlists")
+	script := fs.String("script", "", "Faults to inject, like 'drop/P144p30fps16x9:5-7,delay=3s:10-,lag=2/source,status=503:4-4'")
+	_ = fs.String("config", "", "config file (optional)")
+
+	ff.Parse(fs, os.Args[1:],
+		ff.WithConfigFileFlag("config"),
+		ff.WithConfigFileParser(ff.PlainParser),
+		ff.WithEnvVarPrefix("MOCK_BROADCASTER"),
+	)
+	flag.CommandLine.Parse(nil)
+	vFlag.Value.Set(*verbosity)
+
+	if *version {
+		fmt.Println("Mock broadcaster version: " + model.Version)
+		fmt.Printf("Compiler version: %s %s\n", runtime.Compiler, runtime.Version())
+		return
+	}
+	opts := mockbroadcaster.Options{
+		RTMPAddr:        *rtmpAddr,
+		HTTPAddr:        *httpAddr,
+		SegmentDuration: *segmentDuration,
+		PlaylistSize:    *playlistSize,
+	}
+	if *renditions != "" {
+		for _, rs := range strings.Split(*renditions, ",") {
+			var r mockbroadcaster.Rendition
+			parts := strings.Split(rs, ":")
+			if len(parts) != 3 {
+				glog.Fatalf("Invalid rendition %q, should be name:WxH:bandwidth", rs)
+			}
+			r.Name, r.Resolution = parts[0], parts[1]
+			if _, err := fmt.Sscan(parts[2], &r.Bandwidth); err != nil {
+				glog.Fatalf("Invalid bandwidth of rendition %q: %v", rs, err)
+			}
+			opts.Renditions = append(opts.Renditions, r)
+		}
+	}
+	var err error
+	if opts.Script, err = mockbroadcaster.ParseScript(*script); err != nil {
+		glog.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	exitc := make(chan os.Signal, 1)
+	signal.Notify(exitc, syscall.SIGINT, syscall.SIGTERM)
+	b := mockbroadcaster.New(opts)
+	if err := b.Start(ctx); err != nil {
+		glog.Fatal(err)
+	}
+	fmt.Printf("RTMP: rtmp://%s/<manifestID> (go-livepeer) or rtmp://%s/live/<manifestID> (Mist, Wowza)\n", b.RTMPAddr(), b.RTMPAddr())
+	fmt.Printf("HLS:  %s\n", b.MediaURL(mockbroadcaster.LayoutLivepeer, "<manifestID>"))
+	fmt.Printf("      %s\n", b.MediaURL(mockbroadcaster.LayoutMist, "<manifestID>"))
+	fmt.Printf("      %s\n", b.MediaURL(mockbroadcaster.LayoutWowza, "<manifestID>"))
+	fmt.Printf("Push: %s/<seqNo>.ts\n", b.PushURL("<manifestID>"))
+	<-exitc
+	cancel()
+}
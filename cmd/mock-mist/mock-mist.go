@@ -0,0 +1,74 @@
+// mock-mist runs simulator of the MistServer API, to test mist-api-connector
+// without real Mist. Streams are started and stopped through control endpoints
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"regexp"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/stream-tester/internal/mockmist"
+	"github.com/livepeer/stream-tester/model"
+	"github.com/peterbourgon/ff"
+)
+
+func main() {
+	flag.Set("logtostderr", "true")
+	vFlag := flag.Lookup("v")
+
+	fs := flag.NewFlagSet("mock-mist", flag.ExitOnError)
+
+	verbosity := fs.String("v", "", "Log verbosity.  {4|5|6}")
+	version := fs.Bool("version", false, "Print out the version")
+	addr := fs.String("addr", "localhost:4242", "Address to serve Mist API on")
+	creds := fs.String("creds", "", "login:password of the API. Any request is authorized if empty")
+	failPush := fs.String("fail-push", "", "Regexp of the push targets which fail right after the start")
+	_ = fs.String("config", "", "config file (optional)")
+
+	ff.Parse(fs, os.Args[1:],
+		ff.WithConfigFileFlag("config"),
+		ff.WithConfigFileParser(ff.PlainParser),
+		ff.WithEnvVarPrefix("MOCK_MIST"),
+	)
+	flag.CommandLine.Parse(nil)
+	vFlag.Value.Set(*verbosity)
+
+	if *version {
+		fmt.Println("Mock Mist version: " + model.Version)
+		fmt.Printf("Compiler version: %s %s\n", runtime.Compiler, runtime.Version())
+		return
+	}
+	opts := mockmist.Options{Addr: *addr}
+	if *creds != "" {
+		cp := strings.SplitN(*creds, ":", 2)
+		if len(cp) != 2 {
+			glog.Fatal("Credentials should be in form 'login:password'")
+		}
+		opts.Login, opts.Password = cp[0], cp[1]
+	}
+	if *failPush != "" {
+		re, err := regexp.Compile(*failPush)
+		if err != nil {
+			glog.Fatalf("Invalid fail-push regexp err=%v", err)
+		}
+		opts.FailPush = re.MatchString
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	exitc := make(chan os.Signal, 1)
+	signal.Notify(exitc, syscall.SIGINT, syscall.SIGTERM)
+	s := mockmist.New(opts)
+	if err := s.Start(ctx); err != nil {
+		glog.Fatal(err)
+	}
+	fmt.Printf("API:     http://%s/api\n", s.Addr())
+	fmt.Printf("Control: curl -X POST 'http://%s/_mock/start?key=<streamKey>'\n", s.Addr())
+	<-exitc
+	cancel()
+}
@@ -23,11 +23,15 @@ import (
 	"github.com/livepeer/go-api-client"
 	"github.com/livepeer/joy4/format"
 	"github.com/livepeer/livepeer-data/pkg/client"
+	"github.com/livepeer/stream-tester/internal/alerting"
 	"github.com/livepeer/stream-tester/internal/app/common"
 	"github.com/livepeer/stream-tester/internal/app/recordtester"
 	"github.com/livepeer/stream-tester/internal/app/transcodetester"
 	"github.com/livepeer/stream-tester/internal/app/vodtester"
+	"github.com/livepeer/stream-tester/internal/assertions"
+	"github.com/livepeer/stream-tester/internal/history"
 	"github.com/livepeer/stream-tester/internal/metrics"
+	"github.com/livepeer/stream-tester/internal/report"
 	"github.com/livepeer/stream-tester/internal/server"
 	"github.com/livepeer/stream-tester/internal/testers"
 	"github.com/livepeer/stream-tester/internal/utils"
@@ -177,9 +181,13 @@ func main() {
 	discordURL := fs.String("discord-url", "", "URL of Discord's webhook to send messages to Discord channel")
 	discordUserName := fs.String("discord-user-name", "", "User name to use when sending messages to Discord")
 	discordUsersToNotify := fs.String("discord-users", "", "Id's of users to notify in case of failure")
+	botToken := fs.String("bot-token", "", "Discord's bot token, enables commands controlling continuous tests")
+	channelID := fs.String("channel-id", "", "Discord's channel id to accept bot commands in (can be list of channels, separated by comma)")
+	notifiersCfg := messenger.AddFlags(fs)
 	pagerDutyIntegrationKey := fs.String("pagerduty-integration-key", "", "PagerDuty integration key")
 	pagerDutyComponent := fs.String("pagerduty-component", "", "PagerDuty component")
 	pagerDutyLowUrgency := fs.Bool("pagerduty-low-urgency", false, "Whether to send only low-urgency PagerDuty alerts")
+	alertPolicy := alerting.AddFlags(fs)
 	bind := fs.String("bind", "0.0.0.0:9090", "Address to bind metric server to")
 
 	serfRPCAddr := fs.String("serf-rpc-addr", "", "Serf RPC address for fetching serf members")
@@ -189,6 +197,12 @@ func main() {
 	serfNodeCount := fs.Int("serf-node-count", 5, "Count of serf nodes when selecting nearest members")
 	latitude := fs.Float64("latitude", 0, "latitude/geolocation of this record testing instance")
 	longitude := fs.Float64("longitude", 0, "longitude/geolocation of this record testing instance")
+	asserts := assertions.AddFlags(fs)
+	reportFlags := report.AddFlags(fs)
+	historyDir := fs.String("history-dir", "", "Directory to save results of the test runs to (history is not saved if not specified)")
+	captureDir := fs.String("capture-dir", "", "Record all downloaded playlists and segments to this directory (to be replayed by hls-replay)")
+	fs.BoolVar(&testers.FailureBundles, "failure-bundles", false, "Save bundle of playlists, segments, stats and config for every failure and link it in the alerts")
+	fs.StringVar(&testers.BundleDir, "bundle-dir", testers.BundleDir, "Directory to save failure bundles to if Google Storage or Azure is not configured")
 
 	_ = fs.String("config", "", "config file (optional)")
 
@@ -200,6 +214,7 @@ func main() {
 	)
 	flag.CommandLine.Parse(nil)
 	vFlag.Value.Set(*verbosity)
+	testers.BundleConfig = history.FlagsConfig(fs)
 
 	hostName, _ := os.Hostname()
 	fmt.Println("Recordtester version: " + model.Version)
@@ -278,6 +293,19 @@ func main() {
 		SerfPullCount:    *serfPullCount,
 	}
 
+	var hstore *history.Store
+	if *historyDir != "" {
+		if hstore, err = history.Open(*historyDir); err != nil {
+			glog.Fatalf("Error opening history store dir=%s err=%v", *historyDir, err)
+		}
+	}
+	hconfig := history.FlagsConfig(fs)
+	// all the exits after this point go through exit(), which closes the
+	// capture archive
+	if err := testers.CaptureInit(*captureDir); err != nil {
+		glog.Fatalf("Error creating capture archive dir=%s err=%v", *captureDir, err)
+	}
+
 	var lapi *api.Client
 	cleanup := func(fn, fa string) {
 		if fn != fa {
@@ -296,6 +324,7 @@ func main() {
 		} else {
 			exitCode = 0
 		}
+		testers.CaptureClose()
 		os.Exit(exitCode)
 	}
 
@@ -323,7 +352,9 @@ func main() {
 		time.Sleep(2 * time.Second)
 		// exit(0, fn, fa, nil)
 	}(fileName, *fileArg)
-	messenger.Init(gctx, *discordURL, *discordUserName, *discordUsersToNotify, "", "", "")
+	messenger.Init(gctx, *discordURL, *discordUserName, *discordUsersToNotify, *botToken, *channelID, *apiToken)
+	notifiersCfg.Init()
+	common.AddBotCommands()
 
 	rtOpts := recordtester.RecordTesterOptions{
 		API:                 lapi,
@@ -342,6 +373,8 @@ func main() {
 		var es int
 		var err error
 		start := time.Now()
+		hrec := history.NewRecord("recordtester", lapi.GetServer())
+		hrec.Config = hconfig
 
 		for i := 0; i < *sim; i++ {
 			rt := recordtester.NewRecordTester(gctx, rtOpts, serfOptions)
@@ -372,6 +405,10 @@ func main() {
 		}
 		took := time.Since(start)
 		glog.Infof("%d streams test ended in %s success %f%%", *sim, took, float64(succ)/float64(len(eses))*100.0)
+		hrec.SuccessRate = float64(succ) / float64(len(eses)) * 100.0
+		es = asserts.Apply(hrec, es)
+		hrec.Finish(es, err)
+		hstore.Save(hrec)
 		time.Sleep(1 * time.Hour)
 		exit(es, fileName, *fileArg, err)
 		return
@@ -385,6 +422,8 @@ func main() {
 					PagerDutyIntegrationKey: *pagerDutyIntegrationKey,
 					PagerDutyComponent:      *pagerDutyComponent,
 					PagerDutyLowUrgency:     *pagerDutyLowUrgency,
+					AlertPolicy:             alertPolicy,
+					History:                 hstore,
 					RecordTesterOptions:     rtOpts,
 				}
 				crt := recordtester.NewContinuousRecordTester(egCtx, crtOpts, serfOptions)
@@ -401,6 +440,8 @@ func main() {
 					PagerDutyIntegrationKey: *pagerDutyIntegrationKey,
 					PagerDutyComponent:      *pagerDutyComponent,
 					PagerDutyLowUrgency:     *pagerDutyLowUrgency,
+					AlertPolicy:             alertPolicy,
+					History:                 hstore,
 					TesterOptions:           vtOpts,
 				}
 				cvt := vodtester.NewContinuousVodTester(egCtx, cvtOpts)
@@ -417,6 +458,8 @@ func main() {
 					PagerDutyIntegrationKey: *pagerDutyIntegrationKey,
 					PagerDutyComponent:      *pagerDutyComponent,
 					PagerDutyLowUrgency:     *pagerDutyLowUrgency,
+					AlertPolicy:             alertPolicy,
+					History:                 hstore,
 					TesterOptions:           ttOpts,
 				}
 				ctt := transcodetester.NewContinuousTranscodeTester(egCtx, cttOpts)
@@ -429,8 +472,50 @@ func main() {
 		exit(0, fileName, *fileArg, err)
 		return
 	}
-	// just one stream
-	rt := recordtester.NewRecordTester(gctx, rtOpts, serfOptions)
-	es, err := rt.Start(fileName, *testDuration, *pauseDuration)
+	// just one run of the tests. Live workflow is tested if nothing else is specified
+	suite := reportFlags.NewSuite("recordtester")
+	rtOpts.Report = suite
+	testerOpts := common.TesterOptions{
+		API:                      lapi,
+		CatalystPipelineStrategy: *catalystPipelineStrategy,
+		Report:                   suite,
+	}
+	hrec := history.NewRecord("recordtester", lapi.GetServer())
+	hrec.Config = hconfig
+	var es int
+	var eg errgroup.Group
+	if *testLive || (!*testVod && !*testTranscode) {
+		rt := recordtester.NewRecordTester(gctx, rtOpts, serfOptions)
+		eg.Go(func() error {
+			var err error
+			es, err = rt.Start(fileName, *testDuration, *pauseDuration)
+			vs := rt.VODStats()
+			hrec.SetVODStats(&vs)
+			return err
+		})
+	}
+	if *testVod {
+		eg.Go(func() error {
+			vt := vodtester.NewVodTester(gctx, testerOpts)
+			return vt.Start(fileName, *vodImportUrl, *taskPollDuration)
+		})
+	}
+	if *testTranscode {
+		eg.Go(func() error {
+			tt := transcodetester.NewTranscodeTester(gctx, testerOpts)
+			return tt.Start(*fileArg, *transcodeBucketUrl, *transcodeW3sProof, *taskPollDuration)
+		})
+	}
+	err = eg.Wait()
+	if err != context.Canceled {
+		if err != nil && es == model.ExitCodeOK {
+			es = model.ExitCodeFailure
+		}
+		es = asserts.Apply(hrec, es)
+		hrec.Finish(es, err)
+		hstore.Save(hrec)
+		suite.Finish(es, hrec)
+		suite.Save()
+	}
 	exit(es, fileName, *fileArg, err)
 }
@@ -5,6 +5,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -22,9 +23,15 @@ import (
 	"github.com/livepeer/joy4/format"
 	"github.com/livepeer/stream-tester/apis/livepeer"
 	mistapi "github.com/livepeer/stream-tester/apis/mist"
+	"github.com/livepeer/stream-tester/internal/assertions"
+	"github.com/livepeer/stream-tester/internal/events"
+	"github.com/livepeer/stream-tester/internal/history"
 	"github.com/livepeer/stream-tester/internal/metrics"
+	"github.com/livepeer/stream-tester/internal/report"
+	"github.com/livepeer/stream-tester/internal/selfmon"
 	"github.com/livepeer/stream-tester/internal/server"
 	"github.com/livepeer/stream-tester/internal/testers"
+	"github.com/livepeer/stream-tester/internal/tracing"
 	"github.com/livepeer/stream-tester/internal/utils"
 	"github.com/livepeer/stream-tester/messenger"
 	"github.com/livepeer/stream-tester/model"
@@ -55,6 +62,7 @@ func main() {
 	discordURL := flag.String("discord-url", "", "URL of Discord's webhook to send messages to Discord channel")
 	discordUserName := flag.String("discord-user-name", "", "User name to use when sending messages to Discord")
 	discordUsersToNotify := flag.String("discord-users", "", "Id's of users to notify in case of failure")
+	notifiersCfg := messenger.AddFlags(flag.CommandLine)
 	latencyThreshold := flag.Float64("latency-threshold", 0, "Report failure to Discord if latency is bigger than specified")
 	waitForTarget := flag.Duration("wait-for-target", 0, "How long to wait for RTMP target to appear")
 	rtmpURL := flag.String("rtmp-url", "", "If RTMP URL specified, then infinite streamer will be used (for Wowza testing)")
@@ -70,6 +78,9 @@ func main() {
 	ignoreGaps := flag.Bool("ignore-gaps", false, "Do not stop streaming if gaps found")
 	ignoreTimeDrift := flag.Bool("ignore-time-drift", false, "Do not stop streaming if time drift detected")
 	httpIngest := flag.Bool("http-ingest", false, "Use Livepeer HTTP HLS ingest")
+	flag.BoolVar(&testers.EmbedTimestamps, "sei-timestamps", true, "Embed wall-clock timestamps into key frames (SEI) to measure latency on renditions keeping them")
+	flag.BoolVar(&testers.FailureBundles, "failure-bundles", false, "Save bundle of playlists, segments, stats and config for every failure and link it in the alerts")
+	flag.StringVar(&testers.BundleDir, "bundle-dir", testers.BundleDir, "Directory to save failure bundles to if Google Storage or Azure is not configured")
 	fileArg := flag.String("file", "", "File to stream")
 	failHard := flag.Bool("fail-hard", false, "Panic if can't parse downloaded segments")
 	mistCreds := flag.String("mist-creds", "", "login:password of the Mist server")
@@ -93,6 +104,14 @@ func main() {
 	statsFile := flag.String("stats-file", "", "Path to where to store the stream stats, in JSON")
 	rtmpInfinitePush := flag.Bool("rtmp-infinite-push", false, "Just push file infinitely to -rtmp-url and do not read anything back")
 	statsOnly := flag.Bool("stats-only", false, "Do not actually download segments in infinite-pull")
+	captureDir := flag.String("capture-dir", "", "Record all downloaded playlists and segments to this directory (to be replayed by hls-replay)")
+	flag.IntVar(&metrics.StreamSeriesLimit, "metrics-streams", metrics.StreamSeriesLimit, "Maximum number of streams exported with their own Prometheus series, others are aggregated as stream=\"_other\"")
+	flag.IntVar(&metrics.RenditionSeriesLimit, "metrics-renditions", metrics.RenditionSeriesLimit, "Maximum number of renditions exported with their own Prometheus series for every stream")
+	asserts := assertions.AddFlags(flag.CommandLine)
+	reportFlags := report.AddFlags(flag.CommandLine)
+	testerThresholds := selfmon.AddFlags(flag.CommandLine)
+	eventsFile := flag.String("events-file", "", "Write events (segments sent, playlists fetched, segments downloaded, latencies, gaps, drifts, errors) to the file as NDJSON")
+	traceExporter := flag.String("trace-exporter", "", "Export traces of the segments lifecycle to (file:spans.jsonl, stdout)")
 	_ = flag.String("config", "", "config file (optional)")
 
 	ff.Parse(flag.CommandLine, os.Args[1:],
@@ -101,6 +120,7 @@ func main() {
 		ff.WithEnvVarPrefix("STREAM_TESTER"),
 	)
 	flag.Parse()
+	testers.BundleConfig = history.FlagsConfig(flag.CommandLine)
 
 	hostName, _ := os.Hostname()
 	fmt.Println("Stream tester version: " + model.Version)
@@ -116,7 +136,11 @@ func main() {
 	}
 	metrics.InitCensus(hostName, model.Version, "streamtester")
 	gctx, gcancel := context.WithCancel(context.Background()) // to be used as global parent context, in the future
+	if _, err := selfmon.Start(gctx, 2*time.Second, *testerThresholds); err != nil {
+		glog.Warningf("Can't monitor load of the tester err=%v", err)
+	}
 	messenger.Init(gctx, *discordURL, *discordUserName, *discordUsersToNotify, *botToken, *channelID, *apiToken)
+	notifiersCfg.Init()
 	defer time.Sleep(2 * time.Second)
 	exitc := make(chan os.Signal, 1)
 	signal.Notify(exitc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt, os.Kill)
@@ -137,6 +161,26 @@ func main() {
 	if err := testers.AzureInit(*azureStorageAccount, *azureAccessKey, *azureContainer); err != nil {
 		panic(err)
 	}
+	if err := testers.CaptureInit(*captureDir); err != nil {
+		glog.Fatalf("Error creating capture archive dir=%s err=%v", *captureDir, err)
+	}
+	// os.Exit skips deferred calls, so CaptureClose is also called before
+	// every exit below
+	defer testers.CaptureClose()
+	if *traceExporter != "" {
+		if err := tracing.Init(*traceExporter, "streamtester"); err != nil {
+			glog.Fatal(err)
+		}
+	}
+	defer tracing.Shutdown(context.Background())
+	var eventsSink *events.NDJSONSink
+	if *eventsFile != "" {
+		var err error
+		if eventsSink, err = events.NewNDJSONSink(*eventsFile); err != nil {
+			glog.Fatal(err)
+		}
+	}
+	defer eventsSink.Close()
 	if *delayStart > 0 {
 		glog.Infof("Waiting %s", *delayStart)
 		time.Sleep(*delayStart)
@@ -247,6 +291,7 @@ func main() {
 		}
 		msg := fmt.Sprintf(`Starting %s stream to %s, pulling from %s`, durs, *rtmpURL, *mediaURL)
 		messenger.SendMessage(msg)
+		started := time.Now()
 		sr2 := testers.NewStreamer2(gctx, testers.Streamer2Options{*wowza, *mist, *save, true, true})
 		sr2.StartStreaming(fn, *rtmpURL, *mediaURL, *waitForTarget, *streamDuration)
 		if *wowza {
@@ -255,7 +300,17 @@ func main() {
 		}
 		stats, _ := sr2.Stats()
 		fmt.Printf("Stats: %+v\n", stats)
-		os.Exit(model.ExitCode)
+		hrec := history.NewRecord("streamtester", *rtmpURL)
+		hrec.SetStats1(&stats)
+		exitCode := asserts.Apply(hrec, model.ExitCode)
+		suite := reportFlags.NewSuite("streamtester")
+		suite.Add("stream", "streaming", time.Since(started), sr2.Err())
+		suite.Finish(exitCode, hrec)
+		suite.Save()
+		tracing.Shutdown(context.Background())
+		eventsSink.Close()
+		testers.CaptureClose()
+		os.Exit(exitCode)
 		return
 	}
 
@@ -320,7 +375,7 @@ func main() {
 			fmt.Println(stats.FormatForConsole())
 			fmt.Println(stats.FormatErrorsForConsole())
 			if stats.SuccessRate != 1.0 {
-				model.ExitCode = 127
+				model.ExitCode = model.ExitCodeFailure
 			}
 		} else {
 			*rtmpURL = ingests[0].Ingest + "/" + stream.StreamKey
@@ -339,6 +394,9 @@ func main() {
 		if err != nil {
 			glog.Errorf("Error deleting stream %s: %v", stream.ID, err)
 		}
+		tracing.Shutdown(context.Background())
+		eventsSink.Close()
+		testers.CaptureClose()
 		os.Exit(model.ExitCode)
 		return
 	}
@@ -347,10 +405,11 @@ func main() {
 	defer glog.Infof("Exiting")
 	var sr model.Streamer
 	if !*httpIngest {
-		sr = testers.NewStreamer(gctx, gcancel, *wowza, *mist, mapi, lapi)
+		sr = testers.NewStreamer(gctx, gcancel, *wowza, *mist, mapi, lapi, model.ProfilesNum)
 	} else {
-		sr = testers.NewHTTPLoadTester(gctx, gcancel, lapi, *skipTime)
+		sr = testers.NewHTTPLoadTester(gctx, gcancel, lapi, *skipTime, model.ProfilesNum)
 	}
+	started := time.Now()
 	_, err = sr.StartStreams(fn, *bhost, *rtmp, mHost, *media, *sim, *repeat, *streamDuration, false, *latency, *noBar, 3, 5*time.Second, *waitForTarget)
 	if err != nil {
 		glog.Fatal(err)
@@ -366,6 +425,11 @@ func main() {
 	}
 	// Catch interrupt signal to shut down transcoder
 	glog.Infof("Waiting for test to complete")
+	suite := reportFlags.NewSuite("streamtester")
+	suite.TrackSuccessRate(gctx, 10*time.Second, func() float64 {
+		stats, _ := sr.Stats("")
+		return stats.SuccessRate
+	})
 	<-sr.Done()
 	time.Sleep(2 * time.Second)
 	fmt.Println("========= Stats: =========")
@@ -388,6 +452,11 @@ func main() {
 		}
 	}
 
+	var serr error
+	if model.ExitCode != 0 {
+		serr = fmt.Errorf("success rate %.2f%%", stats.SuccessRate)
+	}
+	suite.Add("stream", "streaming", time.Since(started), serr)
 	if *latencyThreshold > 0 && stats.TranscodedLatencies.P95 > 0 {
 		// check latencies, report failure or success
 		var msg string
@@ -395,14 +464,36 @@ func main() {
 			// report failure
 			msg = fmt.Sprintf(`Test failed: transcode P95 latency is %s which is bigger than threshold %v`, stats.TranscodedLatencies.P95, *latencyThreshold)
 			messenger.SendFatalMessage(msg)
+			suite.Add("stream", "latency threshold", 0, errors.New(msg))
 		} else {
 			msg = fmt.Sprintf(`Test succeded: transcode P95 latency is %s which is lower than threshold %v`, stats.TranscodedLatencies.P95, *latencyThreshold)
 			messenger.SendMessage(msg)
+			suite.Add("stream", "latency threshold", 0, nil)
 		}
 		fmt.Println(msg)
 	}
+	hrec := history.NewRecord("streamtester", *bhost)
+	hrec.SetStats(stats)
+	model.ExitCode = asserts.Apply(hrec, model.ExitCode)
+	if tl := stats.TesterLoad; tl != nil && tl.Overloaded {
+		terr := fmt.Errorf("tester overloaded, results are not valid: %s", strings.Join(tl.Reasons, "; "))
+		glog.Warning(terr)
+		if model.ExitCode != model.ExitCodeOK {
+			model.ExitCode = model.ExitCodeTesterOverloaded
+		}
+		suite.Add("stream", "tester load", time.Since(started), terr)
+	}
+	suite.SetStats(stats)
+	suite.Finish(model.ExitCode, hrec)
+	suite.Save()
+	tracing.Shutdown(context.Background())
+	eventsSink.Close()
 	if *noExit {
 		s := server.NewStreamerServer(*wowza, "", "", *mistPort)
 		s.StartWebServer(gctx, *serverAddr)
 	}
+	if model.ExitCode != 0 {
+		testers.CaptureClose()
+		os.Exit(model.ExitCode)
+	}
 }
@@ -48,6 +48,7 @@ func main() {
 	discordURL := fs.String("discord-url", "", "URL of Discord's webhook to send messages to Discord channel")
 	discordUserName := fs.String("discord-user-name", "", "User name to use when sending messages to Discord")
 	discordUsersToNotify := fs.String("discord-users", "", "User IDs to notify in case of failure")
+	notifiersCfg := messenger.AddFlags(fs)
 
 	_ = fs.String("config", "", "config file (optional)")
 
@@ -68,6 +69,7 @@ func main() {
 	fmt.Printf("Hostname %s OS %s IPs %v\n", hostName, runtime.GOOS, utils.GetIPs())
 
 	messenger.Init(context.Background(), *discordURL, *discordUserName, *discordUsersToNotify, "", "", "")
+	notifiersCfg.Init()
 
 	httpClient := &http.Client{
 		Timeout: 8 * time.Second,
@@ -0,0 +1,460 @@
+// Package alerting decides when results of the continuous tests should page
+// someone. Single flaky runs are not paged: alert is raised only after the
+// configured failure streak (or number of failures among the recent runs) and
+// resolved only after the success streak. Alerts are not raised during
+// maintenance windows. State of all the alerts is served as JSON
+package alerting
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Decision what should be done with the alert after the test run
+type Decision int
+
+// Decisions
+const (
+	// None nothing changes
+	None Decision = iota
+	// Trigger alert should be raised (or kept raised)
+	Trigger
+	// Resolve alert should be resolved
+	Resolve
+	// Silenced alert would be raised but maintenance window is on
+	Silenced
+)
+
+var decisionNames = []string{"none", "trigger", "resolve", "silenced"}
+
+func (d Decision) String() string {
+	return decisionNames[d]
+}
+
+type (
+	// Policy when to raise and resolve alerts
+	Policy struct {
+		// FailuresToAlert alert after that many consecutive failures. Zero
+		// disables the check. If both checks are disabled alert is never raised
+		FailuresToAlert int
+		// WindowFailures alert if that many runs of the last WindowSize runs
+		// failed. Zero disables the check
+		WindowFailures int
+		WindowSize     int
+		// SuccessesToResolve resolve after that many consecutive successes
+		SuccessesToResolve int
+		// Maintenance windows alerts are not raised in
+		Maintenance []Window
+		// failuresSet FailuresToAlert was set by the flag
+		failuresSet bool
+	}
+
+	// Window maintenance window, either absolute (From and To set) or daily
+	// (Start and End offsets from the midnight UTC)
+	Window struct {
+		From, To   time.Time
+		Start, End time.Duration
+		spec       string
+	}
+
+	// Tracker tracks results of the runs of one test and decides on its alert
+	Tracker struct {
+		mu     sync.Mutex
+		name   string
+		policy Policy
+		// results of the recent runs, true for failure
+		recent  []bool
+		pos     int
+		filled  bool
+		status  Status
+		unknown bool // state of the alert before the start is not known
+		// alerts are not raised until that time (set by the command)
+		silencedUntil time.Time
+	}
+
+	// Status state of the alert, as served by the status endpoint
+	Status struct {
+		Name                 string     `json:"name"`
+		Policy               string     `json:"policy"`
+		Alerting             bool       `json:"alerting"`
+		AlertingSince        *time.Time `json:"alerting_since,omitempty"`
+		Silenced             bool       `json:"silenced"`
+		Maintenance          string     `json:"maintenance,omitempty"`
+		SilencedUntil        *time.Time `json:"silenced_until,omitempty"`
+		ConsecutiveFailures  int        `json:"consecutive_failures"`
+		ConsecutiveSuccesses int        `json:"consecutive_successes"`
+		RecentFailures       int        `json:"recent_failures"`
+		RecentRuns           int        `json:"recent_runs"`
+		Runs                 int        `json:"runs"`
+		LastRun              *time.Time `json:"last_run,omitempty"`
+		LastError            string     `json:"last_error,omitempty"`
+		LastErrorAt          *time.Time `json:"last_error_at,omitempty"`
+		LastDecision         string     `json:"last_decision,omitempty"`
+	}
+
+	// maintenanceFlag parses comma-separated list of windows into the policy
+	maintenanceFlag struct {
+		p *Policy
+	}
+
+	// failuresFlag sets FailuresToAlert of the policy, so window doesn't
+	// override it
+	failuresFlag struct {
+		p *Policy
+	}
+)
+
+var (
+	// DefaultPolicy alerts on the first failure and resolves on the first
+	// success
+	DefaultPolicy = Policy{FailuresToAlert: 1, SuccessesToResolve: 1}
+
+	trackersMu sync.Mutex
+	trackers   = make(map[string]*Tracker)
+)
+
+// AddFlags adds `-alert-*` flags to the flag set. Returned policy is filled
+// when flags are parsed
+func AddFlags(fs *flag.FlagSet) *Policy {
+	p := DefaultPolicy
+	fs.Var(failuresFlag{&p}, "alert-after-failures", "Alert after that many consecutive failed runs, 0 to disable (default 1, 0 if -alert-window is set)")
+	fs.Func("alert-window", "Alert if M of the last K runs failed, as M/K (e.g. 3/5). Disables alerting on the first failure", func(s string) error {
+		return p.setWindow(s)
+	})
+	fs.IntVar(&p.SuccessesToResolve, "alert-resolve-after-successes", p.SuccessesToResolve, "Resolve alert after that many consecutive successful runs")
+	fs.Var(maintenanceFlag{&p}, "alert-maintenance", "Comma-separated maintenance windows alerts are not raised in, daily as 15:04-16:30 (UTC) or absolute as RFC3339/RFC3339")
+	return &p
+}
+
+func (p *Policy) setWindow(s string) error {
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid window %q, should be M/K", s)
+	}
+	m, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return err
+	}
+	k, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return err
+	}
+	if m < 1 || k < m {
+		return fmt.Errorf("invalid window %q, should be 1 <= M <= K", s)
+	}
+	p.WindowFailures, p.WindowSize = m, k
+	if !p.failuresSet {
+		// window is given to not alert on single failure
+		p.FailuresToAlert = 0
+	}
+	return nil
+}
+
+func (ff failuresFlag) String() string {
+	if ff.p == nil {
+		return ""
+	}
+	return strconv.Itoa(ff.p.FailuresToAlert)
+}
+
+func (ff failuresFlag) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	if n < 0 {
+		return fmt.Errorf("invalid number of failures %d", n)
+	}
+	ff.p.FailuresToAlert, ff.p.failuresSet = n, true
+	return nil
+}
+
+func (mf maintenanceFlag) String() string {
+	if mf.p == nil {
+		return ""
+	}
+	specs := make([]string, len(mf.p.Maintenance))
+	for i, w := range mf.p.Maintenance {
+		specs[i] = w.String()
+	}
+	return strings.Join(specs, ",")
+}
+
+func (mf maintenanceFlag) Set(s string) error {
+	mf.p.Maintenance = nil
+	for _, spec := range strings.Split(s, ",") {
+		if spec = strings.TrimSpace(spec); spec == "" {
+			continue
+		}
+		w, err := ParseWindow(spec)
+		if err != nil {
+			return err
+		}
+		mf.p.Maintenance = append(mf.p.Maintenance, w)
+	}
+	return nil
+}
+
+// ParseWindow parses maintenance window, daily `15:04-16:30` (UTC, can wrap
+// around the midnight) or absolute `2006-01-02T15:04:05Z/2006-01-02T16:00:00Z`
+func ParseWindow(spec string) (Window, error) {
+	w := Window{spec: spec}
+	if parts := strings.Split(spec, "/"); len(parts) == 2 {
+		var err error
+		if w.From, err = time.Parse(time.RFC3339, parts[0]); err != nil {
+			return w, fmt.Errorf("invalid maintenance window %q: %w", spec, err)
+		}
+		if w.To, err = time.Parse(time.RFC3339, parts[1]); err != nil {
+			return w, fmt.Errorf("invalid maintenance window %q: %w", spec, err)
+		}
+		if !w.To.After(w.From) {
+			return w, fmt.Errorf("invalid maintenance window %q: ends before it starts", spec)
+		}
+		return w, nil
+	}
+	parts := strings.Split(spec, "-")
+	if len(parts) != 2 {
+		return w, fmt.Errorf("invalid maintenance window %q", spec)
+	}
+	for i, part := range parts {
+		t, err := time.Parse("15:04", part)
+		if err != nil {
+			return w, fmt.Errorf("invalid maintenance window %q: %w", spec, err)
+		}
+		off := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+		if i == 0 {
+			w.Start = off
+		} else {
+			w.End = off
+		}
+	}
+	if w.Start == w.End {
+		return w, fmt.Errorf("invalid maintenance window %q: empty", spec)
+	}
+	return w, nil
+}
+
+// Contains returns true if t is inside the window
+func (w Window) Contains(t time.Time) bool {
+	if !w.From.IsZero() {
+		return !t.Before(w.From) && t.Before(w.To)
+	}
+	t = t.UTC()
+	off := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if w.Start < w.End {
+		return off >= w.Start && off < w.End
+	}
+	return off >= w.Start || off < w.End
+}
+
+func (w Window) String() string {
+	return w.spec
+}
+
+// InMaintenance returns maintenance window t is in, if any
+func (p *Policy) InMaintenance(t time.Time) (Window, bool) {
+	for _, w := range p.Maintenance {
+		if w.Contains(t) {
+			return w, true
+		}
+	}
+	return Window{}, false
+}
+
+func (p *Policy) String() string {
+	var conds []string
+	if p.FailuresToAlert > 0 {
+		conds = append(conds, fmt.Sprintf("%d consecutive failures", p.FailuresToAlert))
+	}
+	if p.WindowFailures > 0 {
+		conds = append(conds, fmt.Sprintf("%d of the last %d runs failed", p.WindowFailures, p.WindowSize))
+	}
+	if len(conds) == 0 {
+		return fmt.Sprintf("never alert, resolve after %d successes", p.SuccessesToResolve)
+	}
+	return fmt.Sprintf("alert after %s, resolve after %d successes", strings.Join(conds, " or "), p.SuccessesToResolve)
+}
+
+// NewTracker returns tracker of the alert, registered under the name for the
+// status endpoint. Nil policy means DefaultPolicy
+func NewTracker(name string, policy *Policy) *Tracker {
+	p := DefaultPolicy
+	if policy != nil {
+		p = *policy
+	}
+	if p.SuccessesToResolve < 1 {
+		p.SuccessesToResolve = 1
+	}
+	t := &Tracker{
+		name:    name,
+		policy:  p,
+		recent:  make([]bool, p.WindowSize),
+		status:  Status{Name: name, Policy: p.String()},
+		unknown: true,
+	}
+	trackersMu.Lock()
+	trackers[name] = t
+	trackersMu.Unlock()
+	glog.Infof("Alert %s: %s", name, &p)
+	return t
+}
+
+// Record records result of the test run and returns what should be done
+// with the alert
+func (t *Tracker) Record(err error) Decision {
+	return t.record(err, time.Now())
+}
+
+func (t *Tracker) record(err error, now time.Time) Decision {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st := &t.status
+	st.Runs++
+	st.LastRun = &now
+	if err != nil {
+		st.ConsecutiveFailures++
+		st.ConsecutiveSuccesses = 0
+		st.LastError = err.Error()
+		st.LastErrorAt = &now
+	} else {
+		st.ConsecutiveSuccesses++
+		st.ConsecutiveFailures = 0
+	}
+	t.push(err != nil)
+	w, maintenance := t.policy.InMaintenance(now)
+	st.Maintenance = w.String()
+	st.Silenced = false
+	if !t.silencedUntil.IsZero() && now.Before(t.silencedUntil) {
+		maintenance = true
+	}
+
+	decision := None
+	if err != nil && t.shouldAlert() {
+		if maintenance {
+			decision = Silenced
+			st.Silenced = true
+		} else {
+			decision = Trigger
+			if !st.Alerting {
+				st.Alerting = true
+				st.AlertingSince = &now
+			}
+			t.unknown = false
+		}
+	} else if err == nil && (st.Alerting || t.unknown) && st.ConsecutiveSuccesses >= t.policy.SuccessesToResolve {
+		decision = Resolve
+		st.Alerting = false
+		st.AlertingSince = nil
+		t.unknown = false
+		// start counting anew, so runs that failed before the resolve
+		// can't raise the alert again right away
+		t.resetWindow()
+	}
+	st.LastDecision = decision.String()
+	return decision
+}
+
+func (t *Tracker) shouldAlert() bool {
+	p := &t.policy
+	if p.FailuresToAlert > 0 && t.status.ConsecutiveFailures >= p.FailuresToAlert {
+		return true
+	}
+	return p.WindowFailures > 0 && t.status.RecentFailures >= p.WindowFailures
+}
+
+func (t *Tracker) push(failed bool) {
+	if len(t.recent) == 0 {
+		return
+	}
+	if t.filled && t.recent[t.pos] {
+		t.status.RecentFailures--
+	}
+	t.recent[t.pos] = failed
+	if failed {
+		t.status.RecentFailures++
+	}
+	t.pos++
+	if t.pos == len(t.recent) {
+		t.pos, t.filled = 0, true
+	}
+	t.status.RecentRuns = t.pos
+	if t.filled {
+		t.status.RecentRuns = len(t.recent)
+	}
+}
+
+func (t *Tracker) resetWindow() {
+	for i := range t.recent {
+		t.recent[i] = false
+	}
+	t.pos, t.filled = 0, false
+	t.status.RecentFailures, t.status.RecentRuns = 0, 0
+}
+
+// Silence makes alert to not be raised until the time. Zero time lifts the
+// silence
+func (t *Tracker) Silence(until time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.silencedUntil = until
+	t.status.SilencedUntil = nil
+	if !until.IsZero() {
+		t.status.SilencedUntil = &until
+	}
+}
+
+// Status returns current state of the alert
+func (t *Tracker) Status() Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st := t.status
+	if st.SilencedUntil != nil && !time.Now().Before(*st.SilencedUntil) {
+		st.SilencedUntil = nil
+	}
+	return st
+}
+
+// SilenceReason describes why alert is silenced
+func (s Status) SilenceReason() string {
+	if s.Maintenance != "" {
+		return fmt.Sprintf("maintenance window %s is on", s.Maintenance)
+	}
+	if s.SilencedUntil != nil {
+		return fmt.Sprintf("alerting is paused until %s", s.SilencedUntil.UTC().Format(time.RFC3339))
+	}
+	return "alert is not silenced"
+}
+
+// Statuses returns state of all the alerts, sorted by name
+func Statuses() []Status {
+	trackersMu.Lock()
+	all := make([]*Tracker, 0, len(trackers))
+	for _, t := range trackers {
+		all = append(all, t)
+	}
+	trackersMu.Unlock()
+	res := make([]Status, len(all))
+	for i, t := range all {
+		res[i] = t.Status()
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+// StatusHandler serves state of all the alerts as JSON
+func StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		b, _ := json.Marshal(Statuses())
+		w.Write(b)
+	})
+}
@@ -0,0 +1,154 @@
+package alerting
+
+import (
+	"errors"
+	"flag"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+var errRun = errors.New("run failed")
+
+// run records results of the runs ('F' for failed run, 'S' for successful),
+// returning decisions
+func run(t *Tracker, results string, now time.Time) []Decision {
+	var res []Decision
+	for i, r := range results {
+		var err error
+		if r == 'F' {
+			err = errRun
+		}
+		res = append(res, t.record(err, now.Add(time.Duration(i)*time.Minute)))
+	}
+	return res
+}
+
+func TestTracker(t *testing.T) {
+	noon := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	daily, _ := ParseWindow("11:30-13:00")
+	for _, c := range []struct {
+		name    string
+		policy  *Policy
+		results string
+		want    []Decision
+	}{
+		{"default", nil, "FFSS", []Decision{Trigger, Trigger, Resolve, None}},
+		{"first success resolves unknown state", nil, "SS", []Decision{Resolve, None}},
+		{"failure streak", &Policy{FailuresToAlert: 3}, "SFFSFFFS", []Decision{Resolve, None, None, None, None, None, Trigger, Resolve}},
+		{"window", &Policy{WindowFailures: 2, WindowSize: 3}, "SFSFSSFSF", []Decision{Resolve, None, None, Trigger, Resolve, None, None, None, Trigger}},
+		// failures before the resolve are not counted
+		{"window reset", &Policy{WindowFailures: 2, WindowSize: 4}, "FFSFS", []Decision{None, Trigger, Resolve, None, None}},
+		{"streak or window", &Policy{FailuresToAlert: 2, WindowFailures: 2, WindowSize: 3}, "SFSFF", []Decision{Resolve, None, None, Trigger, Trigger}},
+		{"resolve streak", &Policy{FailuresToAlert: 1, SuccessesToResolve: 3}, "FSSFSSS", []Decision{Trigger, None, None, Trigger, None, None, Resolve}},
+		{"disabled", &Policy{}, "FFFS", []Decision{None, None, None, Resolve}},
+		{"maintenance", &Policy{FailuresToAlert: 1, Maintenance: []Window{daily}}, "FS", []Decision{Silenced, Resolve}},
+	} {
+		tr := NewTracker("test "+c.name, c.policy)
+		got := run(tr, c.results, noon)
+		if len(got) != len(c.want) {
+			t.Fatalf("%s: got decisions %v", c.name, got)
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("%s: got decisions %v, want %v", c.name, got, c.want)
+				break
+			}
+		}
+	}
+
+	tr := NewTracker("test window status", &Policy{WindowFailures: 3, WindowSize: 3})
+	run(tr, "FF", noon)
+	if st := tr.Status(); st.RecentFailures != 2 || st.RecentRuns != 2 || st.ConsecutiveFailures != 2 || st.Runs != 2 || st.Alerting {
+		t.Errorf("status is %+v", st)
+	}
+
+	tr = NewTracker("test maintenance", &Policy{FailuresToAlert: 2, Maintenance: []Window{daily}})
+	run(tr, "F", noon)
+	if d := tr.record(errRun, noon.Add(time.Minute)); d != Silenced {
+		t.Errorf("decision in maintenance window is %s", d)
+	}
+	st := tr.Status()
+	if !st.Silenced || st.Alerting || st.Maintenance != "11:30-13:00" || st.SilenceReason() != "maintenance window 11:30-13:00 is on" {
+		t.Errorf("status in maintenance window is %+v", st)
+	}
+	// failure streak goes on after the window
+	if d := tr.record(errRun, noon.Add(2*time.Hour)); d != Trigger {
+		t.Errorf("decision after maintenance window is %s", d)
+	}
+	if st := tr.Status(); st.Silenced || !st.Alerting || st.AlertingSince == nil {
+		t.Errorf("status after maintenance window is %+v", st)
+	}
+}
+
+func TestSilence(t *testing.T) {
+	now := time.Now()
+	tr := NewTracker("test silence", nil)
+	tr.Silence(now.Add(time.Hour))
+	if d := tr.record(errRun, now); d != Silenced {
+		t.Errorf("decision of silenced alert is %s", d)
+	}
+	if st := tr.Status(); st.SilencedUntil == nil || st.Alerting {
+		t.Errorf("status of silenced alert is %+v", st)
+	}
+	if d := tr.record(errRun, now.Add(2*time.Hour)); d != Trigger {
+		t.Errorf("decision after silence is %s", d)
+	}
+	tr.Silence(now.Add(time.Hour))
+	tr.Silence(time.Time{})
+	if d := tr.record(errRun, now); d != Trigger {
+		t.Errorf("decision after lifted silence is %s", d)
+	}
+}
+
+func TestFlags(t *testing.T) {
+	for _, c := range []struct {
+		args             []string
+		failures, window int
+	}{
+		{nil, 1, 0},
+		{[]string{"-alert-window", "3/5"}, 0, 3},
+		{[]string{"-alert-window", "3/5", "-alert-after-failures", "2"}, 2, 3},
+		{[]string{"-alert-after-failures", "2", "-alert-window", "3/5"}, 2, 3},
+		{[]string{"-alert-after-failures", "1", "-alert-window", "3/5"}, 1, 3},
+		{[]string{"-alert-after-failures", "0"}, 0, 0},
+	} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		p := AddFlags(fs)
+		if err := fs.Parse(c.args); err != nil {
+			t.Fatal(err)
+		}
+		if p.FailuresToAlert != c.failures || p.WindowFailures != c.window {
+			t.Errorf("flags %v give policy %s", c.args, p)
+		}
+	}
+	for _, args := range [][]string{{"-alert-window", "5/3"}, {"-alert-window", "3"}, {"-alert-after-failures", "-1"},
+		{"-alert-maintenance", "10:00-10:00"}, {"-alert-maintenance", "2024-05-01T12:00:00Z/2024-05-01T10:00:00Z"}} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(ioutil.Discard)
+		AddFlags(fs)
+		if err := fs.Parse(args); err == nil {
+			t.Errorf("no error parsing %v", args)
+		}
+	}
+}
+
+func TestWindow(t *testing.T) {
+	w, err := ParseWindow("23:00-01:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for at, want := range map[string]bool{"23:30": true, "00:30": true, "01:00": false, "12:00": false} {
+		tm, _ := time.Parse("15:04", at)
+		if w.Contains(tm) != want {
+			t.Errorf("window %s contains %s: %v", w, at, !want)
+		}
+	}
+	abs, err := ParseWindow("2024-05-01T10:00:00Z/2024-05-01T12:00:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !abs.Contains(time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC)) || abs.Contains(time.Date(2024, 5, 2, 11, 0, 0, 0, time.UTC)) {
+		t.Errorf("absolute window %s is wrong", abs)
+	}
+}
@@ -0,0 +1,274 @@
+package common
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Necroforger/dgrouter/exrouter"
+	"github.com/livepeer/stream-tester/messenger"
+)
+
+const (
+	defaultResultsNum = 10
+	defaultSilence    = time.Hour
+)
+
+var (
+	botCommandsOnce sync.Once
+	adhocMu         sync.Mutex
+	adhocRunning    = make(map[string]bool)
+)
+
+// AddBotCommands adds Discord bot commands controlling continuous testers.
+// Does nothing if the bot is not started
+func AddBotCommands() {
+	botCommandsOnce.Do(func() {
+		// commands changing state of the testers or muting alerts are
+		// privileged
+		cmds := []struct {
+			name, desc string
+			handler    exrouter.HandlerFunc
+			privileged bool
+		}{
+			{"testers", "shows continuous testers and their alerts", botTesters, false},
+			{"start", "<tester> resumes stopped tester", botStart, true},
+			{"stop", "<tester> stops tester, cancelling current run", botStop, true},
+			{"silence", "<tester|all> [duration] does not page for tester's failures (1h by default)", botSilence, true},
+			{"unsilence", "<tester|all> pages again", botUnsilence, true},
+			{"results", "<tester> [N] shows last N results (10 by default)", botResults, false},
+			{"live", "<tester> shows stats of the current run", botLive, false},
+			{"run", "<tester> [duration] runs ad-hoc test now", botRun, true},
+		}
+		for _, cmd := range cmds {
+			add := messenger.AddBotCommand
+			if cmd.privileged {
+				add = messenger.AddPrivilegedBotCommand
+			}
+			if r := add(cmd.name, cmd.handler); r != nil {
+				r.Desc(cmd.desc)
+			}
+		}
+	})
+}
+
+func botTesters(ctx *exrouter.Context) {
+	cs := Controls()
+	if len(cs) == 0 {
+		ctx.Reply("No continuous testers running")
+		return
+	}
+	var lines []string
+	for _, c := range cs {
+		state := "waiting for the next run"
+		if c.Stopped() {
+			state = "stopped"
+		} else if run := c.Current(); run != nil {
+			state = fmt.Sprintf("run #%d for %s", run.Num, time.Since(run.Started).Round(time.Second))
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", c.Name(), state))
+		for _, a := range c.Alerts() {
+			st := a.Status()
+			astate := "ok"
+			if st.Alerting {
+				astate = "ALERTING"
+			}
+			if st.SilencedUntil != nil {
+				astate += ", silenced until " + st.SilencedUntil.UTC().Format(time.RFC3339)
+			}
+			lines = append(lines, fmt.Sprintf("  %s: %s, failures in a row %d", st.Name, astate, st.ConsecutiveFailures))
+		}
+	}
+	ctx.Reply("```\n" + strings.Join(lines, "\n") + "```")
+}
+
+// botControl finds tester named by the first argument of the command
+func botControl(ctx *exrouter.Context) *Control {
+	if len(ctx.Args) < 2 {
+		ctx.Reply(fmt.Sprintf("Specify name of the tester: `%s <tester>`", ctx.Args[0]))
+		return nil
+	}
+	c, err := FindControl(ctx.Args[1])
+	if err != nil {
+		ctx.Reply(err.Error())
+		return nil
+	}
+	return c
+}
+
+// botDuration parses optional duration argument
+func botDuration(ctx *exrouter.Context, def time.Duration) (time.Duration, bool) {
+	if len(ctx.Args) < 3 {
+		return def, true
+	}
+	dur, err := time.ParseDuration(ctx.Args[2])
+	if err != nil || dur <= 0 {
+		ctx.Reply(fmt.Sprintf("Invalid duration %q", ctx.Args[2]))
+		return 0, false
+	}
+	return dur, true
+}
+
+func botStart(ctx *exrouter.Context) {
+	c := botControl(ctx)
+	if c == nil {
+		return
+	}
+	if !c.Resume() {
+		ctx.Reply(fmt.Sprintf("Tester %s is not stopped", c.Name()))
+		return
+	}
+	messenger.SendMessage(fmt.Sprintf(":arrow_forward: Tester %s resumed by %s", c.Name(), ctx.Msg.Author.Username))
+}
+
+func botStop(ctx *exrouter.Context) {
+	c := botControl(ctx)
+	if c == nil {
+		return
+	}
+	if !c.Stop() {
+		ctx.Reply(fmt.Sprintf("Tester %s is already stopped", c.Name()))
+		return
+	}
+	messenger.SendMessage(fmt.Sprintf(":stop_button: Tester %s stopped by %s", c.Name(), ctx.Msg.Author.Username))
+}
+
+// botControls finds testers named by the first argument, `all` for all the testers
+func botControls(ctx *exrouter.Context) []*Control {
+	if len(ctx.Args) > 1 && ctx.Args[1] == "all" {
+		return Controls()
+	}
+	if c := botControl(ctx); c != nil {
+		return []*Control{c}
+	}
+	return nil
+}
+
+func botSilence(ctx *exrouter.Context) {
+	cs := botControls(ctx)
+	if len(cs) == 0 {
+		return
+	}
+	dur, ok := botDuration(ctx, defaultSilence)
+	if !ok {
+		return
+	}
+	until := time.Now().Add(dur)
+	names := make([]string, len(cs))
+	for i, c := range cs {
+		for _, a := range c.Alerts() {
+			a.Silence(until)
+		}
+		names[i] = c.Name()
+	}
+	messenger.SendMessage(fmt.Sprintf(":zipper_mouth: Alerts of %s silenced until %s by %s", strings.Join(names, ", "),
+		until.UTC().Format(time.RFC3339), ctx.Msg.Author.Username))
+}
+
+func botUnsilence(ctx *exrouter.Context) {
+	cs := botControls(ctx)
+	if len(cs) == 0 {
+		return
+	}
+	names := make([]string, len(cs))
+	for i, c := range cs {
+		for _, a := range c.Alerts() {
+			a.Silence(time.Time{})
+		}
+		names[i] = c.Name()
+	}
+	messenger.SendMessage(fmt.Sprintf(":loudspeaker: Alerts of %s unsilenced by %s", strings.Join(names, ", "), ctx.Msg.Author.Username))
+}
+
+func botResults(ctx *exrouter.Context) {
+	c := botControl(ctx)
+	if c == nil {
+		return
+	}
+	n := defaultResultsNum
+	if len(ctx.Args) > 2 {
+		var err error
+		if n, err = strconv.Atoi(ctx.Args[2]); err != nil || n < 1 {
+			ctx.Reply(fmt.Sprintf("Invalid number of results %q", ctx.Args[2]))
+			return
+		}
+	}
+	results := c.Results(n)
+	if len(results) == 0 {
+		ctx.Reply(fmt.Sprintf("No finished runs of %s yet", c.Name()))
+		return
+	}
+	lines := make([]string, 0, len(results))
+	for _, r := range results {
+		res := "ok"
+		if r.Err != nil {
+			res = "error: " + r.Err.Error()
+		}
+		lines = append(lines, fmt.Sprintf("#%d %s took %s %s", r.Num, r.Started.UTC().Format("2006-01-02 15:04:05"),
+			r.Finished.Sub(r.Started).Round(time.Second), res))
+	}
+	messenger.SendCodeMessage(fmt.Sprintf("Last %d runs of %s:\n%s", len(results), c.Name(), strings.Join(lines, "\n")))
+}
+
+func botLive(ctx *exrouter.Context) {
+	c := botControl(ctx)
+	if c == nil {
+		return
+	}
+	run := c.Current()
+	if run == nil {
+		state := "waiting for the next run"
+		if c.Stopped() {
+			state = "stopped"
+		}
+		ctx.Reply(fmt.Sprintf("Tester %s is not running a test now, %s", c.Name(), state))
+		return
+	}
+	msg := fmt.Sprintf("Tester %s run #%d started %s ago, times out in %s", c.Name(), run.Num,
+		time.Since(run.Started).Round(time.Second), time.Until(run.Deadline).Round(time.Second))
+	if stats := c.LiveStats(); stats != "" {
+		msg += "\n" + stats
+	}
+	ctx.Reply(msg)
+}
+
+func botRun(ctx *exrouter.Context) {
+	c := botControl(ctx)
+	if c == nil {
+		return
+	}
+	adhoc := c.AdHoc()
+	if adhoc == nil {
+		ctx.Reply(fmt.Sprintf("Tester %s can't run ad-hoc tests", c.Name()))
+		return
+	}
+	dur, ok := botDuration(ctx, time.Minute)
+	if !ok {
+		return
+	}
+	adhocMu.Lock()
+	if adhocRunning[c.Name()] {
+		adhocMu.Unlock()
+		ctx.Reply(fmt.Sprintf("Ad-hoc test of %s is already running", c.Name()))
+		return
+	}
+	adhocRunning[c.Name()] = true
+	adhocMu.Unlock()
+	user := ctx.Msg.Author.Username
+	messenger.SendMessage(fmt.Sprintf(":arrow_right: Starting ad-hoc %s %s test requested by %s", dur, c.Name(), user))
+	go func() {
+		started := time.Now()
+		err := adhoc(dur)
+		adhocMu.Lock()
+		delete(adhocRunning, c.Name())
+		adhocMu.Unlock()
+		took := time.Since(started).Round(time.Second)
+		if err != nil {
+			messenger.SendMessage(fmt.Sprintf(":rotating_light: Ad-hoc %s test requested by %s ended in %s with err=%v", c.Name(), user, took, err))
+			return
+		}
+		messenger.SendMessage(fmt.Sprintf(":white_check_mark: Ad-hoc %s test requested by %s succeeded in %s", c.Name(), user, took))
+	}()
+}
@@ -4,12 +4,17 @@ import (
 	"context"
 	"fmt"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/PagerDuty/go-pagerduty"
 
 	"github.com/golang/glog"
+	"github.com/livepeer/stream-tester/internal/alerting"
+	"github.com/livepeer/stream-tester/internal/history"
+	"github.com/livepeer/stream-tester/internal/testers"
 	"github.com/livepeer/stream-tester/messenger"
+	"github.com/livepeer/stream-tester/model"
 )
 
 type (
@@ -24,6 +29,10 @@ type (
 		PagerDutyIntegrationKey string
 		PagerDutyComponent      string
 		PagerDutyLowUrgency     bool
+		// AlertPolicy when to trigger and resolve PagerDuty incidents (optional)
+		AlertPolicy *alerting.Policy
+		// History store to save results of the tests to (optional)
+		History *history.Store
 		TesterOptions
 	}
 
@@ -35,6 +44,9 @@ type (
 		pagerDutyComponent      string
 		pagerDutyLowUrgency     bool
 		name                    string
+		history                 *history.Store
+		alert                   *alerting.Tracker
+		control                 *Control
 	}
 )
 
@@ -50,20 +62,45 @@ func NewContinuousTester(gctx context.Context, opts ContinuousTesterOptions, tes
 		pagerDutyComponent:      opts.PagerDutyComponent,
 		pagerDutyLowUrgency:     opts.PagerDutyLowUrgency,
 		name:                    testerName,
+		history:                 opts.History,
 	}
+	ct.alert = alerting.NewTracker(ct.dedupKey(), opts.AlertPolicy)
+	ct.control = NewControl(strings.ToLower(testerName), ct.alert)
 	return ct
 }
 
 func (ct *continuousTester) Start(start func(ctx context.Context) error, testDuration, pauseBetweenTests time.Duration) error {
 	messenger.SendMessage(fmt.Sprintf("Starting continuous %s test of %s", ct.name, ct.host))
+	ct.control.SetAdHoc(func(dur time.Duration) error {
+		ctx, cancel := context.WithTimeout(ct.ctx, dur)
+		defer cancel()
+		return start(ctx)
+	})
 	for {
+		if ct.control.WaitResumed(ct.ctx) != nil {
+			messenger.SendMessage(fmt.Sprintf("Continuous test of %s on %s cancelled", ct.name, ct.host))
+			return ct.ctx.Err()
+		}
 		msg := fmt.Sprintf(":arrow_right: Starting %s %s test to %s", testDuration, ct.name, ct.host)
 		messenger.SendMessage(msg)
 
-		ctx, cancel := context.WithTimeout(ct.ctx, testDuration)
+		ctx, cancel := ct.control.BeginRun(ct.ctx, testDuration)
+		hrec := history.NewRecord(strings.ToLower(ct.name)+"tester", ct.host)
 		err := start(ctx)
 		ctxErr := ctx.Err()
 		cancel()
+		herr := err
+		if herr == nil {
+			herr = ctxErr
+		}
+		if ct.control.EndRun(herr) && ct.ctx.Err() == nil {
+			messenger.SendMessage(fmt.Sprintf(":stop_button: Continuous test of %s on %s stopped", ct.name, ct.host))
+			continue
+		}
+		if ct.ctx.Err() == nil {
+			hrec.Finish(runExitCode(herr), herr)
+			ct.history.Save(hrec)
+		}
 
 		if ct.ctx.Err() != nil {
 			messenger.SendMessage(fmt.Sprintf("Continuous test of %s on %s cancelled", ct.name, ct.host))
@@ -72,15 +109,18 @@ func (ct *continuousTester) Start(start func(ctx context.Context) error, testDur
 			msg := fmt.Sprintf("Test of %s on %s timed out, potential deadlock! ctxErr=%q err=%q", ct.name, ct.host, ctxErr, err)
 			messenger.SendFatalMessage(msg)
 		} else if err != nil {
-			msg := fmt.Sprintf(":rotating_light: Test of %s on %s ended with err=%v", ct.name, ct.host, err)
+			if testers.BundleLocation(err) == "" {
+				err = testers.SaveBundle(testers.NewBundle(strings.ToLower(ct.name)+"_"+ct.host, err))
+			}
+			msg := testers.WithBundleLocation(fmt.Sprintf(":rotating_light: Test of %s on %s ended with err=%v", ct.name, ct.host, err), err)
 			messenger.SendFatalMessage(msg)
 			glog.Warning(msg)
-			ct.sendPagerdutyEvent(err)
+			ct.alertOn(err)
 		} else {
 			msg := fmt.Sprintf(":white_check_mark: Test of %s on %s succeeded", ct.name, ct.host)
 			messenger.SendMessage(msg)
 			glog.Info(msg)
-			ct.sendPagerdutyEvent(nil)
+			ct.alertOn(nil)
 		}
 		glog.Infof("Waiting %s before next test of %s", pauseBetweenTests, ct.name)
 		select {
@@ -93,14 +133,50 @@ func (ct *continuousTester) Start(start func(ctx context.Context) error, testDur
 	return nil
 }
 
+// runExitCode returns exit code the run would end with as a standalone
+// tester. Testers used here report errors only, so any error is a failure
+func runExitCode(err error) int {
+	if err != nil {
+		return model.ExitCodeFailure
+	}
+	return model.ExitCodeOK
+}
+
+func (ct *continuousTester) dedupKey() string {
+	dedupKey := fmt.Sprintf("cont-%s-tester:%s", ct.name, ct.host)
+	if ct.pagerDutyLowUrgency {
+		dedupKey = "lopri-" + dedupKey
+	}
+	return dedupKey
+}
+
+// alertOn records result of the test and triggers or resolves PagerDuty
+// incident if the alert policy says so
+func (ct *continuousTester) alertOn(err error) {
+	switch ct.alert.Record(err) {
+	case alerting.Trigger:
+		ct.sendPagerdutyEvent(err)
+	case alerting.Resolve:
+		ct.sendPagerdutyEvent(nil)
+	case alerting.Silenced:
+		st := ct.alert.Status()
+		messenger.SendMessage(fmt.Sprintf(":zipper_mouth: Not paging for %s test of %s, %s", ct.name, ct.host, st.SilenceReason()))
+	default:
+		if err != nil {
+			st := ct.alert.Status()
+			glog.Infof("Not paging for %s test of %s yet, consecutive failures=%d recent failures=%d/%d", ct.name, ct.host,
+				st.ConsecutiveFailures, st.RecentFailures, st.RecentRuns)
+		}
+	}
+}
+
 func (ct *continuousTester) sendPagerdutyEvent(err error) {
 	if ct.pagerDutyIntegrationKey == "" {
 		return
 	}
-	severity, lopriPrefix, dedupKey := "error", "", fmt.Sprintf("cont-%s-tester:%s", ct.name, ct.host)
+	severity, lopriPrefix, dedupKey := "error", "", ct.dedupKey()
 	if ct.pagerDutyLowUrgency {
 		severity, lopriPrefix = "warning", "[LOPRI] "
-		dedupKey = "lopri-" + dedupKey
 	}
 	event := pagerduty.V2Event{
 		RoutingKey: ct.pagerDutyIntegrationKey,
@@ -126,6 +202,12 @@ func (ct *continuousTester) sendPagerdutyEvent(err error) {
 		Summary:   summary,
 		Timestamp: time.Now().UTC().Format(time.RFC3339),
 	}
+	if location := testers.BundleLocation(err); location != "" {
+		event.Payload.Details = map[string]string{"failure_bundle": location}
+		if strings.HasPrefix(location, "http") {
+			event.Links = append(event.Links, map[string]string{"href": location, "text": "Failure bundle"})
+		}
+	}
 	resp, err := pagerduty.ManageEvent(event)
 	if err != nil {
 		glog.Error(fmt.Errorf("PAGERDUTY Error: %w", err))
@@ -0,0 +1,265 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/livepeer/stream-tester/internal/alerting"
+)
+
+// number of results of the runs kept in memory
+const maxRunResults = 64
+
+// ErrStoppedByCommand error the run is cancelled with when tester is stopped
+// through the bot command
+var ErrStoppedByCommand = errors.New("stopped by command")
+
+type (
+	// Control lets continuous tester to be stopped, resumed and inspected
+	// while running (through the bot commands)
+	Control struct {
+		name      string
+		mu        sync.Mutex
+		stopped   bool
+		resumeCh  chan struct{}
+		runCancel context.CancelFunc
+		run       *RunResult
+		// current run is cancelled by Stop
+		runStopped bool
+		runs       int
+		results    []RunResult
+		alerts     []*alerting.Tracker
+		liveStats  func() string
+		adhoc      func(dur time.Duration) error
+	}
+
+	// RunResult result of one run of the test
+	RunResult struct {
+		Num      int
+		Started  time.Time
+		Finished time.Time
+		Deadline time.Time
+		Err      error
+	}
+)
+
+var (
+	controlsMu sync.Mutex
+	controls   = make(map[string]*Control)
+)
+
+// NewControl returns control of the tester, registered under the name for
+// the bot commands
+func NewControl(name string, alerts ...*alerting.Tracker) *Control {
+	c := &Control{
+		name:     name,
+		resumeCh: make(chan struct{}),
+		alerts:   alerts,
+	}
+	controlsMu.Lock()
+	controls[name] = c
+	controlsMu.Unlock()
+	return c
+}
+
+// Controls returns controls of all the testers, sorted by name
+func Controls() []*Control {
+	controlsMu.Lock()
+	res := make([]*Control, 0, len(controls))
+	for _, c := range controls {
+		res = append(res, c)
+	}
+	controlsMu.Unlock()
+	sort.Slice(res, func(i, j int) bool { return res[i].name < res[j].name })
+	return res
+}
+
+// FindControl returns control by the name. Prefix of the name is enough if
+// it is not ambiguous
+func FindControl(name string) (*Control, error) {
+	var found []*Control
+	for _, c := range Controls() {
+		if c.name == name {
+			return c, nil
+		}
+		if strings.HasPrefix(c.name, name) {
+			found = append(found, c)
+		}
+	}
+	if len(found) == 1 {
+		return found[0], nil
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("no tester %q", name)
+	}
+	return nil, fmt.Errorf("tester name %q is ambiguous", name)
+}
+
+// Name of the tester
+func (c *Control) Name() string {
+	return c.name
+}
+
+// Alerts returns trackers of the tester's alerts
+func (c *Control) Alerts() []*alerting.Tracker {
+	return c.alerts
+}
+
+// SetLiveStats sets function returning stats of the current run
+func (c *Control) SetLiveStats(f func() string) {
+	c.mu.Lock()
+	c.liveStats = f
+	c.mu.Unlock()
+}
+
+// SetAdHoc sets function running ad-hoc test, outside of the continuous
+// tester's loop
+func (c *Control) SetAdHoc(f func(dur time.Duration) error) {
+	c.mu.Lock()
+	c.adhoc = f
+	c.mu.Unlock()
+}
+
+// AdHoc returns function running ad-hoc test, nil if the tester does not
+// support it
+func (c *Control) AdHoc() func(dur time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.adhoc
+}
+
+// Stop stops the tester: current run is cancelled and next one is not
+// started until Resume. Returns false if already stopped
+func (c *Control) Stop() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopped {
+		return false
+	}
+	c.stopped = true
+	if c.runCancel != nil {
+		c.runCancel()
+		c.runStopped = true
+	}
+	return true
+}
+
+// Resume resumes stopped tester. Returns false if it is not stopped
+func (c *Control) Resume() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.stopped {
+		return false
+	}
+	c.stopped = false
+	close(c.resumeCh)
+	c.resumeCh = make(chan struct{})
+	return true
+}
+
+// Stopped returns true if tester is stopped
+func (c *Control) Stopped() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stopped
+}
+
+// WaitResumed blocks while tester is stopped
+func (c *Control) WaitResumed(ctx context.Context) error {
+	for {
+		c.mu.Lock()
+		stopped, resumeCh := c.stopped, c.resumeCh
+		c.mu.Unlock()
+		if !stopped {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-resumeCh:
+		}
+	}
+}
+
+// BeginRun starts new run of the test, returned context is cancelled when
+// the tester is stopped
+func (c *Control) BeginRun(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.runs++
+	now := time.Now()
+	c.run = &RunResult{Num: c.runs, Started: now, Deadline: now.Add(timeout)}
+	c.runCancel = cancel
+	c.runStopped = c.stopped
+	if c.stopped {
+		cancel()
+	}
+	return ctx, cancel
+}
+
+// EndRun records result of the current run. Returns true if the run was
+// cancelled because tester is stopped
+func (c *Control) EndRun(err error) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.run == nil {
+		return false
+	}
+	run := *c.run
+	run.Finished = time.Now()
+	run.Err = err
+	stopped := c.runStopped
+	if stopped {
+		run.Err = ErrStoppedByCommand
+	}
+	c.results = append(c.results, run)
+	if len(c.results) > maxRunResults {
+		c.results = c.results[len(c.results)-maxRunResults:]
+	}
+	c.run = nil
+	c.runCancel = nil
+	c.runStopped = false
+	return stopped
+}
+
+// Current returns current run, nil if test is not running
+func (c *Control) Current() *RunResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.run == nil {
+		return nil
+	}
+	run := *c.run
+	return &run
+}
+
+// LiveStats returns stats of the current run, as reported by the tester
+func (c *Control) LiveStats() string {
+	c.mu.Lock()
+	f := c.liveStats
+	c.mu.Unlock()
+	if f == nil {
+		return ""
+	}
+	return f()
+}
+
+// Results returns last n results, latest first
+func (c *Control) Results(n int) []RunResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n > len(c.results) || n <= 0 {
+		n = len(c.results)
+	}
+	res := make([]RunResult, n)
+	for i := range res {
+		res[i] = c.results[len(c.results)-1-i]
+	}
+	return res
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/golang/glog"
 	"github.com/livepeer/go-api-client"
+	"github.com/livepeer/stream-tester/internal/report"
 	"time"
 )
 
@@ -14,11 +15,14 @@ type (
 		CancelFunc               context.CancelFunc
 		CatalystPipelineStrategy string
 		Lapi                     *api.Client
+		Report                   *report.Suite
 	}
 
 	TesterOptions struct {
 		API                      *api.Client
 		CatalystPipelineStrategy string
+		// Report to add results of the checks to (optional)
+		Report *report.Suite
 	}
 )
 
@@ -50,6 +54,13 @@ func (ta *TesterApp) WaitTaskProcessing(taskPollDuration time.Duration, processi
 	}
 }
 
+// Check returns function which runs the check and adds its result to the report
+func (ta *TesterApp) Check(class, name string, check func() error) func() error {
+	return func() error {
+		return ta.Report.Run(class, name, check)
+	}
+}
+
 func (ta *TesterApp) isCancelled() error {
 	select {
 	case <-ta.Ctx.Done():
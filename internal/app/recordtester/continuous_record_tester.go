@@ -6,11 +6,16 @@ import (
 	"fmt"
 	"net/url"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/PagerDuty/go-pagerduty"
 
 	"github.com/golang/glog"
+	"github.com/livepeer/stream-tester/internal/alerting"
+	"github.com/livepeer/stream-tester/internal/app/common"
+	"github.com/livepeer/stream-tester/internal/history"
 	"github.com/livepeer/stream-tester/internal/testers"
 	"github.com/livepeer/stream-tester/messenger"
 )
@@ -28,6 +33,10 @@ type (
 		PagerDutyIntegrationKey string
 		PagerDutyComponent      string
 		PagerDutyLowUrgency     bool
+		// AlertPolicy when to trigger and resolve PagerDuty incidents (optional)
+		AlertPolicy *alerting.Policy
+		// History store to save results of the tests to (optional)
+		History *history.Store
 		RecordTesterOptions
 	}
 
@@ -40,6 +49,12 @@ type (
 		pagerDutyLowUrgency     bool
 		rtOpts                  RecordTesterOptions
 		serfOpts                SerfOptions
+		history                 *history.Store
+		alert                   *alerting.Tracker
+		streamHealthAlert       *alerting.Tracker
+		control                 *common.Control
+		mu                      sync.Mutex
+		current                 IRecordTester // record tester of the current run
 	}
 
 	pagerDutyLink struct {
@@ -62,7 +77,12 @@ func NewContinuousRecordTester(gctx context.Context, opts ContinuousRecordTester
 		pagerDutyLowUrgency:     opts.PagerDutyLowUrgency,
 		rtOpts:                  opts.RecordTesterOptions,
 		serfOpts:                serfOpts,
+		history:                 opts.History,
 	}
+	crt.alert = alerting.NewTracker(crt.dedupKey(false), opts.AlertPolicy)
+	crt.streamHealthAlert = alerting.NewTracker(crt.dedupKey(true), opts.AlertPolicy)
+	crt.control = common.NewControl("record", crt.alert, crt.streamHealthAlert)
+	crt.control.SetLiveStats(crt.liveStats)
 	return crt
 }
 
@@ -71,16 +91,43 @@ func (crt *continuousRecordTester) Start(fileName string, testDuration, pauseDur
 	try := 0
 	notRtmpTry := 0
 	maxTestDuration := 2*testDuration + pauseDuration + 15*time.Minute
+	crt.control.SetAdHoc(func(dur time.Duration) error {
+		ctx, cancel := context.WithTimeout(crt.ctx, 2*dur+pauseDuration+15*time.Minute)
+		defer cancel()
+		rt := NewRecordTester(ctx, crt.rtOpts, crt.serfOpts)
+		es, err := rt.Start(fileName, dur, pauseDuration)
+		rt.Clean()
+		return runError(es, err, nil)
+	})
 	for {
+		if crt.control.WaitResumed(crt.ctx) != nil {
+			messenger.SendMessage(fmt.Sprintf("Continuous record test of %s cancelled", crt.host))
+			return crt.ctx.Err()
+		}
 		msg := fmt.Sprintf(":arrow_right: Starting %s recordings test stream to %s", 2*testDuration, crt.host)
 		messenger.SendMessage(msg)
 
-		ctx, cancel := context.WithTimeout(crt.ctx, maxTestDuration)
+		ctx, cancel := crt.control.BeginRun(crt.ctx, maxTestDuration)
 		rt := NewRecordTester(ctx, crt.rtOpts, crt.serfOpts)
+		crt.setCurrent(rt)
+		hrec := history.NewRecord("recordtester", crt.host)
 		es, err := rt.Start(fileName, testDuration, pauseDuration)
 		rt.Clean()
 		ctxErr := ctx.Err()
 		cancel()
+		crt.setCurrent(nil)
+		if crt.control.EndRun(runError(es, err, ctxErr)) && crt.ctx.Err() == nil {
+			messenger.SendMessage(fmt.Sprintf(":stop_button: Continuous record test of %s stopped", crt.host))
+			try = 0
+			notRtmpTry = 0
+			continue
+		}
+		if crt.ctx.Err() == nil {
+			vs := rt.VODStats()
+			hrec.SetVODStats(&vs)
+			hrec.Finish(es, err)
+			crt.history.Save(hrec)
+		}
 
 		if crt.ctx.Err() != nil {
 			messenger.SendMessage(fmt.Sprintf("Continuous record test of %s cancelled", crt.host))
@@ -106,21 +153,24 @@ func (crt *continuousRecordTester) Start(fileName string, testDuration, pauseDur
 				time.Sleep(5 * time.Second)
 				continue
 			}
-			msg := fmt.Sprintf(":rotating_light: Test of %s ended with err=%v errCode=%v", crt.host, err, es)
+			if err != nil && testers.BundleLocation(err) == "" {
+				err = crt.saveBundle(rt, err, es)
+			}
+			msg := testers.WithBundleLocation(fmt.Sprintf(":rotating_light: Test of %s ended with err=%v errCode=%v", crt.host, err, es), err)
 			messenger.SendFatalMessage(msg)
 			glog.Warning(msg)
 			var sherr error
 			if errors.As(err, &testers.StreamHealthError{}) {
 				sherr, err = err, nil
 			}
-			crt.sendPagerdutyEvent(rt, err, false)
-			crt.sendPagerdutyEvent(rt, sherr, true)
+			crt.alertOn(rt, err, false)
+			crt.alertOn(rt, sherr, true)
 		} else {
 			msg := fmt.Sprintf(":white_check_mark: Test of %s succeeded", crt.host)
 			messenger.SendMessage(msg)
 			glog.Info(msg)
-			crt.sendPagerdutyEvent(rt, nil, false)
-			crt.sendPagerdutyEvent(rt, nil, true)
+			crt.alertOn(rt, nil, false)
+			crt.alertOn(rt, nil, true)
 		}
 		try = 0
 		notRtmpTry = 0
@@ -134,18 +184,51 @@ func (crt *continuousRecordTester) Start(fileName string, testDuration, pauseDur
 	}
 }
 
+func (crt *continuousRecordTester) dedupKey(isStreamHealth bool) string {
+	dedupKey := fmt.Sprintf("cont-record-tester:%s", crt.host)
+	if crt.pagerDutyLowUrgency || isStreamHealth {
+		dedupKey = "lopri-" + dedupKey
+	}
+	if isStreamHealth {
+		dedupKey = "stream-health-" + dedupKey
+	}
+	return dedupKey
+}
+
+// alertOn records result of the test and triggers or resolves PagerDuty
+// incident if the alert policy says so
+func (crt *continuousRecordTester) alertOn(rt IRecordTester, err error, isStreamHealth bool) {
+	alert := crt.alert
+	if isStreamHealth {
+		alert = crt.streamHealthAlert
+	}
+	switch alert.Record(err) {
+	case alerting.Trigger:
+		crt.sendPagerdutyEvent(rt, err, isStreamHealth)
+	case alerting.Resolve:
+		crt.sendPagerdutyEvent(rt, nil, isStreamHealth)
+	case alerting.Silenced:
+		st := alert.Status()
+		messenger.SendMessage(fmt.Sprintf(":zipper_mouth: Not paging for %s, %s", st.Name, st.SilenceReason()))
+	default:
+		if err != nil {
+			st := alert.Status()
+			glog.Infof("Not paging for %s yet, consecutive failures=%d recent failures=%d/%d", st.Name,
+				st.ConsecutiveFailures, st.RecentFailures, st.RecentRuns)
+		}
+	}
+}
+
 func (crt *continuousRecordTester) sendPagerdutyEvent(rt IRecordTester, err error, isStreamHealth bool) {
 	if crt.pagerDutyIntegrationKey == "" {
 		return
 	}
-	severity, lopriPrefix, dedupKey := "error", "", fmt.Sprintf("cont-record-tester:%s", crt.host)
+	severity, lopriPrefix, dedupKey := "error", "", crt.dedupKey(isStreamHealth)
 	if crt.pagerDutyLowUrgency || isStreamHealth {
 		severity, lopriPrefix = "warning", "[LOPRI] "
-		dedupKey = "lopri-" + dedupKey
 	}
 	componentName := ":movie_camera: LIVE"
 	if isStreamHealth {
-		dedupKey = "stream-health-" + dedupKey
 		componentName = ":hospital: STREAM_HEALTH"
 	}
 	event := pagerduty.V2Event{
@@ -184,6 +267,12 @@ func (crt *continuousRecordTester) sendPagerdutyEvent(rt IRecordTester, err erro
 			event.Links = append(event.Links, link)
 		}
 	}
+	if location := testers.BundleLocation(err); location != "" {
+		event.Payload.Details = map[string]string{"failure_bundle": location}
+		if strings.HasPrefix(location, "http") {
+			event.Links = append(event.Links, pagerDutyLink{Href: location, Text: "Failure bundle"})
+		}
+	}
 	resp, err := pagerduty.ManageEvent(event)
 	if err != nil {
 		glog.Error(fmt.Errorf("PAGERDUTY Error: %w", err))
@@ -193,6 +282,53 @@ func (crt *continuousRecordTester) sendPagerdutyEvent(rt IRecordTester, err erro
 	}
 }
 
+// saveBundle saves failure bundle with stats of the failed test
+func (crt *continuousRecordTester) saveBundle(rt IRecordTester, err error, es int) error {
+	b := testers.NewBundle("record_"+crt.host, err)
+	stats := map[string]interface{}{
+		"host":      crt.host,
+		"exit_code": es,
+		"vod_stats": rt.VODStats(),
+	}
+	if stream := rt.Stream(); stream != nil {
+		stats["stream_id"], stats["playback_id"] = stream.ID, stream.PlaybackID
+	}
+	b.AddJSON("stats.json", stats)
+	return testers.SaveBundle(b)
+}
+
+func (crt *continuousRecordTester) setCurrent(rt IRecordTester) {
+	crt.mu.Lock()
+	crt.current = rt
+	crt.mu.Unlock()
+}
+
+func (crt *continuousRecordTester) liveStats() string {
+	crt.mu.Lock()
+	rt := crt.current
+	crt.mu.Unlock()
+	if rt == nil {
+		return ""
+	}
+	stream := rt.Stream()
+	if stream == nil {
+		return "Stream is not created yet"
+	}
+	return fmt.Sprintf("Stream id=%s playbackId=%s\nhttps://livepeer.com/dashboard/streams/%s", stream.ID, stream.PlaybackID, stream.ID)
+}
+
+// runError returns error the run ended with, including non-zero exit code
+// and timeout
+func runError(es int, err, ctxErr error) error {
+	if err != nil {
+		return err
+	}
+	if es != 0 {
+		return fmt.Errorf("exit code %d", es)
+	}
+	return ctxErr
+}
+
 func (crt *continuousRecordTester) Cancel() {
 	crt.cancel()
 }
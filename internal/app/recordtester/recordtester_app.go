@@ -9,6 +9,7 @@ import (
 	"math/rand"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
@@ -16,6 +17,7 @@ import (
 	api "github.com/livepeer/go-api-client"
 	"github.com/livepeer/joy4/format/mp4"
 	"github.com/livepeer/joy4/format/mp4/mp4io"
+	"github.com/livepeer/stream-tester/internal/report"
 	"github.com/livepeer/stream-tester/internal/testers"
 	"github.com/livepeer/stream-tester/messenger"
 	"github.com/livepeer/stream-tester/model"
@@ -53,6 +55,8 @@ type (
 		UseHTTP             bool
 		TestMP4             bool
 		TestStreamHealth    bool
+		// Report to add results of the checks to (optional)
+		Report *report.Suite
 	}
 
 	recordTester struct {
@@ -67,8 +71,10 @@ type (
 		mp4                 bool
 		streamHealth        bool
 		serfOpts            SerfOptions
+		report              *report.Suite
 
 		// mutable fields
+		mu       sync.Mutex
 		streamID string
 		stream   *api.Stream
 		vodStats model.VODStats
@@ -90,17 +96,25 @@ func NewRecordTester(gctx context.Context, opts RecordTesterOptions, serfOpts Se
 		mp4:                 opts.TestMP4,
 		streamHealth:        opts.TestStreamHealth,
 		serfOpts:            serfOpts,
+		report:              opts.Report,
 	}
 	return rt
 }
 
 func (rt *recordTester) Start(fileName string, testDuration, pauseDuration time.Duration) (int, error) {
+	steps := rt.report.Steps("live", "live streaming")
+	es, err := rt.start(fileName, testDuration, pauseDuration, steps)
+	steps.End(es, err)
+	return es, err
+}
+
+func (rt *recordTester) start(fileName string, testDuration, pauseDuration time.Duration, steps *report.Steps) (int, error) {
 	defer rt.cancel()
 	var err error
 	var broadcasters []string
 	ingest, err := rt.getIngestInfo()
 	if err != nil {
-		return 255, err
+		return model.ExitCodeAPIError, err
 	}
 	apiTry := 0
 	for {
@@ -111,7 +125,7 @@ func (rt *recordTester) Start(fileName string, testDuration, pauseDuration time.
 				continue
 			}
 			// exit(255, fileName, *fileArg, err)
-			return 255, err
+			return model.ExitCodeAPIError, err
 		}
 		break
 	}
@@ -126,9 +140,9 @@ func (rt *recordTester) Start(fileName string, testDuration, pauseDuration time.
 	*/
 	if rt.useHTTP && len(broadcasters) == 0 {
 		// exit(254, fileName, *fileArg, errors.New("Empty list of broadcasters"))
-		return 254, errors.New("empty list of broadcasters")
+		return model.ExitCodeNoIngest, errors.New("empty list of broadcasters")
 	} else if (!rt.useHTTP && ingest.Ingest == "") || ingest.Playback == "" {
-		return 254, errors.New("empty ingest URLs")
+		return model.ExitCodeNoIngest, errors.New("empty ingest URLs")
 		// exit(254, fileName, *fileArg, errors.New("Empty list of ingests"))
 	}
 	// glog.Infof("All cool!")
@@ -144,13 +158,15 @@ func (rt *recordTester) Start(fileName string, testDuration, pauseDuration time.
 			}
 			glog.Errorf("Error creating stream using Livepeer API: %v", err)
 			// exit(253, fileName, *fileArg, err)
-			return 253, err
+			return model.ExitCodeCreateStream, err
 		}
 		break
 	}
 	apiTry = 0
+	rt.mu.Lock()
 	rt.streamID = stream.ID
 	rt.stream = stream
+	rt.mu.Unlock()
 	messenger.SendMessage(fmt.Sprintf(":information_source: Created stream id=%s", stream.ID))
 	// createdAPIStreams = append(createdAPIStreams, stream.ID)
 	glog.V(model.VERBOSE).Infof("Created Livepeer stream id=%s streamKey=%s playbackId=%s name=%s", stream.ID, stream.StreamKey, stream.PlaybackID, streamName)
@@ -181,7 +197,7 @@ func (rt *recordTester) Start(fileName string, testDuration, pauseDuration time.
 		sterr := rt.doOneHTTPStream(fileName, streamName, broadcasters[0], testDuration, stream)
 		if sterr != nil {
 			glog.Warningf("Streaming returned error err=%v", sterr)
-			return 3, err
+			return model.ExitCodeStreamingFailed, err
 		}
 		if pauseDuration > 0 {
 			glog.Infof("Pause specified, waiting %s before streaming second time", pauseDuration)
@@ -189,7 +205,7 @@ func (rt *recordTester) Start(fileName string, testDuration, pauseDuration time.
 			sterr = rt.doOneHTTPStream(fileName, streamName, broadcasters[0], testDuration, stream)
 			if sterr != nil {
 				glog.Warningf("Second time streaming returned error err=%v", sterr)
-				return 3, err
+				return model.ExitCodeStreamingFailed, err
 			}
 			testDuration *= 2
 		}
@@ -202,16 +218,16 @@ func (rt *recordTester) Start(fileName string, testDuration, pauseDuration time.
 		glog.Infof("Streaming stream id=%s done err=%v", stream.ID, srerr)
 		var re *testers.RTMPError
 		if errors.As(srerr, &re) {
-			return 2, re
+			return model.ExitCodeRTMPError, re
 		}
 		if srerr != nil {
 			glog.Warningf("Streaming returned error err=%v", srerr)
-			return 3, srerr
+			return model.ExitCodeStreamingFailed, srerr
 		}
 		stats, err := sr2.Stats()
 		if err != nil {
 			glog.Warningf("Stats returned error err=%v", err)
-			return 21, err
+			return model.ExitCodeStatsError, err
 		}
 		glog.Infof("Streaming success rate=%v", stats.SuccessRate)
 		if err = rt.isCancelled(); err != nil {
@@ -227,16 +243,16 @@ func (rt *recordTester) Start(fileName string, testDuration, pauseDuration time.
 			glog.Infof("Streaming second stream id=%s done", stream.ID)
 			var re *testers.RTMPError
 			if errors.As(srerr, &re) {
-				return 2, re
+				return model.ExitCodeRTMPError, re
 			}
 			if srerr != nil {
 				glog.Warningf("Streaming second returned error err=%v", srerr)
-				return 3, srerr
+				return model.ExitCodeStreamingFailed, srerr
 			}
 			stats, err := sr2.Stats()
 			if err != nil {
 				glog.Warningf("Stats returned error err=%v", err)
-				return 21, err
+				return model.ExitCodeStatsError, err
 			}
 			glog.Infof("Streaming second time success rate=%v", stats.SuccessRate)
 			if err = rt.isCancelled(); err != nil {
@@ -248,6 +264,7 @@ func (rt *recordTester) Start(fileName string, testDuration, pauseDuration time.
 	if err := rt.isCancelled(); err != nil {
 		return 0, err
 	}
+	steps.Next("recording status")
 	glog.Infof("Waiting 10 seconds")
 	time.Sleep(10 * time.Second)
 	// now get sessions
@@ -255,25 +272,25 @@ func (rt *recordTester) Start(fileName string, testDuration, pauseDuration time.
 	if err != nil {
 		glog.Errorf("Error getting sessions for stream id=%s err=%v", stream.ID, err)
 		// exit(252, fileName, *fileArg, err)
-		return 252, err
+		return model.ExitCodeGetSessions, err
 	}
 	glog.Infof("Sessions: %+v", sessions)
 	if len(sessions) != 1 {
 		err := fmt.Errorf("should have one session, got %d", len(sessions))
 		glog.Error(err)
 		// exit(251, fileName, *fileArg, err)
-		return 251, err
+		return model.ExitCodeSessionsMismatch, err
 	}
 	sess := sessions[0]
 	if len(sess.Profiles) != len(stream.Profiles) {
 		glog.Infof("session: %+v", sess)
 		err := fmt.Errorf("got %d profiles but should have %d", len(sess.Profiles), len(stream.Profiles))
-		return 251, err
+		return model.ExitCodeSessionsMismatch, err
 		// exit(251, fileName, *fileArg, err)
 	}
 	if sess.RecordingStatus != api.RecordingStatusWaiting {
 		err := fmt.Errorf("recording status is %s but should be %s", sess.RecordingStatus, api.RecordingStatusWaiting)
-		return 250, err
+		return model.ExitCodeRecordingStatus, err
 		// exit(250, fileName, *fileArg, err)
 	}
 	if err = rt.isCancelled(); err != nil {
@@ -293,7 +310,7 @@ func (rt *recordTester) Start(fileName string, testDuration, pauseDuration time.
 	sessions, err = rt.lapi.GetSessionsNew(stream.ID, rt.useForceURL)
 	if err != nil {
 		err := fmt.Errorf("error getting sessions for stream id=%s err=%v", stream.ID, err)
-		return 252, err
+		return model.ExitCodeGetSessions, err
 		// exit(252, fileName, *fileArg, err)
 	}
 	glog.Infof("Sessions: %+v", sessions)
@@ -308,12 +325,12 @@ func (rt *recordTester) Start(fileName string, testDuration, pauseDuration time.
 	}
 	if sess.RecordingStatus != statusShould {
 		err := fmt.Errorf("recording status is %s but should be %s", sess.RecordingStatus, statusShould)
-		return 240, err
+		return model.ExitCodeRecordingNotReady, err
 		// exit(250, fileName, *fileArg, err)
 	}
 	if sess.RecordingURL == "" {
 		err := fmt.Errorf("recording URL should appear by now")
-		return 249, err
+		return model.ExitCodeNoRecordingURL, err
 		// exit(249, fileName, *fileArg, err)
 	}
 	glog.Infof("recordingURL=%s downloading now", sess.RecordingURL)
@@ -331,12 +348,14 @@ func (rt *recordTester) Start(fileName string, testDuration, pauseDuration time.
 		return 0, err
 	}
 	if rt.mp4 {
+		steps.Next("mp4")
 		es, err := rt.checkDownMp4(stream, sess.Mp4Url, testDuration, pauseDuration > 0)
 		if err != nil {
 			return es, err
 		}
 	}
 
+	steps.Next("recording playback")
 	es, err := rt.checkDown(stream, sess.RecordingURL, testDuration, pauseDuration > 0)
 	if es == 0 {
 		rt.lapi.DeleteStream(stream.ID)
@@ -410,6 +429,9 @@ func (rt *recordTester) isCancelled() error {
 	return nil
 }
 
+// recordings bigger than that are not included in the failure bundle
+const maxBundledMP4Size = 64 * 1024 * 1024
+
 func (rt *recordTester) checkDownMp4(stream *api.Stream, url string, streamDuration time.Duration, doubled bool) (int, error) {
 	es := 0
 	started := time.Now()
@@ -418,17 +440,17 @@ func (rt *recordTester) checkDownMp4(stream *api.Stream, url string, streamDurat
 	resp, err := http.Get(url)
 	if err != nil {
 		glog.Warningf("Error downloading mp4 for manifestID=%s url=%s", stream.ID, url)
-		return 3, err
+		return model.ExitCodeStreamingFailed, err
 	}
 	if resp.StatusCode != http.StatusOK {
 		glog.Warningf("HTTP error downloading mp4 for manifestID=%s url=%s status=%s", stream.ID, url, resp.Status)
-		return 3, err
+		return model.ExitCodeStreamingFailed, err
 	}
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		glog.Warningf("HTTP error downloading mp4 for manifestID=%s url=%s err=%v", stream.ID, url, err)
-		return 3, err
+		return model.ExitCodeStreamingFailed, err
 	}
 	glog.Infof("Downloaded bytes=%b manifestID=%s url=%s took=%s", len(body), stream.ID, url, time.Since(started))
 	bodyR := bytes.NewReader(body)
@@ -436,13 +458,13 @@ func (rt *recordTester) checkDownMp4(stream *api.Stream, url string, streamDurat
 	streams, err := dem.Streams()
 	if err != nil {
 		glog.Warningf("Error parsing mp4 for manifestID=%s url=%s err=%v", stream.ID, url, err)
-		return 203, err
+		return model.ExitCodeMP4Invalid, err
 	}
 	glog.Infof("Got %d streams in mp4 file manifestID=%s url=%s", len(streams), stream.ID, url)
 	dur, err := calcMP4FileDuration(body)
 	if err != nil {
 		glog.Warningf("Error parsing mp4 for manifestID=%s url=%s err=%v", stream.ID, url, err)
-		return 203, err
+		return model.ExitCodeMP4Invalid, err
 	}
 	durDiffShould := 2 * time.Second
 	if doubled {
@@ -455,7 +477,19 @@ func (rt *recordTester) checkDownMp4(stream *api.Stream, url string, streamDurat
 	if durDiff > durDiffShould {
 		ers := fmt.Errorf("duration of mp4 differ by %s (got %s, should %s)", durDiff, dur, streamDuration)
 		glog.Error(ers)
-		return 300, err
+		b := testers.NewBundle("mp4_"+stream.ID, ers)
+		b.AddJSON("mp4.json", map[string]interface{}{
+			"stream_id":       stream.ID,
+			"playback_id":     stream.PlaybackID,
+			"url":             url,
+			"duration":        dur.String(),
+			"should_duration": streamDuration.String(),
+			"bytes":           len(body),
+		})
+		if len(body) <= maxBundledMP4Size {
+			b.AddFile("recording.mp4", body)
+		}
+		return model.ExitCodeMP4Duration, testers.SaveBundle(b)
 	}
 	return es, nil
 }
@@ -473,14 +507,26 @@ func (rt *recordTester) checkDown(stream *api.Stream, url string, streamDuration
 	rt.vodStats = vs
 	if len(vs.SegmentsNum) != len(api.StandardProfiles)+1 {
 		glog.Warningf("Number of renditions doesn't match! Has %d should %d", len(vs.SegmentsNum), len(api.StandardProfiles)+1)
-		es = 35
+		es = model.ExitCodeRenditionsMismatch
 	}
 	glog.Infof("Stats for %s: %s", stream.ID, vs.String())
 	glog.Infof("Stats for %s raw: %+v", stream.ID, vs)
 	if ok, ers := vs.IsOk(streamDuration, doubled); !ok {
 		glog.Warningf("NOT OK! (%s)", ers)
-		es = 36
-		return es, errors.New(ers)
+		es = model.ExitCodeVODCheckFailed
+		b := testers.NewBundle("recording_"+stream.ID, errors.New(ers))
+		if bc, ok := downloader.(testers.BundleCollector); ok {
+			bc.CollectBundle(b)
+		}
+		b.AddJSON("vod_stats.json", map[string]interface{}{
+			"stream_id":       stream.ID,
+			"playback_id":     stream.PlaybackID,
+			"url":             url,
+			"should_duration": streamDuration.String(),
+			"doubled":         doubled,
+			"stats":           vs,
+		})
+		return es, testers.SaveBundle(b)
 	} else {
 		glog.Infoln("All ok!")
 	}
@@ -506,10 +552,14 @@ func (rt *recordTester) Clean() {
 }
 
 func (rt *recordTester) StreamID() string {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
 	return rt.streamID
 }
 
 func (rt *recordTester) Stream() *api.Stream {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
 	return rt.stream
 }
 
@@ -0,0 +1,61 @@
+package recordtester
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	api "github.com/livepeer/go-api-client"
+	"github.com/livepeer/joy4/format"
+	"github.com/livepeer/stream-tester/internal/fakeapi"
+	"github.com/livepeer/stream-tester/internal/metrics"
+	"github.com/livepeer/stream-tester/internal/mockbroadcaster"
+	"github.com/livepeer/stream-tester/model"
+)
+
+func TestMain(m *testing.M) {
+	format.RegisterAll()
+	metrics.InitCensus("test", model.Version, "recordtester")
+	os.Exit(m.Run())
+}
+
+// TestRecordE2E runs the record test against fake API and mock broadcaster.
+// It needs video file (like short mp4 with keyframes every second or two),
+// path to which is taken from STREAM_TESTER_E2E_FILE
+func TestRecordE2E(t *testing.T) {
+	if testing.Short() {
+		t.Skip("end-to-end test takes half a minute")
+	}
+	fileName := os.Getenv("STREAM_TESTER_E2E_FILE")
+	if fileName == "" {
+		t.Skip("STREAM_TESTER_E2E_FILE is not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	b := mockbroadcaster.New(mockbroadcaster.Options{})
+	if err := b.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	fapi := fakeapi.New(fakeapi.Options{Token: "secret", Broadcaster: b})
+	if err := fapi.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	lapi := api.NewAPIClient(api.ClientOptions{Server: fapi.URL(), AccessToken: "secret", Timeout: 8 * time.Second})
+	rt := NewRecordTester(ctx, RecordTesterOptions{
+		API:         lapi,
+		UseForceURL: true,
+		UseHTTP:     true,
+	}, SerfOptions{})
+	es, err := rt.Start(fileName, 10*time.Second, 0)
+	if es != 0 || err != nil {
+		t.Fatalf("record test failed exitCode=%d err=%v", es, err)
+	}
+	vs := rt.VODStats()
+	if len(vs.SegmentsNum) != len(api.StandardProfiles)+1 {
+		t.Errorf("recording has %d renditions: %s", len(vs.SegmentsNum), vs.String())
+	}
+	if n := len(b.Streams()); n != 1 {
+		t.Errorf("broadcaster got %d streams", n)
+	}
+}
@@ -48,6 +48,7 @@ func NewTranscodeTester(gctx context.Context, opts common.TesterOptions) ITransc
 			Lapi:       opts.API,
 			Ctx:        ctx,
 			CancelFunc: cancel,
+			Report:     opts.Report,
 		},
 	}
 	return vt
@@ -59,31 +60,36 @@ func (tt *transcodeTester) Start(fileName, transcodeBucketUrl, transcodeW3sProof
 	eg, egCtx := errgroup.WithContext(tt.Ctx)
 
 	if transcodeBucketUrl != "" {
-		eg.Go(func() error {
+		eg.Go(tt.Check("transcode", "transcode from url", func() error {
 			if err := tt.transcodeS3FromUrlTester(fileName, transcodeBucketUrl, taskPollDuration); err != nil {
 				glog.Errorf("Error in transcode S3 from url err=%v", err)
 				return fmt.Errorf("error in transcode from url: %w", err)
 			}
 			return nil
-		})
+		}))
 
-		eg.Go(func() error {
+		eg.Go(tt.Check("transcode", "transcode from private bucket", func() error {
 			if err := tt.transcodeS3FromPrivateBucketTester(fileName, transcodeBucketUrl, taskPollDuration); err != nil {
 				glog.Errorf("Error in transcode S3 from private bucket err=%v", err)
 				return fmt.Errorf("error in transcode from private bucket: %w", err)
 			}
 			return nil
-		})
+		}))
+	} else {
+		tt.Report.Skip("transcode", "transcode from url", "no bucket URL specified")
+		tt.Report.Skip("transcode", "transcode from private bucket", "no bucket URL specified")
 	}
 
 	if transcodeW3sProof != "" {
-		eg.Go(func() error {
+		eg.Go(tt.Check("transcode", "transcode web3.storage", func() error {
 			if err := tt.transcodeWeb3StorageTester(fileName, transcodeW3sProof, taskPollDuration); err != nil {
 				glog.Errorf("Error in transcode web3.storage err=%v", err)
 				return fmt.Errorf("error in transcode web3.storage: %w", err)
 			}
 			return nil
-		})
+		}))
+	} else {
+		tt.Report.Skip("transcode", "transcode web3.storage", "no web3.storage proof specified")
 	}
 
 	go func() {
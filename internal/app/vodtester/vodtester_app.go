@@ -41,6 +41,7 @@ func NewVodTester(gctx context.Context, opts common.TesterOptions) IVodTester {
 			Ctx:                      ctx,
 			CancelFunc:               cancel,
 			CatalystPipelineStrategy: opts.CatalystPipelineStrategy,
+			Report:                   opts.Report,
 		},
 	}
 	return vt
@@ -51,7 +52,7 @@ func (vt *vodTester) Start(fileName string, vodImportUrl string, taskPollDuratio
 
 	eg, egCtx := errgroup.WithContext(vt.Ctx)
 
-	eg.Go(func() error {
+	eg.Go(vt.Check("vod", "vod import", func() error {
 
 		hostName, _ := os.Hostname()
 		assetName := fmt.Sprintf("vod_test_asset_%s_%s", hostName, time.Now().Format("2006-01-02T15:04:05Z07:00"))
@@ -97,9 +98,9 @@ func (vt *vodTester) Start(fileName string, vodImportUrl string, taskPollDuratio
 		}
 
 		return nil
-	})
+	}))
 
-	eg.Go(func() error {
+	eg.Go(vt.Check("vod", "direct upload", func() error {
 		err := vt.directUploadTester(fileName, taskPollDuration)
 
 		if err != nil {
@@ -108,9 +109,9 @@ func (vt *vodTester) Start(fileName string, vodImportUrl string, taskPollDuratio
 		}
 
 		return nil
-	})
+	}))
 
-	eg.Go(func() error {
+	eg.Go(vt.Check("vod", "resumable upload", func() error {
 		err := vt.resumableUploadTester(fileName, taskPollDuration)
 
 		if err != nil {
@@ -119,7 +120,7 @@ func (vt *vodTester) Start(fileName string, vodImportUrl string, taskPollDuratio
 		}
 
 		return nil
-	})
+	}))
 	go func() {
 		<-egCtx.Done()
 		vt.Cancel()
@@ -0,0 +1,249 @@
+// Package assertions checks results of the test run against the service
+// level objectives (minimum success rate, maximum latencies, etc)
+package assertions
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/livepeer/stream-tester/internal/history"
+	"github.com/livepeer/stream-tester/model"
+)
+
+// Status of the check
+type Status string
+
+// Check statuses
+const (
+	Pass Status = "PASS"
+	Fail Status = "FAIL"
+)
+
+// notMeasured actual value of the check whose metric wasn't measured by the
+// run. Such check fails: if it is asked for, metric should be there
+const notMeasured = "not measured"
+
+type (
+	// Assertions what should be true about the run for it to pass.
+	// Zero values are not checked
+	Assertions struct {
+		// MinSuccessRate minimum success rate, percent
+		MinSuccessRate float64
+		// MaxLatencyP95 maximum P95 of the transcoded segments latency
+		MaxLatencyP95 time.Duration
+		// MaxLatencyP99 maximum P99 of the transcoded segments latency
+		MaxLatencyP99 time.Duration
+		// MaxErrors maximum number of errors by category. "*" sets limit for all categories not listed
+		MaxErrors ErrorLimits
+		// Renditions renditions (resolutions) which should be present in the output
+		Renditions Renditions
+	}
+
+	// ErrorLimits maximum number of errors by category
+	ErrorLimits map[string]int
+
+	// Renditions required renditions, by name or by number
+	Renditions struct {
+		Names []string
+		Min   int
+	}
+
+	// Check result of one assertion
+	Check struct {
+		Name     string `json:"name"`
+		Expected string `json:"expected"`
+		Actual   string `json:"actual"`
+		Status   Status `json:"status"`
+	}
+
+	// Verdict results of all the assertions
+	Verdict struct {
+		Checks []*Check `json:"checks"`
+		Failed int      `json:"failed"`
+	}
+)
+
+// AddFlags adds `-assert-*` flags to the flag set. Returned assertions are filled when flags are parsed
+func AddFlags(fs *flag.FlagSet) *Assertions {
+	a := &Assertions{MaxErrors: make(ErrorLimits)}
+	fs.Float64Var(&a.MinSuccessRate, "assert-success-rate", 0, "Fail if success rate (percent) is lower than this")
+	fs.DurationVar(&a.MaxLatencyP95, "assert-latency-p95", 0, "Fail if P95 of transcode latency is bigger than this")
+	fs.DurationVar(&a.MaxLatencyP99, "assert-latency-p99", 0, "Fail if P99 of transcode latency is bigger than this")
+	fs.Var(a.MaxErrors, "assert-max-errors", "Maximum number of errors by category, in form category=num,... (* for any other category)")
+	fs.Var(&a.Renditions, "assert-renditions", "Renditions that should be present in the output (comma-separated resolutions) or minimum number of them")
+	return a
+}
+
+// Enabled returns true if there is anything to check
+func (a *Assertions) Enabled() bool {
+	return a != nil && (a.MinSuccessRate > 0 || a.MaxLatencyP95 > 0 || a.MaxLatencyP99 > 0 || len(a.MaxErrors) > 0 ||
+		len(a.Renditions.Names) > 0 || a.Renditions.Min > 0)
+}
+
+// Evaluate checks run's results
+func (a *Assertions) Evaluate(rec *history.Record) *Verdict {
+	v := &Verdict{}
+	if a.MinSuccessRate > 0 {
+		v.add("success rate", fmt.Sprintf(">= %.2f%%", a.MinSuccessRate), fmt.Sprintf("%.2f%%", rec.SuccessRate),
+			status(rec.SuccessRate >= a.MinSuccessRate))
+	}
+	for _, l := range []struct {
+		name       string
+		max, value time.Duration
+	}{{"transcode latency p95", a.MaxLatencyP95, rec.TranscodedLatencies.P95}, {"transcode latency p99", a.MaxLatencyP99, rec.TranscodedLatencies.P99}} {
+		if l.max <= 0 {
+			continue
+		}
+		if l.value == 0 {
+			v.add(l.name, "<= "+l.max.String(), notMeasured, Fail)
+			continue
+		}
+		v.add(l.name, "<= "+l.max.String(), l.value.Round(time.Millisecond).String(), status(l.value <= l.max))
+	}
+	if len(a.MaxErrors) > 0 {
+		errs := rec.ErrorCounts()
+		var cats []string
+		for cat := range errs {
+			cats = append(cats, cat)
+		}
+		for cat := range a.MaxErrors {
+			if _, ok := errs[cat]; !ok && cat != "*" {
+				cats = append(cats, cat)
+			}
+		}
+		sort.Strings(cats)
+		for _, cat := range cats {
+			max, ok := a.MaxErrors[cat]
+			if !ok {
+				if max, ok = a.MaxErrors["*"]; !ok {
+					continue
+				}
+			}
+			v.add("errors "+cat, fmt.Sprintf("<= %d", max), strconv.Itoa(errs[cat]), status(errs[cat] <= max))
+		}
+	}
+	if len(a.Renditions.Names) > 0 || a.Renditions.Min > 0 {
+		v.checkRenditions(&a.Renditions, rec.VODStats)
+	}
+	return v
+}
+
+func (v *Verdict) checkRenditions(r *Renditions, vs *model.VODStats) {
+	if vs == nil {
+		v.add("renditions", r.String(), notMeasured, Fail)
+		return
+	}
+	if r.Min > 0 {
+		have := len(vs.SegmentsNum)
+		v.add("renditions number", fmt.Sprintf(">= %d", r.Min), strconv.Itoa(have), status(have >= r.Min))
+	}
+	for _, name := range r.Names {
+		num := vs.SegmentsNum[name]
+		v.add("rendition "+name, "present", fmt.Sprintf("%d segments", num), status(num > 0))
+	}
+}
+
+// Apply evaluates assertions (if any), prints verdict and returns exit code
+// the tester should exit with. Non-zero exitCode of the run is preserved
+func (a *Assertions) Apply(rec *history.Record, exitCode int) int {
+	if !a.Enabled() {
+		return exitCode
+	}
+	v := a.Evaluate(rec)
+	fmt.Println(v.FormatForConsole())
+	if exitCode == model.ExitCodeOK && !v.Passed() {
+		return model.ExitCodeAssertionsFailed
+	}
+	return exitCode
+}
+
+func (v *Verdict) add(name, expected, actual string, st Status) {
+	v.Checks = append(v.Checks, &Check{Name: name, Expected: expected, Actual: actual, Status: st})
+	if st == Fail {
+		v.Failed++
+	}
+}
+
+func status(ok bool) Status {
+	if ok {
+		return Pass
+	}
+	return Fail
+}
+
+// Passed returns true if no assertions failed
+func (v *Verdict) Passed() bool {
+	return v.Failed == 0
+}
+
+// FormatForConsole formats verdict as table
+func (v *Verdict) FormatForConsole() string {
+	lines := []string{"========= Assertions: =========", fmt.Sprintf("%-28s %-20s %-20s %s", "check", "expected", "actual", "status")}
+	for _, c := range v.Checks {
+		lines = append(lines, fmt.Sprintf("%-28s %-20s %-20s %s", c.Name, c.Expected, c.Actual, c.Status))
+	}
+	if v.Passed() {
+		lines = append(lines, "Verdict: PASSED")
+	} else {
+		lines = append(lines, fmt.Sprintf("Verdict: FAILED (%d of %d checks failed)", v.Failed, len(v.Checks)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (el ErrorLimits) String() string {
+	var parts []string
+	for cat, max := range el {
+		parts = append(parts, fmt.Sprintf("%s=%d", cat, max))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+// Set parses limits in form `category=num,...`
+func (el ErrorLimits) Set(s string) error {
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid error limit %q, should be category=num", part)
+		}
+		max, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+		if err != nil || max < 0 {
+			return fmt.Errorf("invalid error limit %q", part)
+		}
+		cat := strings.TrimSpace(kv[0])
+		if cat != "*" {
+			cat = history.ErrorCategory(cat)
+		}
+		el[cat] = max
+	}
+	return nil
+}
+
+func (r *Renditions) String() string {
+	if r.Min > 0 {
+		return fmt.Sprintf(">= %d", r.Min)
+	}
+	return strings.Join(r.Names, ",")
+}
+
+// Set parses either number of renditions or comma-separated list of them
+func (r *Renditions) Set(s string) error {
+	if n, err := strconv.Atoi(strings.TrimSpace(s)); err == nil {
+		r.Min = n
+		return nil
+	}
+	r.Names = nil
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			r.Names = append(r.Names, name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package assertions
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/livepeer/stream-tester/internal/history"
+	"github.com/livepeer/stream-tester/model"
+)
+
+func TestEvaluate(t *testing.T) {
+	rec := &history.Record{
+		SuccessRate:         99,
+		TranscodedLatencies: model.Latencies{P95: 2 * time.Second, P99: 4 * time.Second},
+		Stats:               &model.Stats{FailedToDownloadSegments: 3, Errors: map[string]int{"Timeout": 2}},
+		VODStats:            &model.VODStats{SegmentsNum: map[string]int{"1280x720": 10, "640x360": 10}},
+	}
+	noMetrics := &history.Record{SuccessRate: 100}
+	for _, c := range []struct {
+		name   string
+		a      Assertions
+		rec    *history.Record
+		checks []Status
+	}{
+		{"success rate", Assertions{MinSuccessRate: 99}, rec, []Status{Pass}},
+		{"low success rate", Assertions{MinSuccessRate: 99.5}, rec, []Status{Fail}},
+		{"latency", Assertions{MaxLatencyP95: 3 * time.Second, MaxLatencyP99: 3 * time.Second}, rec, []Status{Pass, Fail}},
+		{"latency not measured", Assertions{MaxLatencyP95: 3 * time.Second}, noMetrics, []Status{Fail}},
+		// categories are sorted, the ones without limit are not checked
+		{"errors", Assertions{MaxErrors: ErrorLimits{"timeout": 2, "failed_to_download": 2}}, rec, []Status{Fail, Pass}},
+		{"errors default limit", Assertions{MaxErrors: ErrorLimits{"*": 0, "timeout": 5}}, rec, []Status{Pass, Pass, Fail, Pass, Pass, Pass}},
+		{"renditions", Assertions{Renditions: Renditions{Names: []string{"1280x720", "1920x1080"}}}, rec, []Status{Pass, Fail}},
+		{"renditions number", Assertions{Renditions: Renditions{Min: 3}}, rec, []Status{Fail}},
+		{"renditions not measured", Assertions{Renditions: Renditions{Min: 1}}, noMetrics, []Status{Fail}},
+	} {
+		v := c.a.Evaluate(c.rec)
+		var got []Status
+		failed := 0
+		for _, check := range v.Checks {
+			got = append(got, check.Status)
+			if check.Status == Fail {
+				failed++
+			}
+		}
+		if len(got) != len(c.checks) {
+			t.Errorf("%s: got checks %v, want %v\n%s", c.name, got, c.checks, v.FormatForConsole())
+			continue
+		}
+		for i := range got {
+			if got[i] != c.checks[i] {
+				t.Errorf("%s: check %s is %s, want %s", c.name, v.Checks[i].Name, got[i], c.checks[i])
+			}
+		}
+		if v.Failed != failed || v.Passed() != (failed == 0) {
+			t.Errorf("%s: verdict has %d failed checks, want %d", c.name, v.Failed, failed)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	rec := &history.Record{SuccessRate: 90}
+	for _, c := range []struct {
+		name     string
+		a        *Assertions
+		exitCode int
+		want     int
+	}{
+		{"no assertions", &Assertions{}, model.ExitCodeOK, model.ExitCodeOK},
+		{"nil assertions", nil, model.ExitCodeFailure, model.ExitCodeFailure},
+		{"passed", &Assertions{MinSuccessRate: 90}, model.ExitCodeOK, model.ExitCodeOK},
+		{"failed", &Assertions{MinSuccessRate: 95}, model.ExitCodeOK, model.ExitCodeAssertionsFailed},
+		{"not measured", &Assertions{MaxLatencyP99: time.Second}, model.ExitCodeOK, model.ExitCodeAssertionsFailed},
+		// exit code of the failed run is preserved
+		{"failed run", &Assertions{MinSuccessRate: 95}, model.ExitCodeRTMPError, model.ExitCodeRTMPError},
+		{"failed run passed", &Assertions{MinSuccessRate: 90}, model.ExitCodeFailure, model.ExitCodeFailure},
+	} {
+		if got := c.a.Apply(rec, c.exitCode); got != c.want {
+			t.Errorf("%s: exit code %d, want %d", c.name, got, c.want)
+		}
+	}
+}
+
+func TestFlags(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	a := AddFlags(fs)
+	if a.Enabled() {
+		t.Error("assertions enabled without flags")
+	}
+	err := fs.Parse([]string{"-assert-max-errors", "Connection Lost=1, *=5", "-assert-renditions", "1280x720, 640x360"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !a.Enabled() || a.MaxErrors["connection_lost"] != 1 || a.MaxErrors["*"] != 5 || len(a.Renditions.Names) != 2 {
+		t.Errorf("parsed assertions are %+v", a)
+	}
+	if err := fs.Parse([]string{"-assert-renditions", "4"}); err != nil || a.Renditions.Min != 4 {
+		t.Errorf("renditions number is %d err=%v", a.Renditions.Min, err)
+	}
+	for _, bad := range []string{"timeout", "timeout=a", "timeout=-1"} {
+		if err := (ErrorLimits{}).Set(bad); err == nil {
+			t.Errorf("no error parsing %q", bad)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+// Package distributed runs load test across many stream-tester instances.
+// Coordinator splits the test between worker agents, starts them in sync
+// and aggregates their statistics into one report
+package distributed
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/stream-tester/internal/testers"
+	"github.com/livepeer/stream-tester/model"
+)
+
+type (
+	// StartReq request to start part of the load test on the agent
+	StartReq struct {
+		RTMPTemplate string `json:"rtmp_template"`
+		HLSTemplate  string `json:"hls_template"`
+		FileName     string `json:"file_name"`
+		// BaseName prefix of manifest ids of the streams, should be unique across workers
+		BaseName string `json:"base_name"`
+		// Sim number of simultaneous streams, used if LoadProfile is not set
+		Sim int `json:"sim"`
+		// LoadProfile load profile specification, see testers.ParseLoadProfile
+		LoadProfile string `json:"load_profile,omitempty"`
+		// Workers, Worker number of workers load profile is split between
+		// and zero based index of this worker. Worker runs only its share
+		// of the profile's streams
+		Workers int `json:"workers,omitempty"`
+		Worker  int `json:"worker,omitempty"`
+		// Seed of the random load profile, same for all the workers so
+		// their shares add up to the same profile
+		Seed                int64  `json:"seed,omitempty"`
+		TestDuration        string `json:"test_duration"`
+		StreamDuration      string `json:"stream_duration,omitempty"`
+		WaitForTarget       string `json:"wait_for_target,omitempty"`
+		DelayBetweenStreams string `json:"delay_between_streams,omitempty"`
+		MistMode            bool   `json:"mist_mode,omitempty"`
+		// StartAt time to start streaming at, so all the workers start in sync
+		StartAt time.Time `json:"start_at"`
+	}
+
+	// Status status of the agent's load test
+	Status struct {
+		Worker  string          `json:"worker"`
+		Running bool            `json:"running"`
+		Started time.Time       `json:"started"`
+		Stats   model.StatsMany `json:"stats"`
+		Error   string          `json:"error,omitempty"`
+	}
+
+	// LoadTest load test described by StartReq
+	LoadTest struct {
+		model.ILoadTester
+		req            *StartReq
+		profile        model.LoadProfile
+		streamDuration time.Duration
+		waitForTarget  time.Duration
+	}
+
+	// Agent runs part of the distributed load test, controlled by coordinator
+	Agent struct {
+		name    string
+		mu      sync.Mutex
+		lt      model.ILoadTester
+		running bool
+		started time.Time
+		err     error
+	}
+)
+
+// NewAgent creates new agent
+func NewAgent(name string) *Agent {
+	return &Agent{name: name}
+}
+
+// AddHandlers adds agent's endpoints to the mux
+func (a *Agent) AddHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/agent/start", a.handleStart)
+	mux.HandleFunc("/agent/stats", a.handleStats)
+	mux.HandleFunc("/agent/stop", a.handleStop)
+}
+
+// NewLoadTest validates request and creates load test from it
+func NewLoadTest(ctx context.Context, req *StartReq) (*LoadTest, error) {
+	if req.RTMPTemplate == "" || req.HLSTemplate == "" {
+		return nil, errors.New("should specify 'rtmp_template' and 'hls_template' fields")
+	}
+	if _, err := os.Stat(req.FileName); os.IsNotExist(err) {
+		return nil, fmt.Errorf("file %s does not exists", req.FileName)
+	}
+	var durs [4]time.Duration
+	for i, ds := range []string{req.TestDuration, req.StreamDuration, req.WaitForTarget, req.DelayBetweenStreams} {
+		if ds == "" {
+			continue
+		}
+		d, err := time.ParseDuration(ds)
+		if err != nil {
+			return nil, err
+		}
+		durs[i] = d
+	}
+	testDuration, streamDuration, waitForTarget, delayBetweenStreams := durs[0], durs[1], durs[2], durs[3]
+	if waitForTarget == 0 {
+		waitForTarget = 30 * time.Second
+	}
+	profile := testers.NewConstantProfile(req.Sim, testDuration)
+	if req.LoadProfile != "" {
+		seed := req.Seed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		var err error
+		if profile, err = testers.ParseLoadProfile(req.LoadProfile, testDuration, seed); err != nil {
+			return nil, err
+		}
+		if req.Workers > 1 {
+			if req.Worker < 0 || req.Worker >= req.Workers {
+				return nil, fmt.Errorf("worker index %d is out of range of %d workers", req.Worker, req.Workers)
+			}
+			profile = testers.NewShareProfile(profile, req.Workers, req.Worker)
+		}
+	}
+	starter := testers.NewTemplateStreamStarter(req.RTMPTemplate, req.HLSTemplate, req.BaseName, testers.Streamer2Options{MistMode: req.MistMode})
+	return &LoadTest{
+		ILoadTester:    testers.NewLoadTester(ctx, starter, delayBetweenStreams),
+		req:            req,
+		profile:        profile,
+		streamDuration: streamDuration,
+		waitForTarget:  waitForTarget,
+	}, nil
+}
+
+// Run waits until StartAt time and runs load test. Blocks until test is finished
+func (lt *LoadTest) Run() error {
+	if wait := time.Until(lt.req.StartAt); wait > 0 {
+		glog.Infof("Waiting %s before starting load test", wait)
+		select {
+		case <-time.After(wait):
+		case <-lt.Done():
+			return nil
+		}
+	}
+	return lt.StartProfile(lt.req.FileName, lt.waitForTarget, lt.streamDuration, lt.profile)
+}
+
+// Start starts load test, returns immediately
+func (a *Agent) Start(req *StartReq) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.running {
+		return errors.New("load test is already running")
+	}
+	lt, err := NewLoadTest(context.Background(), req)
+	if err != nil {
+		return err
+	}
+	a.lt = lt
+	a.running = true
+	a.err = nil
+	a.started = req.StartAt
+	go func() {
+		err := lt.Run()
+		if err != nil {
+			glog.Errorf("Agent %s load test error: %v", a.name, err)
+		}
+		a.mu.Lock()
+		a.running = false
+		a.err = err
+		a.mu.Unlock()
+	}()
+	return nil
+}
+
+// Stop stops running load test
+func (a *Agent) Stop() {
+	a.mu.Lock()
+	lt := a.lt
+	a.mu.Unlock()
+	if lt != nil {
+		lt.Cancel()
+	}
+}
+
+// Status returns status of the load test
+func (a *Agent) Status() *Status {
+	a.mu.Lock()
+	st := &Status{
+		Worker:  a.name,
+		Running: a.running,
+		Started: a.started,
+	}
+	lt := a.lt
+	if a.err != nil {
+		st.Error = a.err.Error()
+	}
+	a.mu.Unlock()
+	if lt != nil {
+		st.Stats, _ = lt.Stats()
+	}
+	// streams can still be winding down, but test is over
+	st.Stats.Finished = !st.Running
+	return st
+}
+
+func (a *Agent) handleStart(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	glog.Infof("Got agent start request: '%s'", string(b))
+	req := &StartReq{}
+	if err = json.Unmarshal(b, req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err = a.Start(req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	a.handleStats(w, r)
+}
+
+func (a *Agent) handleStats(w http.ResponseWriter, r *http.Request) {
+	b, err := json.Marshal(a.Status())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+func (a *Agent) handleStop(w http.ResponseWriter, r *http.Request) {
+	glog.Infof("Agent %s got stop request", a.name)
+	a.Stop()
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,552 @@
+package distributed
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/stream-tester/internal/testers"
+	"github.com/livepeer/stream-tester/model"
+)
+
+const (
+	// maxPollFailures number of consecutive failed stats requests after
+	// which worker is considered dead
+	maxPollFailures = 5
+	// agentsGracePeriod how long after the end of the load profile
+	// coordinator waits for agents to report they are finished
+	agentsGracePeriod = 2 * time.Minute
+)
+
+type (
+	// Coordinator splits load test between workers and aggregates results.
+	// Workers are addressed by base URL, like http://10.0.0.1:7934
+	Coordinator struct {
+		workers      []string
+		pollInterval time.Duration
+		client       *http.Client
+	}
+
+	// Report aggregated result of the agents' load test
+	Report struct {
+		Started time.Time       `json:"started"`
+		Stats   model.StatsMany `json:"stats"`
+		Workers []*Status       `json:"workers"`
+	}
+
+	// StreamsReport aggregated result of the StreamerServer workers
+	StreamsReport struct {
+		Stats   *model.Stats            `json:"stats"`
+		Workers map[string]*model.Stats `json:"workers"`
+		Errors  map[string]string       `json:"errors,omitempty"`
+	}
+)
+
+// NewCoordinator creates new coordinator
+func NewCoordinator(workers []string, pollInterval time.Duration) *Coordinator {
+	ws := make([]string, 0, len(workers))
+	for _, w := range workers {
+		w = strings.TrimRight(strings.TrimSpace(w), "/")
+		if w != "" {
+			ws = append(ws, w)
+		}
+	}
+	return &Coordinator{
+		workers:      ws,
+		pollInterval: pollInterval,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// RunAgents splits streams between agents, starts them all at the same
+// time after syncDelay and waits until they all finish
+func (c *Coordinator) RunAgents(ctx context.Context, req StartReq, syncDelay time.Duration) (*Report, error) {
+	if len(c.workers) == 0 {
+		return nil, errors.New("no workers specified")
+	}
+	if req.Sim < len(c.workers) && req.LoadProfile == "" {
+		return nil, fmt.Errorf("can't split %d streams between %d workers", req.Sim, len(c.workers))
+	}
+	duration, err := testDuration(&req)
+	if err != nil {
+		return nil, err
+	}
+	req.StartAt = time.Now().Add(syncDelay)
+	if req.LoadProfile != "" && req.Seed == 0 {
+		req.Seed = time.Now().UnixNano()
+	}
+	base := req.BaseName
+	reqs := make([]StartReq, len(c.workers))
+	for i := range c.workers {
+		reqs[i] = req
+		// spread remainder over first workers
+		reqs[i].Sim = req.Sim / len(c.workers)
+		if i < req.Sim%len(c.workers) {
+			reqs[i].Sim++
+		}
+		// every worker runs its share of the load profile
+		reqs[i].Workers = len(c.workers)
+		reqs[i].Worker = i
+		reqs[i].BaseName = fmt.Sprintf("%s_w%d", base, i)
+	}
+	errs := c.forAll(func(i int, worker string) error {
+		glog.Infof("Starting %d streams on worker %s at %s", reqs[i].Sim, worker, req.StartAt)
+		return c.post(worker+"/agent/start", &reqs[i], nil)
+	})
+	if err := joinErrors(errs); err != nil {
+		c.StopAgents()
+		return nil, err
+	}
+
+	report := &Report{Started: req.StartAt, Workers: make([]*Status, len(c.workers))}
+	// dead worker never reports it is finished, so do not wait forever
+	deadline := time.NewTimer(time.Until(req.StartAt.Add(duration + agentsGracePeriod)))
+	defer deadline.Stop()
+	failures := make([]int, len(c.workers))
+	ticker := time.NewTicker(c.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			c.StopAgents()
+			report.aggregate()
+			return report, ctx.Err()
+		case <-deadline.C:
+			c.StopAgents()
+			var errs []error
+			for i, worker := range c.workers {
+				if st := report.Workers[i]; st == nil || !st.Stats.Finished {
+					report.failWorker(i, worker, "did not finish in time")
+					errs = append(errs, fmt.Errorf("worker %s did not finish in time", worker))
+				}
+			}
+			report.aggregate()
+			return report, joinErrors(errs)
+		case <-ticker.C:
+		}
+		c.forAll(func(i int, worker string) error {
+			if failures[i] >= maxPollFailures {
+				// already given up on this worker
+				return nil
+			}
+			st := &Status{}
+			if err := c.get(worker+"/agent/stats", st); err != nil {
+				glog.Errorf("Error getting stats from worker %s: %v", worker, err)
+				if failures[i]++; failures[i] >= maxPollFailures {
+					glog.Errorf("Giving up on worker %s after %d failed requests", worker, failures[i])
+					report.failWorker(i, worker, fmt.Sprintf("unreachable: %v", err))
+				}
+				return err
+			}
+			failures[i] = 0
+			// streams are removed from load tester as they finish, so
+			// keep last snapshot which still had streams in it
+			if prev := report.Workers[i]; prev != nil && st.Stats.ActiveStreams == 0 {
+				finished := st.Stats.Finished
+				st.Stats = prev.Stats
+				st.Stats.Finished = finished
+			}
+			st.Worker = worker
+			report.Workers[i] = st
+			return nil
+		})
+		report.aggregate()
+		glog.Infof("Active streams %d success rate %.4f", report.Stats.ActiveStreams, report.Stats.SuccessRate)
+		if report.Stats.Finished {
+			var errs []error
+			for _, st := range report.Workers {
+				if st != nil && st.Error != "" {
+					errs = append(errs, fmt.Errorf("worker %s: %s", st.Worker, st.Error))
+				}
+			}
+			return report, joinErrors(errs)
+		}
+	}
+}
+
+// StopAgents stops load test on all the agents
+func (c *Coordinator) StopAgents() {
+	c.forAll(func(i int, worker string) error {
+		if err := c.get(worker+"/agent/stop", nil); err != nil {
+			glog.Errorf("Error stopping worker %s: %v", worker, err)
+		}
+		return nil
+	})
+}
+
+// RunStreamers starts streams on StreamerServer workers using /start_streams
+// endpoint, all at the same time after syncDelay, then polls /stats until
+// all streams are finished. Simultaneous streams number is split between workers
+func (c *Coordinator) RunStreamers(ctx context.Context, req model.StartStreamsReq, syncDelay time.Duration) (*StreamsReport, error) {
+	if len(c.workers) == 0 {
+		return nil, errors.New("no workers specified")
+	}
+	if int(req.Simultaneous) < len(c.workers) {
+		return nil, fmt.Errorf("can't split %d streams between %d workers", req.Simultaneous, len(c.workers))
+	}
+	select {
+	case <-time.After(syncDelay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	manifestIDs := make([]string, len(c.workers))
+	errs := c.forAll(func(i int, worker string) error {
+		wreq := req
+		wreq.Simultaneous = req.Simultaneous / uint(len(c.workers))
+		if i < int(req.Simultaneous)%len(c.workers) {
+			wreq.Simultaneous++
+		}
+		res := &model.StartStreamsRes{}
+		if err := c.post(worker+"/start_streams", &wreq, res); err != nil {
+			return err
+		}
+		manifestIDs[i] = res.BaseManifestID
+		return nil
+	})
+	if err := joinErrors(errs); err != nil {
+		c.StopStreamers()
+		return nil, err
+	}
+
+	report := &StreamsReport{Workers: make(map[string]*model.Stats)}
+	ticker := time.NewTicker(c.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			c.StopStreamers()
+			return report, ctx.Err()
+		case <-ticker.C:
+		}
+		stats := make([]*model.Stats, len(c.workers))
+		errs := c.forAll(func(i int, worker string) error {
+			st := &model.Stats{}
+			q := url.Values{"latencies": []string{""}, "base_manifest_id": []string{manifestIDs[i]}}
+			if err := c.get(worker+"/stats?"+q.Encode(), st); err != nil {
+				return err
+			}
+			stats[i] = st
+			return nil
+		})
+		report.Errors = make(map[string]string)
+		for i, worker := range c.workers {
+			if errs[i] != nil {
+				report.Errors[worker] = errs[i].Error()
+			} else {
+				report.Workers[worker] = stats[i]
+			}
+		}
+		all := make([]*model.Stats, 0, len(report.Workers))
+		for _, st := range report.Workers {
+			all = append(all, st)
+		}
+		report.Stats = MergeStats(all...)
+		glog.Infof("Active streams %d success rate %.4f", report.Stats.RTMPActiveStreams, report.Stats.SuccessRate)
+		if report.Stats.Finished && len(report.Workers) == len(c.workers) {
+			return report, nil
+		}
+	}
+}
+
+// StopStreamers stops streams on all StreamerServer workers
+func (c *Coordinator) StopStreamers() {
+	c.forAll(func(i int, worker string) error {
+		if err := c.get(worker+"/stop", nil); err != nil {
+			glog.Errorf("Error stopping worker %s: %v", worker, err)
+		}
+		return nil
+	})
+}
+
+// MergeStats merges stats of many streamers into one, recalculating
+// latencies percentiles from merged latency histograms
+func MergeStats(stats ...*model.Stats) *model.Stats {
+	merged := &model.Stats{Finished: true}
+	sourceLatencies := &model.Histogram{}
+	transcodedLatencies := &model.Histogram{}
+	for _, st := range stats {
+		if st == nil {
+			continue
+		}
+		merged.RTMPActiveStreams += st.RTMPActiveStreams
+		merged.RTMPstreams += st.RTMPstreams
+		merged.MediaStreams += st.MediaStreams
+		merged.TotalSegmentsToSend += st.TotalSegmentsToSend
+		merged.SentSegments += st.SentSegments
+		merged.DownloadedSegments += st.DownloadedSegments
+		merged.DownloadedSourceSegments += st.DownloadedSourceSegments
+		merged.DownloadedTranscodedSegments += st.DownloadedTranscodedSegments
+		merged.ShouldHaveDownloadedSegments += st.ShouldHaveDownloadedSegments
+		merged.FailedToDownloadSegments += st.FailedToDownloadSegments
+		merged.BytesDownloaded += st.BytesDownloaded
+		merged.Retries += st.Retries
+		merged.SentKeyFrames += st.SentKeyFrames
+		merged.DownloadedKeyFrames += st.DownloadedKeyFrames
+		merged.ConnectionLost += st.ConnectionLost
+		merged.ProfilesNum = st.ProfilesNum
+		merged.WowzaMode = st.WowzaMode
+		merged.Finished = merged.Finished && st.Finished
+		if merged.StartTime.IsZero() || (!st.StartTime.IsZero() && st.StartTime.Before(merged.StartTime)) {
+			merged.StartTime = st.StartTime
+		}
+		for e, n := range st.Errors {
+			if merged.Errors == nil {
+				merged.Errors = make(map[string]int)
+			}
+			merged.Errors[e] += n
+		}
+		sourceLatencies.Merge(st.SourceLatencyHistogram)
+		transcodedLatencies.Merge(st.TranscodedLatencyHistogram)
+		merged.RawTranscodeLatenciesPerStream = append(merged.RawTranscodeLatenciesPerStream, st.RawTranscodeLatenciesPerStream...)
+		merged.RawTranscodeLatenciesNames = append(merged.RawTranscodeLatenciesNames, st.RawTranscodeLatenciesNames...)
+	}
+	if merged.ShouldHaveDownloadedSegments > 0 {
+		merged.SuccessRate = float64(merged.DownloadedSegments) / float64(merged.ShouldHaveDownloadedSegments) * 100
+	}
+	merged.SourceLatencies = sourceLatencies.Latencies()
+	merged.TranscodedLatencies = transcodedLatencies.Latencies()
+	merged.SourceLatencyHistogram = sourceLatencies
+	merged.TranscodedLatencyHistogram = transcodedLatencies
+	merged.RawSourceLatencies = sourceLatencies.Values(model.RawLatenciesMax)
+	merged.RawTranscodedLatencies = transcodedLatencies.Values(model.RawLatenciesMax)
+	return merged
+}
+
+// MergeStatsMany merges load testers' stats into one. Latencies are
+// recalculated from merged latency histograms if all the workers reported
+// them. Otherwise, as success rate, they are averaged weighting by number of
+// streams of each worker. Concurrency samples taken at the same time are
+// summed.
+func MergeStatsMany(stats ...model.StatsMany) model.StatsMany {
+	merged := model.StatsMany{Finished: true}
+	var weights float64
+	var source, transcoded [4]float64
+	var sourceWeights, transcodedWeights float64
+	sourceHist, transcodedHist := &model.Histogram{}, &model.Histogram{}
+	sourceHistOK, transcodedHistOK := true, true
+	concurrency := make(map[time.Duration]*model.ConcurrencySample)
+	for _, st := range stats {
+		merged.ActiveStreams += st.ActiveStreams
+		merged.Finished = merged.Finished && st.Finished
+		w := float64(st.ActiveStreams)
+		if w == 0 {
+			w = 1
+		}
+		merged.SuccessRate += st.SuccessRate * w
+		weights += w
+		if st.SourceLatencies.P50 > 0 {
+			addLatencies(&source, st.SourceLatencies, w)
+			sourceWeights += w
+			sourceHist.Merge(st.SourceLatencyHistogram)
+			sourceHistOK = sourceHistOK && st.SourceLatencyHistogram != nil
+		}
+		if st.TranscodedLatencies.P50 > 0 {
+			addLatencies(&transcoded, st.TranscodedLatencies, w)
+			transcodedWeights += w
+			transcodedHist.Merge(st.TranscodedLatencyHistogram)
+			transcodedHistOK = transcodedHistOK && st.TranscodedLatencyHistogram != nil
+		}
+		for _, cs := range st.Concurrency {
+			// agents sample concurrency on their own, but they were started
+			// in sync, so samples taken around the same time are merged
+			at := cs.At.Round(time.Second)
+			if ms, ok := concurrency[at]; ok {
+				ms.Requested += cs.Requested
+				ms.Achieved += cs.Achieved
+			} else {
+				concurrency[at] = &model.ConcurrencySample{At: at, Requested: cs.Requested, Achieved: cs.Achieved}
+			}
+		}
+	}
+	if weights > 0 {
+		merged.SuccessRate /= weights
+	}
+	merged.SourceLatencies = weightedLatencies(source, sourceWeights)
+	if sourceHistOK && sourceHist.Count() > 0 {
+		merged.SourceLatencies = sourceHist.Latencies()
+		merged.SourceLatencyHistogram = sourceHist
+	}
+	merged.TranscodedLatencies = weightedLatencies(transcoded, transcodedWeights)
+	if transcodedHistOK && transcodedHist.Count() > 0 {
+		merged.TranscodedLatencies = transcodedHist.Latencies()
+		merged.TranscodedLatencyHistogram = transcodedHist
+	}
+	for _, cs := range concurrency {
+		merged.Concurrency = append(merged.Concurrency, *cs)
+	}
+	sort.Slice(merged.Concurrency, func(i, j int) bool { return merged.Concurrency[i].At < merged.Concurrency[j].At })
+	return merged
+}
+
+func addLatencies(sum *[4]float64, l model.Latencies, w float64) {
+	sum[0] += float64(l.Avg) * w
+	sum[1] += float64(l.P50) * w
+	sum[2] += float64(l.P95) * w
+	sum[3] += float64(l.P99) * w
+}
+
+func weightedLatencies(sum [4]float64, weights float64) model.Latencies {
+	if weights == 0 {
+		return model.Latencies{}
+	}
+	return model.Latencies{
+		Avg: time.Duration(sum[0] / weights),
+		P50: time.Duration(sum[1] / weights),
+		P95: time.Duration(sum[2] / weights),
+		P99: time.Duration(sum[3] / weights),
+	}
+}
+
+// failWorker marks worker as finished with error, keeping its last stats
+func (r *Report) failWorker(i int, worker, reason string) {
+	st := r.Workers[i]
+	if st == nil {
+		st = &Status{Worker: worker}
+		r.Workers[i] = st
+	}
+	st.Running = false
+	st.Error = reason
+	st.Stats.Finished = true
+}
+
+// testDuration returns how long load test described by request lasts
+func testDuration(req *StartReq) (time.Duration, error) {
+	var duration time.Duration
+	if req.TestDuration != "" {
+		d, err := time.ParseDuration(req.TestDuration)
+		if err != nil {
+			return 0, err
+		}
+		duration = d
+	}
+	if req.LoadProfile != "" {
+		profile, err := testers.ParseLoadProfile(req.LoadProfile, duration, 0)
+		if err != nil {
+			return 0, err
+		}
+		duration = profile.Duration()
+	}
+	return duration, nil
+}
+
+func (r *Report) aggregate() {
+	stats := make([]model.StatsMany, 0, len(r.Workers))
+	for _, st := range r.Workers {
+		if st == nil {
+			// haven't heard from the worker yet
+			stats = append(stats, model.StatsMany{})
+			continue
+		}
+		stats = append(stats, st.Stats)
+	}
+	r.Stats = MergeStatsMany(stats...)
+}
+
+// FormatForConsole formats report to be shown in console
+func (r *Report) FormatForConsole() string {
+	lines := []string{"Aggregated stats:", r.Stats.FormatForConsole(), "Workers:"}
+	for _, st := range r.Workers {
+		if st == nil {
+			continue
+		}
+		line := fmt.Sprintf("  %-30s streams %4d success rate %.4f transcoded latencies %s", st.Worker, st.Stats.ActiveStreams,
+			st.Stats.SuccessRate, st.Stats.TranscodedLatencies.String())
+		if st.Error != "" {
+			line += " error: " + st.Error
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// FormatForConsole formats report to be shown in console
+func (r *StreamsReport) FormatForConsole() string {
+	lines := []string{"Aggregated stats:", r.Stats.FormatForConsole(), "Workers:"}
+	workers := make([]string, 0, len(r.Workers))
+	for w := range r.Workers {
+		workers = append(workers, w)
+	}
+	sort.Strings(workers)
+	for _, w := range workers {
+		st := r.Workers[w]
+		lines = append(lines, fmt.Sprintf("  %-30s streams %4d sent %6d downloaded %6d success rate %.4f", w, st.RTMPstreams,
+			st.SentSegments, st.DownloadedSegments, st.SuccessRate))
+	}
+	for w, e := range r.Errors {
+		lines = append(lines, fmt.Sprintf("  %-30s error: %s", w, e))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// forAll calls f for every worker concurrently and waits for all calls to finish
+func (c *Coordinator) forAll(f func(i int, worker string) error) []error {
+	errs := make([]error, len(c.workers))
+	var wg sync.WaitGroup
+	for i, worker := range c.workers {
+		wg.Add(1)
+		go func(i int, worker string) {
+			defer wg.Done()
+			errs[i] = f(i, worker)
+		}(i, worker)
+	}
+	wg.Wait()
+	return errs
+}
+
+func (c *Coordinator) post(uri string, req, res interface{}) error {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Post(uri, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	return readResponse(uri, resp, res)
+}
+
+func (c *Coordinator) get(uri string, res interface{}) error {
+	resp, err := c.client.Get(uri)
+	if err != nil {
+		return err
+	}
+	return readResponse(uri, resp, res)
+}
+
+func readResponse(uri string, resp *http.Response, res interface{}) error {
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status %s: %s", uri, resp.Status, strings.TrimSpace(string(b)))
+	}
+	if res == nil {
+		return nil
+	}
+	return json.Unmarshal(b, res)
+}
+
+func joinErrors(errs []error) error {
+	var msgs []string
+	for _, err := range errs {
+		if err != nil {
+			msgs = append(msgs, err.Error())
+		}
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(msgs, "; "))
+}
@@ -0,0 +1,92 @@
+package distributed
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/livepeer/stream-tester/model"
+)
+
+// fakeAgent records start requests and reports test as finished
+type fakeAgent struct {
+	mu   sync.Mutex
+	reqs []StartReq
+}
+
+func (fa *fakeAgent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/agent/start":
+		req := StartReq{}
+		json.NewDecoder(r.Body).Decode(&req)
+		fa.mu.Lock()
+		fa.reqs = append(fa.reqs, req)
+		fa.mu.Unlock()
+	case "/agent/stats":
+		json.NewEncoder(w).Encode(&Status{Stats: model.StatsMany{ActiveStreams: 1, SuccessRate: 1, Finished: true}})
+	}
+}
+
+func TestRunAgentsSplitsLoadProfile(t *testing.T) {
+	agents := []*fakeAgent{{}, {}, {}}
+	var urls []string
+	for _, a := range agents {
+		ts := httptest.NewServer(a)
+		defer ts.Close()
+		urls = append(urls, ts.URL)
+	}
+	c := NewCoordinator(urls, 10*time.Millisecond)
+	_, err := c.RunAgents(context.Background(), StartReq{LoadProfile: "poisson:rate=1,session=1m", TestDuration: "1m", BaseName: "t"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var seed int64
+	for i, a := range agents {
+		if len(a.reqs) != 1 {
+			t.Fatalf("agent %d got %d start requests", i, len(a.reqs))
+		}
+		req := a.reqs[0]
+		if req.Workers != 3 || req.Worker != i {
+			t.Errorf("agent %d got share %d of %d", i, req.Worker, req.Workers)
+		}
+		if i == 0 {
+			seed = req.Seed
+		}
+		if req.Seed == 0 || req.Seed != seed {
+			t.Errorf("agent %d got seed %d, want %d", i, req.Seed, seed)
+		}
+	}
+}
+
+func TestRunAgentsGivesUpOnDeadWorker(t *testing.T) {
+	alive := httptest.NewServer(&fakeAgent{})
+	defer alive.Close()
+	dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/agent/start" {
+			return
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer dead.Close()
+	c := NewCoordinator([]string{alive.URL, dead.URL}, 10*time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	report, err := c.RunAgents(ctx, StartReq{Sim: 2, TestDuration: "1h", BaseName: "t"}, 0)
+	if ctx.Err() != nil {
+		t.Fatal("coordinator waited for dead worker")
+	}
+	if err == nil || !strings.Contains(err.Error(), dead.URL) {
+		t.Errorf("error %v should name dead worker", err)
+	}
+	if st := report.Workers[1]; st == nil || !strings.HasPrefix(st.Error, "unreachable") {
+		t.Errorf("dead worker is not marked as failed: %+v", st)
+	}
+	if report.Workers[0].Error != "" {
+		t.Errorf("alive worker is marked as failed: %s", report.Workers[0].Error)
+	}
+}
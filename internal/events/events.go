@@ -0,0 +1,144 @@
+// Package events publishes events happening during the test (segment
+// sent, segment downloaded, errors) to the subscribers, so they can react
+// on them immediately instead of polling stats
+package events
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Type type of the event
+type Type string
+
+// Event types
+const (
+	SegmentSent       Type = "segment_sent"
+	PlaylistFetched   Type = "playlist_fetched"
+	SegmentDownloaded Type = "segment_downloaded"
+	LatencyMatched    Type = "latency_matched"
+	Gap               Type = "gap"
+	Drift             Type = "drift"
+	Error             Type = "error"
+	StreamFinished    Type = "stream_finished"
+	// Stats periodic stats snapshot
+	Stats Type = "stats"
+)
+
+type (
+	// Event something that happened during the test
+	Event struct {
+		Type Type      `json:"type"`
+		Time time.Time `json:"time"`
+		// Stream URL of the stream (ingest URL for sent segments, media playlist
+		// URL for downloaded ones), usually contains manifest id
+		Stream     string        `json:"stream,omitempty"`
+		SeqNo      int64         `json:"seq_no,omitempty"`
+		Resolution string        `json:"resolution,omitempty"`
+		Bytes      int           `json:"bytes,omitempty"`
+		Took       time.Duration `json:"took,omitempty"`
+		Error      string        `json:"error,omitempty"`
+		// PTS of the first frame of the segment
+		PTS      time.Duration `json:"pts,omitempty"`
+		Duration time.Duration `json:"duration,omitempty"`
+		// Latency and SpeedRatio of the segment matched against sent frames
+		Latency    time.Duration `json:"latency,omitempty"`
+		SpeedRatio float64       `json:"speed_ratio,omitempty"`
+		// Segments number of segments in the fetched playlist
+		Segments int `json:"segments,omitempty"`
+		// Stats snapshot of stats, for Stats events
+		Stats interface{} `json:"stats,omitempty"`
+	}
+
+	// Bus delivers published events to subscribers. Publishing never blocks:
+	// if subscriber does not keep up, events are dropped for it
+	Bus struct {
+		mu   sync.RWMutex
+		subs map[*Subscription]bool
+		// number of subscribers, to skip creating events when nobody listens
+		num int32
+	}
+
+	// Subscription subscription to the events
+	Subscription struct {
+		C       <-chan *Event
+		c       chan *Event
+		filter  func(*Event) bool
+		dropped uint64
+	}
+)
+
+// DefaultBus bus used by testers
+var DefaultBus = NewBus()
+
+// NewBus creates new bus
+func NewBus() *Bus {
+	return &Bus{subs: make(map[*Subscription]bool)}
+}
+
+// Subscribe subscribes to events for which filter returns true (or to all
+// events if filter is nil). Subscription buffers up to buffer events.
+func (b *Bus) Subscribe(buffer int, filter func(*Event) bool) *Subscription {
+	c := make(chan *Event, buffer)
+	s := &Subscription{C: c, c: c, filter: filter}
+	b.mu.Lock()
+	b.subs[s] = true
+	atomic.StoreInt32(&b.num, int32(len(b.subs)))
+	b.mu.Unlock()
+	return s
+}
+
+// Unsubscribe removes subscription and closes its channel
+func (b *Bus) Unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	if b.subs[s] {
+		delete(b.subs, s)
+		close(s.c)
+	}
+	atomic.StoreInt32(&b.num, int32(len(b.subs)))
+	b.mu.Unlock()
+}
+
+// HasSubscribers returns true if there is someone listening
+func (b *Bus) HasSubscribers() bool {
+	return atomic.LoadInt32(&b.num) > 0
+}
+
+// Publish sends event to all the subscribers
+func (b *Bus) Publish(e *Event) {
+	if !b.HasSubscribers() {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b.mu.RLock()
+	for s := range b.subs {
+		if s.filter != nil && !s.filter(e) {
+			continue
+		}
+		select {
+		case s.c <- e:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+	b.mu.RUnlock()
+}
+
+// Dropped number of events dropped because subscriber didn't keep up
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Publish publishes event to the default bus
+func Publish(e *Event) {
+	DefaultBus.Publish(e)
+}
+
+// Enabled returns true if someone is subscribed to the default bus.
+// Used to not build events nobody will receive
+func Enabled() bool {
+	return DefaultBus.HasSubscribers()
+}
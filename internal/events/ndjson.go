@@ -0,0 +1,95 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	ndjsonBuffer     = 16384
+	ndjsonFlushEvery = time.Second
+)
+
+// NDJSONSink writes events published to the bus to the file, one JSON object
+// per line, for offline analysis (jq, notebooks)
+type NDJSONSink struct {
+	bus  *Bus
+	sub  *Subscription
+	f    *os.File
+	w    *bufio.Writer
+	done chan struct{}
+	once sync.Once
+	num  int64
+}
+
+// NewNDJSONSink starts writing events of the default bus to the file. Stats
+// events are not written
+func NewNDJSONSink(fileName string) (*NDJSONSink, error) {
+	return NewNDJSONSinkBus(DefaultBus, fileName)
+}
+
+// NewNDJSONSinkBus starts writing events of the bus to the file
+func NewNDJSONSinkBus(bus *Bus, fileName string) (*NDJSONSink, error) {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return nil, err
+	}
+	s := &NDJSONSink{
+		bus:  bus,
+		sub:  bus.Subscribe(ndjsonBuffer, func(e *Event) bool { return e.Type != Stats }),
+		f:    f,
+		w:    bufio.NewWriter(f),
+		done: make(chan struct{}),
+	}
+	go s.loop()
+	glog.Infof("Writing events to %s", fileName)
+	return s, nil
+}
+
+func (s *NDJSONSink) loop() {
+	defer close(s.done)
+	enc := json.NewEncoder(s.w)
+	ticker := time.NewTicker(ndjsonFlushEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case e, ok := <-s.sub.C:
+			if !ok {
+				return
+			}
+			if err := enc.Encode(e); err != nil {
+				glog.Errorf("Error writing event err=%v", err)
+				continue
+			}
+			s.num++
+		case <-ticker.C:
+			s.w.Flush()
+		}
+	}
+}
+
+// Close stops writing events, flushes and closes the file
+func (s *NDJSONSink) Close() error {
+	if s == nil {
+		return nil
+	}
+	var err error
+	s.once.Do(func() {
+		s.bus.Unsubscribe(s.sub)
+		<-s.done
+		if err = s.w.Flush(); err == nil {
+			err = s.f.Close()
+		} else {
+			s.f.Close()
+		}
+		if dropped := s.sub.Dropped(); dropped > 0 {
+			glog.Warningf("Events file %s is incomplete: %d events written, %d dropped", s.f.Name(), s.num, dropped)
+		}
+	})
+	return err
+}
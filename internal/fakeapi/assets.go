@@ -0,0 +1,609 @@
+package fakeapi
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/go-api-client"
+	"github.com/livepeer/joy4/av"
+	"github.com/livepeer/joy4/format/mp4"
+	"github.com/livepeer/joy4/format/ts"
+	"github.com/livepeer/stream-tester/internal/mockbroadcaster"
+	"github.com/livepeer/stream-tester/model"
+)
+
+// Asset phases
+const (
+	assetWaiting    = "waiting"
+	assetProcessing = "processing"
+	assetReady      = "ready"
+	assetFailed     = "failed"
+)
+
+const assetSegmentDuration = 2 * time.Second
+
+var importClient = &http.Client{Timeout: 5 * time.Minute}
+
+type asset struct {
+	api.Asset
+	data     []byte
+	segments []*vodSegment
+	// uploadTask task waiting for the upload, resumable upload state
+	uploadTask   string
+	uploadLength int64
+	uploaded     []byte
+}
+
+func (s *Server) handleAsset(w http.ResponseWriter, r *http.Request, pp []string) {
+	switch {
+	case len(pp) == 0 && r.Method == http.MethodGet:
+		s.listAssets(w, r)
+	case len(pp) == 1 && pp[0] == "request-upload" && r.Method == http.MethodPost:
+		s.requestUpload(w, r)
+	case len(pp) == 2 && pp[0] == "upload" && pp[1] == "url" && r.Method == http.MethodPost:
+		s.importAsset(w, r)
+	case len(pp) == 2 && pp[0] == "upload" && pp[1] == "direct" && r.Method == http.MethodPut:
+		s.directUpload(w, r)
+	case len(pp) >= 2 && pp[0] == "upload" && pp[1] == "tus":
+		s.tusUpload(w, r, pp[2:])
+	case len(pp) == 1 && r.Method == http.MethodGet:
+		s.mu.Lock()
+		a := s.assets[pp[0]]
+		var res api.Asset
+		if a != nil {
+			res = a.Asset
+		}
+		s.mu.Unlock()
+		if a == nil {
+			writeError(w, http.StatusNotFound, "not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+	case len(pp) == 1 && r.Method == http.MethodDelete:
+		s.mu.Lock()
+		a := s.assets[pp[0]]
+		if a != nil {
+			a.Deleted = true
+		}
+		s.mu.Unlock()
+		if a == nil {
+			writeError(w, http.StatusNotFound, "not found")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case len(pp) == 2 && pp[1] == "transcode" && r.Method == http.MethodPost:
+		s.transcodeAsset(w, r, pp[0])
+	case len(pp) == 2 && pp[1] == "export" && r.Method == http.MethodPost:
+		s.exportAsset(w, r, pp[0])
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// newAsset creates asset. Should be called with lock held
+func (s *Server) newAsset(name string) *asset {
+	now := millis(time.Now())
+	a := &asset{Asset: api.Asset{
+		ID:         newUUID(),
+		PlaybackID: newID(8),
+		CreatedAt:  now,
+		Status:     api.AssetStatus{Phase: assetWaiting, UpdatedAt: now},
+		AssetSpec:  api.AssetSpec{Name: name, Type: "video"},
+	}}
+	s.assets[a.ID] = a
+	return a
+}
+
+// newTask creates task. Should be called with lock held
+func (s *Server) newTask(typ string, phase api.TaskPhase) *api.Task {
+	now := millis(time.Now())
+	t := &api.Task{ID: newUUID(), CreatedAt: now, Type: typ, Status: api.TaskStatus{Phase: phase, UpdatedAt: now}}
+	s.tasks[t.ID] = t
+	return t
+}
+
+func (s *Server) importAsset(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+		URL  string `json:"url"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.URL == "" {
+		writeError(w, http.StatusUnprocessableEntity, "url is required")
+		return
+	}
+	s.mu.Lock()
+	a := s.newAsset(req.Name)
+	t := s.newTask("import", api.TaskPhasePending)
+	t.OutputAssetID = a.ID
+	t.Params.Import = &api.UploadTaskParams{URL: req.URL}
+	res := api.TaskAndAsset{Asset: a.Asset, Task: *t}
+	s.mu.Unlock()
+	s.runTask(t.ID, a.ID, func() error {
+		resp, err := importClient.Get(req.URL)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("error fetching url=%s status=%s", req.URL, resp.Status)
+		}
+		data, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		return s.processAsset(a.ID, data)
+	})
+	writeJSON(w, http.StatusCreated, res)
+}
+
+// requestUpload creates asset waiting for upload, to direct upload URL or
+// resumable (tus) endpoint
+func (s *Server) requestUpload(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	s.mu.Lock()
+	a := s.newAsset(req.Name)
+	t := s.newTask("upload", api.TaskPhaseWaiting)
+	t.OutputAssetID = a.ID
+	t.Params.Upload = &api.UploadTaskParams{}
+	a.uploadTask = t.ID
+	res := api.UploadUrls{
+		Url:         fmt.Sprintf("%s/api/asset/upload/direct?token=%s", s.URL(), a.ID),
+		TusEndpoint: fmt.Sprintf("%s/api/asset/upload/tus?token=%s", s.URL(), a.ID),
+		Asset:       a.Asset,
+		Task:        api.TaskOnlyId{ID: t.ID},
+	}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, res)
+}
+
+// uploadAsset returns asset waiting for the upload
+func (s *Server) uploadAsset(id string) (*asset, error) {
+	a := s.assets[id]
+	if a == nil {
+		return nil, api.ErrNotExists
+	}
+	if a.uploadTask == "" || s.tasks[a.uploadTask].Status.Phase != api.TaskPhaseWaiting {
+		return nil, errors.New("asset is already uploaded")
+	}
+	return a, nil
+}
+
+func (s *Server) directUpload(w http.ResponseWriter, r *http.Request) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.mu.Lock()
+	a, err := s.uploadAsset(r.URL.Query().Get("token"))
+	s.mu.Unlock()
+	if err == api.ErrNotExists {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	} else if err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	s.uploaded(a, data)
+	w.WriteHeader(http.StatusOK)
+}
+
+// tusUpload implements the subset of the tus protocol used by the tus client:
+// creation (POST to the endpoint), offset (HEAD) and data (PATCH) requests
+func (s *Server) tusUpload(w http.ResponseWriter, r *http.Request, pp []string) {
+	w.Header().Set("Tus-Resumable", "1.0.0")
+	id := r.URL.Query().Get("token")
+	if len(pp) == 1 {
+		id = pp[0]
+	}
+	s.mu.Lock()
+	a, err := s.uploadAsset(id)
+	if err == api.ErrNotExists {
+		s.mu.Unlock()
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		s.mu.Unlock()
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	switch {
+	case len(pp) == 0 && r.Method == http.MethodPost:
+		length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+		if err != nil || length <= 0 {
+			s.mu.Unlock()
+			http.Error(w, "invalid Upload-Length", http.StatusBadRequest)
+			return
+		}
+		a.uploadLength, a.uploaded = length, nil
+		s.mu.Unlock()
+		w.Header().Set("Location", "/api/asset/upload/tus/"+a.ID)
+		w.WriteHeader(http.StatusCreated)
+	case len(pp) == 1 && r.Method == http.MethodHead:
+		offset, length := len(a.uploaded), a.uploadLength
+		s.mu.Unlock()
+		w.Header().Set("Upload-Offset", strconv.Itoa(offset))
+		w.Header().Set("Upload-Length", strconv.FormatInt(length, 10))
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+	case len(pp) == 1 && r.Method == http.MethodPatch:
+		s.mu.Unlock()
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		if offset := r.Header.Get("Upload-Offset"); offset != strconv.Itoa(len(a.uploaded)) {
+			s.mu.Unlock()
+			http.Error(w, "offset mismatch", http.StatusConflict)
+			return
+		}
+		a.uploaded = append(a.uploaded, data...)
+		offset, done := len(a.uploaded), int64(len(a.uploaded)) >= a.uploadLength
+		s.mu.Unlock()
+		if done {
+			s.uploaded(a, a.uploaded)
+		}
+		w.Header().Set("Upload-Offset", strconv.Itoa(offset))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.mu.Unlock()
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// uploaded starts processing of the uploaded file
+func (s *Server) uploaded(a *asset, data []byte) {
+	glog.Infof("Fake API asset uploaded id=%s size=%d", a.ID, len(data))
+	s.runTask(a.uploadTask, a.ID, func() error {
+		return s.processAsset(a.ID, data)
+	})
+}
+
+// processAsset cuts the file (MP4 or MPEG-TS) into segments, to be served as HLS
+func (s *Server) processAsset(id string, data []byte) error {
+	var demuxer av.Demuxer
+	format := "mp4"
+	if len(data) > 0 && data[0] == 0x47 {
+		// MPEG-TS sync byte
+		demuxer = ts.NewDemuxer(bytes.NewReader(data))
+		format = "mpegts"
+	} else {
+		demuxer = mp4.NewDemuxer(bytes.NewReader(data))
+	}
+	var segments []*vodSegment
+	var duration time.Duration
+	err := mockbroadcaster.CutSegments(demuxer, assetSegmentDuration, func(seg *model.HlsSegment) {
+		segments = append(segments, &vodSegment{seqNo: seg.SeqNo, duration: seg.Duration, data: seg.Data})
+		duration += seg.Duration
+	})
+	if err != nil {
+		return fmt.Errorf("error reading %s file: %w", format, err)
+	}
+	if len(segments) == 0 {
+		return errors.New("no video found in the file")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a := s.assets[id]
+	a.data = data
+	a.segments = segments
+	a.Size = uint64(len(data))
+	a.VideoSpec = &api.AssetVideoSpec{Format: format, DurationSec: duration.Seconds()}
+	sum := sha256.Sum256(data)
+	a.Hash = []api.AssetHash{{Hash: hex.EncodeToString(sum[:]), Algorithm: "sha256"}}
+	a.PlaybackURL = fmt.Sprintf("%s/asset/%s/index.m3u8", s.URL(), a.PlaybackID)
+	a.DownloadURL = fmt.Sprintf("%s/asset/%s/video", s.URL(), a.PlaybackID)
+	return nil
+}
+
+// runTask runs work in background. Task is running for at least TaskDuration,
+// then it is completed, or failed if work returned error. Output asset's
+// status follows the task's phase
+func (s *Server) runTask(taskID, assetID string, work func() error) {
+	go func() {
+		started := time.Now()
+		s.updateTask(taskID, assetID, api.TaskPhaseRunning, 0, nil)
+		err := work()
+		if rest := s.opts.TaskDuration - time.Since(started); rest > 0 && err == nil {
+			time.Sleep(rest / 2)
+			s.updateTask(taskID, assetID, api.TaskPhaseRunning, 0.5, nil)
+			time.Sleep(rest / 2)
+		}
+		if err != nil {
+			glog.Errorf("Fake API task failed id=%s err=%v", taskID, err)
+			s.updateTask(taskID, assetID, api.TaskPhaseFailed, 0, err)
+			return
+		}
+		s.updateTask(taskID, assetID, api.TaskPhaseCompleted, 1, nil)
+	}()
+}
+
+func (s *Server) updateTask(taskID, assetID string, phase api.TaskPhase, progress float64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := millis(time.Now())
+	t := s.tasks[taskID]
+	t.Status = api.TaskStatus{Phase: phase, Progress: progress, UpdatedAt: now}
+	if err != nil {
+		t.Status.ErrorMessage = err.Error()
+	}
+	if a := s.assets[assetID]; a != nil {
+		switch phase {
+		case api.TaskPhaseRunning:
+			a.Status.Phase = assetProcessing
+		case api.TaskPhaseCompleted:
+			a.Status.Phase = assetReady
+		case api.TaskPhaseFailed:
+			a.Status.Phase = assetFailed
+			a.Status.ErrorMessage = t.Status.ErrorMessage
+		}
+		a.Status.UpdatedAt = now
+	}
+}
+
+// readyAsset returns asset ready to be used as the input of a task
+func (s *Server) readyAsset(id string) (*asset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a := s.assets[id]
+	if a == nil || a.Deleted {
+		return nil, fmt.Errorf("asset id=%s not found", id)
+	}
+	if a.Status.Phase != assetReady {
+		return nil, fmt.Errorf("asset id=%s is not ready", id)
+	}
+	return a, nil
+}
+
+func (s *Server) transcodeAsset(w http.ResponseWriter, r *http.Request, id string) {
+	var req struct {
+		Name    string      `json:"name"`
+		Profile api.Profile `json:"profile"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if _, err := s.readyAsset(id); err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	s.mu.Lock()
+	a := s.newAsset(req.Name)
+	a.SourceAssetID = id
+	t := s.newTask("transcode", api.TaskPhasePending)
+	t.InputAssetID, t.OutputAssetID = id, a.ID
+	t.Params.Transcode = &api.TranscodeTaskParams{Profile: req.Profile}
+	res := api.TaskAndAsset{Asset: a.Asset, Task: *t}
+	s.mu.Unlock()
+	s.runTask(t.ID, a.ID, func() error {
+		src, err := s.readyAsset(id)
+		if err != nil {
+			return err
+		}
+		// fake transcoding is the copy of the source
+		return s.processAsset(a.ID, src.data)
+	})
+	writeJSON(w, http.StatusCreated, res)
+}
+
+// exportAsset exports asset to IPFS. Nothing is uploaded, IPFS output has
+// made up CIDs
+func (s *Server) exportAsset(w http.ResponseWriter, r *http.Request, id string) {
+	var params api.ExportTaskParams
+	if !readJSON(w, r, &params) {
+		return
+	}
+	if _, err := s.readyAsset(id); err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	s.mu.Lock()
+	t := s.newTask("export", api.TaskPhasePending)
+	t.InputAssetID = id
+	t.Params.Export = &params
+	res := api.ExportAssetResp{Task: *t}
+	s.mu.Unlock()
+	s.runTask(t.ID, "", func() error {
+		a, err := s.readyAsset(id)
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		cid := "fake" + a.Hash[0].Hash[:40]
+		a.Storage.IPFS = &api.AssetIPFS{IPFSFileInfo: api.IPFSFileInfo{CID: cid, Url: "ipfs://" + cid, GatewayUrl: "https://ipfs.io/ipfs/" + cid}}
+		output := map[string]interface{}{"export": map[string]interface{}{"ipfs": map[string]string{
+			"videoFileCid":        cid,
+			"videoFileUrl":        "ipfs://" + cid,
+			"videoFileGatewayUrl": "https://ipfs.io/ipfs/" + cid,
+		}}}
+		b, _ := json.Marshal(output)
+		return json.Unmarshal(b, &s.tasks[t.ID].Output)
+	})
+	writeJSON(w, http.StatusCreated, res)
+}
+
+// handleTranscodeFile accepts transcode file task. Input is fetched if it is
+// HTTP URL, but nothing is written to the output storage
+func (s *Server) handleTranscodeFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	var req api.TranscodeFileReq
+	if !readJSON(w, r, &req) {
+		return
+	}
+	s.mu.Lock()
+	t := s.newTask("transcode-file", api.TaskPhasePending)
+	params := &api.TranscodeFileTaskParams{}
+	params.Input.URL = req.Input.Url
+	params.Storage.URL = req.Storage.Endpoint
+	params.Outputs.HLS.Path = req.Outputs.Hls.Path
+	t.Params.TranscodeFile = params
+	res := *t
+	s.mu.Unlock()
+	s.runTask(t.ID, "", func() error {
+		if !strings.HasPrefix(req.Input.Url, "http://") && !strings.HasPrefix(req.Input.Url, "https://") {
+			return nil
+		}
+		resp, err := importClient.Get(req.Input.Url)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("error fetching input url=%s status=%s", req.Input.Url, resp.Status)
+		}
+		return nil
+	})
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) handleTask(w http.ResponseWriter, r *http.Request, pp []string) {
+	switch {
+	case len(pp) == 1 && r.Method == http.MethodGet:
+		s.mu.Lock()
+		t := s.tasks[pp[0]]
+		var res api.Task
+		if t != nil {
+			res = *t
+		}
+		s.mu.Unlock()
+		if t == nil {
+			writeError(w, http.StatusNotFound, "not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+	case len(pp) == 2 && pp[1] == "status" && r.Method == http.MethodPost:
+		var req struct {
+			Status api.TaskStatus `json:"status"`
+		}
+		if !readJSON(w, r, &req) {
+			return
+		}
+		s.mu.Lock()
+		t := s.tasks[pp[0]]
+		if t != nil {
+			req.Status.UpdatedAt = millis(time.Now())
+			t.Status = req.Status
+		}
+		s.mu.Unlock()
+		if t == nil {
+			writeError(w, http.StatusNotFound, "not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"id": pp[0]})
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// listAssets lists assets, only filter by playbackId is supported
+func (s *Server) listAssets(w http.ResponseWriter, r *http.Request) {
+	var filters []struct {
+		ID    string `json:"id"`
+		Value string `json:"value"`
+	}
+	if f := r.URL.Query().Get("filters"); f != "" {
+		if err := json.Unmarshal([]byte(f), &filters); err != nil {
+			writeError(w, http.StatusUnprocessableEntity, "invalid filters: "+err.Error())
+			return
+		}
+	}
+	all := r.URL.Query().Get("all") == "true"
+	s.mu.Lock()
+	res := []api.Asset{}
+	for _, a := range s.assets {
+		ok := all || !a.Deleted
+		for _, f := range filters {
+			ok = ok && (f.ID != "playbackId" || f.Value == a.PlaybackID)
+		}
+		if ok {
+			res = append(res, a.Asset)
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(res, func(i, j int) bool { return res[i].CreatedAt > res[j].CreatedAt })
+	writeJSON(w, http.StatusOK, res)
+}
+
+// handlePlayback returns playback info of the asset or of the live stream
+func (s *Server) handlePlayback(w http.ResponseWriter, r *http.Request, pp []string) {
+	if len(pp) != 1 || r.Method != http.MethodGet {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	var info api.PlaybackInfo
+	s.mu.Lock()
+	for _, a := range s.assets {
+		if a.PlaybackID == pp[0] && !a.Deleted && a.Status.Phase == assetReady {
+			info.Type = api.PlaybackInfoTypeVod
+			info.Meta.Source = []api.PlaybackInfoSource{{Hrn: api.HRN_HLS, Type: api.PlaybackInfoSourceTypeHLS, Url: a.PlaybackURL}}
+		}
+	}
+	var live bool
+	for _, st := range s.streams {
+		if st.ParentID == "" && st.PlaybackID == pp[0] && !st.Deleted {
+			live = true
+		}
+	}
+	s.mu.Unlock()
+	if live && s.opts.Broadcaster != nil {
+		info.Type = api.PlaybackInfoTypeLive
+		info.Meta.Source = []api.PlaybackInfoSource{{Hrn: api.HRN_HLS, Type: api.PlaybackInfoSourceTypeHLS,
+			Url: s.opts.Broadcaster.MediaURL(mockbroadcaster.LayoutMist, pp[0])}}
+	}
+	if info.Type == "" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// serveAssetHLS serves asset as VOD HLS, and the original file as download
+func (s *Server) serveAssetHLS(w http.ResponseWriter, r *http.Request, pp []string) {
+	if len(pp) < 2 {
+		http.NotFound(w, r)
+		return
+	}
+	s.mu.Lock()
+	var a *asset
+	for _, v := range s.assets {
+		if v.PlaybackID == pp[0] && !v.Deleted && v.Status.Phase == assetReady {
+			a = v
+		}
+	}
+	s.mu.Unlock()
+	if a == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if len(pp) == 2 && pp[1] == "video" {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(a.data)
+		return
+	}
+	serveVOD(w, r, api.StandardProfiles, a.segments, pp[1:])
+}
@@ -0,0 +1,271 @@
+// Package fakeapi is in-process stand-in for the Livepeer API. It implements
+// the endpoints used by the testers (streams, sessions with recording status,
+// ingests and broadcasters, assets and tasks), keeping everything in memory.
+// Combined with mockbroadcaster, record and VOD workflows can be run against
+// local stack
+package fakeapi
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/go-api-client"
+	"github.com/livepeer/stream-tester/internal/mockbroadcaster"
+	"github.com/livepeer/stream-tester/model"
+)
+
+type (
+	// Options of the fake API
+	Options struct {
+		// Addr address to listen on. Random free port on localhost is used if empty
+		Addr string
+		// Token if not empty, API requests should have it as the bearer token
+		Token string
+		// Broadcaster if set, streams are published to it. It is used to
+		// build ingests and broadcasters lists, and its streams are tracked
+		// as sessions
+		Broadcaster *mockbroadcaster.Broadcaster
+		// Ingests, Broadcasters returned by the API. If empty, they are
+		// derived from the Broadcaster
+		Ingests      []api.Ingest
+		Broadcasters []string
+		// TaskDuration minimal time task spends running
+		TaskDuration time.Duration
+		// RecordingReadyAfter time after the end of the session recording
+		// becomes ready
+		RecordingReadyAfter time.Duration
+		// SessionJoinWindow stream reconnected within this time continues
+		// the previous session
+		SessionJoinWindow time.Duration
+		// SessionTimeout session without new segments for this long is
+		// considered ended (for HTTP push, which has no explicit end)
+		SessionTimeout time.Duration
+	}
+
+	// Server fake Livepeer API server
+	Server struct {
+		opts    Options
+		addr    string
+		server  *http.Server
+		mu      sync.Mutex
+		streams map[string]*stream
+		// live sessions by manifest ID under which they are published
+		live    map[string]*stream
+		assets  map[string]*asset
+		tasks   map[string]*api.Task
+		stopped bool
+	}
+)
+
+// New creates fake API server. If broadcaster is given in the options, its
+// hooks are set to the server
+func New(opts Options) *Server {
+	if opts.TaskDuration <= 0 {
+		opts.TaskDuration = 2 * time.Second
+	}
+	if opts.RecordingReadyAfter <= 0 {
+		opts.RecordingReadyAfter = 5 * time.Minute
+	}
+	if opts.SessionJoinWindow <= 0 {
+		opts.SessionJoinWindow = 5 * time.Minute
+	}
+	if opts.SessionTimeout <= 0 {
+		opts.SessionTimeout = time.Minute
+	}
+	s := &Server{
+		opts:    opts,
+		streams: make(map[string]*stream),
+		live:    make(map[string]*stream),
+		assets:  make(map[string]*asset),
+		tasks:   make(map[string]*api.Task),
+	}
+	if opts.Broadcaster != nil {
+		opts.Broadcaster.SetHooks(mockbroadcaster.Hooks{
+			Publish: s.publish,
+			Segment: s.segment,
+			Ended:   s.ended,
+		})
+	}
+	return s
+}
+
+// Start starts serving the API
+func (s *Server) Start(ctx context.Context) error {
+	addr := s.opts.Addr
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.addr = l.Addr().String()
+	s.server = &http.Server{Handler: s}
+	go func() {
+		if err := s.server.Serve(l); err != http.ErrServerClosed {
+			glog.Errorf("Fake API server error err=%v", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		s.Stop()
+	}()
+	glog.Infof("Fake API started url=%s", s.URL())
+	return nil
+}
+
+// Stop stops the server
+func (s *Server) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return
+	}
+	s.stopped = true
+	if s.server != nil {
+		s.server.Close()
+	}
+}
+
+// URL returns base URL of the API, to be used as the API server by the clients
+func (s *Server) URL() string {
+	return "http://" + s.addr
+}
+
+// ServeHTTP routes requests to the API endpoints, and serves recordings and
+// assets as HLS
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pp := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	glog.V(model.VERBOSE).Infof("Fake API request method=%s url=%s", r.Method, r.URL)
+	switch pp[0] {
+	case "recordings":
+		s.serveRecording(w, r, pp[1:])
+		return
+	case "asset":
+		s.serveAssetHLS(w, r, pp[1:])
+		return
+	case "api":
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if len(pp) < 2 {
+		http.NotFound(w, r)
+		return
+	}
+	if !isUpload(pp) && !s.authorized(r) {
+		writeError(w, http.StatusUnauthorized, "no authorization token provided")
+		return
+	}
+	switch pp[1] {
+	case "ingest":
+		s.handleIngest(w, r)
+	case "broadcaster":
+		s.handleBroadcasters(w, r)
+	case "stream":
+		s.handleStream(w, r, pp[2:])
+	case "session":
+		s.handleSession(w, r, pp[2:])
+	case "playback":
+		s.handlePlayback(w, r, pp[2:])
+	case "asset":
+		s.handleAsset(w, r, pp[2:])
+	case "task":
+		s.handleTask(w, r, pp[2:])
+	case "transcode":
+		s.handleTranscodeFile(w, r)
+	default:
+		// multistream targets, object stores and such are not supported
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// isUpload returns true for upload endpoints, which are authorized by the
+// token in the URL
+func isUpload(pp []string) bool {
+	return len(pp) >= 4 && pp[1] == "asset" && pp[2] == "upload" && (pp[3] == "direct" || pp[3] == "tus")
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	return s.opts.Token == "" || r.Header.Get("Authorization") == "Bearer "+s.opts.Token
+}
+
+func (s *Server) handleIngest(w http.ResponseWriter, r *http.Request) {
+	ingests := s.opts.Ingests
+	if len(ingests) == 0 && s.opts.Broadcaster != nil {
+		ingests = []api.Ingest{{
+			Base:     s.URL(),
+			Ingest:   fmt.Sprintf("rtmp://%s/live", s.opts.Broadcaster.RTMPAddr()),
+			Playback: fmt.Sprintf("http://%s/hls", s.opts.Broadcaster.HTTPAddr()),
+		}}
+	}
+	if ingests == nil {
+		ingests = []api.Ingest{}
+	}
+	if r.URL.Query().Get("first") != "false" && len(ingests) > 1 {
+		ingests = ingests[:1]
+	}
+	writeJSON(w, http.StatusOK, ingests)
+}
+
+func (s *Server) handleBroadcasters(w http.ResponseWriter, r *http.Request) {
+	addrs := s.opts.Broadcasters
+	if len(addrs) == 0 && s.opts.Broadcaster != nil {
+		addrs = []string{"http://" + s.opts.Broadcaster.HTTPAddr()}
+	}
+	type address struct {
+		Address string `json:"address"`
+	}
+	res := []address{}
+	for _, a := range addrs {
+		res = append(res, address{a})
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		glog.Errorf("Fake API error writing response err=%v", err)
+	}
+}
+
+// writeError writes error in the format of the Livepeer API
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string][]string{"errors": {msg}})
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, "invalid request body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// newID returns random hex string of n bytes
+func newID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// newUUID returns random id in the form of the API's object ids
+func newUUID() string {
+	id := newID(16)
+	return id[:8] + "-" + id[8:12] + "-" + id[12:16] + "-" + id[16:20] + "-" + id[20:]
+}
+
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
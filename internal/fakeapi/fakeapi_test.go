@@ -0,0 +1,152 @@
+package fakeapi
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-api-client"
+	"github.com/livepeer/m3u8"
+	"github.com/livepeer/stream-tester/internal/mockbroadcaster"
+)
+
+const testToken = "secret"
+
+func startAPI(t *testing.T, opts Options) (*Server, *api.Client) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	b := mockbroadcaster.New(mockbroadcaster.Options{})
+	if err := b.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	opts.Broadcaster = b
+	opts.Token = testToken
+	s := New(opts)
+	if err := s.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	lapi := api.NewAPIClient(api.ClientOptions{Server: s.URL(), AccessToken: testToken, Timeout: 5 * time.Second})
+	return s, lapi
+}
+
+func TestTokenAuth(t *testing.T) {
+	s, lapi := startAPI(t, Options{})
+	for _, auth := range []string{"", "Bearer wrong"} {
+		req, _ := http.NewRequest(http.MethodGet, s.URL()+"/api/ingest", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("request with authorization %q returned %d", auth, resp.StatusCode)
+		}
+	}
+	ingests, err := lapi.Ingest(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ingests) != 1 || ingests[0].Ingest == "" || ingests[0].Playback == "" {
+		t.Errorf("got ingests %+v", ingests)
+	}
+	bad := api.NewAPIClient(api.ClientOptions{Server: s.URL(), AccessToken: "wrong"})
+	if _, err := bad.CreateStreamEx("unauthorized", false, nil); err == nil {
+		t.Error("stream created with wrong token")
+	}
+}
+
+func TestRecordingFlow(t *testing.T) {
+	s, lapi := startAPI(t, Options{
+		SessionTimeout:      200 * time.Millisecond,
+		RecordingReadyAfter: 100 * time.Millisecond,
+	})
+	stream, err := lapi.CreateStreamEx("recorded", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stream.StreamKey == "" || stream.PlaybackID == "" || len(stream.Profiles) == 0 {
+		t.Fatalf("stream is not filled in: %+v", stream)
+	}
+	if byKey, err := lapi.GetStreamByKey(stream.StreamKey); err != nil || byKey.ID != stream.ID {
+		t.Errorf("stream by key is %+v err=%v", byKey, err)
+	}
+	const segments = 3
+	for i := 0; i < segments; i++ {
+		transcoded, err := lapi.PushSegment(stream.ID, i, 2*time.Second, []byte("segment"), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(transcoded) != len(mockbroadcaster.DefaultRenditions) {
+			t.Errorf("push returned %d renditions", len(transcoded))
+		}
+	}
+	sessions, err := lapi.GetSessionsNew(stream.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("stream has %d sessions", len(sessions))
+	}
+	sess := sessions[0]
+	if sess.ParentID != stream.ID || sess.SourceSegments != segments || sess.RecordingStatus != api.RecordingStatusWaiting || sess.RecordingURL != "" {
+		t.Errorf("live session is %+v", sess)
+	}
+	if forced, err := lapi.GetSessionsNew(stream.ID, true); err != nil || forced[0].RecordingURL == "" {
+		t.Errorf("recording URL is not forced: %+v err=%v", forced, err)
+	}
+	// session times out without new segments, then recording becomes ready
+	time.Sleep(400 * time.Millisecond)
+	ready, err := lapi.GetStream(sess.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ready.RecordingStatus != api.RecordingStatusReady || ready.RecordingURL == "" {
+		t.Fatalf("recording is not ready: %+v", ready)
+	}
+	resp, err := http.Get(ready.RecordingURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	pl, plType, err := m3u8.DecodeFrom(bytes.NewReader(data), true)
+	if err != nil || plType != m3u8.MASTER || len(pl.(*m3u8.MasterPlaylist).Variants) != 1+len(stream.Profiles) {
+		t.Fatalf("invalid recording playlist err=%v:\n%s", err, data)
+	}
+	resp, err = http.Get(s.URL() + "/recordings/" + sess.ID + "/" + mockbroadcaster.SourceRendition + "/index.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	pl, plType, err = m3u8.DecodeFrom(bytes.NewReader(data), true)
+	if err != nil || plType != m3u8.MEDIA {
+		t.Fatalf("invalid recording media playlist err=%v:\n%s", err, data)
+	}
+	if mpl := pl.(*m3u8.MediaPlaylist); mpl.Count() != segments || mpl.MediaType != m3u8.VOD {
+		t.Errorf("recording media playlist has %d segments:\n%s", mpl.Count(), data)
+	}
+	// stream pushed again after the join window gets new session
+	s.mu.Lock()
+	s.opts.SessionJoinWindow = time.Millisecond
+	s.mu.Unlock()
+	if _, err := lapi.PushSegment(stream.ID, 0, 2*time.Second, []byte("segment"), ""); err != nil {
+		t.Fatal(err)
+	}
+	if sessions, err := lapi.GetSessionsNew(stream.ID, false); err != nil || len(sessions) != 2 {
+		t.Errorf("stream has sessions %+v err=%v", sessions, err)
+	}
+	if err := lapi.DeleteStream(stream.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lapi.PushSegment(stream.ID, 1, 2*time.Second, []byte("segment"), ""); err == nil {
+		t.Error("segment of deleted stream accepted")
+	}
+}
@@ -0,0 +1,347 @@
+package fakeapi
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/go-api-client"
+	"github.com/livepeer/stream-tester/model"
+)
+
+type (
+	// stream parent stream or session (stream with ParentID)
+	stream struct {
+		api.Stream
+		// session's fields
+		segments []*vodSegment
+		lastSeen time.Time
+		ended    bool
+		// run incremented on every reconnect to the session, as sequence
+		// numbers start from zero again
+		run int
+	}
+
+	vodSegment struct {
+		run      int
+		seqNo    int
+		duration time.Duration
+		data     []byte
+	}
+)
+
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request, pp []string) {
+	switch {
+	case len(pp) == 0 && r.Method == http.MethodPost:
+		s.createStream(w, r, "")
+	case len(pp) == 1 && pp[0] == "deactivate-many" && r.Method == http.MethodPatch:
+		s.deactivateMany(w, r)
+	case len(pp) == 1 && r.Method == http.MethodGet:
+		s.getStream(w, r, func(st *stream) bool { return st.ID == pp[0] })
+	case len(pp) == 1 && r.Method == http.MethodDelete:
+		s.deleteStream(w, pp[0])
+	case len(pp) == 2 && pp[0] == "key" && r.Method == http.MethodGet:
+		s.getStream(w, r, func(st *stream) bool { return st.StreamKey == pp[1] && !st.Deleted })
+	case len(pp) == 2 && pp[0] == "playback" && r.Method == http.MethodGet:
+		s.getStream(w, r, func(st *stream) bool { return st.PlaybackID == pp[1] && !st.Deleted })
+	case len(pp) == 2 && pp[1] == "stream" && r.Method == http.MethodPost:
+		s.createStream(w, r, pp[0])
+	case len(pp) == 2 && pp[1] == "sessions" && r.Method == http.MethodGet:
+		s.listSessions(w, r, pp[0])
+	case len(pp) == 2 && pp[1] == "setactive" && r.Method == http.MethodPut:
+		s.setActive(w, pp[0])
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (s *Server) handleSession(w http.ResponseWriter, r *http.Request, pp []string) {
+	switch {
+	case len(pp) == 0 && r.Method == http.MethodGet:
+		s.listSessions(w, r, r.URL.Query().Get("parentId"))
+	case len(pp) == 1 && r.Method == http.MethodGet:
+		s.getStream(w, r, func(st *stream) bool { return st.ID == pp[0] && st.ParentID != "" })
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// createStream creates stream, or session of the parent stream if parentID is not empty
+func (s *Server) createStream(w http.ResponseWriter, r *http.Request, parentID string) {
+	var req api.CreateStreamReq
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.Name == "" {
+		writeError(w, http.StatusUnprocessableEntity, "stream must have a name")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if parentID != "" {
+		parent := s.streams[parentID]
+		if parent == nil || parent.ParentID != "" || parent.Deleted {
+			writeError(w, http.StatusNotFound, "parent stream not found")
+			return
+		}
+		sess := s.newSession(parent)
+		sess.Name = req.Name
+		glog.Infof("Fake API created session id=%s parentId=%s", sess.ID, parentID)
+		writeJSON(w, http.StatusCreated, s.view(sess, false))
+		return
+	}
+	st := &stream{Stream: api.Stream{
+		ID:         newUUID(),
+		Name:       req.Name,
+		Kind:       "stream",
+		Presets:    req.Presets,
+		Profiles:   req.Profiles,
+		Record:     req.Record,
+		StreamKey:  fmt.Sprintf("%s-%s-%s-%s", newID(2), newID(2), newID(2), newID(2)),
+		PlaybackID: newID(8),
+		CreatedAt:  millis(time.Now()),
+	}}
+	if len(st.Profiles) == 0 && len(st.Presets) == 0 {
+		st.Profiles = api.StandardProfiles
+	}
+	s.streams[st.ID] = st
+	glog.Infof("Fake API created stream id=%s name=%s streamKey=%s playbackId=%s", st.ID, st.Name, st.StreamKey, st.PlaybackID)
+	writeJSON(w, http.StatusCreated, s.view(st, false))
+}
+
+// newSession creates session of the parent stream. Should be called with lock held
+func (s *Server) newSession(parent *stream) *stream {
+	now := time.Now()
+	sess := &stream{
+		Stream: api.Stream{
+			ID:         newUUID(),
+			Name:       parent.Name,
+			Kind:       "stream",
+			ParentID:   parent.ID,
+			PlaybackID: parent.PlaybackID,
+			Presets:    parent.Presets,
+			Profiles:   parent.Profiles,
+			Record:     parent.Record,
+			CreatedAt:  millis(now),
+		},
+		lastSeen: now,
+	}
+	s.streams[sess.ID] = sess
+	return sess
+}
+
+func (s *Server) getStream(w http.ResponseWriter, r *http.Request, match func(st *stream) bool) {
+	forceURL := r.URL.Query().Get("forceUrl") != ""
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, st := range s.streams {
+		if match(st) {
+			writeJSON(w, http.StatusOK, s.view(st, forceURL))
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "not found")
+}
+
+func (s *Server) deleteStream(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.streams[id]
+	if st == nil {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	st.Deleted = true
+	glog.Infof("Fake API deleted stream id=%s", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listSessions(w http.ResponseWriter, r *http.Request, parentID string) {
+	forceURL := r.URL.Query().Get("forceUrl") != ""
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if parent := s.streams[parentID]; parent == nil || parent.ParentID != "" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	res := []api.UserSession{}
+	for _, st := range s.streams {
+		if st.ParentID == parentID {
+			res = append(res, api.UserSession{Stream: s.view(st, forceURL)})
+		}
+	}
+	// newest first, as the API does
+	sort.Slice(res, func(i, j int) bool { return res[i].CreatedAt > res[j].CreatedAt })
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) setActive(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	st := s.streams[id]
+	s.mu.Unlock()
+	if st == nil {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if st.Deleted {
+		writeError(w, http.StatusForbidden, "stream is deleted")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) deactivateMany(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		IDs []string `json:"ids"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	s.mu.Lock()
+	count := 0
+	for _, id := range req.IDs {
+		if s.streams[id] != nil {
+			count++
+		}
+	}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]int{"rowCount": count})
+}
+
+// view returns stream as returned by the API. Recording status and URL are
+// filled in for the sessions of the recorded streams. Should be called with lock held
+func (s *Server) view(st *stream, forceURL bool) api.Stream {
+	res := st.Stream
+	if st.ParentID == "" || !st.Record {
+		return res
+	}
+	res.RecordingStatus = api.RecordingStatusWaiting
+	if len(st.segments) == 0 {
+		return res
+	}
+	idle := time.Since(st.lastSeen)
+	if (st.ended || idle > s.opts.SessionTimeout) && idle >= s.opts.RecordingReadyAfter {
+		res.RecordingStatus = api.RecordingStatusReady
+	}
+	if forceURL || res.RecordingStatus == api.RecordingStatusReady {
+		res.RecordingURL = fmt.Sprintf("%s/recordings/%s/index.m3u8", s.URL(), st.ID)
+	}
+	return res
+}
+
+// publish is the broadcaster's hook. Stream key, stream ID or session ID
+// (pushed segments) is mapped to the session, new session is created if the
+// stream was not active within the join window
+func (s *Server) publish(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if st := s.streams[key]; st != nil && st.ParentID != "" {
+		// session created through the API
+		if parent := s.streams[st.ParentID]; parent == nil || parent.Deleted {
+			return "", false
+		}
+		s.live[key] = st
+		s.resume(st)
+		return key, true
+	}
+	var parent *stream
+	for _, st := range s.streams {
+		if st.ParentID == "" && !st.Deleted && (st.ID == key || st.StreamKey == key) {
+			parent = st
+			break
+		}
+	}
+	if parent == nil {
+		glog.Infof("Fake API rejected stream key=%s", key)
+		return "", false
+	}
+	// streams published by key are played back by playback ID
+	manifestID := parent.PlaybackID
+	if key == parent.ID {
+		manifestID = key
+	}
+	sess := s.live[manifestID]
+	if sess == nil || sess.ParentID != parent.ID || time.Since(sess.lastSeen) > s.opts.SessionJoinWindow {
+		sess = s.newSession(parent)
+		s.live[manifestID] = sess
+		glog.Infof("Fake API started session id=%s parentId=%s manifestID=%s", sess.ID, parent.ID, manifestID)
+	} else {
+		s.resume(sess)
+	}
+	return manifestID, true
+}
+
+// resume continues the session. Should be called with lock held
+func (s *Server) resume(sess *stream) {
+	if sess.ended || (len(sess.segments) > 0 && time.Since(sess.lastSeen) > s.opts.SessionTimeout) {
+		sess.run++
+	}
+	sess.ended = false
+	sess.lastSeen = time.Now()
+}
+
+// segment is the broadcaster's hook, it records source segment into the session
+func (s *Server) segment(manifestID string, seg *model.HlsSegment) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess := s.live[manifestID]
+	if sess == nil {
+		return
+	}
+	now := time.Now()
+	sess.lastSeen = now
+	vs := &vodSegment{run: sess.run, seqNo: seg.SeqNo, duration: seg.Duration, data: seg.Data}
+	// pushed segment can be retried
+	for i := len(sess.segments) - 1; i >= 0 && sess.segments[i].run == sess.run; i-- {
+		if sess.segments[i].seqNo == seg.SeqNo {
+			sess.segments[i] = vs
+			return
+		}
+	}
+	sess.segments = append(sess.segments, vs)
+	for _, st := range []*stream{sess, s.streams[sess.ParentID]} {
+		if st == nil {
+			continue
+		}
+		st.LastSeen = millis(now)
+		st.SourceSegments++
+		st.SourceSegmentsDuration += seg.Duration.Seconds()
+		st.TranscodedSegments += int64(len(sess.Profiles))
+		st.TranscodedSegmentsDuration += seg.Duration.Seconds() * float64(len(sess.Profiles))
+	}
+}
+
+// ended is the broadcaster's hook, called when RTMP stream ends
+func (s *Server) ended(manifestID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sess := s.live[manifestID]; sess != nil {
+		sess.ended = true
+		sess.lastSeen = time.Now()
+		glog.Infof("Fake API session ended id=%s manifestID=%s segments=%d", sess.ID, manifestID, len(sess.segments))
+	}
+}
+
+// serveRecording serves session's recording as VOD HLS
+func (s *Server) serveRecording(w http.ResponseWriter, r *http.Request, pp []string) {
+	if len(pp) < 2 {
+		http.NotFound(w, r)
+		return
+	}
+	s.mu.Lock()
+	sess := s.streams[pp[0]]
+	var segments []*vodSegment
+	var profiles []api.Profile
+	if sess != nil && sess.ParentID != "" && sess.Record {
+		segments = append(segments, sess.segments...)
+		profiles = sess.Profiles
+	}
+	s.mu.Unlock()
+	if len(segments) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	serveVOD(w, r, profiles, segments, pp[1:])
+}
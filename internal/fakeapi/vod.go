@@ -0,0 +1,73 @@
+package fakeapi
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/livepeer/go-api-client"
+	"github.com/livepeer/m3u8"
+	"github.com/livepeer/stream-tester/internal/mockbroadcaster"
+)
+
+// serveVOD serves segments as VOD HLS, with the source rendition and fake
+// rendition (copy of the source) for every profile:
+//
+//	index.m3u8, <rendition>/index.m3u8, <rendition>/<n>.ts
+func serveVOD(w http.ResponseWriter, r *http.Request, profiles []api.Profile, segments []*vodSegment, pp []string) {
+	renditions := []string{mockbroadcaster.SourceRendition}
+	for _, p := range profiles {
+		renditions = append(renditions, p.Name)
+	}
+	switch {
+	case len(pp) == 1 && pp[0] == "index.m3u8":
+		mpl := m3u8.NewMasterPlaylist()
+		mpl.Append(mockbroadcaster.SourceRendition+"/index.m3u8", nil, m3u8.VariantParams{
+			Name: mockbroadcaster.SourceRendition, Bandwidth: 4000000, Resolution: "1280x720"})
+		for _, p := range profiles {
+			mpl.Append(p.Name+"/index.m3u8", nil, m3u8.VariantParams{
+				Name: p.Name, Bandwidth: uint32(p.Bitrate), Resolution: fmt.Sprintf("%dx%d", p.Width, p.Height)})
+		}
+		writePlaylist(w, mpl.Encode().Bytes())
+	case len(pp) == 2 && pp[1] == "index.m3u8" && contains(renditions, pp[0]):
+		mpl, err := m3u8.NewMediaPlaylist(0, uint(len(segments)))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		mpl.MediaType = m3u8.VOD
+		for i, seg := range segments {
+			mpl.Append(fmt.Sprintf("%d.ts", i), seg.duration.Seconds(), "")
+			if i > 0 && seg.run != segments[i-1].run {
+				mpl.SetDiscontinuity()
+			}
+		}
+		mpl.Close()
+		writePlaylist(w, mpl.Encode().Bytes())
+	case len(pp) == 2 && strings.HasSuffix(pp[1], ".ts") && contains(renditions, pp[0]):
+		i, err := strconv.Atoi(strings.TrimSuffix(pp[1], ".ts"))
+		if err != nil || i < 0 || i >= len(segments) {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "video/mp2t")
+		w.Write(segments[i].data)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writePlaylist(w http.ResponseWriter, data []byte) {
+	w.Header().Set("Content-Type", "application/x-mpegURL")
+	w.Write(data)
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,286 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/livepeer/stream-tester/model"
+)
+
+type (
+	// Tolerance how much worse current run can be than baseline before it is
+	// considered regression
+	Tolerance struct {
+		// SuccessRate allowed drop of the success rate, percentage points
+		SuccessRate float64
+		// Latency allowed relative increase of the latency (0.1 is 10%)
+		Latency float64
+		// LatencyMin increase of the latency smaller than that is never a regression
+		LatencyMin time.Duration
+		// ErrorRate allowed increase of the errors of each category, percent of segments
+		ErrorRate float64
+		// Confidence z-score the change of the rate should exceed to be
+		// statistically significant (1.96 for 95%). Used only when number of
+		// segments is known for both runs. 0 disables the check
+		Confidence float64
+	}
+
+	// Delta change of one metric between runs
+	Delta struct {
+		Metric     string  `json:"metric"`
+		Unit       string  `json:"unit"`
+		Baseline   float64 `json:"baseline"`
+		Current    float64 `json:"current"`
+		Change     float64 `json:"change"`
+		Regression bool    `json:"regression"`
+		Note       string  `json:"note,omitempty"`
+	}
+
+	// Comparison result of comparison of the current run with the baseline
+	Comparison struct {
+		Baseline    string   `json:"baseline"`
+		Current     string   `json:"current"`
+		Deltas      []*Delta `json:"deltas"`
+		Regressions int      `json:"regressions"`
+	}
+)
+
+// DefaultTolerance tolerance used if not specified otherwise
+var DefaultTolerance = Tolerance{
+	SuccessRate: 1,
+	Latency:     0.1,
+	LatencyMin:  100 * time.Millisecond,
+	ErrorRate:   1,
+	Confidence:  1.96,
+}
+
+// Regressed returns true if current run regressed compared with the baseline
+func (c *Comparison) Regressed() bool {
+	return c.Regressions > 0
+}
+
+// CompareStats compares stats of two streamer runs (as returned by `/stats` endpoint, for example)
+func CompareStats(baseline, current *model.Stats, tol Tolerance) *Comparison {
+	br := &Record{ID: "baseline", Success: true}
+	br.SetStats(baseline)
+	cr := &Record{ID: "current", Success: true}
+	cr.SetStats(current)
+	return Compare(br, cr, tol)
+}
+
+// Compare compares current run with the baseline
+func Compare(baseline, current *Record, tol Tolerance) *Comparison {
+	c := &Comparison{Baseline: baseline.ID, Current: current.ID}
+	if baseline.Success && !current.Success {
+		c.add(&Delta{Metric: "run_success", Baseline: 1, Current: 0, Change: -1, Regression: true, Note: current.Error})
+	}
+
+	bn, cn := baseline.SegmentsNum(), current.SegmentsNum()
+	d := &Delta{Metric: "success_rate", Unit: "%", Baseline: baseline.SuccessRate, Current: current.SuccessRate}
+	d.Change = d.Current - d.Baseline
+	if -d.Change > tol.SuccessRate {
+		d.Regression, d.Note = significant(100-d.Baseline, bn, 100-d.Current, cn, tol.Confidence)
+	}
+	c.add(d)
+
+	latencies := []struct {
+		name      string
+		base, cur model.Latencies
+	}{
+		{"source", baseline.SourceLatencies, current.SourceLatencies},
+		{"transcoded", baseline.TranscodedLatencies, current.TranscodedLatencies},
+	}
+	for _, l := range latencies {
+		for _, p := range []struct {
+			name      string
+			base, cur time.Duration
+		}{{"avg", l.base.Avg, l.cur.Avg}, {"p50", l.base.P50, l.cur.P50}, {"p95", l.base.P95, l.cur.P95}, {"p99", l.base.P99, l.cur.P99}} {
+			if p.base == 0 && p.cur == 0 {
+				continue
+			}
+			d := &Delta{Metric: l.name + "_latency_" + p.name, Unit: "ms", Baseline: durMs(p.base), Current: durMs(p.cur),
+				Change: durMs(p.cur - p.base)}
+			if p.base == 0 {
+				d.Note = "no baseline"
+			} else if float64(p.cur) > float64(p.base)*(1+tol.Latency) && p.cur-p.base > tol.LatencyMin {
+				d.Regression = true
+			}
+			c.add(d)
+		}
+	}
+
+	be, ce := baseline.ErrorCounts(), current.ErrorCounts()
+	var cats []string
+	for cat := range be {
+		cats = append(cats, cat)
+	}
+	for cat := range ce {
+		if _, ok := be[cat]; !ok {
+			cats = append(cats, cat)
+		}
+	}
+	sort.Strings(cats)
+	for _, cat := range cats {
+		if be[cat] == 0 && ce[cat] == 0 {
+			continue
+		}
+		d := &Delta{Metric: "errors_" + cat}
+		if bn > 0 && cn > 0 {
+			d.Unit = "%"
+			d.Baseline = float64(be[cat]) / float64(bn) * 100
+			d.Current = float64(ce[cat]) / float64(cn) * 100
+		} else {
+			d.Baseline, d.Current = float64(be[cat]), float64(ce[cat])
+			d.Note = "number of segments unknown"
+		}
+		d.Change = d.Current - d.Baseline
+		if d.Unit == "%" && d.Change > tol.ErrorRate {
+			d.Regression, d.Note = significant(d.Baseline, bn, d.Current, cn, tol.Confidence)
+		}
+		c.add(d)
+	}
+	return c
+}
+
+func (c *Comparison) add(d *Delta) {
+	c.Deltas = append(c.Deltas, d)
+	if d.Regression {
+		c.Regressions++
+	}
+}
+
+// significant checks with two-proportion z-test if increase of the failure rate
+// (in percent) from p1 of n1 segments to p2 of n2 segments is statistically significant
+func significant(p1 float64, n1 int, p2 float64, n2 int, confidence float64) (bool, string) {
+	if confidence <= 0 || n1 == 0 || n2 == 0 {
+		return true, ""
+	}
+	p1, p2 = p1/100, p2/100
+	p := (p1*float64(n1) + p2*float64(n2)) / float64(n1+n2)
+	se := math.Sqrt(p * (1 - p) * (1/float64(n1) + 1/float64(n2)))
+	if se == 0 {
+		return true, ""
+	}
+	z := (p2 - p1) / se
+	if z < confidence {
+		return false, fmt.Sprintf("not significant (z=%.2f)", z)
+	}
+	return true, fmt.Sprintf("z=%.2f", z)
+}
+
+// SegmentsNum returns number of segments the success rate of the run was calculated from, 0 if not known
+func (r *Record) SegmentsNum() int {
+	if r.Stats != nil {
+		if r.Stats.ShouldHaveDownloadedSegments > 0 {
+			return r.Stats.ShouldHaveDownloadedSegments
+		}
+		return r.Stats.SentSegments
+	}
+	if r.VODStats != nil {
+		return r.VODStats.SegmentsAll
+	}
+	return 0
+}
+
+// ErrorCounts returns number of errors of the run by category
+func (r *Record) ErrorCounts() map[string]int {
+	errs := make(map[string]int)
+	if st := r.Stats; st != nil {
+		errs["failed_to_download"] = st.FailedToDownloadSegments
+		errs["connection_lost"] = st.ConnectionLost
+		errs["retries"] = st.Retries
+		for cat, n := range st.Errors {
+			errs[ErrorCategory(cat)] += n
+		}
+	}
+	if vs := r.VODStats; vs != nil {
+		errs["download_errors"] = vs.DownloadErrors
+		errs["parse_errors"] = vs.ParseErrors
+		for cat, n := range vs.ErrorsDet {
+			errs[ErrorCategory(cat)] += n
+		}
+	}
+	return errs
+}
+
+// ErrorCategory normalizes error category name, so it can be used as metric name
+func ErrorCategory(cat string) string {
+	cat = strings.ToLower(strings.TrimSpace(cat))
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, cat)
+}
+
+func durMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// Load loads run to compare. `ref` can be path to the JSON file with the
+// history record or with the streamer's stats (as returned by `/stats`
+// endpoint), or id of the record in the store
+func Load(store *Store, ref string) (*Record, error) {
+	if _, err := os.Stat(ref); err == nil {
+		data, err := ioutil.ReadFile(ref)
+		if err != nil {
+			return nil, err
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, fmt.Errorf("error parsing %s: %w", ref, err)
+		}
+		if _, isRecord := fields["tool"]; isRecord {
+			r := &Record{}
+			if err := json.Unmarshal(data, r); err != nil {
+				return nil, fmt.Errorf("error parsing %s: %w", ref, err)
+			}
+			return r, nil
+		}
+		st := &model.Stats{}
+		if err := json.Unmarshal(data, st); err != nil {
+			return nil, fmt.Errorf("error parsing %s: %w", ref, err)
+		}
+		r := &Record{ID: ref, Tool: "streamer", Name: ref, Started: st.StartTime, Success: true}
+		r.SetStats(st)
+		return r, nil
+	}
+	if store == nil {
+		return nil, fmt.Errorf("file %s not found", ref)
+	}
+	r, err := store.Get(ref)
+	if err == model.ErroNotFound {
+		return nil, fmt.Errorf("run %s not found", ref)
+	}
+	return r, err
+}
+
+// FormatForConsole formats comparison as table
+func (c *Comparison) FormatForConsole() string {
+	lines := []string{fmt.Sprintf("Comparing %s (current) with %s (baseline)", c.Current, c.Baseline),
+		fmt.Sprintf("%-28s %12s %12s %12s  %s", "metric", "baseline", "current", "change", "")}
+	for _, d := range c.Deltas {
+		status := ""
+		if d.Regression {
+			status = "REGRESSION"
+		}
+		if d.Note != "" {
+			status = strings.TrimSpace(status + " " + d.Note)
+		}
+		lines = append(lines, fmt.Sprintf("%-28s %10.2f%-2s %10.2f%-2s %+10.2f%-2s  %s", d.Metric, d.Baseline, d.Unit, d.Current, d.Unit,
+			d.Change, d.Unit, status))
+	}
+	if c.Regressed() {
+		lines = append(lines, fmt.Sprintf("%d regressions found", c.Regressions))
+	} else {
+		lines = append(lines, "No regressions found")
+	}
+	return strings.Join(lines, "\n")
+}
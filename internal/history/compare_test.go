@@ -0,0 +1,103 @@
+package history
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/livepeer/stream-tester/model"
+)
+
+func TestSignificant(t *testing.T) {
+	for _, c := range []struct {
+		name       string
+		p1         float64
+		n1         int
+		p2         float64
+		n2         int
+		confidence float64
+		want       bool
+	}{
+		{"many segments", 1, 1000, 3, 1000, 1.96, true},
+		{"few segments", 1, 100, 3, 100, 1.96, false},
+		{"rate dropped", 3, 1000, 1, 1000, 1.96, false},
+		{"lower confidence", 1, 100, 3, 100, 1, true},
+		{"all failed", 100, 100, 100, 100, 1.96, true},
+		{"no baseline segments", 1, 0, 3, 100, 1.96, true},
+		{"no current segments", 1, 100, 3, 0, 1.96, true},
+		{"check disabled", 1, 100, 3, 100, 0, true},
+	} {
+		got, note := significant(c.p1, c.n1, c.p2, c.n2, c.confidence)
+		if got != c.want {
+			t.Errorf("%s: significant=%v note=%q, want %v", c.name, got, note, c.want)
+		}
+		if !got && !strings.HasPrefix(note, "not significant") {
+			t.Errorf("%s: note is %q", c.name, note)
+		}
+	}
+}
+
+func findDelta(c *Comparison, metric string) *Delta {
+	for _, d := range c.Deltas {
+		if d.Metric == metric {
+			return d
+		}
+	}
+	return nil
+}
+
+func TestCompare(t *testing.T) {
+	stats := func(segments, failed int, p95 time.Duration) *model.Stats {
+		return &model.Stats{
+			SentSegments:             segments,
+			FailedToDownloadSegments: failed,
+			SuccessRate:              float64(segments-failed) / float64(segments) * 100,
+			TranscodedLatencies:      model.Latencies{P95: p95},
+		}
+	}
+	for _, c := range []struct {
+		name              string
+		baseline, current *model.Stats
+		regressions       []string
+	}{
+		{"same", stats(1000, 10, time.Second), stats(1000, 10, time.Second), nil},
+		{"success rate significant drop", stats(1000, 10, time.Second), stats(1000, 30, time.Second),
+			[]string{"success_rate", "errors_failed_to_download"}},
+		{"success rate drop on few segments", stats(100, 1, time.Second), stats(100, 3, time.Second), nil},
+		{"success rate drop within tolerance", stats(1000, 10, time.Second), stats(1000, 15, time.Second), nil},
+		{"latency increase within tolerance", stats(1000, 0, 2*time.Second), stats(1000, 0, 2150*time.Millisecond), nil},
+		{"latency increase", stats(1000, 0, 2*time.Second), stats(1000, 0, 2300*time.Millisecond),
+			[]string{"transcoded_latency_p95"}},
+		// relative increase is big, but absolute is below LatencyMin
+		{"small latency increase", stats(1000, 0, 200*time.Millisecond), stats(1000, 0, 280*time.Millisecond), nil},
+		{"latency decrease", stats(1000, 0, 2*time.Second), stats(1000, 0, time.Second), nil},
+		{"no baseline latency", stats(1000, 0, 0), stats(1000, 0, 5*time.Second), nil},
+	} {
+		cmp := CompareStats(c.baseline, c.current, DefaultTolerance)
+		var got []string
+		for _, d := range cmp.Deltas {
+			if d.Regression {
+				got = append(got, d.Metric)
+			}
+		}
+		if strings.Join(got, ",") != strings.Join(c.regressions, ",") || cmp.Regressions != len(c.regressions) || cmp.Regressed() != (len(got) > 0) {
+			t.Errorf("%s: got regressions %v, want %v\n%s", c.name, got, c.regressions, cmp.FormatForConsole())
+		}
+	}
+
+	cmp := CompareStats(stats(1000, 0, 0), stats(1000, 0, 5*time.Second), DefaultTolerance)
+	if d := findDelta(cmp, "transcoded_latency_p95"); d == nil || d.Note != "no baseline" {
+		t.Errorf("delta without baseline is %+v", d)
+	}
+	base := &Record{ID: "base", Success: true, SuccessRate: 100}
+	cur := &Record{ID: "cur", Success: false, SuccessRate: 100, Error: "timed out"}
+	cmp = Compare(base, cur, DefaultTolerance)
+	if d := findDelta(cmp, "run_success"); d == nil || !d.Regression || d.Note != "timed out" || cmp.Regressions != 1 {
+		t.Errorf("failed run is not a regression: %s", cmp.FormatForConsole())
+	}
+	// number of segments is unknown, so any drop above tolerance is a regression
+	cmp = Compare(&Record{Success: true, SuccessRate: 100}, &Record{Success: true, SuccessRate: 98}, DefaultTolerance)
+	if d := findDelta(cmp, "success_rate"); d == nil || !d.Regression || d.Change != -2 {
+		t.Errorf("success rate delta is %+v", d)
+	}
+}
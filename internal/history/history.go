@@ -0,0 +1,291 @@
+// Package history persists results of the test runs in local file-based
+// store, so they can be queried later and trends can be plotted
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/stream-tester/model"
+)
+
+const dayFormat = "2006-01-02"
+
+type (
+	// Record result of one test run
+	Record struct {
+		ID string `json:"id"`
+		// Tool name of the tool that ran the test (recordtester, loadtester, ...)
+		Tool string `json:"tool"`
+		// Name name of the test, usually includes host being tested
+		Name     string        `json:"name,omitempty"`
+		Host     string        `json:"host,omitempty"`
+		Started  time.Time     `json:"started"`
+		Duration time.Duration `json:"duration"`
+		Success  bool          `json:"success"`
+		ExitCode int           `json:"exit_code,omitempty"`
+		Error    string        `json:"error,omitempty"`
+		// SuccessRate success rate of the run, percent
+		SuccessRate         float64                  `json:"success_rate"`
+		SourceLatencies     model.Latencies          `json:"source_latencies"`
+		TranscodedLatencies model.Latencies          `json:"transcoded_latencies"`
+		Config              map[string]string        `json:"config,omitempty"`
+		Timings             map[string]time.Duration `json:"timings,omitempty"`
+		Stats               *model.Stats             `json:"stats,omitempty"`
+		StatsMany           *model.StatsMany         `json:"stats_many,omitempty"`
+		VODStats            *model.VODStats          `json:"vod_stats,omitempty"`
+	}
+
+	// Query selects records from the store. Zero fields are not used for filtering
+	Query struct {
+		Tool       string
+		Name       string
+		Since      time.Time
+		Until      time.Time
+		OnlyFailed bool
+		// Limit return only that many latest records
+		Limit int
+	}
+
+	// Store file-based store of the records. Records are kept as JSON
+	// lines, in one file per day, so it is safe to append to the store
+	// from many processes at the same time
+	Store struct {
+		dir string
+		mu  sync.Mutex
+	}
+)
+
+// Open opens store in the directory, creating directory if needed
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir}, nil
+}
+
+// NewRecord creates new record for the run started now
+func NewRecord(tool, name string) *Record {
+	host, _ := os.Hostname()
+	return &Record{
+		ID:      fmt.Sprintf("%d-%04x", time.Now().Unix(), rand.Intn(0x10000)),
+		Tool:    tool,
+		Name:    name,
+		Host:    host,
+		Started: time.Now(),
+	}
+}
+
+// SetStats fills record from the streamer's stats. Raw latencies are not stored
+func (r *Record) SetStats(stats *model.Stats) {
+	st := *stats
+	st.RawSourceLatencies = nil
+	st.RawTranscodedLatencies = nil
+	st.RawTranscodeLatenciesPerStream = nil
+	st.RawTranscodeLatenciesNames = nil
+	r.Stats = &st
+	r.SuccessRate = stats.SuccessRate
+	r.SourceLatencies = stats.SourceLatencies
+	r.TranscodedLatencies = stats.TranscodedLatencies
+}
+
+// SetStatsMany fills record from the load tester's stats
+func (r *Record) SetStatsMany(stats *model.StatsMany) {
+	r.StatsMany = stats
+	r.SuccessRate = stats.SuccessRate * 100
+	r.SourceLatencies = stats.SourceLatencies
+	r.TranscodedLatencies = stats.TranscodedLatencies
+}
+
+// SetStats1 fills record from the single stream's stats
+func (r *Record) SetStats1(stats *model.Stats1) {
+	r.SuccessRate = stats.SuccessRate * 100
+	r.SourceLatencies = stats.SourceLatencies
+	r.TranscodedLatencies = stats.TranscodedLatencies
+}
+
+// SetVODStats fills record from the VOD stats
+func (r *Record) SetVODStats(stats *model.VODStats) {
+	r.VODStats = stats
+	if stats.SegmentsAll > 0 {
+		r.SuccessRate = float64(stats.SegmentsAll-stats.DownloadErrors-stats.ParseErrors) / float64(stats.SegmentsAll) * 100
+	}
+}
+
+// Finish sets outcome of the run
+func (r *Record) Finish(exitCode int, err error) {
+	r.Duration = time.Since(r.Started)
+	r.ExitCode = exitCode
+	r.Success = exitCode == 0 && err == nil
+	if err != nil {
+		r.Error = err.Error()
+	}
+}
+
+// Add appends record to the store
+func (s *Store) Add(r *Record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.dayFile(r.Started), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	// one write with O_APPEND, so lines from different processes do not interleave
+	if _, err = f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Save adds record to the store, logging error. Does nothing if store is nil,
+// so can be used by the testers without checking if history is enabled
+func (s *Store) Save(r *Record) {
+	if s == nil {
+		return
+	}
+	if err := s.Add(r); err != nil {
+		glog.Errorf("Error saving run id=%s to history: %v", r.ID, err)
+	}
+}
+
+// Get returns record by id
+func (s *Store) Get(id string) (*Record, error) {
+	recs, err := s.Query(Query{})
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range recs {
+		if r.ID == id {
+			return r, nil
+		}
+	}
+	return nil, model.ErroNotFound
+}
+
+// Query returns records matching query, sorted by start time
+func (s *Store) Query(q Query) ([]*Record, error) {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	var recs []*Record
+	for _, fn := range files {
+		day, err := time.ParseInLocation(dayFormat, strings.TrimSuffix(filepath.Base(fn), ".jsonl"), time.UTC)
+		if err != nil {
+			continue
+		}
+		if (!q.Since.IsZero() && day.Add(24*time.Hour).Before(q.Since)) || (!q.Until.IsZero() && day.After(q.Until)) {
+			continue
+		}
+		frecs, err := readFile(fn)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range frecs {
+			if q.matches(r) {
+				recs = append(recs, r)
+			}
+		}
+	}
+	sort.Slice(recs, func(i, j int) bool { return recs[i].Started.Before(recs[j].Started) })
+	if q.Limit > 0 && len(recs) > q.Limit {
+		recs = recs[len(recs)-q.Limit:]
+	}
+	return recs, nil
+}
+
+func (q *Query) matches(r *Record) bool {
+	if q.Tool != "" && r.Tool != q.Tool {
+		return false
+	}
+	if q.Name != "" && !strings.Contains(r.Name, q.Name) {
+		return false
+	}
+	if !q.Since.IsZero() && r.Started.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && r.Started.After(q.Until) {
+		return false
+	}
+	return !q.OnlyFailed || !r.Success
+}
+
+func (s *Store) dayFile(t time.Time) string {
+	return filepath.Join(s.dir, t.UTC().Format(dayFormat)+".jsonl")
+}
+
+func readFile(fn string) ([]*Record, error) {
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	var recs []*Record
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		r := &Record{}
+		if err := json.Unmarshal(scanner.Bytes(), r); err != nil {
+			// partially written line shouldn't make whole history unreadable
+			glog.Warningf("Skipping invalid record in %s:%d: %v", fn, line, err)
+			continue
+		}
+		recs = append(recs, r)
+	}
+	return recs, scanner.Err()
+}
+
+// FormatForConsole formats records as table
+func FormatForConsole(recs []*Record) string {
+	lines := []string{fmt.Sprintf("%-16s %-20s %-14s %-30s %10s %7s %9s %9s  %s", "started", "id", "tool", "name", "duration", "result",
+		"success", "lat p95", "error")}
+	for _, r := range recs {
+		result := "ok"
+		if !r.Success {
+			result = fmt.Sprintf("fail %d", r.ExitCode)
+		}
+		errMsg := r.Error
+		if len(errMsg) > 80 {
+			errMsg = errMsg[:77] + "..."
+		}
+		lines = append(lines, fmt.Sprintf("%-16s %-20s %-14s %-30s %10s %7s %8.2f%% %9s  %s", r.Started.Local().Format("2006-01-02 15:04"), r.ID,
+			r.Tool, r.Name, r.Duration.Round(time.Second), result, r.SuccessRate, r.TranscodedLatencies.P95.Round(time.Millisecond), errMsg))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// FlagsConfig returns values of the flags to be saved as run's config.
+// Values of the flags which look like secrets are not saved
+func FlagsConfig(fs *flag.FlagSet) map[string]string {
+	config := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		name := strings.ToLower(f.Name)
+		for _, secret := range []string{"token", "key", "secret", "password", "creds", "proof", "webhook", "discord-url", "bucket-url"} {
+			if strings.Contains(name, secret) {
+				return
+			}
+		}
+		config[f.Name] = f.Value.String()
+	})
+	return config
+}
@@ -0,0 +1,168 @@
+package history
+
+import (
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/livepeer/stream-tester/model"
+)
+
+func openStore(t *testing.T) *Store {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	s, err := Open(filepath.Join(dir, "store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestStoreRoundTrip(t *testing.T) {
+	s := openStore(t)
+	day := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	rec := NewRecord("loadtester", "load-host1")
+	rec.Started = day
+	rec.SetStatsMany(&model.StatsMany{SuccessRate: 0.5, TranscodedLatencies: model.Latencies{P95: time.Second}})
+	rec.Config = map[string]string{"sim": "10"}
+	rec.Finish(model.ExitCodeAssertionsFailed, errors.New("failed"))
+	ok := NewRecord("recordtester", "record-host1")
+	ok.Started = day.Add(time.Hour)
+	ok.Finish(0, nil)
+	next := NewRecord("recordtester", "record-host2")
+	next.Started = day.Add(24 * time.Hour)
+	next.Finish(0, nil)
+	for _, r := range []*Record{next, ok, rec} {
+		if err := s.Add(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// partially written line is skipped
+	f, _ := os.OpenFile(s.dayFile(day), os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString(`{"id": "broken`)
+	f.Close()
+
+	all, err := s.Query(Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 || all[0].ID != rec.ID || all[1].ID != ok.ID || all[2].ID != next.ID {
+		t.Fatalf("got records %s", FormatForConsole(all))
+	}
+	got := all[0]
+	if got.Success || got.ExitCode != model.ExitCodeAssertionsFailed || got.Error != "failed" || got.SuccessRate != 50 ||
+		got.TranscodedLatencies.P95 != time.Second || got.Config["sim"] != "10" || !got.Started.Equal(day) {
+		t.Errorf("record changed after round trip: %+v", got)
+	}
+	if r, err := s.Get(ok.ID); err != nil || r.Name != ok.Name {
+		t.Errorf("got record %+v err=%v", r, err)
+	}
+	if _, err := s.Get("unknown"); err != model.ErroNotFound {
+		t.Errorf("got err=%v for unknown record", err)
+	}
+	for _, c := range []struct {
+		q    Query
+		want []string
+	}{
+		{Query{Tool: "recordtester"}, []string{ok.ID, next.ID}},
+		{Query{Name: "host1"}, []string{rec.ID, ok.ID}},
+		{Query{OnlyFailed: true}, []string{rec.ID}},
+		{Query{Since: day.Add(time.Minute)}, []string{ok.ID, next.ID}},
+		{Query{Until: day.Add(2 * time.Hour)}, []string{rec.ID, ok.ID}},
+		{Query{Limit: 1}, []string{next.ID}},
+	} {
+		recs, err := s.Query(c.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, r := range recs {
+			ids = append(ids, r.ID)
+		}
+		if strings.Join(ids, ",") != strings.Join(c.want, ",") {
+			t.Errorf("query %+v returned %v, want %v", c.q, ids, c.want)
+		}
+	}
+}
+
+func TestSaveWithoutStore(t *testing.T) {
+	var s *Store
+	// history is disabled
+	s.Save(NewRecord("loadtester", "name"))
+}
+
+func TestFlagsConfig(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Int("sim", 1, "")
+	fs.String("file", "", "")
+	fs.Duration("time", 0, "")
+	for _, secret := range []string{"api-token", "gs-key", "azure-access-key", "client-secret", "mist-password", "mist-creds",
+		"proof", "webhook-url", "discord-url", "bucket-url", "PagerDuty-Integration-Key"} {
+		fs.String(secret, "", "")
+	}
+	fs.Parse([]string{"-sim", "5", "-file", "a.mp4", "-api-token", "tok", "-mist-creds", "admin:pass", "-gs-key", "key.json"})
+	config := FlagsConfig(fs)
+	want := map[string]string{"sim": "5", "file": "a.mp4", "time": "0s"}
+	if len(config) != len(want) {
+		t.Errorf("got config %v, want %v", config, want)
+	}
+	for k, v := range want {
+		if config[k] != v {
+			t.Errorf("flag %s is %q, want %q", k, config[k], v)
+		}
+	}
+}
+
+func TestTrend(t *testing.T) {
+	since := time.Now().Add(-48 * time.Hour).Truncate(24 * time.Hour)
+	rec := func(at time.Duration, success bool, rate float64, p95 time.Duration) *Record {
+		return &Record{Started: since.Add(at), Success: success, SuccessRate: rate,
+			TranscodedLatencies: model.Latencies{P50: p95 / 2, P95: p95}}
+	}
+	recs := []*Record{
+		rec(time.Hour, true, 100, 2*time.Second),
+		rec(2*time.Hour, false, 50, 4*time.Second),
+		// no latencies measured, not included in the average
+		rec(3*time.Hour, false, 0, 0),
+		rec(49*time.Hour, true, 90, time.Second),
+	}
+	points := Trend(recs, since, 24*time.Hour)
+	if len(points) != 3 {
+		t.Fatalf("got %d points, want 3", len(points))
+	}
+	p := points[0]
+	if !p.Start.Equal(since) || p.Runs != 3 || p.Failed != 2 || p.SuccessRate != 50 || p.LatencyP95 != 3*time.Second || p.LatencyP50 != 1500*time.Millisecond {
+		t.Errorf("first day is %+v", p)
+	}
+	if p := points[1]; p.Runs != 0 {
+		t.Errorf("second day is %+v", p)
+	}
+	if p := points[2]; p.Runs != 1 || p.SuccessRate != 90 || p.LatencyP95 != time.Second {
+		t.Errorf("third day is %+v", p)
+	}
+	if Trend(nil, since, time.Hour) != nil || Trend(recs, since, 0) != nil {
+		t.Error("trend of no records or without bucket is not empty")
+	}
+
+	chart, err := FormatTrend(points, MetricFailedRuns, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(chart, "\n")
+	if len(lines) != 4 || !strings.HasSuffix(lines[1], strings.Repeat(chartBar, 10)+" 2/3") ||
+		!strings.HasSuffix(lines[2], chartNoDataMarker) || !strings.HasSuffix(lines[3], " 0/1") {
+		t.Errorf("unexpected chart:\n%s", chart)
+	}
+	if _, err := FormatTrend(points, "fps", 10); err == nil {
+		t.Error("no error for unknown metric")
+	}
+}
@@ -0,0 +1,124 @@
+package history
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Trend metrics
+const (
+	MetricSuccessRate  = "success_rate"
+	MetricLatencyP50   = "latency_p50"
+	MetricLatencyP95   = "latency_p95"
+	MetricFailedRuns   = "failed_runs"
+	defaultChartWidth  = 50
+	chartBar           = "#"
+	chartNoDataMarker  = "-"
+	trendLineDayFormat = "2006-01-02 15:04"
+)
+
+// TrendPoint aggregated records of one time bucket
+type TrendPoint struct {
+	Start  time.Time `json:"start"`
+	Runs   int       `json:"runs"`
+	Failed int       `json:"failed"`
+	// SuccessRate average success rate, percent
+	SuccessRate float64 `json:"success_rate"`
+	// Latencies average transcoded latencies of the runs which measured them
+	LatencyP50 time.Duration `json:"latency_p50"`
+	LatencyP95 time.Duration `json:"latency_p95"`
+}
+
+// Trend groups records into buckets (days, for example) starting from `since`
+// and aggregates each bucket. Records should be sorted by start time.
+func Trend(recs []*Record, since time.Time, bucket time.Duration) []*TrendPoint {
+	if bucket <= 0 || len(recs) == 0 {
+		return nil
+	}
+	if since.IsZero() {
+		since = recs[0].Started
+	}
+	since = since.Truncate(bucket)
+	n := int(time.Since(since)/bucket) + 1
+	points := make([]*TrendPoint, n)
+	latencyRuns := make([]int, n)
+	for i := range points {
+		points[i] = &TrendPoint{Start: since.Add(time.Duration(i) * bucket)}
+	}
+	for _, r := range recs {
+		i := int(r.Started.Sub(since) / bucket)
+		if i < 0 || i >= n {
+			continue
+		}
+		p := points[i]
+		p.Runs++
+		if !r.Success {
+			p.Failed++
+		}
+		p.SuccessRate += r.SuccessRate
+		if r.TranscodedLatencies.P50 > 0 {
+			p.LatencyP50 += r.TranscodedLatencies.P50
+			p.LatencyP95 += r.TranscodedLatencies.P95
+			latencyRuns[i]++
+		}
+	}
+	for i, p := range points {
+		if p.Runs > 0 {
+			p.SuccessRate /= float64(p.Runs)
+		}
+		if latencyRuns[i] > 0 {
+			p.LatencyP50 /= time.Duration(latencyRuns[i])
+			p.LatencyP95 /= time.Duration(latencyRuns[i])
+		}
+	}
+	return points
+}
+
+func (p *TrendPoint) value(metric string) (float64, string) {
+	switch metric {
+	case MetricLatencyP50:
+		return p.LatencyP50.Seconds(), p.LatencyP50.Round(time.Millisecond).String()
+	case MetricLatencyP95:
+		return p.LatencyP95.Seconds(), p.LatencyP95.Round(time.Millisecond).String()
+	case MetricFailedRuns:
+		return float64(p.Failed), fmt.Sprintf("%d/%d", p.Failed, p.Runs)
+	}
+	return p.SuccessRate, fmt.Sprintf("%.2f%%", p.SuccessRate)
+}
+
+// FormatTrend plots metric as horizontal ASCII bar chart, one line per point
+func FormatTrend(points []*TrendPoint, metric string, width int) (string, error) {
+	switch metric {
+	case MetricSuccessRate, MetricLatencyP50, MetricLatencyP95, MetricFailedRuns:
+	default:
+		return "", fmt.Errorf("unknown metric %q", metric)
+	}
+	if width <= 0 {
+		width = defaultChartWidth
+	}
+	var max float64
+	for _, p := range points {
+		if v, _ := p.value(metric); p.Runs > 0 && v > max {
+			max = v
+		}
+	}
+	if metric == MetricSuccessRate {
+		// keep the same scale for all the charts
+		max = 100
+	}
+	lines := []string{fmt.Sprintf("%-16s %5s  %s", "", "runs", metric)}
+	for _, p := range points {
+		if p.Runs == 0 {
+			lines = append(lines, fmt.Sprintf("%-16s %5d  %s", p.Start.Local().Format(trendLineDayFormat), 0, chartNoDataMarker))
+			continue
+		}
+		v, label := p.value(metric)
+		bar := 0
+		if max > 0 {
+			bar = int(v / max * float64(width))
+		}
+		lines = append(lines, fmt.Sprintf("%-16s %5d  %s %s", p.Start.Local().Format(trendLineDayFormat), p.Runs, strings.Repeat(chartBar, bar), label))
+	}
+	return strings.Join(lines, "\n"), nil
+}
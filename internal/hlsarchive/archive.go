@@ -0,0 +1,234 @@
+// Package hlsarchive records all the HLS playlists and segments fetched by the
+// testers into archive, and serves archive back with the original timing, so
+// problems found in production can be reproduced offline
+package hlsarchive
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	indexFileName = "index.jsonl"
+	bodiesDir     = "bodies"
+)
+
+type (
+	// Entry one recorded HTTP fetch
+	Entry struct {
+		Seq int `json:"seq"`
+		// At time since start of the recording
+		At          time.Duration `json:"at"`
+		Took        time.Duration `json:"took"`
+		URL         string        `json:"url"`
+		Status      int           `json:"status,omitempty"`
+		ContentType string        `json:"content_type,omitempty"`
+		Size        int           `json:"size"`
+		// File name of the file with the response body, relative to archive's dir
+		File  string `json:"file,omitempty"`
+		Error string `json:"error,omitempty"`
+	}
+
+	// Recorder http.RoundTripper which records all GET requests into archive
+	Recorder struct {
+		dir       string
+		transport http.RoundTripper
+		started   time.Time
+		mu        sync.Mutex
+		seq       int
+		index     *os.File
+	}
+
+	// Archive recorded fetches
+	Archive struct {
+		Dir     string
+		Entries []*Entry
+		// byKey entries of the same resource, in order of recording
+		byKey map[string][]*Entry
+		// byPath same as byKey but ignoring query (session ids and such
+		// differ between recording and replay)
+		byPath map[string][]*Entry
+	}
+)
+
+// NewRecorder creates recorder writing archive into dir. Requests are made using transport
+// (http.DefaultTransport if nil)
+func NewRecorder(dir string, transport http.RoundTripper) (*Recorder, error) {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if err := os.MkdirAll(filepath.Join(dir, bodiesDir), 0755); err != nil {
+		return nil, err
+	}
+	index, err := os.OpenFile(filepath.Join(dir, indexFileName), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	glog.Infof("Recording HLS fetches to dir=%s", dir)
+	return &Recorder{dir: dir, transport: transport, started: time.Now(), index: index}, nil
+}
+
+// RoundTrip implements http.RoundTripper
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return r.transport.RoundTrip(req)
+	}
+	started := time.Now()
+	resp, err := r.transport.RoundTrip(req)
+	e := &Entry{At: started.Sub(r.started), URL: req.URL.String()}
+	if err != nil {
+		e.Took = time.Since(started)
+		e.Error = err.Error()
+		r.add(e, nil)
+		return resp, err
+	}
+	body, rerr := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	e.Took = time.Since(started)
+	e.Status = resp.StatusCode
+	e.ContentType = resp.Header.Get("Content-Type")
+	e.Size = len(body)
+	if rerr != nil {
+		e.Error = rerr.Error()
+	}
+	r.add(e, body)
+	// body is consumed, so give the copy to the caller. Read error is returned
+	// to the caller as it would be without recorder
+	resp.Body = ioutil.NopCloser(&errReader{r: bytes.NewReader(body), err: rerr})
+	return resp, nil
+}
+
+func (r *Recorder) add(e *Entry, body []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seq++
+	e.Seq = r.seq
+	if len(body) > 0 {
+		ext := path.Ext(stripQuery(e.URL))
+		e.File = filepath.Join(bodiesDir, fmt.Sprintf("%08d%s", e.Seq, ext))
+		if err := ioutil.WriteFile(filepath.Join(r.dir, e.File), body, 0644); err != nil {
+			glog.Errorf("Error saving body of url=%s to archive err=%v", e.URL, err)
+			e.File = ""
+		}
+	}
+	b, _ := json.Marshal(e)
+	if _, err := r.index.Write(append(b, '\n')); err != nil {
+		glog.Errorf("Error writing archive index err=%v", err)
+	}
+}
+
+// Close closes the archive
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.index.Close()
+}
+
+type errReader struct {
+	r   *bytes.Reader
+	err error
+}
+
+func (er *errReader) Read(p []byte) (int, error) {
+	n, err := er.r.Read(p)
+	if err != nil && er.err != nil {
+		return n, er.err
+	}
+	return n, err
+}
+
+// Open reads archive from the dir
+func Open(dir string) (*Archive, error) {
+	f, err := os.Open(filepath.Join(dir, indexFileName))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	a := &Archive{Dir: dir, byKey: make(map[string][]*Entry), byPath: make(map[string][]*Entry)}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		e := &Entry{}
+		if err := json.Unmarshal(scanner.Bytes(), e); err != nil {
+			// recording could be interrupted in the middle of the line
+			glog.Warningf("Skipping invalid archive entry %s:%d: %v", indexFileName, line, err)
+			continue
+		}
+		a.Entries = append(a.Entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(a.Entries, func(i, j int) bool { return a.Entries[i].At < a.Entries[j].At })
+	for _, e := range a.Entries {
+		key := Key(e.URL)
+		a.byKey[key] = append(a.byKey[key], e)
+		p := stripQuery(key)
+		a.byPath[p] = append(a.byPath[p], e)
+	}
+	return a, nil
+}
+
+// Body returns recorded body of the entry
+func (a *Archive) Body(e *Entry) ([]byte, error) {
+	if e.File == "" {
+		return nil, nil
+	}
+	return ioutil.ReadFile(filepath.Join(a.Dir, e.File))
+}
+
+// Duration returns time span of the recording
+func (a *Archive) Duration() time.Duration {
+	if len(a.Entries) == 0 {
+		return 0
+	}
+	return a.Entries[len(a.Entries)-1].At
+}
+
+// Playlists returns URLs of the recorded playlists in order of the first fetch
+// (so master playlist goes first)
+func (a *Archive) Playlists() []string {
+	var res []string
+	seen := make(map[string]bool)
+	for _, e := range a.Entries {
+		if IsPlaylist(e.URL) && !seen[e.URL] {
+			seen[e.URL] = true
+			res = append(res, e.URL)
+		}
+	}
+	return res
+}
+
+// Key returns key by which resource is looked up in the archive: path and
+// query of the URL (host is replaced by replay server's host)
+func Key(u string) string {
+	if i := strings.Index(u, "://"); i >= 0 {
+		u = u[i+3:]
+		if j := strings.Index(u, "/"); j >= 0 {
+			return u[j:]
+		}
+		return "/"
+	}
+	return u
+}
+
+// IsPlaylist returns true if URL looks like HLS playlist
+func IsPlaylist(u string) bool {
+	return strings.HasSuffix(stripQuery(u), ".m3u8")
+}
+
+func stripQuery(u string) string {
+	return strings.SplitN(u, "?", 2)[0]
+}
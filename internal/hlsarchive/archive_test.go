@@ -0,0 +1,121 @@
+package hlsarchive
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+const recordGap = 200 * time.Millisecond
+
+// origin serves media playlist which gets new segment after the first fetch
+type origin struct {
+	mu       sync.Mutex
+	segments int
+}
+
+func (o *origin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	switch r.URL.Path {
+	case "/stream/index.m3u8":
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		fmt.Fprint(w, "#EXTM3U\n#EXT-X-TARGETDURATION:2\n")
+		for i := 0; i < o.segments; i++ {
+			fmt.Fprintf(w, "#EXTINF:2.000,\n%d.ts\n", i)
+		}
+		o.segments++
+	case "/stream/0.ts", "/stream/1.ts":
+		w.Header().Set("Content-Type", "video/mp2t")
+		fmt.Fprintf(w, "segment %s", r.URL.Path)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func fetch(t *testing.T, c *http.Client, uri string) (int, string) {
+	t.Helper()
+	resp, err := c.Get(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestRecordReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hlsarchive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	hs := httptest.NewServer(&origin{segments: 1})
+	defer hs.Close()
+
+	rec, err := NewRecorder(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &http.Client{Transport: rec}
+	var recorded []string
+	for _, uri := range []string{"/stream/index.m3u8", "/stream/0.ts?session=1", "", "/stream/index.m3u8", "/stream/1.ts", "/stream/2.ts"} {
+		if uri == "" {
+			time.Sleep(recordGap)
+			continue
+		}
+		// caller gets the body as if there was no recorder
+		_, body := fetch(t, c, hs.URL+uri)
+		recorded = append(recorded, body)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archive.Entries) != 5 || archive.Duration() < recordGap {
+		t.Fatalf("archive has %d entries over %s", len(archive.Entries), archive.Duration())
+	}
+	if pls := archive.Playlists(); len(pls) != 1 || pls[0] != hs.URL+"/stream/index.m3u8" {
+		t.Errorf("archive has playlists %v", pls)
+	}
+	if e := archive.Entries[1]; e.Status != http.StatusOK || e.ContentType != "video/mp2t" || e.Size != len(recorded[1]) {
+		t.Errorf("segment entry is %+v", e)
+	}
+
+	replay := httptest.NewServer(NewServer(archive, 1, false))
+	defer replay.Close()
+	for _, c := range []struct {
+		uri    string
+		status int
+		body   string
+	}{
+		{"/stream/index.m3u8", http.StatusOK, recorded[0]},
+		// query differs from the recorded one
+		{"/stream/0.ts?session=2", http.StatusOK, recorded[1]},
+		{"/stream/1.ts", http.StatusOK, recorded[3]},
+		{"/stream/2.ts", http.StatusNotFound, recorded[4]},
+		{"/stream/3.ts", http.StatusNotFound, "404 page not found\n"},
+	} {
+		status, body := fetch(t, http.DefaultClient, replay.URL+c.uri)
+		if status != c.status || body != c.body {
+			t.Errorf("replay of %s returned %d %q, want %d %q", c.uri, status, body, c.status, c.body)
+		}
+	}
+	// playlist evolves as it did during recording
+	time.Sleep(2 * recordGap)
+	if _, body := fetch(t, http.DefaultClient, replay.URL+"/stream/index.m3u8"); body != recorded[2] || body == recorded[0] {
+		t.Errorf("replayed playlist is not updated:\n%s", body)
+	}
+}
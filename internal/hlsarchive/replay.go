@@ -0,0 +1,118 @@
+package hlsarchive
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Server serves recorded archive with the original timing. Replay clock starts
+// with the first request, and playlist request made at replay time T gets the
+// latest playlist recorded by the time T, so playlists evolve as they did during
+// recording. Segments are served regardless of the time
+type Server struct {
+	archive *Archive
+	speed   float64
+	delay   bool
+	mu      sync.Mutex
+	started time.Time
+}
+
+// NewServer creates replay server. speed > 1 replays faster than recorded. If
+// delay is true, responses are delayed by the time fetch took during recording
+func NewServer(archive *Archive, speed float64, delay bool) *Server {
+	if speed <= 0 {
+		speed = 1
+	}
+	return &Server{archive: archive, speed: speed, delay: delay}
+}
+
+// Reset restarts replay clock on the next request
+func (s *Server) Reset() {
+	s.mu.Lock()
+	s.started = time.Time{}
+	s.mu.Unlock()
+}
+
+func (s *Server) now() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started.IsZero() {
+		s.started = time.Now()
+		// first fetch of the recording happens at zero replay time
+		if len(s.archive.Entries) > 0 {
+			s.started = s.started.Add(-time.Duration(float64(s.archive.Entries[0].At) / s.speed))
+		}
+	}
+	return time.Duration(float64(time.Since(s.started)) * s.speed)
+}
+
+// find returns entry to serve for the key at replay time at
+func (s *Server) find(key string, at time.Duration) *Entry {
+	entries := s.archive.byKey[key]
+	if len(entries) == 0 {
+		entries = s.archive.byPath[stripQuery(key)]
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	if !IsPlaylist(key) {
+		// segments are immutable, prefer successful fetch
+		for _, e := range entries {
+			if e.Error == "" && e.Status == http.StatusOK {
+				return e
+			}
+		}
+		return entries[0]
+	}
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].At > at })
+	if i == 0 {
+		return nil
+	}
+	return entries[i-1]
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	at := s.now()
+	key := r.URL.RequestURI()
+	e := s.find(key, at)
+	if e == nil {
+		glog.V(2).Infof("Replay at=%s url=%s not recorded yet", at, key)
+		http.NotFound(w, r)
+		return
+	}
+	glog.V(2).Infof("Replay at=%s url=%s seq=%d status=%d", at, key, e.Seq, e.Status)
+	if s.delay && e.Took > 0 {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(time.Duration(float64(e.Took) / s.speed)):
+		}
+	}
+	if e.Error != "" && e.Status == 0 {
+		// fetch failed without response during recording, closest thing is to drop the connection
+		if hj, ok := w.(http.Hijacker); ok {
+			if conn, _, err := hj.Hijack(); err == nil {
+				conn.Close()
+				return
+			}
+		}
+		http.Error(w, e.Error, http.StatusBadGateway)
+		return
+	}
+	body, err := s.archive.Body(e)
+	if err != nil {
+		glog.Errorf("Error reading archived body url=%s file=%s err=%v", key, e.File, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if e.ContentType != "" {
+		w.Header().Set("Content-Type", e.ContentType)
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(e.Status)
+	w.Write(body)
+}
@@ -233,6 +233,8 @@ func InitCensus(nodeID, version, namespace string) {
 	registry := rprom.NewRegistry()
 	registry.MustRegister(rprom.NewProcessCollector(rprom.ProcessCollectorOpts{}))
 	registry.MustRegister(rprom.NewGoCollector())
+	streams = newStreamsCollector(namespace, nodeID)
+	registry.MustRegister(streams)
 	pe, err := prometheus.NewExporter(prometheus.Options{
 		Namespace: namespace,
 		Registry:  registry,
@@ -0,0 +1,384 @@
+package metrics
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	rprom "github.com/prometheus/client_golang/prometheus"
+
+	"github.com/livepeer/stream-tester/model"
+)
+
+// OtherSeries label value of the series aggregating streams (or renditions)
+// over the limit
+const OtherSeries = "_other"
+
+var (
+	// StreamSeriesLimit maximum number of streams exported with their own
+	// series. Streams registered when the limit is reached are aggregated
+	// into stream="_other" series
+	StreamSeriesLimit = 20
+	// RenditionSeriesLimit maximum number of renditions exported with their
+	// own series for every stream
+	RenditionSeriesLimit = 8
+)
+
+type (
+	// StreamMetrics per-stream and per-rendition metrics of one tested stream
+	StreamMetrics struct {
+		c           *streamsCollector
+		name        string
+		own         bool // has its own series
+		mu          sync.Mutex
+		renditions  map[string]*renditionMetrics
+		driftEvents int64
+	}
+
+	renditionMetrics struct {
+		// latencies and speed ratios (as durations of ratio seconds) of the
+		// last segments: current window and the previous one
+		latencies, prevLatencies     *model.Histogram
+		speedRatios, prevSpeedRatios *model.Histogram
+		successRate                  float64
+		gaps                         int64
+		downloadErrors               int64
+	}
+
+	// renditionValues values of rendition exported on scrape
+	renditionValues struct {
+		latency        []time.Duration
+		speedRatio     []float64
+		successRate    float64
+		gaps           int64
+		downloadErrors int64
+		streams        int
+	}
+
+	// streamsCollector exports metrics of the registered streams on scrape,
+	// so series of the finished streams disappear
+	streamsCollector struct {
+		nodeID  string
+		mu      sync.Mutex
+		streams []*StreamMetrics
+		own     int
+		// counters of the finished streams aggregated into `_other` series,
+		// so these counters never go down
+		retiredGaps           map[string]int64
+		retiredDownloadErrors map[string]int64
+		retiredDriftEvents    int64
+
+		dStreams        *rprom.Desc
+		dLatency        *rprom.Desc
+		dSpeedRatio     *rprom.Desc
+		dSuccessRate    *rprom.Desc
+		dGaps           *rprom.Desc
+		dDownloadErrors *rprom.Desc
+		dDriftEvents    *rprom.Desc
+	}
+)
+
+// segmentsWindow number of segments in the window latency percentiles are
+// exported for. Percentiles are calculated over the current and previous
+// windows
+const segmentsWindow = 256
+
+// minSegments minimum number of segments to export latency percentiles
+const minSegments = 8
+
+var percentiles = []float64{50, 95, 99}
+var quantiles = []string{"0.5", "0.95", "0.99"}
+
+var streams *streamsCollector
+
+func newStreamsCollector(namespace, nodeID string) *streamsCollector {
+	name := func(n string) string {
+		return rprom.BuildFQName(namespace, "", n)
+	}
+	labels := []string{"node_id", "stream", "rendition"}
+	qlabels := append(labels, "quantile")
+	return &streamsCollector{
+		nodeID:                nodeID,
+		retiredGaps:           make(map[string]int64),
+		retiredDownloadErrors: make(map[string]int64),
+		dStreams:              rprom.NewDesc(name("monitored_streams"), "Number of streams with per-stream metrics", []string{"node_id"}, nil),
+		dLatency: rprom.NewDesc(name("stream_latency_seconds"),
+			"Transcode latency of the last segments of the stream (worst of the streams for _other)", qlabels, nil),
+		dSpeedRatio: rprom.NewDesc(name("stream_speed_ratio"),
+			"Speed ratio of the last segments of the stream (average of the streams for _other)", qlabels, nil),
+		dSuccessRate: rprom.NewDesc(name("stream_success_rate"),
+			"Success rate of the stream (worst of the streams for _other)", labels, nil),
+		dGaps:           rprom.NewDesc(name("stream_gaps_total"), "Number of gaps found in the stream", labels, nil),
+		dDownloadErrors: rprom.NewDesc(name("stream_download_errors_total"), "Number of segment download errors", labels, nil),
+		dDriftEvents: rprom.NewDesc(name("stream_time_drifts_total"), "Number of times renditions of the stream drifted apart",
+			[]string{"node_id", "stream"}, nil),
+	}
+}
+
+// RegisterStream registers stream to export metrics for. Stream should be
+// closed when it ends. Returned metrics do nothing if census is not initialised
+func RegisterStream(name string) *StreamMetrics {
+	c := streams
+	if c == nil {
+		return nil
+	}
+	sm := &StreamMetrics{c: c, name: name, renditions: make(map[string]*renditionMetrics)}
+	c.mu.Lock()
+	if c.own < StreamSeriesLimit {
+		sm.own = true
+		c.own++
+	}
+	c.streams = append(c.streams, sm)
+	c.mu.Unlock()
+	return sm
+}
+
+// Segment records latency and speed ratio of the downloaded segment, and
+// success rate of the rendition (if known)
+func (sm *StreamMetrics) Segment(rendition string, latency time.Duration, speedRatio, successRate float64) {
+	if sm == nil {
+		return
+	}
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	rm := sm.rendition(rendition)
+	if rm.latencies.Count() >= segmentsWindow {
+		rm.prevLatencies, rm.latencies = rm.latencies, rm.prevLatencies
+		rm.prevSpeedRatios, rm.speedRatios = rm.speedRatios, rm.prevSpeedRatios
+		rm.latencies.Reset()
+		rm.speedRatios.Reset()
+	}
+	rm.latencies.Record(latency)
+	rm.speedRatios.Record(time.Duration(speedRatio * float64(time.Second)))
+	if successRate > 0 {
+		rm.successRate = successRate
+	}
+}
+
+// Gap records gap found in the rendition
+func (sm *StreamMetrics) Gap(rendition string) {
+	if sm == nil {
+		return
+	}
+	sm.mu.Lock()
+	sm.rendition(rendition).gaps++
+	sm.mu.Unlock()
+}
+
+// DownloadError records failed download of the rendition's segment
+func (sm *StreamMetrics) DownloadError(rendition string) {
+	if sm == nil {
+		return
+	}
+	sm.mu.Lock()
+	sm.rendition(rendition).downloadErrors++
+	sm.mu.Unlock()
+}
+
+// TimeDrift records time drift between renditions of the stream
+func (sm *StreamMetrics) TimeDrift() {
+	if sm == nil {
+		return
+	}
+	sm.mu.Lock()
+	sm.driftEvents++
+	sm.mu.Unlock()
+}
+
+// Close unregisters the stream, its series are not exported anymore
+func (sm *StreamMetrics) Close() {
+	if sm == nil {
+		return
+	}
+	c := sm.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, s := range c.streams {
+		if s != sm {
+			continue
+		}
+		c.streams = append(c.streams[:i], c.streams[i+1:]...)
+		if sm.own {
+			c.own--
+			return
+		}
+		sm.mu.Lock()
+		for name, rm := range sm.renditions {
+			c.retiredGaps[name] += rm.gaps
+			c.retiredDownloadErrors[name] += rm.downloadErrors
+		}
+		c.retiredDriftEvents += sm.driftEvents
+		sm.mu.Unlock()
+		return
+	}
+}
+
+// rendition returns metrics of the rendition, renditions over the limit share
+// `_other` metrics. Should be called with lock held
+func (sm *StreamMetrics) rendition(name string) *renditionMetrics {
+	rm, ok := sm.renditions[name]
+	if !ok {
+		if len(sm.renditions) >= RenditionSeriesLimit {
+			name = OtherSeries
+			rm = sm.renditions[name]
+		}
+		if rm == nil {
+			rm = &renditionMetrics{
+				latencies:       &model.Histogram{},
+				prevLatencies:   &model.Histogram{},
+				speedRatios:     &model.Histogram{},
+				prevSpeedRatios: &model.Histogram{},
+			}
+			sm.renditions[name] = rm
+		}
+	}
+	return rm
+}
+
+// values returns values of the renditions to export
+func (sm *StreamMetrics) values() (map[string]*renditionValues, int64) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	res := make(map[string]*renditionValues, len(sm.renditions))
+	for name, rm := range sm.renditions {
+		res[name] = &renditionValues{
+			latency:        make([]time.Duration, len(percentiles)),
+			speedRatio:     make([]float64, len(percentiles)),
+			successRate:    rm.successRate,
+			gaps:           rm.gaps,
+			downloadErrors: rm.downloadErrors,
+			streams:        1,
+		}
+		latencies := rm.latencies.Clone()
+		latencies.Merge(rm.prevLatencies)
+		if latencies.Count() < minSegments {
+			continue
+		}
+		speedRatios := rm.speedRatios.Clone()
+		speedRatios.Merge(rm.prevSpeedRatios)
+		for i, p := range percentiles {
+			res[name].latency[i] = latencies.Percentile(p)
+			res[name].speedRatio[i] = speedRatios.Percentile(p).Seconds()
+		}
+	}
+	return res, sm.driftEvents
+}
+
+// Describe implements prometheus.Collector
+func (c *streamsCollector) Describe(ch chan<- *rprom.Desc) {
+	ch <- c.dStreams
+	ch <- c.dLatency
+	ch <- c.dSpeedRatio
+	ch <- c.dSuccessRate
+	ch <- c.dGaps
+	ch <- c.dDownloadErrors
+	ch <- c.dDriftEvents
+}
+
+// Collect implements prometheus.Collector
+func (c *streamsCollector) Collect(ch chan<- rprom.Metric) {
+	c.mu.Lock()
+	registered := append([]*StreamMetrics(nil), c.streams...)
+	other := make(map[string]*renditionValues)
+	for name, gaps := range c.retiredGaps {
+		other[name] = &renditionValues{gaps: gaps, downloadErrors: c.retiredDownloadErrors[name]}
+	}
+	otherDrifts := c.retiredDriftEvents
+	c.mu.Unlock()
+
+	ch <- rprom.MustNewConstMetric(c.dStreams, rprom.GaugeValue, float64(len(registered)), c.nodeID)
+	hasOther := len(other) > 0
+	for _, sm := range registered {
+		values, drifts := sm.values()
+		if sm.own {
+			c.collectStream(ch, sm.name, values, drifts)
+			continue
+		}
+		hasOther = true
+		otherDrifts += drifts
+		for name, rv := range values {
+			if ov := other[name]; ov != nil {
+				ov.merge(rv)
+			} else {
+				other[name] = rv
+			}
+		}
+	}
+	if hasOther {
+		c.collectStream(ch, OtherSeries, capRenditions(other), otherDrifts)
+	}
+}
+
+func (c *streamsCollector) collectStream(ch chan<- rprom.Metric, stream string, values map[string]*renditionValues, drifts int64) {
+	for rendition, rv := range values {
+		if rv.streams > 0 {
+			for i, q := range quantiles {
+				ch <- rprom.MustNewConstMetric(c.dLatency, rprom.GaugeValue, rv.latency[i].Seconds(), c.nodeID, stream, rendition, q)
+				ch <- rprom.MustNewConstMetric(c.dSpeedRatio, rprom.GaugeValue, rv.speedRatio[i]/float64(rv.streams), c.nodeID, stream, rendition, q)
+			}
+			ch <- rprom.MustNewConstMetric(c.dSuccessRate, rprom.GaugeValue, rv.successRate, c.nodeID, stream, rendition)
+		}
+		ch <- rprom.MustNewConstMetric(c.dGaps, rprom.CounterValue, float64(rv.gaps), c.nodeID, stream, rendition)
+		ch <- rprom.MustNewConstMetric(c.dDownloadErrors, rprom.CounterValue, float64(rv.downloadErrors), c.nodeID, stream, rendition)
+	}
+	ch <- rprom.MustNewConstMetric(c.dDriftEvents, rprom.CounterValue, float64(drifts), c.nodeID, stream)
+}
+
+// merge merges values of rendition of another stream: worst latencies and
+// success rate, sum of speed ratios (averaged on export) and counters
+func (rv *renditionValues) merge(o *renditionValues) {
+	rv.gaps += o.gaps
+	rv.downloadErrors += o.downloadErrors
+	if o.streams == 0 {
+		return
+	}
+	if rv.streams == 0 {
+		rv.latency, rv.speedRatio, rv.successRate = o.latency, o.speedRatio, o.successRate
+	} else {
+		for i := range rv.latency {
+			if o.latency[i] > rv.latency[i] {
+				rv.latency[i] = o.latency[i]
+			}
+			rv.speedRatio[i] += o.speedRatio[i]
+		}
+		if o.successRate > 0 && (rv.successRate == 0 || o.successRate < rv.successRate) {
+			rv.successRate = o.successRate
+		}
+	}
+	rv.streams += o.streams
+}
+
+// capRenditions merges renditions over the limit (in order of the names) into
+// `_other` rendition
+func capRenditions(values map[string]*renditionValues) map[string]*renditionValues {
+	if len(values) <= RenditionSeriesLimit {
+		return values
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		if name != OtherSeries {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	res := make(map[string]*renditionValues, RenditionSeriesLimit+1)
+	for i, name := range names {
+		if i < RenditionSeriesLimit {
+			res[name] = values[name]
+			continue
+		}
+		if ov := res[OtherSeries]; ov != nil {
+			ov.merge(values[name])
+		} else {
+			res[OtherSeries] = values[name]
+		}
+	}
+	if ov := values[OtherSeries]; ov != nil {
+		if rv := res[OtherSeries]; rv != nil {
+			rv.merge(ov)
+		} else {
+			res[OtherSeries] = ov
+		}
+	}
+	return res
+}
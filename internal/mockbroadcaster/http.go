@@ -0,0 +1,259 @@
+package mockbroadcaster
+
+import (
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/m3u8"
+	"github.com/livepeer/stream-tester/internal/utils"
+	"github.com/livepeer/stream-tester/model"
+)
+
+var wowzaSessionRE = regexp.MustCompile(`_w\d+_`)
+
+// ServeHTTP serves HLS in all the layouts and accepts HTTP push
+//
+//	go-livepeer: /stream/<id>.m3u8, /stream/<id>/<rendition>.m3u8, /stream/<id>/<rendition>/<n>.ts
+//	Mist:        /hls/<id>/index.m3u8, /hls/<id>/<rendition>/index.m3u8, /hls/<id>/<rendition>/<n>.ts
+//	Wowza:       /live/ngrp:<id>_all/playlist.m3u8, .../chunklist_w1_<rendition>.m3u8, .../media_w1_<rendition>_<n>.ts
+//	HTTP push:   POST /live/<id>/<n>.ts
+func (b *Broadcaster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pp := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	glog.V(model.VERBOSE).Infof("Mock broadcaster request method=%s path=%s", r.Method, r.URL.Path)
+	if r.Method == http.MethodPost {
+		if len(pp) == 3 && pp[0] == "live" {
+			b.handlePush(w, r, pp[1], pp[2])
+			return
+		}
+		http.NotFound(w, r)
+		return
+	}
+	switch {
+	case pp[0] == "stream" && len(pp) == 2:
+		b.serveMaster(w, r, strings.TrimSuffix(pp[1], ".m3u8"), func(rn string) string {
+			return strings.TrimSuffix(pp[1], ".m3u8") + "/" + rn + ".m3u8"
+		})
+	case pp[0] == "stream" && len(pp) == 3:
+		b.serveMedia(w, r, pp[1], strings.TrimSuffix(pp[2], ".m3u8"), func(rn string, seqNo int) string {
+			return fmt.Sprintf("%s/%d.ts", rn, seqNo)
+		})
+	case pp[0] == "stream" && len(pp) == 4:
+		b.serveSegment(w, r, pp[1], pp[2], pp[3])
+	case pp[0] == "hls" && len(pp) == 3 && pp[2] == "index.m3u8":
+		b.serveMaster(w, r, pp[1], func(rn string) string {
+			return rn + "/index.m3u8"
+		})
+	case pp[0] == "hls" && len(pp) == 4 && pp[3] == "index.m3u8":
+		b.serveMedia(w, r, pp[1], pp[2], func(_ string, seqNo int) string {
+			return fmt.Sprintf("%d.ts", seqNo)
+		})
+	case pp[0] == "hls" && len(pp) == 4:
+		b.serveSegment(w, r, pp[1], pp[2], pp[3])
+	case pp[0] == "live" && len(pp) == 3 && strings.HasPrefix(pp[1], "ngrp:") && strings.HasSuffix(pp[1], "_all"):
+		b.serveWowza(w, r, strings.TrimSuffix(strings.TrimPrefix(pp[1], "ngrp:"), "_all"), pp[2])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (b *Broadcaster) serveWowza(w http.ResponseWriter, r *http.Request, manifestID, name string) {
+	name = wowzaSessionRE.ReplaceAllString(name, "_")
+	switch {
+	case name == "playlist.m3u8":
+		b.serveMaster(w, r, manifestID, func(rn string) string {
+			return "chunklist_w1_" + rn + ".m3u8"
+		})
+	case strings.HasPrefix(name, "chunklist_") && strings.HasSuffix(name, ".m3u8"):
+		b.serveMedia(w, r, manifestID, strings.TrimSuffix(strings.TrimPrefix(name, "chunklist_"), ".m3u8"), func(rn string, seqNo int) string {
+			return fmt.Sprintf("media_w1_%s_%d.ts", rn, seqNo)
+		})
+	case strings.HasPrefix(name, "media_"):
+		name = strings.TrimPrefix(name, "media_")
+		i := strings.LastIndex(name, "_")
+		if i < 0 {
+			http.NotFound(w, r)
+			return
+		}
+		b.serveSegment(w, r, manifestID, name[:i], name[i+1:])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (b *Broadcaster) serveMaster(w http.ResponseWriter, r *http.Request, manifestID string, variantURI func(rendition string) string) {
+	b.mu.Lock()
+	st := b.streams[manifestID]
+	if st == nil || len(st.source) == 0 {
+		b.mu.Unlock()
+		http.NotFound(w, r)
+		return
+	}
+	mpl := m3u8.NewMasterPlaylist()
+	for _, rn := range st.renditions {
+		mpl.Append(variantURI(rn.Name), nil, m3u8.VariantParams{Name: rn.Name, Bandwidth: rn.Bandwidth, Resolution: rn.Resolution})
+	}
+	b.mu.Unlock()
+	writePlaylist(w, mpl.Encode().Bytes())
+}
+
+func (b *Broadcaster) serveMedia(w http.ResponseWriter, r *http.Request, manifestID, renditionName string,
+	segmentURI func(rendition string, seqNo int) string) {
+
+	b.mu.Lock()
+	rn := b.findRendition(manifestID, renditionName)
+	if rn == nil {
+		b.mu.Unlock()
+		http.NotFound(w, r)
+		return
+	}
+	now := time.Now()
+	var visible []*segment
+	for _, seg := range rn.segments {
+		if !seg.visibleAt.After(now) {
+			visible = append(visible, seg)
+		}
+	}
+	if len(visible) > b.opts.PlaylistSize {
+		visible = visible[len(visible)-b.opts.PlaylistSize:]
+	}
+	mpl, err := m3u8.NewMediaPlaylist(0, uint(len(visible))+1)
+	if err != nil {
+		b.mu.Unlock()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(visible) > 0 {
+		mpl.SeqNo = uint64(visible[0].seqNo)
+	}
+	for _, seg := range visible {
+		mpl.Append(segmentURI(rn.Name, seg.seqNo), seg.duration.Seconds(), "")
+	}
+	b.mu.Unlock()
+	writePlaylist(w, mpl.Encode().Bytes())
+}
+
+func (b *Broadcaster) serveSegment(w http.ResponseWriter, r *http.Request, manifestID, renditionName, name string) {
+	seqNo, err := parseSeqNo(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	b.mu.Lock()
+	var seg *segment
+	if rn := b.findRendition(manifestID, renditionName); rn != nil {
+		for _, s := range rn.segments {
+			if s.seqNo == seqNo && !s.visibleAt.After(time.Now()) {
+				seg = s
+				break
+			}
+		}
+	}
+	b.mu.Unlock()
+	if seg == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if seg.status != 0 && seg.status != http.StatusOK {
+		http.Error(w, http.StatusText(seg.status), seg.status)
+		return
+	}
+	w.Header().Set("Content-Type", "video/mp2t")
+	w.Write(seg.data)
+}
+
+func (b *Broadcaster) findRendition(manifestID, renditionName string) *rendition {
+	st := b.streams[manifestID]
+	if st == nil {
+		return nil
+	}
+	for _, rn := range st.renditions {
+		if rn.Name == renditionName {
+			return rn
+		}
+	}
+	return nil
+}
+
+func writePlaylist(w http.ResponseWriter, data []byte) {
+	w.Header().Set("Content-Type", "application/x-mpegURL")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(data)
+}
+
+// handlePush accepts pushed segment and returns transcoded renditions as
+// multipart/mixed, as go-livepeer does
+func (b *Broadcaster) handlePush(w http.ResponseWriter, r *http.Request, manifestID, name string) {
+	seqNo, err := parseSeqNo(name)
+	if err != nil {
+		http.Error(w, "invalid segment name", http.StatusBadRequest)
+		return
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var dur time.Duration
+	if ms, err := strconv.Atoi(r.Header.Get("Content-Duration")); err == nil {
+		dur = time.Duration(ms) * time.Millisecond
+	} else if _, d, err := utils.GetVideoStartTimeAndDur(data); err == nil {
+		dur = d
+	}
+	st, ok := b.publish(manifestID, false)
+	if !ok {
+		http.Error(w, "stream not allowed", http.StatusForbidden)
+		return
+	}
+	rule := b.addSegment(st, &model.HlsSegment{SeqNo: seqNo, Duration: dur, Data: data})
+	if rule.Delay > 0 {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(rule.Delay):
+		}
+	}
+	if rule.Status != 0 && rule.Status != http.StatusOK {
+		http.Error(w, http.StatusText(rule.Status), rule.Status)
+		return
+	}
+	// collect segments just added to transcoded renditions
+	type part struct {
+		name string
+		data []byte
+	}
+	var parts []part
+	b.mu.Lock()
+	for _, rn := range st.renditions[1:] {
+		for _, seg := range rn.segments {
+			if seg.seqNo == seqNo {
+				parts = append(parts, part{rn.Name, seg.data})
+				break
+			}
+		}
+	}
+	b.mu.Unlock()
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	w.WriteHeader(http.StatusOK)
+	for _, p := range parts {
+		hdr := textproto.MIMEHeader{}
+		hdr.Set("Content-Type", "video/mp2t")
+		hdr.Set("Content-Length", strconv.Itoa(len(p.data)))
+		hdr.Set("Rendition-Name", p.name)
+		pw, err := mw.CreatePart(hdr)
+		if err != nil {
+			glog.Errorf("Mock broadcaster error writing push response manifestID=%s seqNo=%d err=%v", manifestID, seqNo, err)
+			return
+		}
+		pw.Write(p.data)
+	}
+	mw.Close()
+}
@@ -0,0 +1,368 @@
+// Package mockbroadcaster is in-process mock of the media server (go-livepeer
+// broadcaster, Mist or Wowza). It accepts RTMP and HTTP segment push and
+// serves source segments along with fake renditions (copies of the source) as
+// HLS, at the URL layouts the testers expect. Dropped segments, delays and
+// drift between renditions can be scripted, so testers can be run without real
+// media server (in `go test`, for example)
+package mockbroadcaster
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/joy4/format/rtmp"
+	"github.com/livepeer/stream-tester/model"
+)
+
+// SourceRendition name of the source rendition
+const SourceRendition = "source"
+
+// Layouts of the URLs of the media servers
+const (
+	LayoutLivepeer = "livepeer"
+	LayoutMist     = "mist"
+	LayoutWowza    = "wowza"
+)
+
+type (
+	// Rendition fake rendition
+	Rendition struct {
+		Name       string
+		Resolution string
+		Bandwidth  uint32
+	}
+
+	// Options of the mock broadcaster
+	Options struct {
+		// RTMPAddr, HTTPAddr addresses to listen on. Random free ports on
+		// localhost are used if empty
+		RTMPAddr string
+		HTTPAddr string
+		// Renditions transcoded renditions to serve along with the source
+		Renditions []Rendition
+		// SegmentDuration target duration of the segments cut from RTMP stream
+		SegmentDuration time.Duration
+		// PlaylistSize number of segments in media playlists
+		PlaylistSize int
+		Script       Script
+	}
+
+	// Hooks are called by the broadcaster, to integrate it with (fake) API.
+	// All the hooks are optional
+	Hooks struct {
+		// Publish authorizes new stream (RTMP or first pushed segment) and
+		// maps stream key to the manifest ID the stream is served under
+		Publish func(streamKey string) (manifestID string, ok bool)
+		// Segment is called for every source segment received
+		Segment func(manifestID string, seg *model.HlsSegment)
+		// Ended is called when RTMP stream ends
+		Ended func(manifestID string)
+	}
+
+	// Broadcaster mock media server
+	Broadcaster struct {
+		opts     Options
+		hooks    Hooks
+		rtmpAddr string
+		httpAddr string
+		server   *http.Server
+		mu       sync.Mutex
+		streams  map[string]*stream
+		stopped  bool
+	}
+
+	stream struct {
+		id         string
+		renditions []*rendition
+		// source all the source segments, indexed by sequence number
+		source []*segment
+	}
+
+	rendition struct {
+		Rendition
+		segments []*segment
+	}
+
+	segment struct {
+		seqNo     int
+		duration  time.Duration
+		data      []byte
+		visibleAt time.Time
+		status    int
+	}
+)
+
+// DefaultRenditions renditions served if none specified
+var DefaultRenditions = []Rendition{
+	{Name: "P240p30fps16x9", Resolution: "426x240", Bandwidth: 600000},
+	{Name: "P144p30fps16x9", Resolution: "256x144", Bandwidth: 400000},
+}
+
+// New creates mock broadcaster
+func New(opts Options) *Broadcaster {
+	if opts.Renditions == nil {
+		opts.Renditions = DefaultRenditions
+	}
+	if opts.SegmentDuration <= 0 {
+		opts.SegmentDuration = 2 * time.Second
+	}
+	if opts.PlaylistSize <= 0 {
+		opts.PlaylistSize = 6
+	}
+	return &Broadcaster{opts: opts, streams: make(map[string]*stream)}
+}
+
+// Start starts listening for RTMP and HTTP connections
+func (b *Broadcaster) Start(ctx context.Context) error {
+	hl, err := listen(b.opts.HTTPAddr)
+	if err != nil {
+		return err
+	}
+	b.httpAddr = hl.Addr().String()
+	b.rtmpAddr = b.opts.RTMPAddr
+	if b.rtmpAddr == "" {
+		// rtmp.Server does not accept listener, so find free port for it
+		rl, err := listen("")
+		if err != nil {
+			hl.Close()
+			return err
+		}
+		b.rtmpAddr = rl.Addr().String()
+		rl.Close()
+	}
+	b.server = &http.Server{Handler: b}
+	go func() {
+		if err := b.server.Serve(hl); err != http.ErrServerClosed {
+			glog.Errorf("Mock broadcaster HTTP server error err=%v", err)
+		}
+	}()
+	// rtmp.Server can't be stopped, so after Stop it just rejects new streams
+	rs := &rtmp.Server{Addr: b.rtmpAddr, HandlePublish: b.handlePublish}
+	go func() {
+		if err := rs.ListenAndServe(); err != nil {
+			glog.Errorf("Mock broadcaster RTMP server error err=%v", err)
+		}
+	}()
+	if err := waitListening(b.rtmpAddr, 5*time.Second); err != nil {
+		b.Stop()
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		b.Stop()
+	}()
+	glog.Infof("Mock broadcaster started rtmp=%s http=%s", b.rtmpAddr, b.httpAddr)
+	return nil
+}
+
+func listen(addr string) (net.Listener, error) {
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	return net.Listen("tcp", addr)
+}
+
+// waitListening polls the address until it accepts connections. It is used
+// instead of utils.WaitForTCP, which sleeps seconds between attempts
+func waitListening(addr string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("RTMP server is not listening on %s: %w", addr, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// Stop stops serving HTTP and accepting new RTMP streams
+func (b *Broadcaster) Stop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.stopped {
+		return
+	}
+	b.stopped = true
+	if b.server != nil {
+		b.server.Close()
+	}
+}
+
+// RTMPAddr returns host:port of the RTMP server
+func (b *Broadcaster) RTMPAddr() string {
+	return b.rtmpAddr
+}
+
+// HTTPAddr returns host:port of the HTTP server
+func (b *Broadcaster) HTTPAddr() string {
+	return b.httpAddr
+}
+
+// RTMPURL returns URL to stream stream to, in the layout of the media server
+func (b *Broadcaster) RTMPURL(layout, manifestID string) string {
+	if layout == LayoutLivepeer {
+		return fmt.Sprintf("rtmp://%s/%s", b.rtmpAddr, manifestID)
+	}
+	return fmt.Sprintf("rtmp://%s/live/%s", b.rtmpAddr, manifestID)
+}
+
+// MediaURL returns URL of the master playlist of the stream, in the layout of the media server
+func (b *Broadcaster) MediaURL(layout, manifestID string) string {
+	switch layout {
+	case LayoutMist:
+		return fmt.Sprintf("http://%s/hls/%s/index.m3u8", b.httpAddr, manifestID)
+	case LayoutWowza:
+		return fmt.Sprintf("http://%s/live/ngrp:%s_all/playlist.m3u8", b.httpAddr, manifestID)
+	}
+	return fmt.Sprintf("http://%s/stream/%s.m3u8", b.httpAddr, manifestID)
+}
+
+// PushURL returns base URL for HTTP push of the stream's segments
+func (b *Broadcaster) PushURL(manifestID string) string {
+	return fmt.Sprintf("http://%s/live/%s", b.httpAddr, manifestID)
+}
+
+// Segments returns number of the source segments of the stream (highest
+// sequence number received plus one)
+func (b *Broadcaster) Segments(manifestID string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if st := b.streams[manifestID]; st != nil {
+		return len(st.source)
+	}
+	return 0
+}
+
+// Streams returns manifest IDs of the streams received
+func (b *Broadcaster) Streams() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var res []string
+	for id := range b.streams {
+		res = append(res, id)
+	}
+	return res
+}
+
+// SetHooks sets hooks. Should be called before Start
+func (b *Broadcaster) SetHooks(hooks Hooks) {
+	b.mu.Lock()
+	b.hooks = hooks
+	b.mu.Unlock()
+}
+
+// publish starts the stream. RTMP stream (restart) replaces existing stream
+// with the same manifest ID, pushed segments are added to existing stream
+func (b *Broadcaster) publish(streamKey string, restart bool) (*stream, bool) {
+	b.mu.Lock()
+	hooks, stopped := b.hooks, b.stopped
+	if st := b.streams[streamKey]; st != nil && !restart && hooks.Publish == nil {
+		b.mu.Unlock()
+		return st, true
+	}
+	b.mu.Unlock()
+	if stopped {
+		return nil, false
+	}
+	manifestID := streamKey
+	if hooks.Publish != nil {
+		var ok bool
+		if manifestID, ok = hooks.Publish(streamKey); !ok {
+			return nil, false
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st := b.streams[manifestID]
+	if st == nil || restart {
+		st = &stream{id: manifestID}
+		st.renditions = append(st.renditions, &rendition{Rendition: Rendition{Name: SourceRendition, Resolution: "1280x720", Bandwidth: 4000000}})
+		for _, r := range b.opts.Renditions {
+			st.renditions = append(st.renditions, &rendition{Rendition: r})
+		}
+		b.streams[manifestID] = st
+	}
+	return st, true
+}
+
+func (b *Broadcaster) ended(st *stream) {
+	b.mu.Lock()
+	hook := b.hooks.Ended
+	b.mu.Unlock()
+	if hook != nil {
+		hook(st.id)
+	}
+}
+
+// addSegment adds source segment to the stream and to its renditions, applying
+// the script. Returns rule applied to the source rendition
+func (b *Broadcaster) addSegment(st *stream, seg *model.HlsSegment) Rule {
+	b.mu.Lock()
+	hook := b.hooks.Segment
+	b.mu.Unlock()
+	if hook != nil {
+		hook(st.id, seg)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	src := &segment{seqNo: seg.SeqNo, duration: seg.Duration, data: seg.Data}
+	for len(st.source) <= seg.SeqNo {
+		st.source = append(st.source, nil)
+	}
+	st.source[seg.SeqNo] = src
+	var sourceRule Rule
+	for _, r := range st.renditions {
+		rule := b.opts.Script.apply(st.id, r.Name, seg.SeqNo)
+		if r.Name == SourceRendition {
+			sourceRule = rule
+		}
+		if rule.Drop {
+			glog.V(model.DEBUG).Infof("Mock broadcaster dropping segment stream=%s rendition=%s seqNo=%d", st.id, r.Name, seg.SeqNo)
+			continue
+		}
+		from := src
+		if rule.Lag > 0 {
+			if seg.SeqNo-rule.Lag < 0 || st.source[seg.SeqNo-rule.Lag] == nil {
+				continue
+			}
+			from = st.source[seg.SeqNo-rule.Lag]
+		}
+		rs := &segment{seqNo: seg.SeqNo, duration: from.duration, data: from.data, visibleAt: now.Add(rule.Delay), status: rule.Status}
+		// pushed segments can come out of order
+		i := sort.Search(len(r.segments), func(i int) bool { return r.segments[i].seqNo >= rs.seqNo })
+		if i < len(r.segments) && r.segments[i].seqNo == rs.seqNo {
+			r.segments[i] = rs
+		} else {
+			r.segments = append(r.segments, nil)
+			copy(r.segments[i+1:], r.segments[i:])
+			r.segments[i] = rs
+		}
+		// keep some segments past the playlist window for slow downloaders
+		if keep := 2 * b.opts.PlaylistSize; len(r.segments) > keep {
+			r.segments = r.segments[len(r.segments)-keep:]
+		}
+	}
+	// source segments are kept only as far as lag can reach back
+	if old := len(st.source) - 1 - 4*b.opts.PlaylistSize; old >= 0 {
+		st.source[old] = nil
+	}
+	return sourceRule
+}
+
+// parseSeqNo parses sequence number from segment's name (like 12.ts)
+func parseSeqNo(name string) (int, error) {
+	return strconv.Atoi(strings.TrimSuffix(name, ".ts"))
+}
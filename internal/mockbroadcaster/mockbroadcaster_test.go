@@ -0,0 +1,271 @@
+package mockbroadcaster
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/livepeer/m3u8"
+)
+
+func startBroadcaster(t *testing.T, script Script) *Broadcaster {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	b := New(Options{Script: script})
+	if err := b.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// push pushes segment with the data, returns response status and the parts of
+// the multipart response by rendition name
+func push(t *testing.T, b *Broadcaster, manifestID string, seqNo int) (int, map[string][]byte) {
+	t.Helper()
+	data := []byte(fmt.Sprintf("segment %d", seqNo))
+	req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/%d.ts", b.PushURL(manifestID), seqNo), bytes.NewReader(data))
+	req.Header.Set("Content-Duration", "2000")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, nil
+	}
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("push response content type is %q err=%v", resp.Header.Get("Content-Type"), err)
+	}
+	parts := make(map[string][]byte)
+	mr := multipart.NewReader(resp.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		pd, _ := ioutil.ReadAll(p)
+		parts[p.Header.Get("Rendition-Name")] = pd
+	}
+	return resp.StatusCode, parts
+}
+
+func get(t *testing.T, uri string) (int, []byte) {
+	t.Helper()
+	resp, err := http.Get(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, data
+}
+
+func resolve(base, ref string) string {
+	bu, _ := url.Parse(base)
+	ru, _ := url.Parse(ref)
+	return bu.ResolveReference(ru).String()
+}
+
+func TestLayouts(t *testing.T) {
+	b := startBroadcaster(t, nil)
+	for i := 0; i < 3; i++ {
+		if status, _ := push(t, b, "st1", i); status != http.StatusOK {
+			t.Fatalf("push returned %d", status)
+		}
+	}
+	if n := b.Segments("st1"); n != 3 {
+		t.Errorf("broadcaster has %d segments instead of 3", n)
+	}
+	for _, layout := range []string{LayoutLivepeer, LayoutMist, LayoutWowza} {
+		masterURL := b.MediaURL(layout, "st1")
+		status, data := get(t, masterURL)
+		if status != http.StatusOK {
+			t.Fatalf("%s: master playlist %s returned %d", layout, masterURL, status)
+		}
+		pl, plType, err := m3u8.DecodeFrom(bytes.NewReader(data), true)
+		if err != nil || plType != m3u8.MASTER {
+			t.Fatalf("%s: invalid master playlist err=%v:\n%s", layout, err, data)
+		}
+		variants := pl.(*m3u8.MasterPlaylist).Variants
+		if len(variants) != 1+len(DefaultRenditions) || variants[0].Name != SourceRendition {
+			t.Fatalf("%s: master playlist has wrong variants:\n%s", layout, data)
+		}
+		for _, v := range variants {
+			mediaURL := resolve(masterURL, v.URI)
+			status, data := get(t, mediaURL)
+			if status != http.StatusOK {
+				t.Fatalf("%s: media playlist %s returned %d", layout, mediaURL, status)
+			}
+			pl, plType, err := m3u8.DecodeFrom(bytes.NewReader(data), true)
+			if err != nil || plType != m3u8.MEDIA {
+				t.Fatalf("%s: invalid media playlist err=%v:\n%s", layout, err, data)
+			}
+			mpl := pl.(*m3u8.MediaPlaylist)
+			if mpl.Count() != 3 || mpl.SeqNo != 0 || mpl.Segments[0].Duration != 2 {
+				t.Fatalf("%s: media playlist of %s has wrong segments:\n%s", layout, v.Name, data)
+			}
+			segURL := resolve(mediaURL, mpl.Segments[2].URI)
+			if status, data := get(t, segURL); status != http.StatusOK || string(data) != "segment 2" {
+				t.Errorf("%s: segment %s returned %d %q", layout, segURL, status, data)
+			}
+		}
+	}
+	if status, _ := get(t, b.MediaURL(LayoutLivepeer, "unknown")); status != http.StatusNotFound {
+		t.Errorf("playlist of unknown stream returned %d", status)
+	}
+}
+
+func TestWowzaSessionIgnored(t *testing.T) {
+	b := startBroadcaster(t, nil)
+	push(t, b, "st1", 0)
+	uri := fmt.Sprintf("http://%s/live/ngrp:st1_all/media_w123456_%s_0.ts", b.HTTPAddr(), SourceRendition)
+	if status, data := get(t, uri); status != http.StatusOK || string(data) != "segment 0" {
+		t.Errorf("segment with Wowza session returned %d %q", status, data)
+	}
+}
+
+func TestPushResponse(t *testing.T) {
+	b := startBroadcaster(t, Script{{Rendition: DefaultRenditions[1].Name, From: 1, To: 1, Drop: true}})
+	status, parts := push(t, b, "st1", 0)
+	if status != http.StatusOK || len(parts) != len(DefaultRenditions) {
+		t.Fatalf("push returned %d with %d parts", status, len(parts))
+	}
+	for _, rn := range DefaultRenditions {
+		if string(parts[rn.Name]) != "segment 0" {
+			t.Errorf("rendition %s has %q", rn.Name, parts[rn.Name])
+		}
+	}
+	// dropped segment is not returned
+	_, parts = push(t, b, "st1", 1)
+	if len(parts) != 1 || parts[DefaultRenditions[0].Name] == nil {
+		t.Errorf("push with dropped rendition returned renditions %v", parts)
+	}
+	uri := fmt.Sprintf("http://%s/live/st1/abc.ts", b.HTTPAddr())
+	if resp, err := http.Post(uri, "video/mp2t", strings.NewReader("x")); err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("push of invalid segment name returned %v err=%v", resp, err)
+	}
+}
+
+func TestRuleStatus(t *testing.T) {
+	b := startBroadcaster(t, Script{
+		{Rendition: SourceRendition, From: 1, To: 1, Status: http.StatusServiceUnavailable},
+		{Rendition: DefaultRenditions[0].Name, From: 2, To: -1, Status: http.StatusNotFound},
+	})
+	if status, _ := push(t, b, "st1", 0); status != http.StatusOK {
+		t.Errorf("push of segment 0 returned %d", status)
+	}
+	// rule for the source rendition fails the push
+	if status, _ := push(t, b, "st1", 1); status != http.StatusServiceUnavailable {
+		t.Errorf("push of segment 1 returned %d instead of 503", status)
+	}
+	push(t, b, "st1", 2)
+	base := fmt.Sprintf("http://%s/stream/st1/", b.HTTPAddr())
+	for _, c := range []struct {
+		uri    string
+		status int
+	}{
+		{SourceRendition + "/0.ts", http.StatusOK},
+		{SourceRendition + "/1.ts", http.StatusServiceUnavailable},
+		{DefaultRenditions[0].Name + "/1.ts", http.StatusOK},
+		{DefaultRenditions[0].Name + "/2.ts", http.StatusNotFound},
+		{DefaultRenditions[1].Name + "/2.ts", http.StatusOK},
+	} {
+		if status, _ := get(t, base+c.uri); status != c.status {
+			t.Errorf("%s returned %d instead of %d", c.uri, status, c.status)
+		}
+	}
+}
+
+func TestRuleDelay(t *testing.T) {
+	const delay = 300 * time.Millisecond
+	b := startBroadcaster(t, Script{{Rendition: SourceRendition, From: 1, To: 1, Delay: delay}})
+	push(t, b, "st1", 0)
+	// push response is delayed by the source rendition's rule
+	start := time.Now()
+	if status, _ := push(t, b, "st1", 1); status != http.StatusOK {
+		t.Fatalf("push returned %d", status)
+	}
+	if took := time.Since(start); took < delay {
+		t.Errorf("push of delayed segment took %s", took)
+	}
+	b2 := startBroadcaster(t, Script{{Rendition: SourceRendition, From: 1, To: 1, Delay: delay}})
+	push(t, b2, "st1", 0)
+	go func() {
+		uri := fmt.Sprintf("%s/1.ts", b2.PushURL("st1"))
+		if resp, err := http.Post(uri, "video/mp2t", strings.NewReader("segment 1")); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	time.Sleep(delay / 3)
+	playlist := fmt.Sprintf("http://%s/stream/st1/%s.m3u8", b2.HTTPAddr(), SourceRendition)
+	segURL := fmt.Sprintf("http://%s/stream/st1/%s/1.ts", b2.HTTPAddr(), SourceRendition)
+	if _, data := get(t, playlist); strings.Contains(string(data), "1.ts") {
+		t.Errorf("delayed segment is in the playlist before the delay:\n%s", data)
+	}
+	if status, _ := get(t, segURL); status != http.StatusNotFound {
+		t.Errorf("delayed segment returned %d before the delay", status)
+	}
+	time.Sleep(delay)
+	if _, data := get(t, playlist); !strings.Contains(string(data), "1.ts") {
+		t.Errorf("delayed segment is not in the playlist after the delay:\n%s", data)
+	}
+	if status, _ := get(t, segURL); status != http.StatusOK {
+		t.Errorf("delayed segment returned %d after the delay", status)
+	}
+}
+
+func TestRuleLag(t *testing.T) {
+	b := startBroadcaster(t, Script{{Rendition: DefaultRenditions[0].Name, To: -1, Lag: 1}})
+	push(t, b, "st1", 0)
+	_, parts := push(t, b, "st1", 1)
+	if got := string(parts[DefaultRenditions[0].Name]); got != "segment 0" {
+		t.Errorf("lagging rendition returned %q instead of previous segment", got)
+	}
+	if got := string(parts[DefaultRenditions[1].Name]); got != "segment 1" {
+		t.Errorf("rendition returned %q", got)
+	}
+}
+
+func TestParseScript(t *testing.T) {
+	script, err := ParseScript("drop/P144p30fps16x9:5-7, delay=3s:10-, lag=2@stream1/source, status=503:4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Script{
+		{Rendition: "P144p30fps16x9", From: 5, To: 7, Drop: true},
+		{From: 10, To: -1, Delay: 3 * time.Second},
+		{Stream: "stream1", Rendition: SourceRendition, To: -1, Lag: 2},
+		{From: 4, To: 4, Status: 503},
+	}
+	if len(script) != len(want) {
+		t.Fatalf("got %d rules: %+v", len(script), script)
+	}
+	for i := range want {
+		if script[i] != want[i] {
+			t.Errorf("rule %d is %+v, want %+v", i, script[i], want[i])
+		}
+	}
+	for _, s := range []string{"explode", "delay=soon", "lag=2:a-b"} {
+		if _, err := ParseScript(s); err == nil {
+			t.Errorf("no error parsing %q", s)
+		}
+	}
+	r := script.apply("stream1", SourceRendition, 5)
+	if r.Lag != 2 || r.Drop || r.Delay != 0 {
+		t.Errorf("applied rules are %+v", r)
+	}
+}
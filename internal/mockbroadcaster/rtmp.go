@@ -0,0 +1,97 @@
+package mockbroadcaster
+
+import (
+	"bytes"
+	"io"
+	"path"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/joy4/av"
+	"github.com/livepeer/joy4/format/rtmp"
+	"github.com/livepeer/joy4/format/ts"
+	"github.com/livepeer/stream-tester/model"
+)
+
+// handlePublish cuts incoming RTMP stream into segments
+func (b *Broadcaster) handlePublish(conn *rtmp.Conn) {
+	defer conn.Close()
+	streamKey := path.Base(conn.URL.Path)
+	st, ok := b.publish(streamKey, true)
+	if !ok {
+		glog.Infof("Mock broadcaster rejected RTMP stream streamKey=%s", streamKey)
+		return
+	}
+	glog.Infof("Mock broadcaster got RTMP stream streamKey=%s manifestID=%s", streamKey, st.id)
+	err := CutSegments(conn, b.opts.SegmentDuration, func(seg *model.HlsSegment) {
+		glog.V(model.DEBUG).Infof("Mock broadcaster cut segment manifestID=%s seqNo=%d pts=%s dur=%s", st.id, seg.SeqNo, seg.Pts, seg.Duration)
+		b.addSegment(st, seg)
+	})
+	if err != nil {
+		glog.V(model.DEBUG).Infof("Mock broadcaster RTMP read error manifestID=%s err=%v", st.id, err)
+	}
+	b.ended(st)
+	glog.Infof("Mock broadcaster RTMP stream ended manifestID=%s", st.id)
+}
+
+// CutSegments reads packets from the demuxer and cuts them into MPEG-TS
+// segments on video keyframes, at least segLen long. Returns nil at the end of
+// the stream
+func CutSegments(demuxer av.Demuxer, segLen time.Duration, out func(*model.HlsSegment)) error {
+	streams, err := demuxer.Streams()
+	if err != nil {
+		return err
+	}
+	var videoIdx int8 = -1
+	for i, s := range streams {
+		if s.Type().IsVideo() {
+			videoIdx = int8(i)
+		}
+	}
+	seqNo := 0
+	var buf *bytes.Buffer
+	var muxer *ts.Muxer
+	var segStart, lastTime time.Duration
+	flush := func() {
+		if muxer == nil {
+			return
+		}
+		muxer.WriteTrailer()
+		out(&model.HlsSegment{SeqNo: seqNo, Pts: segStart, Duration: lastTime - segStart, Data: buf.Bytes()})
+		seqNo++
+		muxer = nil
+	}
+	for {
+		pkt, err := demuxer.ReadPacket()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			flush()
+			return err
+		}
+		keyFrame := pkt.IsKeyFrame && (pkt.Idx == videoIdx || videoIdx < 0)
+		if muxer != nil && keyFrame && pkt.Time-segStart >= segLen {
+			lastTime = pkt.Time
+			flush()
+		}
+		if muxer == nil {
+			if !keyFrame {
+				// segment should start with keyframe
+				continue
+			}
+			buf = &bytes.Buffer{}
+			muxer = ts.NewMuxer(buf)
+			if err := muxer.WriteHeader(streams); err != nil {
+				return err
+			}
+			segStart = pkt.Time
+		}
+		if err := muxer.WritePacket(pkt); err != nil {
+			return err
+		}
+		lastTime = pkt.Time
+	}
+	flush()
+	return nil
+}
@@ -0,0 +1,130 @@
+package mockbroadcaster
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// Rule changes how segments of the streams are served
+	Rule struct {
+		// Stream manifest ID of the stream, empty matches all the streams
+		Stream string
+		// Rendition name of the rendition, empty matches all the renditions
+		Rendition string
+		// From, To range of the source segments' sequence numbers (inclusive).
+		// To < 0 means up to the end of the stream
+		From, To int
+		// Drop segment is not added to the rendition's playlist
+		Drop bool
+		// Delay segment appears in the playlist after the delay. For HTTP
+		// push, response to the push is delayed too
+		Delay time.Duration
+		// Lag rendition serves the segment published Lag segments earlier, which
+		// makes time drift between renditions
+		Lag int
+		// Status requests of the segment fail with this HTTP status. For HTTP
+		// push, rule for the source rendition fails the push
+		Status int
+	}
+
+	// Script rules applied to the segments. When several rules match, all of them
+	// are applied
+	Script []Rule
+)
+
+func (r *Rule) matches(stream, rendition string, seqNo int) bool {
+	return (r.Stream == "" || r.Stream == stream) && (r.Rendition == "" || r.Rendition == rendition) &&
+		seqNo >= r.From && (r.To < 0 || seqNo <= r.To)
+}
+
+// apply returns combined effect of the rules matching the segment
+func (s Script) apply(stream, rendition string, seqNo int) Rule {
+	res := Rule{Stream: stream, Rendition: rendition, From: seqNo, To: seqNo}
+	for i := range s {
+		r := &s[i]
+		if !r.matches(stream, rendition, seqNo) {
+			continue
+		}
+		res.Drop = res.Drop || r.Drop
+		res.Delay += r.Delay
+		res.Lag += r.Lag
+		if r.Status != 0 {
+			res.Status = r.Status
+		}
+	}
+	return res
+}
+
+// ParseScript parses rules separated by commas. Each rule is in form
+//
+//	action[=value][@stream][/rendition][:from-to]
+//
+// where action is one of drop, delay, lag, status. Examples:
+//
+//	drop/P144p30fps16x9:5-7  delay=3s:10-  lag=2@stream1/source  status=503:4-4
+func ParseScript(s string) (Script, error) {
+	var res Script
+	for _, rs := range strings.Split(s, ",") {
+		rs = strings.TrimSpace(rs)
+		if rs == "" {
+			continue
+		}
+		r, err := parseRule(rs)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule %q: %w", rs, err)
+		}
+		res = append(res, r)
+	}
+	return res, nil
+}
+
+func parseRule(s string) (Rule, error) {
+	r := Rule{To: -1}
+	if i := strings.LastIndex(s, ":"); i >= 0 {
+		from, to := s[i+1:], ""
+		s = s[:i]
+		if j := strings.Index(from, "-"); j >= 0 {
+			from, to = from[:j], from[j+1:]
+		} else {
+			to = from
+		}
+		var err error
+		if from != "" {
+			if r.From, err = strconv.Atoi(from); err != nil {
+				return r, err
+			}
+		}
+		if to != "" {
+			if r.To, err = strconv.Atoi(to); err != nil {
+				return r, err
+			}
+		}
+	}
+	if i := strings.Index(s, "/"); i >= 0 {
+		s, r.Rendition = s[:i], s[i+1:]
+	}
+	if i := strings.Index(s, "@"); i >= 0 {
+		s, r.Stream = s[:i], s[i+1:]
+	}
+	action, value := s, ""
+	if i := strings.Index(s, "="); i >= 0 {
+		action, value = s[:i], s[i+1:]
+	}
+	var err error
+	switch action {
+	case "drop":
+		r.Drop = true
+	case "delay":
+		r.Delay, err = time.ParseDuration(value)
+	case "lag":
+		r.Lag, err = strconv.Atoi(value)
+	case "status":
+		r.Status, err = strconv.Atoi(value)
+	default:
+		err = fmt.Errorf("unknown action %q", action)
+	}
+	return r, err
+}
@@ -0,0 +1,275 @@
+package mockmist
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/stream-tester/apis/mist"
+	"github.com/livepeer/stream-tester/model"
+)
+
+type authCommand struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// ServeHTTP serves Mist API and control endpoints
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, controlPrefix) {
+		s.serveControl(w, r)
+		return
+	}
+	if r.URL.Path != "/api" && r.URL.Path != "/api2" {
+		http.NotFound(w, r)
+		return
+	}
+	command := r.URL.Query().Get("command")
+	if r.Method == http.MethodPost {
+		// mist.API sends form encoded body with JSON content type
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if form, err := url.ParseQuery(string(b)); err == nil && form.Get("command") != "" {
+			command = form.Get("command")
+		} else if len(b) > 0 {
+			command = string(b)
+		}
+	}
+	cmd := make(map[string]json.RawMessage)
+	if command != "" {
+		if err := json.Unmarshal([]byte(command), &cmd); err != nil {
+			http.Error(w, "invalid command: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	glog.V(model.VERBOSE).Infof("Mock Mist got command=%s", command)
+	resp := make(map[string]interface{})
+	if s.authorize(r, cmd, resp) {
+		s.handleCommand(r, cmd, resp)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// authorize checks request's credentials, answering with new challenge if
+// they are invalid. Requests without credentials are accepted from localhost
+func (s *Server) authorize(r *http.Request, cmd map[string]json.RawMessage, resp map[string]interface{}) bool {
+	if s.opts.Password == "" {
+		resp["authorize"] = map[string]string{"status": "OK"}
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if raw, ok := cmd["authorize"]; ok {
+		var auth authCommand
+		if err := json.Unmarshal(raw, &auth); err == nil && auth.Username == s.opts.Login &&
+			auth.Password == challengeResponse(s.opts.Password, s.challenge) {
+			resp["authorize"] = map[string]string{"status": "OK"}
+			return true
+		}
+		glog.Infof("Mock Mist authorization failed user=%s", auth.Username)
+		s.challenge = newChallenge()
+	} else if len(cmd) > 0 && isLocal(r) {
+		return true
+	}
+	resp["authorize"] = map[string]string{"status": "CHALL", "challenge": s.challenge}
+	return false
+}
+
+func (s *Server) handleCommand(r *http.Request, cmd map[string]json.RawMessage, resp map[string]interface{}) {
+	minimal := r.URL.Query().Get("minimal") == "1"
+	if raw, ok := cmd["minimal"]; ok {
+		minimal = string(raw) == "1" || string(raw) == "true"
+	}
+	if raw, ok := cmd["addstream"]; ok {
+		var streams map[string]*mist.Stream
+		if err := json.Unmarshal(raw, &streams); err == nil {
+			s.mu.Lock()
+			for name, st := range streams {
+				st.Name = name
+				s.streams[name] = st
+			}
+			s.mu.Unlock()
+			glog.V(model.DEBUG).Infof("Mock Mist added streams=%d", len(streams))
+		}
+	}
+	if raw, ok := cmd["deletestream"]; ok {
+		var names []string
+		if err := json.Unmarshal(raw, &names); err != nil {
+			var byName map[string]interface{}
+			json.Unmarshal(raw, &byName)
+			for name := range byName {
+				names = append(names, name)
+			}
+		}
+		s.mu.Lock()
+		for _, name := range names {
+			delete(s.streams, name)
+		}
+		s.mu.Unlock()
+	}
+	if raw, ok := cmd["nuke_stream"]; ok {
+		var name string
+		json.Unmarshal(raw, &name)
+		// Mist kills the input, so closing connection
+		go s.StopStream(name)
+	}
+	if raw, ok := cmd["push_start"]; ok {
+		var ps struct {
+			Stream string `json:"stream"`
+			Target string `json:"target"`
+		}
+		if err := json.Unmarshal(raw, &ps); err == nil && ps.Stream != "" && ps.Target != "" {
+			s.startPush(ps.Stream, ps.Target)
+		}
+	}
+	if raw, ok := cmd["push_stop"]; ok {
+		var ids []int64
+		if err := json.Unmarshal(raw, &ids); err != nil {
+			var id int64
+			json.Unmarshal(raw, &id)
+			ids = append(ids, id)
+		}
+		for _, id := range ids {
+			go s.EndPush(id)
+		}
+	}
+	if raw, ok := cmd["config"]; ok {
+		var config mist.Config
+		if err := json.Unmarshal(raw, &config); err == nil {
+			s.mu.Lock()
+			if config.Triggers != nil {
+				s.triggers = config.Triggers
+				glog.Infof("Mock Mist triggers set triggers=%d", len(config.Triggers))
+			}
+			s.mu.Unlock()
+		}
+		resp["config"] = s.config()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := cmd["active_streams"]; ok {
+		resp["active_streams"] = s.activeNames()
+	}
+	if raw, ok := cmd["stats_streams"]; ok {
+		var fields []string
+		json.Unmarshal(raw, &fields)
+		resp["stats_streams"] = s.streamsStats(fields)
+	}
+	if _, ok := cmd["push_list"]; ok {
+		pushes := []interface{}{}
+		for _, p := range s.sortedPushes() {
+			pushes = append(pushes, []interface{}{p.ID, p.Stream, p.OriginalURI, p.EffectiveURI, nil, p.stats()})
+		}
+		resp["push_list"] = pushes
+	}
+	if !minimal {
+		streams := make(map[string]*mist.Stream, len(s.streams))
+		for name, st := range s.streams {
+			streams[name] = st
+		}
+		resp["streams"] = streams
+	}
+}
+
+func (s *Server) config() *mist.Config {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &mist.Config{Triggers: s.triggers, Version: s.opts.Version, Time: time.Now().Unix()}
+}
+
+// streamsStats returns requested fields for active streams
+func (s *Server) streamsStats(fields []string) map[string][]interface{} {
+	res := make(map[string][]interface{})
+	for name, st := range s.active {
+		var vals []interface{}
+		for _, f := range fields {
+			switch f {
+			case "clients":
+				vals = append(vals, st.viewers)
+			case "lastms":
+				vals = append(vals, time.Since(st.started).Milliseconds())
+			default:
+				vals = append(vals, 0)
+			}
+		}
+		res[name] = vals
+	}
+	return res
+}
+
+func (p *push) stats() *mist.PushStats {
+	active := time.Since(p.started)
+	return &mist.PushStats{
+		ActiveSeconds: int64(active.Seconds()),
+		Bytes:         int64(active.Seconds() * 250000),
+		MediaTime:     active.Milliseconds(),
+		Tracks:        []int{1, 2},
+	}
+}
+
+func (s *Server) pushStatus(p *push) string {
+	b, _ := json.Marshal(p.stats())
+	return string(b)
+}
+
+// trackList returns LIVE_TRACK_LIST payload for the stream: source video and
+// audio, and video track for every profile of the stream's Livepeer process.
+// Config of the wildcard stream is used for `base+name` streams
+func (s *Server) trackList(name string) string {
+	type track struct {
+		Type     string `json:"type"`
+		Codec    string `json:"codec"`
+		Trackid  int    `json:"trackid"`
+		Width    int    `json:"width,omitempty"`
+		Height   int    `json:"height,omitempty"`
+		Fpks     int64  `json:"fpks,omitempty"`
+		Bps      int64  `json:"bps,omitempty"`
+		Rate     int    `json:"rate,omitempty"`
+		Channels int    `json:"channels,omitempty"`
+	}
+	tracks := map[string]*track{
+		"video_H264_1280x720_30fps_1": {Type: "video", Codec: "H264", Trackid: 1, Width: 1280, Height: 720, Fpks: 30000, Bps: 500000},
+		"audio_AAC_2ch_48000hz_2":     {Type: "audio", Codec: "AAC", Trackid: 2, Rate: 48000, Channels: 2, Bps: 16000},
+	}
+	st := s.streams[name]
+	if st == nil {
+		if i := strings.Index(name, "+"); i > 0 {
+			st = s.streams[name[:i]]
+		}
+	}
+	if st != nil {
+		id := 3
+		for _, proc := range st.Processes {
+			if proc.Process != "Livepeer" {
+				continue
+			}
+			for _, p := range proc.TargetProfiles {
+				key := fmt.Sprintf("video_H264_%dx%d_%dfps_%d", p.Width, p.Height, p.Fps, id)
+				tracks[key] = &track{Type: "video", Codec: "H264", Trackid: id, Width: p.Width, Height: p.Height,
+					Fpks: int64(p.Fps) * 1000, Bps: int64(p.Bitrate) / 8}
+				id++
+			}
+		}
+	}
+	b, _ := json.Marshal(tracks)
+	return string(b)
+}
+
+func isLocal(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
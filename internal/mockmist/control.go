@@ -0,0 +1,69 @@
+package mockmist
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const controlPrefix = "/_mock/"
+
+// serveControl serves endpoints driving the simulator:
+//
+//	POST /_mock/start?key=<streamKey>          - start stream, responds with its name
+//	POST /_mock/stop?stream=<name>             - stop stream
+//	POST /_mock/viewers?stream=<name>&count=N  - set number of viewers
+//	POST /_mock/push_end?id=<pushID>           - end push
+//	POST /_mock/trigger?name=<TRIGGER>&stream=<name> - fire trigger with body lines
+//	GET  /_mock/pushes                         - list active pushes
+func (s *Server) serveControl(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	action := strings.TrimPrefix(r.URL.Path, controlPrefix)
+	if action == "pushes" {
+		for _, p := range s.Pushes() {
+			fmt.Fprintf(w, "%d %s %s\n", p.ID, p.Stream, p.OriginalURI)
+		}
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var err error
+	switch action {
+	case "start":
+		var name string
+		if name, err = s.StartStream(q.Get("key")); err == nil {
+			fmt.Fprintln(w, name)
+		}
+	case "stop":
+		err = s.StopStream(q.Get("stream"))
+	case "viewers":
+		var count int
+		if count, err = strconv.Atoi(q.Get("count")); err == nil {
+			err = s.SetViewers(q.Get("stream"), count)
+		}
+	case "push_end":
+		var id int64
+		if id, err = strconv.ParseInt(q.Get("id"), 10, 64); err == nil {
+			err = s.EndPush(id)
+		}
+	case "trigger":
+		var body []byte
+		if body, err = ioutil.ReadAll(r.Body); err == nil {
+			var resp string
+			lines := strings.Split(strings.TrimRight(string(body), "\n"), "\n")
+			if resp, err = s.Trigger(q.Get("name"), q.Get("stream"), lines...); err == nil {
+				fmt.Fprintln(w, resp)
+			}
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
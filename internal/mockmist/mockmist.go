@@ -0,0 +1,361 @@
+// Package mockmist is simulator of the MistServer API. It implements `/api`
+// and `/api2` commands used by mist.API (authorization challenge, addstream,
+// deletestream, nuke_stream, push_start, stats, config with triggers) and
+// fires triggers configured through the API at their handlers, so
+// mist-api-connector flows can be run without real MistServer
+package mockmist
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/stream-tester/apis/mist"
+	"github.com/livepeer/stream-tester/internal/utils"
+	"github.com/livepeer/stream-tester/model"
+)
+
+// ErrRejected returned when stream is rejected by the PUSH_REWRITE handler
+var ErrRejected = errors.New("stream rejected")
+
+type (
+	// Options of the simulator
+	Options struct {
+		// Addr address to listen on. Random free port on localhost is used if empty
+		Addr string
+		// Login, Password credentials. If password is empty, any request is
+		// authorized. Otherwise requests without credentials are authorized
+		// only from the localhost, as Mist does
+		Login, Password string
+		// Version reported to the trigger handlers
+		Version string
+		// FailPush if set, pushes to the targets it returns true for fail
+		// right after the start
+		FailPush func(target string) bool
+	}
+
+	// Server MistServer simulator
+	Server struct {
+		opts      Options
+		addr      string
+		server    *http.Server
+		client    *http.Client
+		mu        sync.Mutex
+		challenge string
+		triggers  mist.TriggersMap
+		streams   map[string]*mist.Stream
+		active    map[string]*activeStream
+		pushes    map[int64]*push
+		lastPush  int64
+		stopped   bool
+	}
+
+	activeStream struct {
+		name      string
+		streamKey string
+		started   time.Time
+		viewers   int
+	}
+
+	push struct {
+		mist.Push
+		started time.Time
+	}
+)
+
+// New creates Mist simulator
+func New(opts Options) *Server {
+	if opts.Version == "" {
+		opts.Version = "mockmist " + model.Version
+	}
+	return &Server{
+		opts:      opts,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		challenge: newChallenge(),
+		triggers:  make(mist.TriggersMap),
+		streams:   make(map[string]*mist.Stream),
+		active:    make(map[string]*activeStream),
+		pushes:    make(map[int64]*push),
+	}
+}
+
+// Start starts serving the API
+func (s *Server) Start(ctx context.Context) error {
+	addr := s.opts.Addr
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.addr = l.Addr().String()
+	s.server = &http.Server{Handler: s}
+	go func() {
+		if err := s.server.Serve(l); err != http.ErrServerClosed {
+			glog.Errorf("Mock Mist server error err=%v", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		s.Stop()
+	}()
+	glog.Infof("Mock Mist started addr=%s", s.addr)
+	return nil
+}
+
+// Stop stops the server
+func (s *Server) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return
+	}
+	s.stopped = true
+	if s.server != nil {
+		s.server.Close()
+	}
+}
+
+// Addr returns host:port the API is served on
+func (s *Server) Addr() string {
+	return s.addr
+}
+
+// Triggers returns triggers configured through the API
+func (s *Server) Triggers() mist.TriggersMap {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make(mist.TriggersMap)
+	for name, tt := range s.triggers {
+		res[name] = append([]mist.Trigger(nil), tt...)
+	}
+	return res
+}
+
+// Stream returns stream's configuration (nil if stream was not added)
+func (s *Server) Stream(name string) *mist.Stream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.streams[name]
+}
+
+// ActiveStreams returns names of the streams being streamed
+func (s *Server) ActiveStreams() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.activeNames()
+}
+
+func (s *Server) activeNames() []string {
+	res := []string{}
+	for name := range s.active {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Pushes returns active pushes
+func (s *Server) Pushes() []mist.Push {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var res []mist.Push
+	for _, p := range s.sortedPushes() {
+		res = append(res, p.Push)
+	}
+	return res
+}
+
+func (s *Server) sortedPushes() []*push {
+	var res []*push
+	for _, p := range s.pushes {
+		res = append(res, p)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res
+}
+
+// Trigger fires trigger at all the handlers configured for it (and for the
+// stream, if handler is limited to the streams). Handlers are called one by
+// one, even asynchronous ones. Returns response of the last sync handler, or
+// trigger's default value if handler fails
+func (s *Server) Trigger(name, stream string, lines ...string) (string, error) {
+	s.mu.Lock()
+	var handlers []mist.Trigger
+	for _, t := range s.triggers[name] {
+		if len(t.Streams) == 0 || utils.StringsSliceContains(t.Streams, stream) {
+			handlers = append(handlers, t)
+		}
+	}
+	s.mu.Unlock()
+	body := strings.Join(lines, "\n")
+	var res string
+	var err error
+	for _, t := range handlers {
+		resp, herr := s.callHandler(name, t.Handler, body)
+		if herr != nil {
+			glog.Errorf("Mock Mist trigger handler error trigger=%s handler=%s err=%v", name, t.Handler, herr)
+			err = herr
+			resp = t.Default
+		}
+		if t.Sync {
+			res = resp
+		}
+	}
+	glog.V(model.DEBUG).Infof("Mock Mist fired trigger=%s stream=%s handlers=%d response=%q", name, stream, len(handlers), res)
+	return res, err
+}
+
+func (s *Server) callHandler(trigger, handler, body string) (string, error) {
+	req, err := http.NewRequest(http.MethodPost, handler, strings.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Trigger", trigger)
+	req.Header.Set("X-Version", s.opts.Version)
+	req.Header.Set("Content-Type", "text/plain")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return string(b), fmt.Errorf("handler responded with status %s", resp.Status)
+	}
+	return string(b), nil
+}
+
+// StartStream simulates incoming RTMP stream. PUSH_REWRITE trigger decides
+// the name of the stream, then LIVE_TRACK_LIST trigger is fired with the
+// source tracks and a video track for every transcoding profile of the stream.
+// ErrRejected is returned if the handler rejects the stream or fails
+func (s *Server) StartStream(streamKey string) (string, error) {
+	rtmpURL := s.rtmpURL(streamKey)
+	name, err := s.Trigger("PUSH_REWRITE", "", rtmpURL, "127.0.0.1", streamKey)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrRejected, err)
+	}
+	if name == "" || name == "false" {
+		return "", ErrRejected
+	}
+	s.mu.Lock()
+	s.active[name] = &activeStream{name: name, streamKey: streamKey, started: time.Now()}
+	tracks := s.trackList(name)
+	s.mu.Unlock()
+	glog.Infof("Mock Mist stream started streamKey=%s name=%s", streamKey, name)
+	s.Trigger("LIVE_TRACK_LIST", name, name, tracks)
+	return name, nil
+}
+
+// StopStream ends the stream. Its pushes are ended (PUSH_END trigger), and
+// CONN_CLOSE trigger is fired for the RTMP connection
+func (s *Server) StopStream(name string) error {
+	s.mu.Lock()
+	st := s.active[name]
+	delete(s.active, name)
+	var ended []*push
+	for id, p := range s.pushes {
+		if p.Stream == name {
+			ended = append(ended, p)
+			delete(s.pushes, id)
+		}
+	}
+	s.mu.Unlock()
+	if st == nil {
+		return fmt.Errorf("stream %s is not active", name)
+	}
+	for _, p := range ended {
+		s.firePushEnd(p, "Stream ended")
+	}
+	_, err := s.Trigger("CONN_CLOSE", name, name, "127.0.0.1", "RTMP", s.rtmpURL(st.streamKey))
+	glog.Infof("Mock Mist stream stopped name=%s", name)
+	return err
+}
+
+// SetViewers sets number of clients reported in the stream's stats
+func (s *Server) SetViewers(name string, viewers int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.active[name]
+	if st == nil {
+		return fmt.Errorf("stream %s is not active", name)
+	}
+	st.viewers = viewers
+	return nil
+}
+
+// EndPush ends the push, firing PUSH_END trigger
+func (s *Server) EndPush(id int64) error {
+	s.mu.Lock()
+	p := s.pushes[id]
+	delete(s.pushes, id)
+	s.mu.Unlock()
+	if p == nil {
+		return fmt.Errorf("push %d not found", id)
+	}
+	s.firePushEnd(p, "Push ended")
+	return nil
+}
+
+// startPush starts push of the stream to the target, PUSH_OUT_START trigger is
+// fired asynchronously
+func (s *Server) startPush(stream, target string) {
+	s.mu.Lock()
+	s.lastPush++
+	p := &push{
+		Push:    mist.Push{ID: s.lastPush, Stream: stream, OriginalURI: target, EffectiveURI: target},
+		started: time.Now(),
+	}
+	s.pushes[p.ID] = p
+	s.mu.Unlock()
+	glog.Infof("Mock Mist push started id=%d stream=%s target=%s", p.ID, stream, target)
+	go func() {
+		s.Trigger("PUSH_OUT_START", stream, stream, target)
+		if s.opts.FailPush != nil && s.opts.FailPush(target) {
+			s.mu.Lock()
+			delete(s.pushes, p.ID)
+			s.mu.Unlock()
+			s.firePushEnd(p, "Connection refused")
+		}
+	}()
+}
+
+func (s *Server) firePushEnd(p *push, message string) {
+	logs := fmt.Sprintf(`[[%d,"INFO","%s",""]]`, time.Now().Unix(), message)
+	s.Trigger("PUSH_END", p.Stream, fmt.Sprint(p.ID), p.Stream, p.OriginalURI, p.EffectiveURI, logs, s.pushStatus(p))
+	glog.Infof("Mock Mist push ended id=%d stream=%s target=%s", p.ID, p.Stream, p.OriginalURI)
+}
+
+func (s *Server) rtmpURL(streamKey string) string {
+	host, _, _ := net.SplitHostPort(s.addr)
+	return fmt.Sprintf("rtmp://%s/live/%s", host, streamKey)
+}
+
+func newChallenge() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// challengeResponse calculates response to the challenge as Mist does
+func challengeResponse(password, challenge string) string {
+	h1 := md5.Sum([]byte(password))
+	h2 := md5.Sum([]byte(hex.EncodeToString(h1[:]) + challenge))
+	return hex.EncodeToString(h2[:])
+}
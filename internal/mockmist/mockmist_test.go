@@ -0,0 +1,196 @@
+package mockmist
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/livepeer/stream-tester/apis/mist"
+)
+
+func startMist(t *testing.T, opts Options) (*Server, *mist.API) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	s := New(opts)
+	if err := s.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	host, port, _ := net.SplitHostPort(s.Addr())
+	p, _ := strconv.Atoi(port)
+	mapi := mist.NewMist(host, opts.Login, opts.Password, "", uint(p))
+	if err := mapi.Login(); err != nil {
+		t.Fatal(err)
+	}
+	return s, mapi
+}
+
+// command sends command to the API and returns authorization status and challenge
+func command(t *testing.T, s *Server, cmd string) (string, string) {
+	t.Helper()
+	resp, err := http.Get("http://" + s.Addr() + "/api2?command=" + url.QueryEscape(cmd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var res struct {
+		Authorize struct {
+			Status    string `json:"status"`
+			Challenge string `json:"challenge"`
+		} `json:"authorize"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	return res.Authorize.Status, res.Authorize.Challenge
+}
+
+func TestAuthorization(t *testing.T) {
+	s, _ := startMist(t, Options{Login: "admin", Password: "pass"})
+	status, challenge := command(t, s, "")
+	if status != "CHALL" || challenge == "" {
+		t.Fatalf("request without command got status=%s challenge=%s", status, challenge)
+	}
+	auth := `{"authorize":{"username":"admin","password":"%s"}}`
+	status, next := command(t, s, fmt.Sprintf(auth, challengeResponse("wrong", challenge)))
+	if status != "CHALL" || next == challenge {
+		t.Errorf("wrong password got status=%s challenge=%s", status, next)
+	}
+	if status, _ := command(t, s, fmt.Sprintf(auth, challengeResponse("pass", next))); status != "OK" {
+		t.Errorf("right password got status=%s", status)
+	}
+}
+
+func TestStreamCommands(t *testing.T) {
+	s, mapi := startMist(t, Options{Login: "admin", Password: "pass"})
+	profiles := mist.PresetsStr2Profiles("P240p30fps16x9")
+	if err := mapi.CreateStream("video", nil, profiles, "", "", "", "", false, false, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := mapi.CreateStream("other", nil, nil, "", "", "", "", false, false, true); err != nil {
+		t.Fatal(err)
+	}
+	streams, _, err := mapi.Streams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(streams) != 2 || streams["video"] == nil || len(streams["video"].Processes) == 0 {
+		t.Fatalf("got streams %v", streams)
+	}
+	if st := s.Stream("video"); st == nil || st.Name != "video" {
+		t.Errorf("stream is %v", st)
+	}
+	if err := mapi.DeleteStreams("other"); err != nil {
+		t.Fatal(err)
+	}
+	if streams, _, _ := mapi.Streams(); len(streams) != 1 || streams["other"] != nil {
+		t.Errorf("stream is not deleted: %v", streams)
+	}
+}
+
+// triggerLog trigger handler recording the triggers fired
+type triggerLog struct {
+	mu    sync.Mutex
+	fired map[string][]string
+}
+
+func (tl *triggerLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b, _ := ioutil.ReadAll(r.Body)
+	lines := strings.Split(string(b), "\n")
+	trigger := r.Header.Get("X-Trigger")
+	tl.mu.Lock()
+	tl.fired[trigger] = lines
+	tl.mu.Unlock()
+	if trigger == "PUSH_REWRITE" {
+		if lines[2] == "rejected" {
+			w.Write([]byte("false"))
+			return
+		}
+		w.Write([]byte("video+" + lines[2]))
+	}
+}
+
+func (tl *triggerLog) wait(t *testing.T, trigger string) []string {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		tl.mu.Lock()
+		lines := tl.fired[trigger]
+		tl.mu.Unlock()
+		if lines != nil {
+			return lines
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("trigger %s was not fired", trigger)
+	return nil
+}
+
+func TestTriggersAndStats(t *testing.T) {
+	tl := &triggerLog{fired: make(map[string][]string)}
+	hs := httptest.NewServer(tl)
+	defer hs.Close()
+	s, mapi := startMist(t, Options{Login: "admin", Password: "pass"})
+	triggers := mist.TriggersMap{}
+	for _, name := range []string{"PUSH_REWRITE", "LIVE_TRACK_LIST", "PUSH_OUT_START", "PUSH_END", "CONN_CLOSE"} {
+		triggers[name] = []mist.Trigger{{Handler: hs.URL, Sync: name == "PUSH_REWRITE"}}
+	}
+	if err := mapi.SetTriggers(triggers); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := mapi.GetTriggers(); err != nil || len(got) != len(triggers) {
+		t.Fatalf("got triggers %v err=%v", got, err)
+	}
+	profiles := mist.PresetsStr2Profiles("P240p30fps16x9,P144p30fps16x9")
+	if err := mapi.CreateStream("video", nil, profiles, "", "", "", "", false, false, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.StartStream("rejected"); err != ErrRejected {
+		t.Errorf("rejected stream started err=%v", err)
+	}
+	name, err := s.StartStream("key1")
+	if err != nil || name != "video+key1" {
+		t.Fatalf("stream started as %q err=%v", name, err)
+	}
+	// source audio and video plus track for every profile
+	var tracks map[string]interface{}
+	if lines := tl.wait(t, "LIVE_TRACK_LIST"); lines[0] != name || json.Unmarshal([]byte(lines[1]), &tracks) != nil || len(tracks) != 4 {
+		t.Errorf("LIVE_TRACK_LIST fired with %q", lines)
+	}
+	if err := mapi.StartPush(name, "rtmp://target/live/key"); err != nil {
+		t.Fatal(err)
+	}
+	tl.wait(t, "PUSH_OUT_START")
+	s.SetViewers(name, 3)
+	stats, err := mapi.GetStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := stats.StreamsStats[name]; st == nil || st.Clients != 3 {
+		t.Errorf("stream stats are %+v", stats.StreamsStats)
+	}
+	if len(stats.PushList) != 1 || stats.PushList[0].Stream != name || stats.PushList[0].OriginalURI != "rtmp://target/live/key" {
+		t.Errorf("push list is %+v", stats.PushList)
+	}
+	if err := mapi.NukeStream(name); err != nil {
+		t.Fatal(err)
+	}
+	if lines := tl.wait(t, "PUSH_END"); lines[1] != name {
+		t.Errorf("PUSH_END fired with %q", lines)
+	}
+	if lines := tl.wait(t, "CONN_CLOSE"); lines[0] != name || lines[2] != "RTMP" {
+		t.Errorf("CONN_CLOSE fired with %q", lines)
+	}
+	if active := s.ActiveStreams(); len(active) != 0 {
+		t.Errorf("streams still active after nuke: %v", active)
+	}
+}
@@ -0,0 +1,260 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"sort"
+	"time"
+
+	"github.com/livepeer/stream-tester/model"
+)
+
+const (
+	histogramBins = 30
+	// maxStreamSeries streams with the highest latency plotted on the per stream chart
+	maxStreamSeries = 10
+)
+
+// Sample value of the metric at the moment of the run
+type Sample struct {
+	At          time.Duration `json:"at"` // since start of the run
+	SuccessRate float64       `json:"success_rate"`
+}
+
+// SetStats sets stats of the streamer, including latency histograms, used for the HTML report
+func (s *Suite) SetStats(stats *model.Stats) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.stats = stats
+	s.mu.Unlock()
+}
+
+// TrackSuccessRate samples success rate (percent) every interval until ctx is done,
+// to show it over time in the HTML report
+func (s *Suite) TrackSuccessRate(ctx context.Context, interval time.Duration, successRate func() float64) {
+	if s == nil || s.htmlPath == "" {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			sample := Sample{At: time.Since(s.Started), SuccessRate: successRate()}
+			s.mu.Lock()
+			s.Timeline = append(s.Timeline, sample)
+			s.mu.Unlock()
+		}
+	}()
+}
+
+type (
+	htmlRow struct {
+		Name, Value string
+	}
+
+	htmlData struct {
+		*Suite
+		Summary     []htmlRow
+		Errors      []htmlRow
+		Renditions  []htmlRow
+		ConfigRows  []htmlRow
+		SuccessRate template.HTML
+		Concurrency template.HTML
+		Histograms  []htmlChart
+		PerStream   template.HTML
+		ErrorsChart template.HTML
+	}
+
+	htmlChart struct {
+		Title string
+		SVG   template.HTML
+	}
+)
+
+// HTML returns self-contained HTML report of the run
+func (s *Suite) HTML() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := &htmlData{Suite: s}
+	rec := s.Result
+	if rec != nil {
+		d.Summary = append(d.Summary, htmlRow{"Success rate", fmt.Sprintf("%.2f%%", rec.SuccessRate)})
+		for _, l := range []struct {
+			name string
+			lat  model.Latencies
+		}{{"Source latency", rec.SourceLatencies}, {"Transcode latency", rec.TranscodedLatencies}} {
+			if l.lat.P50 > 0 {
+				d.Summary = append(d.Summary, htmlRow{l.name, fmt.Sprintf("avg %s p50 %s p95 %s p99 %s", l.lat.Avg.Round(time.Millisecond),
+					l.lat.P50.Round(time.Millisecond), l.lat.P95.Round(time.Millisecond), l.lat.P99.Round(time.Millisecond))})
+			}
+		}
+		var labels []string
+		var values []float64
+		errs := rec.ErrorCounts()
+		for cat := range errs {
+			labels = append(labels, cat)
+		}
+		sort.Strings(labels)
+		for _, cat := range labels {
+			d.Errors = append(d.Errors, htmlRow{cat, fmt.Sprint(errs[cat])})
+			values = append(values, float64(errs[cat]))
+		}
+		d.ErrorsChart = barChart(labels, values)
+		if rec.VODStats != nil {
+			var res []string
+			for r := range rec.VODStats.SegmentsNum {
+				res = append(res, r)
+			}
+			sort.Strings(res)
+			for _, r := range res {
+				d.Renditions = append(d.Renditions, htmlRow{r, fmt.Sprintf("%d segments, %s", rec.VODStats.SegmentsNum[r],
+					rec.VODStats.SegmentsDur[r].Round(time.Millisecond))})
+			}
+		}
+		if sm := rec.StatsMany; sm != nil && len(sm.Concurrency) > 0 {
+			req, ach := series{Name: "requested"}, series{Name: "achieved"}
+			for _, cs := range sm.Concurrency {
+				req.Points = append(req.Points, point{cs.At.Seconds(), float64(cs.Requested)})
+				ach.Points = append(ach.Points, point{cs.At.Seconds(), float64(cs.Achieved)})
+			}
+			d.Concurrency = lineChart("seconds since start", "streams", []series{req, ach}, 0)
+		}
+	}
+	if len(s.Timeline) > 0 {
+		sr := series{Name: "success rate"}
+		for _, sample := range s.Timeline {
+			sr.Points = append(sr.Points, point{sample.At.Seconds(), sample.SuccessRate})
+		}
+		d.SuccessRate = lineChart("seconds since start", "success rate, %", []series{sr}, 100)
+	}
+	if st := s.stats; st != nil {
+		for _, h := range []struct {
+			title string
+			hist  *model.Histogram
+		}{{"Source latency", st.SourceLatencyHistogram}, {"Transcode latency", st.TranscodedLatencyHistogram}} {
+			if h.hist.Count() > 0 {
+				var values, counts []float64
+				h.hist.Buckets(func(value time.Duration, count int64) {
+					values = append(values, value.Seconds())
+					counts = append(counts, float64(count))
+				})
+				d.Histograms = append(d.Histograms, htmlChart{h.title, histogram("latency, seconds", values, counts, histogramBins)})
+			}
+		}
+		d.PerStream = perStreamChart(st)
+	}
+	for name, value := range s.Config {
+		d.ConfigRows = append(d.ConfigRows, htmlRow{name, value})
+	}
+	sort.Slice(d.ConfigRows, func(i, j int) bool { return d.ConfigRows[i].Name < d.ConfigRows[j].Name })
+	var buf bytes.Buffer
+	if err := htmlTemplate.Execute(&buf, d); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// perStreamChart plots latency of each segment for the streams (and renditions) with the highest latency
+func perStreamChart(st *model.Stats) template.HTML {
+	type streamLatencies struct {
+		name string
+		lat  []time.Duration
+		max  time.Duration
+	}
+	var sls []streamLatencies
+	for i, lat := range st.RawTranscodeLatenciesPerStream {
+		if len(lat) == 0 {
+			continue
+		}
+		sl := streamLatencies{name: fmt.Sprintf("stream %d", i), lat: lat}
+		if i < len(st.RawTranscodeLatenciesNames) {
+			sl.name = st.RawTranscodeLatenciesNames[i]
+		}
+		for _, l := range lat {
+			if l > sl.max {
+				sl.max = l
+			}
+		}
+		sls = append(sls, sl)
+	}
+	sort.Slice(sls, func(i, j int) bool { return sls[i].max > sls[j].max })
+	if len(sls) > maxStreamSeries {
+		sls = sls[:maxStreamSeries]
+	}
+	var ss []series
+	for _, sl := range sls {
+		s := series{Name: sl.name}
+		for i, l := range sl.lat {
+			s.Points = append(s.Points, point{float64(i), l.Seconds()})
+		}
+		ss = append(ss, s)
+	}
+	return lineChart("segment number", "latency, seconds", ss, 0)
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"round": func(d time.Duration) time.Duration { return d.Round(time.Millisecond) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Name}} report {{.Started.Format "2006-01-02 15:04:05"}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+td, th { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+th { background: #f4f4f4; }
+.pass { color: #2ca02c; font-weight: bold; }
+.fail { color: #d62728; font-weight: bold; }
+.skip { color: #7f7f7f; }
+h2 { margin-top: 1.5em; }
+</style>
+</head>
+<body>
+<h1>{{.Name}} {{if .Passed}}<span class="pass">PASSED</span>{{else}}<span class="fail">FAILED</span>{{end}}</h1>
+<table>
+<tr><th>Started</th><td>{{.Started.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+<tr><th>Duration</th><td>{{round .Duration}}</td></tr>
+<tr><th>Exit code</th><td>{{.ExitCode}}</td></tr>
+{{range .Summary}}<tr><th>{{.Name}}</th><td>{{.Value}}</td></tr>
+{{end}}</table>
+{{if .Cases}}<h2>Checks</h2>
+<table>
+<tr><th>Group</th><th>Check</th><th>Duration</th><th>Result</th></tr>
+{{range .Cases}}<tr><td>{{.Class}}</td><td>{{.Name}}</td><td>{{round .Duration}}</td>
+<td>{{if .Failure}}<span class="fail">FAIL</span> {{.Failure}}{{else if .Skipped}}<span class="skip">SKIP {{.Skipped}}</span>{{else}}<span class="pass">PASS</span>{{end}}</td></tr>
+{{end}}</table>{{end}}
+{{if .SuccessRate}}<h2>Success rate over time</h2>
+{{.SuccessRate}}{{end}}
+{{if .Concurrency}}<h2>Concurrency</h2>
+{{.Concurrency}}{{end}}
+{{range .Histograms}}<h2>{{.Title}} histogram</h2>
+{{.SVG}}
+{{end}}
+{{if .PerStream}}<h2>Transcode latency per stream and rendition</h2>
+{{.PerStream}}{{end}}
+{{if .Renditions}}<h2>Renditions</h2>
+<table>
+{{range .Renditions}}<tr><th>{{.Name}}</th><td>{{.Value}}</td></tr>
+{{end}}</table>{{end}}
+{{if .Errors}}<h2>Errors</h2>
+{{.ErrorsChart}}
+<table>
+{{range .Errors}}<tr><th>{{.Name}}</th><td>{{.Value}}</td></tr>
+{{end}}</table>{{end}}
+{{if .ConfigRows}}<h2>Configuration</h2>
+<table>
+{{range .ConfigRows}}<tr><th>{{.Name}}</th><td>{{.Value}}</td></tr>
+{{end}}</table>{{end}}
+</body>
+</html>
+`))
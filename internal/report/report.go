@@ -0,0 +1,310 @@
+// Package report writes results of the test run as JUnit XML (to be shown by CI
+// systems), as machine-readable JSON summary and as self-contained HTML report
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/stream-tester/internal/history"
+	"github.com/livepeer/stream-tester/model"
+)
+
+type (
+	// Flags report flags of the command line tool
+	Flags struct {
+		JUnit string
+		JSON  string
+		HTML  string
+	}
+
+	// Case result of one check
+	Case struct {
+		// Class group of the checks (tester name, usually)
+		Class    string        `json:"class"`
+		Name     string        `json:"name"`
+		Duration time.Duration `json:"duration"`
+		Failure  string        `json:"failure,omitempty"`
+		Skipped  string        `json:"skipped,omitempty"`
+	}
+
+	// Suite collects results of the checks of one run. All the methods can be
+	// called on nil suite (which means report is not needed)
+	Suite struct {
+		Name     string            `json:"name"`
+		Started  time.Time         `json:"started"`
+		Duration time.Duration     `json:"duration"`
+		Tests    int               `json:"tests"`
+		Failures int               `json:"failures"`
+		Skipped  int               `json:"skipped"`
+		Passed   bool              `json:"passed"`
+		ExitCode int               `json:"exit_code"`
+		Config   map[string]string `json:"config,omitempty"`
+		Cases    []*Case           `json:"cases"`
+		Result   *history.Record   `json:"result,omitempty"`
+		Timeline []Sample          `json:"timeline,omitempty"`
+
+		junitPath string
+		jsonPath  string
+		htmlPath  string
+		stats     *model.Stats
+		mu        sync.Mutex
+	}
+
+	// Steps reports sequential steps of the test as separate checks
+	Steps struct {
+		suite   *Suite
+		class   string
+		name    string
+		started time.Time
+	}
+)
+
+// AddFlags adds `-report-*` flags to the flag set
+func AddFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{}
+	fs.StringVar(&f.JUnit, "report-junit", "", "Write results of the checks to this file as JUnit XML")
+	fs.StringVar(&f.JSON, "report-json", "", "Write summary of the run to this file as JSON")
+	fs.StringVar(&f.HTML, "report-html", "", "Write HTML report with charts to this file")
+	return f
+}
+
+// NewSuite creates suite for the run. Returns nil if no reports were requested
+func (f *Flags) NewSuite(name string) *Suite {
+	if f.JUnit == "" && f.JSON == "" && f.HTML == "" {
+		return nil
+	}
+	return &Suite{Name: name, Started: time.Now(), junitPath: f.JUnit, jsonPath: f.JSON, htmlPath: f.HTML}
+}
+
+// Add adds result of the check. Check cancelled by the context is reported as skipped
+func (s *Suite) Add(class, name string, took time.Duration, err error) {
+	if s == nil {
+		return
+	}
+	c := &Case{Class: class, Name: name, Duration: took}
+	if errors.Is(err, context.Canceled) {
+		c.Skipped = "cancelled"
+	} else if err != nil {
+		c.Failure = err.Error()
+	}
+	s.mu.Lock()
+	s.Cases = append(s.Cases, c)
+	s.mu.Unlock()
+}
+
+// Skip adds skipped check
+func (s *Suite) Skip(class, name, reason string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.Cases = append(s.Cases, &Case{Class: class, Name: name, Skipped: reason})
+	s.mu.Unlock()
+}
+
+// Run runs check and adds its result
+func (s *Suite) Run(class, name string, check func() error) error {
+	started := time.Now()
+	err := check()
+	s.Add(class, name, time.Since(started), err)
+	return err
+}
+
+// Steps starts reporting steps of the test
+func (s *Suite) Steps(class, first string) *Steps {
+	return &Steps{suite: s, class: class, name: first, started: time.Now()}
+}
+
+// Next marks current step as passed and starts next one
+func (st *Steps) Next(name string) {
+	st.suite.Add(st.class, st.name, time.Since(st.started), nil)
+	st.name = name
+	st.started = time.Now()
+}
+
+// End finishes current step. Non-zero exit code without error is reported as failure too
+func (st *Steps) End(exitCode int, err error) {
+	if err == nil && exitCode != 0 {
+		err = fmt.Errorf("exit code %d", exitCode)
+	}
+	st.suite.Add(st.class, st.name, time.Since(st.started), err)
+}
+
+// Finish sets outcome of the run. rec is result of the run, can be nil
+func (s *Suite) Finish(exitCode int, rec *history.Record) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Duration = time.Since(s.Started)
+	s.ExitCode = exitCode
+	s.Result = rec
+	if rec != nil {
+		s.Config = rec.Config
+	}
+	s.Tests, s.Failures, s.Skipped = len(s.Cases), 0, 0
+	for _, c := range s.Cases {
+		if c.Failure != "" {
+			s.Failures++
+		} else if c.Skipped != "" {
+			s.Skipped++
+		}
+	}
+	s.Passed = exitCode == 0 && s.Failures == 0
+}
+
+// Save writes requested reports, logging errors
+func (s *Suite) Save() {
+	if s == nil {
+		return
+	}
+	if s.junitPath != "" {
+		if err := s.writeFile(s.junitPath, s.JUnit); err != nil {
+			glog.Errorf("Error writing JUnit report to %s: %v", s.junitPath, err)
+		}
+	}
+	if s.jsonPath != "" {
+		if err := s.writeFile(s.jsonPath, s.JSON); err != nil {
+			glog.Errorf("Error writing JSON report to %s: %v", s.jsonPath, err)
+		}
+	}
+	if s.htmlPath != "" {
+		if err := s.writeFile(s.htmlPath, s.HTML); err != nil {
+			glog.Errorf("Error writing HTML report to %s: %v", s.htmlPath, err)
+		}
+	}
+}
+
+func (s *Suite) writeFile(path string, marshal func() ([]byte, error)) error {
+	data, err := marshal()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// JSON returns summary of the run as JSON
+func (s *Suite) JSON() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return json.MarshalIndent(s, "", "  ")
+}
+
+type (
+	junitTestSuites struct {
+		XMLName  xml.Name          `xml:"testsuites"`
+		Name     string            `xml:"name,attr"`
+		Tests    int               `xml:"tests,attr"`
+		Failures int               `xml:"failures,attr"`
+		Skipped  int               `xml:"skipped,attr"`
+		Time     string            `xml:"time,attr"`
+		Suites   []*junitTestSuite `xml:"testsuite"`
+	}
+
+	junitTestSuite struct {
+		Name       string           `xml:"name,attr"`
+		Tests      int              `xml:"tests,attr"`
+		Failures   int              `xml:"failures,attr"`
+		Skipped    int              `xml:"skipped,attr"`
+		Time       string           `xml:"time,attr"`
+		Timestamp  string           `xml:"timestamp,attr"`
+		Hostname   string           `xml:"hostname,attr,omitempty"`
+		Properties *junitProperties `xml:"properties,omitempty"`
+		Cases      []*junitTestCase `xml:"testcase"`
+	}
+
+	junitProperties struct {
+		Properties []*junitProperty `xml:"property"`
+	}
+
+	junitProperty struct {
+		Name  string `xml:"name,attr"`
+		Value string `xml:"value,attr"`
+	}
+
+	junitTestCase struct {
+		Classname string        `xml:"classname,attr"`
+		Name      string        `xml:"name,attr"`
+		Time      string        `xml:"time,attr"`
+		Failure   *junitMessage `xml:"failure,omitempty"`
+		Skipped   *junitMessage `xml:"skipped,omitempty"`
+	}
+
+	junitMessage struct {
+		Message string `xml:"message,attr"`
+		Text    string `xml:",chardata"`
+	}
+)
+
+func junitTime(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// JUnit returns checks as JUnit XML, one test suite per class
+func (s *Suite) JUnit() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hostname, _ := os.Hostname()
+	var props *junitProperties
+	if len(s.Config) > 0 {
+		props = &junitProperties{}
+		for name, value := range s.Config {
+			props.Properties = append(props.Properties, &junitProperty{Name: name, Value: value})
+		}
+		sort.Slice(props.Properties, func(i, j int) bool { return props.Properties[i].Name < props.Properties[j].Name })
+	}
+	res := &junitTestSuites{Name: s.Name, Time: junitTime(s.Duration)}
+	suites := make(map[string]*junitTestSuite)
+	var total time.Duration
+	for _, c := range s.Cases {
+		ts := suites[c.Class]
+		if ts == nil {
+			ts = &junitTestSuite{Name: c.Class, Timestamp: s.Started.UTC().Format("2006-01-02T15:04:05"), Hostname: hostname, Properties: props}
+			suites[c.Class] = ts
+			res.Suites = append(res.Suites, ts)
+		}
+		tc := &junitTestCase{Classname: s.Name + "." + c.Class, Name: c.Name, Time: junitTime(c.Duration)}
+		if c.Failure != "" {
+			tc.Failure = &junitMessage{Message: c.Failure, Text: c.Failure}
+			ts.Failures++
+			res.Failures++
+		} else if c.Skipped != "" {
+			tc.Skipped = &junitMessage{Message: c.Skipped}
+			ts.Skipped++
+			res.Skipped++
+		}
+		ts.Tests++
+		res.Tests++
+		ts.Cases = append(ts.Cases, tc)
+		total += c.Duration
+	}
+	for _, ts := range res.Suites {
+		var d time.Duration
+		for _, c := range s.Cases {
+			if c.Class == ts.Name {
+				d += c.Duration
+			}
+		}
+		ts.Time = junitTime(d)
+	}
+	if s.Duration == 0 {
+		res.Time = junitTime(total)
+	}
+	b, err := xml.MarshalIndent(res, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(b, '\n')...), nil
+}
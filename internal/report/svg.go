@@ -0,0 +1,182 @@
+package report
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"math"
+	"strings"
+)
+
+const (
+	chartWidth   = 760
+	chartHeight  = 260
+	chartPadLeft = 60
+	chartPadBot  = 40
+	chartPadTop  = 20
+	chartPadRght = 20
+	chartTicks   = 5
+)
+
+var chartColors = []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b", "#e377c2", "#7f7f7f", "#bcbd22", "#17becf"}
+
+type (
+	point struct {
+		X, Y float64
+	}
+
+	series struct {
+		Name   string
+		Points []point
+	}
+
+	// chart maps data coordinates into SVG coordinates
+	chart struct {
+		minX, maxX, maxY float64
+		b                strings.Builder
+	}
+)
+
+func newChart(minX, maxX, maxY float64) *chart {
+	if maxX <= minX {
+		maxX = minX + 1
+	}
+	if maxY <= 0 {
+		maxY = 1
+	}
+	c := &chart{minX: minX, maxX: maxX, maxY: maxY}
+	fmt.Fprintf(&c.b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif" font-size="11">`,
+		chartWidth, chartHeight, chartWidth, chartHeight)
+	return c
+}
+
+func (c *chart) x(v float64) float64 {
+	return chartPadLeft + (v-c.minX)/(c.maxX-c.minX)*(chartWidth-chartPadLeft-chartPadRght)
+}
+
+func (c *chart) y(v float64) float64 {
+	return chartHeight - chartPadBot - v/c.maxY*(chartHeight-chartPadBot-chartPadTop)
+}
+
+func (c *chart) axes(xLabel, yLabel string) {
+	x0, y0 := c.x(c.minX), c.y(0)
+	fmt.Fprintf(&c.b, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#333"/>`, x0, y0, c.x(c.maxX), y0)
+	fmt.Fprintf(&c.b, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#333"/>`, x0, y0, x0, c.y(c.maxY))
+	for i := 0; i <= chartTicks; i++ {
+		yv := c.maxY * float64(i) / chartTicks
+		fmt.Fprintf(&c.b, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#eee"/>`, x0, c.y(yv), c.x(c.maxX), c.y(yv))
+		fmt.Fprintf(&c.b, `<text x="%.1f" y="%.1f" text-anchor="end">%s</text>`, x0-4, c.y(yv)+4, formatTick(yv))
+		xv := c.minX + (c.maxX-c.minX)*float64(i)/chartTicks
+		fmt.Fprintf(&c.b, `<text x="%.1f" y="%.1f" text-anchor="middle">%s</text>`, c.x(xv), y0+14, formatTick(xv))
+	}
+	fmt.Fprintf(&c.b, `<text x="%.1f" y="%d" text-anchor="middle">%s</text>`, c.x((c.minX+c.maxX)/2), chartHeight-6, html.EscapeString(xLabel))
+	fmt.Fprintf(&c.b, `<text x="12" y="%.1f" text-anchor="middle" transform="rotate(-90 12 %.1f)">%s</text>`, c.y(c.maxY/2), c.y(c.maxY/2),
+		html.EscapeString(yLabel))
+}
+
+func (c *chart) svg() template.HTML {
+	c.b.WriteString("</svg>")
+	return template.HTML(c.b.String())
+}
+
+func formatTick(v float64) string {
+	if v == math.Trunc(v) {
+		return fmt.Sprintf("%.0f", v)
+	}
+	return fmt.Sprintf("%.2f", v)
+}
+
+// lineChart plots series as lines
+func lineChart(xLabel, yLabel string, ss []series, maxY float64) template.HTML {
+	minX, maxX := math.Inf(1), math.Inf(-1)
+	for _, s := range ss {
+		for _, p := range s.Points {
+			minX, maxX = math.Min(minX, p.X), math.Max(maxX, p.X)
+			maxY = math.Max(maxY, p.Y)
+		}
+	}
+	if math.IsInf(minX, 0) {
+		return ""
+	}
+	c := newChart(minX, maxX, maxY)
+	c.axes(xLabel, yLabel)
+	for i, s := range ss {
+		color := chartColors[i%len(chartColors)]
+		pts := make([]string, len(s.Points))
+		for j, p := range s.Points {
+			pts[j] = fmt.Sprintf("%.1f,%.1f", c.x(p.X), c.y(p.Y))
+		}
+		fmt.Fprintf(&c.b, `<polyline fill="none" stroke="%s" stroke-width="1.5" points="%s"><title>%s</title></polyline>`, color,
+			strings.Join(pts, " "), html.EscapeString(s.Name))
+		if len(ss) > 1 && len(ss) <= len(chartColors) {
+			fmt.Fprintf(&c.b, `<text x="%.1f" y="%d" fill="%s">%s</text>`, c.x(minX)+8, chartPadTop+12*i, color, html.EscapeString(s.Name))
+		}
+	}
+	return c.svg()
+}
+
+// histogram plots distribution of the values
+func histogram(xLabel string, values, weights []float64, bins int) template.HTML {
+	if len(values) == 0 {
+		return ""
+	}
+	min, max := values[0], values[0]
+	for _, v := range values {
+		min, max = math.Min(min, v), math.Max(max, v)
+	}
+	if max == min {
+		max = min + 1
+	}
+	counts := make([]float64, bins)
+	width := (max - min) / float64(bins)
+	var maxCount float64
+	for j, v := range values {
+		i := int((v - min) / width)
+		if i >= bins {
+			i = bins - 1
+		}
+		if weights != nil {
+			counts[i] += weights[j]
+		} else {
+			counts[i]++
+		}
+		maxCount = math.Max(maxCount, counts[i])
+	}
+	c := newChart(min, max, maxCount)
+	c.axes(xLabel, "segments")
+	for i, n := range counts {
+		x1, x2 := c.x(min+float64(i)*width), c.x(min+float64(i+1)*width)
+		fmt.Fprintf(&c.b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"><title>%s-%s: %.0f</title></rect>`,
+			x1+1, c.y(n), math.Max(x2-x1-2, 1), c.y(0)-c.y(n), chartColors[0], formatTick(min+float64(i)*width),
+			formatTick(min+float64(i+1)*width), n)
+	}
+	return c.svg()
+}
+
+// barChart plots horizontal bars, one per label
+func barChart(labels []string, values []float64) template.HTML {
+	if len(labels) == 0 {
+		return ""
+	}
+	const rowHeight, labelWidth, barWidth = 20, 220, 480
+	var max float64
+	for _, v := range values {
+		max = math.Max(max, v)
+	}
+	if max == 0 {
+		max = 1
+	}
+	var b strings.Builder
+	height := rowHeight*len(labels) + 10
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="sans-serif" font-size="11">`,
+		labelWidth+barWidth+80, height)
+	for i, label := range labels {
+		y := i*rowHeight + 5
+		w := values[i] / max * barWidth
+		fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="end">%s</text>`, labelWidth-6, y+13, html.EscapeString(label))
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%.1f" height="%d" fill="%s"/>`, labelWidth, y+2, w, rowHeight-6, chartColors[3])
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d">%s</text>`, float64(labelWidth)+w+4, y+13, formatTick(values[i]))
+	}
+	b.WriteString("</svg>")
+	return template.HTML(b.String())
+}
@@ -0,0 +1,443 @@
+package scenario
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/stream-tester/internal/testers"
+	"github.com/livepeer/stream-tester/model"
+)
+
+// statsSampleInterval how often stats of running phase are sampled
+const statsSampleInterval = 5 * time.Second
+
+type (
+	// StarterFactory returns stream starter for the ingest protocol
+	StarterFactory func(protocol string) (model.StreamStarter, error)
+
+	// Runner runs scenario phase by phase
+	Runner struct {
+		ctx      context.Context
+		scenario *Scenario
+		starters StarterFactory
+
+		mu      sync.Mutex
+		lt      model.ILoadTester
+		streams []*phaseStream
+	}
+
+	// phaseStream stream started by the scenario
+	phaseStream struct {
+		stream  model.OneTestStream
+		started time.Time
+		ended   time.Time
+	}
+
+	// streamSnapshot cumulative stats of one stream at the phase boundary
+	streamSnapshot struct {
+		successRate float64
+		// active how long stream was streaming
+		active     time.Duration
+		source     *model.Histogram
+		transcoded *model.Histogram
+	}
+
+	// snapshot stats of all the streams at the phase boundary
+	snapshot map[*phaseStream]streamSnapshot
+
+	// Result combined result of all the phases
+	Result struct {
+		Name     string         `json:"name"`
+		Started  time.Time      `json:"started"`
+		Duration time.Duration  `json:"duration"`
+		Passed   bool           `json:"passed"`
+		Phases   []*PhaseResult `json:"phases"`
+	}
+
+	// PhaseResult result of one phase
+	PhaseResult struct {
+		Name              string        `json:"name"`
+		Type              PhaseType     `json:"type"`
+		Protocol          string        `json:"protocol"`
+		RequestedStreams  int           `json:"requested_streams"`
+		PeakActiveStreams int           `json:"peak_active_streams"`
+		Started           time.Time     `json:"started"`
+		Duration          time.Duration `json:"duration"`
+		// StreamTime total time streams were active during the phase,
+		// weight of the phase's success rate in the scenario's
+		StreamTime time.Duration   `json:"stream_time"`
+		Stats      model.StatsMany `json:"stats"`
+		Passed     bool            `json:"passed"`
+		Failures   []string        `json:"failures,omitempty"`
+		Error      string          `json:"error,omitempty"`
+	}
+)
+
+// NewRunner creates new scenario runner
+func NewRunner(ctx context.Context, sc *Scenario, starters StarterFactory) *Runner {
+	return &Runner{
+		ctx:      ctx,
+		scenario: sc,
+		starters: starters,
+	}
+}
+
+// Run runs all the phases one after another, using one load tester, so
+// streams started in one phase continue into the next one. Blocks until
+// all phases are finished or context is cancelled.
+func (r *Runner) Run() *Result {
+	phases := r.scenario.Phases
+	res := &Result{
+		Name:    r.scenario.Name,
+		Started: time.Now(),
+	}
+	defer func() {
+		res.Duration = time.Since(res.Started)
+	}()
+	starters := make(map[string]model.StreamStarter)
+	profiles := make([]model.LoadProfile, 0, len(phases))
+	ends := make([]time.Duration, 0, len(phases))
+	var prevStreams int
+	var end time.Duration
+	for i := range phases {
+		ph := &phases[i]
+		pres := &PhaseResult{
+			Name:             ph.Name,
+			Type:             ph.Type,
+			Protocol:         ph.Protocol,
+			RequestedStreams: ph.Streams,
+		}
+		res.Phases = append(res.Phases, pres)
+		if ph.Source == "" {
+			pres.Error = "no source file to stream"
+			return res
+		}
+		if _, has := starters[ph.Protocol]; !has {
+			starter, err := r.starters(ph.Protocol)
+			if err != nil {
+				pres.Error = err.Error()
+				return res
+			}
+			starters[ph.Protocol] = starter
+		}
+		profiles = append(profiles, phaseProfile(ph, prevStreams))
+		prevStreams = ph.Streams
+		end += time.Duration(ph.Duration)
+		ends = append(ends, end)
+	}
+	phaseAt := func(elapsed time.Duration) int {
+		for i, end := range ends {
+			if elapsed < end {
+				return i
+			}
+		}
+		return len(ends) - 1
+	}
+
+	glog.Infof("Starting scenario %q with %d phases, expected duration %s", r.scenario.Name, len(phases), r.scenario.TotalDuration())
+	started := time.Now()
+	// load tester passes the same source to all the streams, so
+	// choose source and ingest protocol of the current phase here
+	starter := func(ctx context.Context, _ string, _, _ time.Duration) (model.OneTestStream, error) {
+		ph := &phases[phaseAt(time.Since(started))]
+		if ph.Type == PhaseHold && ph.DelayBetweenStreams > 0 {
+			// ramp phases are paced by their profile, extra delay
+			// would make them lag behind the ramp
+			time.Sleep(time.Duration(ph.DelayBetweenStreams))
+		}
+		stream, err := starters[ph.Protocol](ctx, ph.Source, time.Duration(ph.WaitForTarget), time.Duration(ph.StreamDuration))
+		if err == nil {
+			r.track(stream)
+		}
+		return stream, err
+	}
+	lt := testers.NewLoadTester(r.ctx, starter, 0)
+	r.mu.Lock()
+	r.lt = lt
+	r.mu.Unlock()
+
+	var mu sync.Mutex
+	sample := func(phase int) {
+		stats, err := lt.Stats()
+		if err != nil || stats.Finished {
+			return
+		}
+		mu.Lock()
+		pres := res.Phases[phase]
+		pres.Stats = stats
+		if stats.ActiveStreams > pres.PeakActiveStreams {
+			pres.PeakActiveStreams = stats.ActiveStreams
+		}
+		mu.Unlock()
+	}
+	stopped := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		ticker := time.NewTicker(statsSampleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopped:
+				return
+			case <-ticker.C:
+				sample(phaseAt(time.Since(started)))
+			}
+		}
+	}()
+	// stats of the streams are cumulative, so take snapshot at the end of
+	// every phase to judge phase only by what happened during it
+	boundaries := make([]snapshot, len(ends))
+	boundary := func(phase int, snap snapshot) {
+		mu.Lock()
+		if boundaries[phase] == nil {
+			boundaries[phase] = snap
+		}
+		mu.Unlock()
+	}
+	// take last sample of number of streams of every phase just before it
+	// ends (but not before it starts, if phase is short), and snapshot of
+	// the streams right at the end
+	timers := make([]*time.Timer, 0, 2*len(ends))
+	var phaseStart time.Duration
+	for i, end := range ends {
+		phase := i
+		sampleAt := end - time.Second
+		if sampleAt < phaseStart {
+			sampleAt = phaseStart
+		}
+		timers = append(timers,
+			time.AfterFunc(sampleAt, func() { sample(phase) }),
+			time.AfterFunc(end, func() { boundary(phase, r.snapshot()) }))
+		phaseStart = end
+	}
+	err := lt.StartProfile("", 0, 0, testers.NewSequenceProfile(profiles...))
+	for _, timer := range timers {
+		timer.Stop()
+	}
+	close(stopped)
+	<-sampled
+	stats, _ := lt.Stats()
+	// phases cut short by error or cancellation end now
+	last := r.snapshot()
+	for i := range boundaries {
+		boundary(i, last)
+	}
+	lt.Cancel()
+
+	res.Passed = err == nil && r.ctx.Err() == nil
+	phaseStart = 0
+	var prev snapshot
+	for i, pres := range res.Phases {
+		pres.Started = started.Add(phaseStart)
+		pres.Duration = ends[i] - phaseStart
+		pres.setStats(prev, boundaries[i])
+		prev = boundaries[i]
+		pres.Stats.Finished = true
+		pres.Stats.Concurrency = nil
+		for _, cs := range stats.Concurrency {
+			if cs.At >= phaseStart && cs.At < ends[i] {
+				pres.Stats.Concurrency = append(pres.Stats.Concurrency, cs)
+			}
+		}
+		phaseStart = ends[i]
+		if err != nil {
+			pres.Error = err.Error()
+			err = nil
+		} else {
+			pres.Failures = phases[i].Pass.check(pres)
+			pres.Passed = pres.Error == "" && len(pres.Failures) == 0 && r.ctx.Err() == nil
+		}
+		if !pres.Passed {
+			res.Passed = false
+		}
+		glog.Infof("Phase %q finished: %s", pres.Name, pres.String())
+	}
+	return res
+}
+
+// track remembers stream started by the load tester and the time it ended
+func (r *Runner) track(stream model.OneTestStream) {
+	ps := &phaseStream{stream: stream, started: time.Now()}
+	r.mu.Lock()
+	r.streams = append(r.streams, ps)
+	r.mu.Unlock()
+	go func() {
+		<-stream.Done()
+		r.mu.Lock()
+		ps.ended = time.Now()
+		r.mu.Unlock()
+	}()
+}
+
+// snapshot returns cumulative stats of all the streams started so far
+func (r *Runner) snapshot() snapshot {
+	now := time.Now()
+	r.mu.Lock()
+	streams := make([]*phaseStream, len(r.streams))
+	ended := make([]time.Time, len(r.streams))
+	for i, ps := range r.streams {
+		streams[i] = ps
+		ended[i] = ps.ended
+	}
+	r.mu.Unlock()
+	snap := make(snapshot, len(streams))
+	for i, ps := range streams {
+		stats, err := ps.stream.Stats()
+		if err != nil {
+			continue
+		}
+		end := now
+		if !ended[i].IsZero() {
+			end = ended[i]
+		}
+		snap[ps] = streamSnapshot{
+			successRate: stats.SuccessRate,
+			active:      end.Sub(ps.started),
+			source:      stats.SourceLatencyHistogram,
+			transcoded:  stats.TranscodedLatencyHistogram,
+		}
+	}
+	return snap
+}
+
+// setStats replaces cumulative success rate and latencies of the phase with
+// the ones of the segments streamed between two snapshots. Success rate of
+// the stream during the phase is derived from its cumulative success rate,
+// weighted by the time stream was active
+func (pres *PhaseResult) setStats(from, to snapshot) {
+	var good, active float64
+	source, transcoded := &model.Histogram{}, &model.Histogram{}
+	for ps, cur := range to {
+		prev := from[ps]
+		took := (cur.active - prev.active).Seconds()
+		if took <= 0 {
+			// stream ended before the phase
+			continue
+		}
+		good += cur.successRate*cur.active.Seconds() - prev.successRate*prev.active.Seconds()
+		active += took
+		source.Merge(cur.source.Diff(prev.source))
+		transcoded.Merge(cur.transcoded.Diff(prev.transcoded))
+	}
+	pres.StreamTime = time.Duration(active * float64(time.Second))
+	pres.Stats.SuccessRate = 0
+	if active > 0 {
+		pres.Stats.SuccessRate = math.Max(0, math.Min(1, good/active))
+	}
+	pres.Stats.SourceLatencyHistogram, pres.Stats.TranscodedLatencyHistogram = nil, nil
+	pres.Stats.SourceLatencies, pres.Stats.TranscodedLatencies = model.Latencies{}, model.Latencies{}
+	if source.Count() > 0 {
+		pres.Stats.SourceLatencyHistogram = source
+		pres.Stats.SourceLatencies = source.Latencies()
+	}
+	if transcoded.Count() > 0 {
+		pres.Stats.TranscodedLatencyHistogram = transcoded
+		pres.Stats.TranscodedLatencies = transcoded.Latencies()
+	}
+}
+
+// Stats returns stats of the whole scenario run: success rate of the phases
+// weighted by their stream time, latencies of all the phases' segments and
+// peak number of active streams
+func (res *Result) Stats() *model.StatsMany {
+	st := &model.StatsMany{Finished: true}
+	source, transcoded := &model.Histogram{}, &model.Histogram{}
+	var good, active float64
+	for _, pres := range res.Phases {
+		good += pres.Stats.SuccessRate * pres.StreamTime.Seconds()
+		active += pres.StreamTime.Seconds()
+		source.Merge(pres.Stats.SourceLatencyHistogram)
+		transcoded.Merge(pres.Stats.TranscodedLatencyHistogram)
+		if pres.PeakActiveStreams > st.ActiveStreams {
+			st.ActiveStreams = pres.PeakActiveStreams
+		}
+		st.Concurrency = append(st.Concurrency, pres.Stats.Concurrency...)
+	}
+	if active > 0 {
+		st.SuccessRate = good / active
+	}
+	if source.Count() > 0 {
+		st.SourceLatencyHistogram = source
+		st.SourceLatencies = source.Latencies()
+	}
+	if transcoded.Count() > 0 {
+		st.TranscodedLatencyHistogram = transcoded
+		st.TranscodedLatencies = transcoded.Latencies()
+	}
+	return st
+}
+
+// Stats returns combined stats of the scenario run so far
+func (r *Runner) Stats() (model.StatsMany, error) {
+	r.mu.Lock()
+	lt := r.lt
+	r.mu.Unlock()
+	if lt == nil {
+		return model.StatsMany{}, nil
+	}
+	return lt.Stats()
+}
+
+// phaseProfile returns load profile which gives phase its shape
+func phaseProfile(ph *Phase, prevStreams int) model.LoadProfile {
+	duration := time.Duration(ph.Duration)
+	switch ph.Type {
+	case PhaseRampUp, PhaseRampDown:
+		return testers.NewRampProfile(prevStreams, ph.Streams, duration, duration)
+	default:
+		// hold and spike phases have the same shape: number of streams
+		// changes at once. They differ in how fast streams are started:
+		// hold respects DelayBetweenStreams, spike starts them all
+		// without delay to hit the service with burst of new streams
+		return testers.NewConstantProfile(ph.Streams, duration)
+	}
+}
+
+func (pc *PassCriteria) check(pres *PhaseResult) []string {
+	var failures []string
+	if pc.MinSuccessRate > 0 && pres.Stats.SuccessRate < pc.MinSuccessRate {
+		failures = append(failures, fmt.Sprintf("success rate %.4f is lower than %.4f", pres.Stats.SuccessRate, pc.MinSuccessRate))
+	}
+	if pc.MinActiveStreams > 0 && pres.PeakActiveStreams < pc.MinActiveStreams {
+		failures = append(failures, fmt.Sprintf("reached %d concurrent streams instead of %d", pres.PeakActiveStreams, pc.MinActiveStreams))
+	}
+	return failures
+}
+
+func (pres *PhaseResult) String() string {
+	status := "PASSED"
+	if !pres.Passed {
+		status = "FAILED"
+	}
+	r := fmt.Sprintf("%s type=%s protocol=%s streams=%d/%d success rate %.4f took %s",
+		status, pres.Type, pres.Protocol, pres.PeakActiveStreams, pres.RequestedStreams, pres.Stats.SuccessRate, pres.Duration.Round(time.Second))
+	if pres.Error != "" {
+		r += " error: " + pres.Error
+	}
+	if len(pres.Failures) > 0 {
+		r += " failures: " + strings.Join(pres.Failures, "; ")
+	}
+	return r
+}
+
+// FormatForConsole formats result to be shown in console
+func (res *Result) FormatForConsole() string {
+	status := "PASSED"
+	if !res.Passed {
+		status = "FAILED"
+	}
+	lines := []string{fmt.Sprintf("Scenario %q %s after %s", res.Name, status, res.Duration.Round(time.Second))}
+	for i, pres := range res.Phases {
+		lines = append(lines, fmt.Sprintf("  %2d. %-20s %s", i+1, pres.Name, pres.String()))
+		for _, cs := range pres.Stats.Concurrency {
+			lines = append(lines, fmt.Sprintf("      %10s: %4d/%d streams", cs.At.Round(time.Second), cs.Achieved, cs.Requested))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
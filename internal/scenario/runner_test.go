@@ -0,0 +1,151 @@
+package scenario
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/livepeer/stream-tester/model"
+)
+
+// fakeStream stream that delivers all the segments during first goodFor and
+// half of them after that
+type fakeStream struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	started time.Time
+	goodFor time.Duration
+}
+
+func (fs *fakeStream) Done() <-chan struct{} {
+	return fs.ctx.Done()
+}
+
+func (fs *fakeStream) Cancel() {
+	fs.cancel()
+}
+
+func (fs *fakeStream) Finished() bool {
+	return fs.ctx.Err() != nil
+}
+
+func (fs *fakeStream) Stats() (model.Stats1, error) {
+	elapsed := time.Since(fs.started)
+	good := elapsed
+	if elapsed > fs.goodFor {
+		good = fs.goodFor + (elapsed-fs.goodFor)/2
+	}
+	// one segment every 100ms
+	latencies := &model.Histogram{}
+	latencies.RecordN(time.Second, int64(elapsed/(100*time.Millisecond)))
+	return model.Stats1{
+		SuccessRate:            good.Seconds() / elapsed.Seconds(),
+		SourceLatencyHistogram: latencies,
+		Started:                true,
+	}, nil
+}
+
+func TestRunnerJudgesPhaseByItsOwnSegments(t *testing.T) {
+	sc, err := Parse([]byte(`
+name: degrading
+source: a.mp4
+phases:
+  - name: good
+    type: hold
+    streams: 1
+    duration: 1.5
+    pass:
+      min_success_rate: 0.95
+  - name: degraded
+    type: hold
+    streams: 1
+    duration: 1.5
+    pass:
+      # cumulative success rate at the end of this phase is 0.75
+      min_success_rate: 0.7
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	starters := func(protocol string) (model.StreamStarter, error) {
+		return func(ctx context.Context, _ string, _, _ time.Duration) (model.OneTestStream, error) {
+			sctx, cancel := context.WithCancel(ctx)
+			return &fakeStream{ctx: sctx, cancel: cancel, started: time.Now(), goodFor: 1500 * time.Millisecond}, nil
+		}, nil
+	}
+	res := NewRunner(context.Background(), sc, starters).Run()
+	if len(res.Phases) != 2 {
+		t.Fatalf("got %d phases", len(res.Phases))
+	}
+	good, degraded := res.Phases[0], res.Phases[1]
+	if !good.Passed || good.Stats.SuccessRate < 0.95 {
+		t.Errorf("first phase should pass with success rate ~1: %s", good)
+	}
+	if degraded.Passed || degraded.Stats.SuccessRate < 0.4 || degraded.Stats.SuccessRate > 0.6 {
+		t.Errorf("second phase should fail with success rate ~0.5: %s", degraded)
+	}
+	if res.Passed {
+		t.Error("scenario should fail")
+	}
+	// each phase has only latencies of the segments streamed during it
+	for _, pres := range res.Phases {
+		if n := pres.Stats.SourceLatencyHistogram.Count(); n < 12 || n > 18 {
+			t.Errorf("phase %q has %d latency samples instead of ~15", pres.Name, n)
+		}
+	}
+}
+
+func TestSetStats(t *testing.T) {
+	a, b := &phaseStream{}, &phaseStream{}
+	from := snapshot{
+		a: {successRate: 1, active: 10 * time.Second, source: model.NewHistogram(time.Second)},
+	}
+	to := snapshot{
+		a: {successRate: 0.75, active: 20 * time.Second, source: model.NewHistogram(time.Second, 3*time.Second)},
+		b: {successRate: 1, active: 10 * time.Second, source: model.NewHistogram(5 * time.Second)},
+	}
+	pres := &PhaseResult{}
+	pres.setStats(from, to)
+	// a delivered half of the segments during the phase, b all of them
+	if sr := pres.Stats.SuccessRate; sr < 0.749 || sr > 0.751 {
+		t.Errorf("success rate is %.4f, want 0.75", sr)
+	}
+	if pres.StreamTime != 20*time.Second {
+		t.Errorf("stream time is %s, want 20s", pres.StreamTime)
+	}
+	h := pres.Stats.SourceLatencyHistogram
+	if h.Count() != 2 || h.Mean() != 4*time.Second {
+		t.Errorf("latencies of the phase are %s, want 3s and 5s", h)
+	}
+	if pres.Stats.TranscodedLatencyHistogram != nil {
+		t.Errorf("unexpected transcoded latencies %s", pres.Stats.TranscodedLatencyHistogram)
+	}
+}
+
+func TestResultStats(t *testing.T) {
+	res := &Result{Phases: []*PhaseResult{
+		{PeakActiveStreams: 2, StreamTime: 30 * time.Second, Stats: model.StatsMany{SuccessRate: 1,
+			SourceLatencyHistogram: model.NewHistogram(time.Second)}},
+		// failed in the middle of the run
+		{PeakActiveStreams: 10, StreamTime: 60 * time.Second, Stats: model.StatsMany{SuccessRate: 0.5,
+			SourceLatencyHistogram: model.NewHistogram(3 * time.Second)}},
+		// ramp-down went fine
+		{PeakActiveStreams: 10, StreamTime: 30 * time.Second, Stats: model.StatsMany{SuccessRate: 1}},
+	}}
+	st := res.Stats()
+	if sr := st.SuccessRate; sr < 0.749 || sr > 0.751 {
+		t.Errorf("success rate is %.4f, want 0.75", sr)
+	}
+	if st.ActiveStreams != 10 {
+		t.Errorf("active streams %d, want 10", st.ActiveStreams)
+	}
+	if h := st.SourceLatencyHistogram; h.Count() != 2 || st.SourceLatencies.Avg != 2*time.Second {
+		t.Errorf("latencies are %s, want 1s and 3s", h)
+	}
+	if st.TranscodedLatencyHistogram != nil {
+		t.Errorf("unexpected transcoded latencies %s", st.TranscodedLatencyHistogram)
+	}
+	if st := (&Result{}).Stats(); st.SuccessRate != 0 {
+		t.Errorf("empty result has success rate %f", st.SuccessRate)
+	}
+}
@@ -0,0 +1,187 @@
+// Package scenario describes multi-phase load tests declaratively
+// (in YAML or JSON) and runs them using load tester
+package scenario
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+)
+
+// PhaseType type of the load test phase
+type PhaseType string
+
+// Phase types
+const (
+	PhaseRampUp   PhaseType = "ramp-up"
+	PhaseHold     PhaseType = "hold"
+	PhaseSpike    PhaseType = "spike"
+	PhaseRampDown PhaseType = "ramp-down"
+)
+
+// Ingest protocols
+const (
+	ProtocolRTMP = "rtmp"
+	ProtocolHTTP = "http"
+)
+
+type (
+	// Duration is time.Duration that can be read from strings like "30s" or "5m"
+	Duration time.Duration
+
+	// Scenario describes multi-phase load test
+	Scenario struct {
+		Name string `json:"name"`
+		// Defaults used by phases that do not specify them.
+		// If source is not set, file specified on command line is used
+		Source              string   `json:"source"`
+		Protocol            string   `json:"protocol"`
+		WaitForTarget       Duration `json:"wait_for_target"`
+		DelayBetweenStreams Duration `json:"delay_between_streams"`
+		Phases              []Phase  `json:"phases"`
+	}
+
+	// Phase one phase of the load test
+	Phase struct {
+		Name string    `json:"name"`
+		Type PhaseType `json:"type"`
+		// Streams number of concurrent streams to reach in this phase.
+		// Ramp phases change number of streams linearly from the previous
+		// phase's number, hold and spike phases change it at once
+		Streams  int    `json:"streams"`
+		Protocol string `json:"protocol"`
+		Source   string `json:"source"`
+		// Duration how long phase should last
+		Duration Duration `json:"duration"`
+		// StreamDuration how long each stream should last (0 to stream whole file)
+		StreamDuration Duration `json:"stream_duration"`
+		WaitForTarget  Duration `json:"wait_for_target"`
+		// DelayBetweenStreams minimal delay between starting streams (used only in hold phase)
+		DelayBetweenStreams Duration     `json:"delay_between_streams"`
+		Pass                PassCriteria `json:"pass"`
+	}
+
+	// PassCriteria conditions phase should meet to be considered successful
+	PassCriteria struct {
+		// MinSuccessRate minimum average success rate of the streams (0..1)
+		MinSuccessRate float64 `json:"min_success_rate"`
+		// MinActiveStreams minimum number of concurrent streams that should be reached
+		MinActiveStreams int `json:"min_active_streams"`
+	}
+)
+
+// MarshalJSON implements json.Marshaler
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch value := v.(type) {
+	case float64:
+		*d = Duration(time.Duration(value * float64(time.Second)))
+	case string:
+		dur, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*d = Duration(dur)
+	default:
+		return fmt.Errorf("invalid duration %s", string(b))
+	}
+	return nil
+}
+
+// Load reads scenario from YAML or JSON file
+func Load(fileName string) (*Scenario, error) {
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(b)
+}
+
+// Parse parses scenario from YAML or JSON and fills defaults
+func Parse(data []byte) (*Scenario, error) {
+	sc := &Scenario{}
+	// YAML is superset of JSON, so this handles both formats
+	if err := yaml.Unmarshal(data, sc); err != nil {
+		return nil, fmt.Errorf("error parsing scenario: %w", err)
+	}
+	if sc.Protocol == "" {
+		sc.Protocol = ProtocolRTMP
+	}
+	if sc.WaitForTarget == 0 {
+		sc.WaitForTarget = Duration(30 * time.Second)
+	}
+	for i := range sc.Phases {
+		ph := &sc.Phases[i]
+		if ph.Name == "" {
+			ph.Name = fmt.Sprintf("%d-%s", i+1, ph.Type)
+		}
+		if ph.Protocol == "" {
+			ph.Protocol = sc.Protocol
+		}
+		if ph.Source == "" {
+			ph.Source = sc.Source
+		}
+		if ph.WaitForTarget == 0 {
+			ph.WaitForTarget = sc.WaitForTarget
+		}
+		if ph.DelayBetweenStreams == 0 {
+			ph.DelayBetweenStreams = sc.DelayBetweenStreams
+		}
+	}
+	if err := sc.Validate(); err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
+
+// Validate checks that scenario can be run
+func (sc *Scenario) Validate() error {
+	if len(sc.Phases) == 0 {
+		return fmt.Errorf("scenario %q has no phases", sc.Name)
+	}
+	var errs []string
+	for _, ph := range sc.Phases {
+		switch ph.Type {
+		case PhaseRampUp, PhaseHold, PhaseSpike, PhaseRampDown:
+		default:
+			errs = append(errs, fmt.Sprintf("phase %q has unknown type %q", ph.Name, ph.Type))
+		}
+		if ph.Streams <= 0 {
+			errs = append(errs, fmt.Sprintf("phase %q should have positive number of streams", ph.Name))
+		}
+		if ph.Duration <= 0 {
+			errs = append(errs, fmt.Sprintf("phase %q should have positive duration", ph.Name))
+		}
+		if ph.Protocol != ProtocolRTMP && ph.Protocol != ProtocolHTTP {
+			errs = append(errs, fmt.Sprintf("phase %q has unknown protocol %q", ph.Name, ph.Protocol))
+		}
+		if ph.Pass.MinSuccessRate < 0 || ph.Pass.MinSuccessRate > 1 {
+			errs = append(errs, fmt.Sprintf("phase %q min_success_rate should be in 0..1 range", ph.Name))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid scenario: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// TotalDuration sum of all phases durations
+func (sc *Scenario) TotalDuration() time.Duration {
+	var total time.Duration
+	for _, ph := range sc.Phases {
+		total += time.Duration(ph.Duration)
+	}
+	return total
+}
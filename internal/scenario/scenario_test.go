@@ -0,0 +1,70 @@
+package scenario
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDurations(t *testing.T) {
+	sc, err := Parse([]byte(`
+name: durations
+source: a.mp4
+phases:
+  - type: ramp-up
+    streams: 2
+    duration: 1.5
+  - type: hold
+    streams: 2
+    duration: 90
+  - type: spike
+    streams: 4
+    duration: 2m30s
+    stream_duration: 0.25
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []time.Duration{1500 * time.Millisecond, 90 * time.Second, 150 * time.Second}
+	for i, ph := range sc.Phases {
+		if time.Duration(ph.Duration) != want[i] {
+			t.Errorf("phase %q duration is %s, want %s", ph.Name, time.Duration(ph.Duration), want[i])
+		}
+	}
+	if d := time.Duration(sc.Phases[2].StreamDuration); d != 250*time.Millisecond {
+		t.Errorf("stream duration is %s, want 250ms", d)
+	}
+	if d := sc.TotalDuration(); d != 241500*time.Millisecond {
+		t.Errorf("total duration is %s, want 4m1.5s", d)
+	}
+}
+
+func TestParseDefaults(t *testing.T) {
+	sc, err := Parse([]byte(`{"name": "json", "source": "a.mp4", "protocol": "http",
+		"phases": [{"type": "hold", "streams": 1, "duration": "1m"},
+			{"type": "hold", "streams": 1, "duration": "1m", "protocol": "rtmp", "wait_for_target": "5s"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ph := sc.Phases[0]; ph.Name != "1-hold" || ph.Protocol != ProtocolHTTP || ph.Source != "a.mp4" || time.Duration(ph.WaitForTarget) != 30*time.Second {
+		t.Errorf("defaults are not applied to the phase: %+v", ph)
+	}
+	if ph := sc.Phases[1]; ph.Protocol != ProtocolRTMP || time.Duration(ph.WaitForTarget) != 5*time.Second {
+		t.Errorf("phase settings are overridden by defaults: %+v", ph)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, data := range []string{
+		`name: empty`,
+		`phases: [{type: plateau, streams: 1, duration: 1m}]`,
+		`phases: [{type: hold, streams: 0, duration: 1m}]`,
+		`phases: [{type: hold, streams: 1, duration: 0}]`,
+		`phases: [{type: hold, streams: 1, duration: 1m, protocol: srt}]`,
+		`phases: [{type: hold, streams: 1, duration: 1m, pass: {min_success_rate: 99}}]`,
+		`phases: [{type: hold, streams: 1, duration: true}]`,
+	} {
+		if _, err := Parse([]byte(data)); err == nil {
+			t.Errorf("no error parsing %s", data)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+// Package sei embeds wall-clock timestamps into H.264 video as SEI "user data
+// unregistered" messages and extracts them back from the downloaded segments.
+// Timestamps survive remuxing and timestamp rewriting by the media server, so
+// latency can be measured without matching segments' PTS with sent frames.
+package sei
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+)
+
+const (
+	naluTypeSEI           = 6
+	payloadTypeUnreg      = 5
+	tsPacketSize          = 188
+	tsSyncByte            = 0x47
+	timestampPayloadBytes = 8
+)
+
+// UUID identifies SEI messages carrying timestamps of the stream tester
+var UUID = [16]byte{'s', 't', 'r', 'e', 'a', 'm', '-', 't', 'e', 's', 't', 'e', 'r', '-', 't', 's'}
+
+// NALU returns SEI NAL unit carrying the timestamp
+func NALU(t time.Time) []byte {
+	rbsp := make([]byte, 0, 2+len(UUID)+timestampPayloadBytes+1)
+	rbsp = append(rbsp, payloadTypeUnreg, byte(len(UUID)+timestampPayloadBytes))
+	rbsp = append(rbsp, UUID[:]...)
+	var ts [timestampPayloadBytes]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(t.UnixNano()))
+	rbsp = append(rbsp, ts[:]...)
+	rbsp = append(rbsp, 0x80) // rbsp trailing bits
+	return append([]byte{naluTypeSEI}, escape(rbsp)...)
+}
+
+// Inject returns data of the video packet in AVCC format (NAL units prefixed
+// by 4-byte length, as used by joy4 packets) with the SEI NAL unit carrying
+// the timestamp put in front of it
+func Inject(avcc []byte, t time.Time) []byte {
+	nalu := NALU(t)
+	res := make([]byte, 4+len(nalu)+len(avcc))
+	binary.BigEndian.PutUint32(res, uint32(len(nalu)))
+	copy(res[4:], nalu)
+	copy(res[4+len(nalu):], avcc)
+	return res
+}
+
+// FromSegment returns the first timestamp found in the segment. Segment can be
+// MPEG-TS or raw H.264 stream
+func FromSegment(segment []byte) (time.Time, bool) {
+	if len(segment) >= tsPacketSize && len(segment)%tsPacketSize == 0 && segment[0] == tsSyncByte {
+		for _, es := range tsPayloads(segment) {
+			if t, ok := find(es); ok {
+				return t, true
+			}
+		}
+		return time.Time{}, false
+	}
+	return find(segment)
+}
+
+// find searches the stream for the timestamp message
+func find(data []byte) (time.Time, bool) {
+	for off := 0; ; {
+		i := bytes.Index(data[off:], UUID[:])
+		if i < 0 {
+			return time.Time{}, false
+		}
+		i += off
+		off = i + 1
+		if i < 2 || data[i-2] != payloadTypeUnreg || data[i-1] != byte(len(UUID)+timestampPayloadBytes) {
+			continue
+		}
+		ts := unescape(data[i+len(UUID):], timestampPayloadBytes)
+		if len(ts) < timestampPayloadBytes {
+			return time.Time{}, false
+		}
+		return time.Unix(0, int64(binary.BigEndian.Uint64(ts))), true
+	}
+}
+
+// tsPayloads joins payloads of the MPEG-TS packets of every PID, returning
+// elementary streams (with PES headers) in order of appearance
+func tsPayloads(segment []byte) [][]byte {
+	var order []uint16
+	streams := make(map[uint16][]byte)
+	for off := 0; off+tsPacketSize <= len(segment); off += tsPacketSize {
+		pkt := segment[off : off+tsPacketSize]
+		if pkt[0] != tsSyncByte {
+			continue
+		}
+		pid := uint16(pkt[1]&0x1f)<<8 | uint16(pkt[2])
+		afc := pkt[3] >> 4 & 0x3
+		start := 4
+		if afc&0x2 != 0 {
+			start += 1 + int(pkt[4])
+		}
+		if afc&0x1 == 0 || start >= tsPacketSize {
+			continue
+		}
+		if _, ok := streams[pid]; !ok {
+			order = append(order, pid)
+		}
+		streams[pid] = append(streams[pid], pkt[start:]...)
+	}
+	res := make([][]byte, 0, len(order))
+	for _, pid := range order {
+		res = append(res, streams[pid])
+	}
+	return res
+}
+
+// escape inserts emulation prevention bytes, so the payload does not contain
+// start codes
+func escape(rbsp []byte) []byte {
+	res := make([]byte, 0, len(rbsp)+4)
+	zeros := 0
+	for _, b := range rbsp {
+		if zeros == 2 && b <= 3 {
+			res = append(res, 3)
+			zeros = 0
+		}
+		res = append(res, b)
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return res
+}
+
+// unescape removes emulation prevention bytes, returning first n bytes of
+// the payload
+func unescape(data []byte, n int) []byte {
+	res := make([]byte, 0, n)
+	zeros := 0
+	for _, b := range data {
+		if len(res) == n {
+			break
+		}
+		if zeros == 2 && b == 3 {
+			zeros = 0
+			continue
+		}
+		res = append(res, b)
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return res
+}
@@ -0,0 +1,133 @@
+package sei
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// tsPackets splits payload into MPEG-TS packets of the PID. First packet
+// carries only `first` bytes of the payload, the rest is padded with
+// adaptation field stuffing
+func tsPackets(pid uint16, payload []byte, first int) []byte {
+	var res []byte
+	for start := true; len(payload) > 0; start = false {
+		n := 184
+		if start && first > 0 {
+			n = first
+		}
+		if n > len(payload) {
+			n = len(payload)
+		}
+		pkt := []byte{tsSyncByte, byte(pid>>8) & 0x1f, byte(pid), 0x10}
+		if start {
+			pkt[1] |= 0x40
+		}
+		if n < 184 {
+			pkt[3] |= 0x20
+			afLen := 183 - n
+			pkt = append(pkt, byte(afLen))
+			if afLen > 0 {
+				pkt = append(pkt, 0)
+				pkt = append(pkt, bytes.Repeat([]byte{0xff}, afLen-1)...)
+			}
+		}
+		pkt = append(pkt, payload[:n]...)
+		payload = payload[n:]
+		res = append(res, pkt...)
+	}
+	return res
+}
+
+// pes returns PES packet with the H.264 NAL units in Annex B format
+func pes(nalus ...[]byte) []byte {
+	res := []byte{0, 0, 1, 0xe0, 0, 0, 0x80, 0, 0}
+	for _, nalu := range nalus {
+		res = append(res, 0, 0, 0, 1)
+		res = append(res, nalu...)
+	}
+	return res
+}
+
+var keyFrame = []byte{0x65, 0x88, 0x84, 0x21, 0xa0}
+
+func TestInject(t *testing.T) {
+	ts := time.Unix(1600000000, 123456789)
+	avcc := make([]byte, 4+len(keyFrame))
+	binary.BigEndian.PutUint32(avcc, uint32(len(keyFrame)))
+	copy(avcc[4:], keyFrame)
+	data := Inject(avcc, ts)
+	// first NAL unit is SEI, then the original packet follows
+	n := binary.BigEndian.Uint32(data)
+	if data[4] != naluTypeSEI || !bytes.Equal(data[4+n:], avcc) {
+		t.Fatalf("injected packet is %x", data)
+	}
+	got, ok := FromSegment(data)
+	if !ok || !got.Equal(ts) {
+		t.Errorf("got timestamp %s ok=%v, want %s", got, ok, ts)
+	}
+	if _, ok := FromSegment(avcc); ok {
+		t.Error("timestamp found in the packet without SEI")
+	}
+}
+
+func TestEmulationPrevention(t *testing.T) {
+	// timestamp bytes 16 00 00 01 00 00 00 02 contain start code
+	ts := time.Unix(0, 0x1600000100000002)
+	nalu := NALU(ts)
+	for _, sc := range [][]byte{{0, 0, 0}, {0, 0, 1}, {0, 0, 2}} {
+		if bytes.Contains(nalu, sc) {
+			t.Fatalf("NAL unit %x contains %x", nalu, sc)
+		}
+	}
+	// SEI header, UUID, 8 bytes of timestamp, trailing bits, and two emulation prevention bytes
+	if want := 1 + 2 + len(UUID) + timestampPayloadBytes + 1 + 2; len(nalu) != want {
+		t.Errorf("NAL unit is %d bytes, want %d: %x", len(nalu), want, nalu)
+	}
+	got, ok := FromSegment(pes(nalu, keyFrame))
+	if !ok || !got.Equal(ts) {
+		t.Errorf("got timestamp %s ok=%v, want %s", got, ok, ts)
+	}
+	if esc := escape([]byte{0, 0, 3, 0, 0, 0}); !bytes.Equal(esc, []byte{0, 0, 3, 3, 0, 0, 3, 0}) {
+		t.Errorf("escaped %x", esc)
+	}
+	if unesc := unescape([]byte{0, 0, 3, 3, 0, 0, 3, 0}, 6); !bytes.Equal(unesc, []byte{0, 0, 3, 0, 0, 0}) {
+		t.Errorf("unescaped %x", unesc)
+	}
+}
+
+func TestFromTSSegment(t *testing.T) {
+	ts := time.Unix(1600000000, 987654321)
+	video := pes([]byte{0x09, 0xf0}, NALU(ts), keyFrame, bytes.Repeat([]byte{0xab}, 400))
+	audio := bytes.Repeat([]byte{0xcd}, 300)
+	// SEI starts in the first packet and ends in the second one
+	split := bytes.Index(video, UUID[:]) + 5
+	vpkts := tsPackets(0x100, video, split)
+	apkts := tsPackets(0x101, audio, 0)
+	var segment []byte
+	segment = append(segment, tsPackets(0, []byte{0, 0, 0xb0}, 0)...)
+	segment = append(segment, vpkts[:tsPacketSize]...)
+	// packets of other PIDs in between do not break the SEI
+	segment = append(segment, apkts...)
+	segment = append(segment, vpkts[tsPacketSize:]...)
+	if len(segment)%tsPacketSize != 0 {
+		t.Fatalf("segment is %d bytes", len(segment))
+	}
+	if bytes.Contains(segment, NALU(ts)) {
+		t.Fatal("SEI is not split between packets")
+	}
+	got, ok := FromSegment(segment)
+	if !ok || !got.Equal(ts) {
+		t.Errorf("got timestamp %s ok=%v, want %s", got, ok, ts)
+	}
+	// UUID without SEI header is skipped
+	noSEI := tsPackets(0x100, pes(append([]byte{0x06, 0x01, 0x02}, UUID[:]...), keyFrame), 0)
+	if _, ok := FromSegment(noSEI); ok {
+		t.Error("timestamp found in the segment without SEI")
+	}
+	// truncated segment
+	if _, ok := FromSegment(vpkts[:tsPacketSize]); ok {
+		t.Error("timestamp found in the truncated SEI")
+	}
+}
@@ -0,0 +1,247 @@
+// Package selfmon monitors resources of the tester process itself (CPU,
+// goroutines, network throughput, how far sending and downloading of media
+// lag behind) to detect when the tester, and not the service under test, is
+// the bottleneck. Results of such runs are flagged as invalid
+package selfmon
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/stream-tester/model"
+	"github.com/shirou/gopsutil/net"
+	"github.com/shirou/gopsutil/process"
+)
+
+type (
+	// Thresholds tester is considered overloaded above. Zero values are not checked
+	Thresholds struct {
+		// CPU usage, percent of all the cores
+		CPU float64
+		// Goroutines number of goroutines
+		Goroutines int
+		// SendLag how far sending of media can fall behind real time
+		SendLag time.Duration
+		// DownloadQueueLag how long segment can wait for download worker
+		DownloadQueueLag time.Duration
+		// UplinkMbps uplink capacity, overloaded if sending at more than 90% of it
+		UplinkMbps float64
+		// Sustained number of consecutive samples threshold should be exceeded in
+		Sustained int
+	}
+
+	// MaxGauge keeps maximum of the durations observed since last sample
+	MaxGauge struct {
+		v int64
+	}
+
+	// Monitor samples resources of the process
+	Monitor struct {
+		interval   time.Duration
+		thresholds Thresholds
+		proc       *process.Process
+		mu         sync.Mutex
+		load       model.TesterLoad
+		cpuSum     float64
+		samples    int
+		streaks    map[string]int
+		lastNet    *net.IOCountersStat
+		lastNetAt  time.Time
+	}
+)
+
+var (
+	// DefaultThresholds used by the testers
+	DefaultThresholds = Thresholds{
+		CPU:              90,
+		Goroutines:       100000,
+		SendLag:          2 * time.Second,
+		DownloadQueueLag: 4 * time.Second,
+		Sustained:        3,
+	}
+
+	// SendLag how far behind the real time media is being sent, observed by RTMP streamers
+	SendLag = &MaxGauge{}
+	// DownloadQueueLag time segments wait for the download worker, observed by downloaders
+	DownloadQueueLag = &MaxGauge{}
+
+	started *Monitor
+	startMu sync.Mutex
+)
+
+// AddFlags adds `-tester-*` flags to the flag set. Returned thresholds are
+// filled when flags are parsed
+func AddFlags(fs *flag.FlagSet) *Thresholds {
+	th := DefaultThresholds
+	fs.Float64Var(&th.CPU, "tester-max-cpu", th.CPU, "Tester is overloaded if it uses more CPU than this (percent of all the cores)")
+	fs.IntVar(&th.Goroutines, "tester-max-goroutines", th.Goroutines, "Tester is overloaded if it runs more goroutines than this")
+	fs.DurationVar(&th.SendLag, "tester-max-send-lag", th.SendLag, "Tester is overloaded if sending of media falls behind real time more than this")
+	fs.DurationVar(&th.DownloadQueueLag, "tester-max-download-lag", th.DownloadQueueLag, "Tester is overloaded if segments wait for download longer than this")
+	fs.Float64Var(&th.UplinkMbps, "tester-uplink-mbps", th.UplinkMbps, "Uplink capacity of the tester's host, overloaded if more than 90% of it is used")
+	return &th
+}
+
+// Observe records observed duration
+func (g *MaxGauge) Observe(d time.Duration) {
+	for {
+		cur := atomic.LoadInt64(&g.v)
+		if int64(d) <= cur || atomic.CompareAndSwapInt64(&g.v, cur, int64(d)) {
+			return
+		}
+	}
+}
+
+// take returns maximum since last call and resets it
+func (g *MaxGauge) take() time.Duration {
+	return time.Duration(atomic.SwapInt64(&g.v, 0))
+}
+
+// Start starts monitoring of the process, which lasts until context is done.
+// Report of the monitor is returned by Report
+func Start(ctx context.Context, interval time.Duration, thresholds Thresholds) (*Monitor, error) {
+	proc, err := process.NewProcess(int32(os.Getpid()))
+	if err != nil {
+		return nil, err
+	}
+	if thresholds.Sustained < 1 {
+		thresholds.Sustained = 1
+	}
+	m := &Monitor{
+		interval:   interval,
+		thresholds: thresholds,
+		proc:       proc,
+		streaks:    make(map[string]int),
+	}
+	// first call sets the base for the following ones
+	proc.Percent(0)
+	m.sampleNet(time.Now())
+	SendLag.take()
+	DownloadQueueLag.take()
+	startMu.Lock()
+	started = m
+	startMu.Unlock()
+	go m.loop(ctx)
+	return m, nil
+}
+
+// Report returns report of the started monitor, nil if monitor was not started
+func Report() *model.TesterLoad {
+	startMu.Lock()
+	m := started
+	startMu.Unlock()
+	if m == nil {
+		return nil
+	}
+	return m.Report()
+}
+
+// Report returns resources used by the tester so far
+func (m *Monitor) Report() *model.TesterLoad {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	load := m.load
+	if m.samples > 0 {
+		load.AvgCPU = m.cpuSum / float64(m.samples)
+	}
+	load.Reasons = append([]string(nil), m.load.Reasons...)
+	return &load
+}
+
+func (m *Monitor) loop(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			m.sample(now)
+		}
+	}
+}
+
+func (m *Monitor) sample(now time.Time) {
+	cpu, err := m.proc.Percent(0)
+	if err != nil {
+		glog.V(model.DEBUG).Infof("Error getting CPU usage of the tester: %v", err)
+	}
+	cpu /= float64(runtime.NumCPU())
+	goroutines := runtime.NumGoroutine()
+	sendLag := SendLag.take()
+	downloadLag := DownloadQueueLag.take()
+	tx, rx := m.sampleNet(now)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.samples++
+	m.cpuSum += cpu
+	l := &m.load
+	l.MaxCPU = maxFloat(l.MaxCPU, cpu)
+	if goroutines > l.MaxGoroutines {
+		l.MaxGoroutines = goroutines
+	}
+	if sendLag > l.MaxSendLag {
+		l.MaxSendLag = sendLag
+	}
+	if downloadLag > l.MaxDownloadQueueLag {
+		l.MaxDownloadQueueLag = downloadLag
+	}
+	l.MaxTxMbps = maxFloat(l.MaxTxMbps, tx)
+	l.MaxRxMbps = maxFloat(l.MaxRxMbps, rx)
+	th := m.thresholds
+	m.check("cpu", th.CPU > 0 && cpu > th.CPU, fmt.Sprintf("CPU usage %.0f%% > %.0f%%", cpu, th.CPU))
+	m.check("goroutines", th.Goroutines > 0 && goroutines > th.Goroutines, fmt.Sprintf("%d goroutines > %d", goroutines, th.Goroutines))
+	m.check("send", th.SendLag > 0 && sendLag > th.SendLag, fmt.Sprintf("sending media lags %s behind real time", sendLag.Round(time.Millisecond)))
+	m.check("download", th.DownloadQueueLag > 0 && downloadLag > th.DownloadQueueLag, fmt.Sprintf("segments wait %s for download",
+		downloadLag.Round(time.Millisecond)))
+	m.check("uplink", th.UplinkMbps > 0 && tx > 0.9*th.UplinkMbps, fmt.Sprintf("sending %.1f Mbps of %.1f Mbps uplink", tx, th.UplinkMbps))
+}
+
+// check records reason tester is overloaded, once the condition holds for
+// th.Sustained samples in a row
+func (m *Monitor) check(name string, exceeded bool, reason string) {
+	if !exceeded {
+		m.streaks[name] = 0
+		return
+	}
+	m.streaks[name]++
+	if m.streaks[name] != m.thresholds.Sustained {
+		return
+	}
+	reason = fmt.Sprintf("%s for %s", reason, time.Duration(m.thresholds.Sustained)*m.interval)
+	glog.Warningf("Tester overloaded: %s", reason)
+	m.load.Overloaded = true
+	m.load.Reasons = append(m.load.Reasons, reason)
+}
+
+// sampleNet returns throughput of all network interfaces since last call, Mbps
+func (m *Monitor) sampleNet(now time.Time) (float64, float64) {
+	counters, err := net.IOCounters(false)
+	if err != nil || len(counters) == 0 {
+		return 0, 0
+	}
+	cur := &counters[0]
+	var tx, rx float64
+	if m.lastNet != nil {
+		if secs := now.Sub(m.lastNetAt).Seconds(); secs > 0 {
+			tx = float64(cur.BytesSent-m.lastNet.BytesSent) * 8 / secs / 1e6
+			rx = float64(cur.BytesRecv-m.lastNet.BytesRecv) * 8 / secs / 1e6
+		}
+	}
+	m.lastNet, m.lastNetAt = cur, now
+	return tx, rx
+}
+
+func maxFloat(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
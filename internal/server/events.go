@@ -0,0 +1,116 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/stream-tester/internal/events"
+)
+
+const (
+	eventsBuffer        = 1024
+	eventsStatsInterval = 5 * time.Second
+)
+
+// handleEvents streams events to the client as Server-Sent Events.
+// Query parameters (all optional):
+// `run` - id of the run to send stats of (default run if not specified)
+// `stream` - send only events of streams whose URL contains this string
+// `types` - comma-separated list of event types to send
+// `interval` - how often to send stats (5s by default, 0 to not send stats)
+func (ss *StreamerServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("streaming is not supported"))
+		return
+	}
+	q := r.URL.Query()
+	runID := q.Get("run")
+	if runID == "" {
+		runID = defaultRunID
+	}
+	interval := eventsStatsInterval
+	if is := q.Get("interval"); is != "" {
+		var err error
+		if interval, err = time.ParseDuration(is); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+	}
+	stream := q.Get("stream")
+	types := make(map[events.Type]bool)
+	if ts := q.Get("types"); ts != "" {
+		for _, t := range strings.Split(ts, ",") {
+			types[events.Type(strings.TrimSpace(t))] = true
+		}
+	}
+	filter := func(e *events.Event) bool {
+		if len(types) > 0 && !types[e.Type] {
+			return false
+		}
+		return stream == "" || strings.Contains(e.Stream, stream)
+	}
+	sub := events.DefaultBus.Subscribe(eventsBuffer, filter)
+	defer events.DefaultBus.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	glog.Infof("Events client %s connected run=%s stream=%q", r.RemoteAddr, runID, stream)
+
+	var statsC <-chan time.Time
+	if interval > 0 && (len(types) == 0 || types[events.Stats]) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		statsC = ticker.C
+	}
+	var dropped uint64
+	for {
+		var e *events.Event
+		select {
+		case <-r.Context().Done():
+			glog.Infof("Events client %s disconnected", r.RemoteAddr)
+			return
+		case e = <-sub.C:
+		case <-statsC:
+			run := ss.getRun(runID)
+			if run == nil {
+				continue
+			}
+			e = &events.Event{Type: events.Stats, Time: time.Now(), Stats: run.info(true, false)}
+		}
+		if d := sub.Dropped(); d > dropped {
+			// let client know it is missing events
+			if err := writeEvent(w, &events.Event{Type: events.Error, Time: time.Now(),
+				Error: fmt.Sprintf("dropped %d events because client is too slow", d-dropped)}); err != nil {
+				return
+			}
+			dropped = d
+		}
+		if err := writeEvent(w, e); err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e *events.Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, b)
+	return err
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/livepeer/stream-tester/internal/alerting"
 	"github.com/livepeer/stream-tester/internal/metrics"
 )
 
@@ -23,6 +24,7 @@ func NewMetricsServer() *MetricsServer {
 func (s *MetricsServer) Start(ctx context.Context, bindAddr string) {
 	mux := http.NewServeMux()
 	mux.Handle("/metrics", metrics.Exporter)
+	mux.Handle("/alerts", alerting.StatusHandler())
 
 	srv := &http.Server{
 		Addr:    bindAddr,
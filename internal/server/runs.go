@@ -0,0 +1,455 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/stream-tester/internal/distributed"
+	"github.com/livepeer/stream-tester/internal/testers"
+	"github.com/livepeer/stream-tester/model"
+)
+
+// defaultRunID id of the run used by old (non-v2) endpoints
+const defaultRunID = "default"
+
+const (
+	// finishedRunsTTL how long finished runs are kept
+	finishedRunsTTL = time.Hour
+	// maxFinishedRuns maximum number of finished runs kept
+	maxFinishedRuns = 100
+)
+
+// Types of the test runs
+const (
+	RunTypeStreamer  = "streamer"
+	RunTypeStreamer2 = "streamer2"
+	RunTypeLoad      = "load"
+)
+
+type (
+	// CreateRunReq request to create new test run. Depending on type,
+	// one of Streams, Stream or Load should be specified
+	CreateRunReq struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+		// Streams parameters of the `streamer` run
+		Streams *model.StartStreamsReq `json:"streams,omitempty"`
+		// Stream parameters of the `streamer2` run
+		Stream *Streamer2Req `json:"stream,omitempty"`
+		// Load parameters of the `load` run
+		Load *distributed.StartReq `json:"load,omitempty"`
+	}
+
+	// Streamer2Req parameters of one stream streamed into RTMP URL and read back from media URL
+	Streamer2Req struct {
+		FileName      string `json:"file_name"`
+		RTMPURL       string `json:"rtmp_url"`
+		MediaURL      string `json:"media_url"`
+		Time          string `json:"time"`
+		WaitForTarget string `json:"wait_for_target"`
+		WowzaMode     bool   `json:"wowza_mode"`
+		MistMode      bool   `json:"mist_mode"`
+	}
+
+	// RunInfo describes test run
+	RunInfo struct {
+		ID             string    `json:"id"`
+		Name           string    `json:"name"`
+		Type           string    `json:"type"`
+		Created        time.Time `json:"created"`
+		Finished       bool      `json:"finished"`
+		BaseManifestID string    `json:"base_manifest_id,omitempty"`
+		Error          string    `json:"error,omitempty"`
+		// Stats is model.Stats, model.Stats1 or model.StatsMany depending on the run's type
+		Stats interface{} `json:"stats,omitempty"`
+	}
+
+	// testRun one test run, backed by one of the testers
+	testRun struct {
+		id      string
+		name    string
+		kind    string
+		created time.Time
+		cancel  context.CancelFunc
+		// profilesNum number of transcoding profiles of the streamer run
+		profilesNum int
+
+		streamer   model.Streamer
+		streamer2  model.Streamer2
+		loadTester *distributed.LoadTest
+
+		mu             sync.Mutex
+		baseManifestID string
+		err            error
+		finishedAt     time.Time
+	}
+)
+
+func (tr *testRun) finite() model.IFinite {
+	switch {
+	case tr.streamer != nil:
+		return tr.streamer
+	case tr.streamer2 != nil:
+		return tr.streamer2
+	}
+	return tr.loadTester
+}
+
+func (tr *testRun) stop() {
+	tr.finite().Cancel()
+	tr.cancel()
+}
+
+// finishedSince returns time run was first seen finished, zero if it is
+// still running
+func (tr *testRun) finishedSince(now time.Time) time.Time {
+	finished := tr.finite().Finished()
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	if finished && tr.finishedAt.IsZero() {
+		tr.finishedAt = now
+	}
+	return tr.finishedAt
+}
+
+func (tr *testRun) setBaseManifestID(baseManifestID string) {
+	tr.mu.Lock()
+	tr.baseManifestID = baseManifestID
+	tr.mu.Unlock()
+}
+
+func (tr *testRun) setErr(err error) {
+	tr.mu.Lock()
+	tr.err = err
+	tr.mu.Unlock()
+}
+
+func (tr *testRun) info(withStats, rawLatencies bool) *RunInfo {
+	tr.mu.Lock()
+	ri := &RunInfo{
+		ID:             tr.id,
+		Name:           tr.name,
+		Type:           tr.kind,
+		Created:        tr.created,
+		Finished:       tr.finite().Finished(),
+		BaseManifestID: tr.baseManifestID,
+	}
+	if tr.err != nil {
+		ri.Error = tr.err.Error()
+	}
+	tr.mu.Unlock()
+	if !withStats {
+		return ri
+	}
+	switch {
+	case tr.streamer != nil:
+		stats, err := tr.streamer.Stats("")
+		if err == nil {
+			if !rawLatencies {
+				stats.RawSourceLatencies = nil
+				stats.RawTranscodedLatencies = nil
+				stats.RawTranscodeLatenciesPerStream = nil
+				stats.RawTranscodeLatenciesNames = nil
+			}
+			ri.Stats = stats
+		}
+	case tr.streamer2 != nil:
+		if stats, err := tr.streamer2.Stats(); err == nil {
+			ri.Stats = stats
+		}
+		if err := tr.streamer2.Err(); err != nil && ri.Error == "" {
+			ri.Error = err.Error()
+		}
+	case tr.loadTester != nil:
+		if stats, err := tr.loadTester.Stats(); err == nil {
+			ri.Stats = stats
+		}
+	}
+	return ri
+}
+
+func (ss *StreamerServer) getRun(id string) *testRun {
+	ss.lock.RLock()
+	defer ss.lock.RUnlock()
+	return ss.runs[id]
+}
+
+// addRun adds run, replacing existing run with the same id. Returns
+// replaced run, so caller can stop it
+func (ss *StreamerServer) addRun(run *testRun) *testRun {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	ss.pruneRuns()
+	prev, has := ss.runs[run.id]
+	if !has {
+		ss.runsOrder = append(ss.runsOrder, run.id)
+	}
+	ss.runs[run.id] = run
+	return prev
+}
+
+// pruneRuns removes runs finished more than finishedRunsTTL ago, and oldest
+// finished runs above maxFinishedRuns. Default run is never removed, so its
+// stats stay available on the old endpoints. Should be called with lock held
+func (ss *StreamerServer) pruneRuns() {
+	now := time.Now()
+	var finished []string
+	for _, id := range ss.runsOrder {
+		if id == defaultRunID {
+			continue
+		}
+		if since := ss.runs[id].finishedSince(now); !since.IsZero() {
+			finished = append(finished, id)
+		}
+	}
+	evict := make(map[string]bool)
+	for i, id := range finished {
+		if i < len(finished)-maxFinishedRuns || now.Sub(ss.runs[id].finishedSince(now)) > finishedRunsTTL {
+			evict[id] = true
+		}
+	}
+	if len(evict) == 0 {
+		return
+	}
+	order := ss.runsOrder[:0]
+	for _, id := range ss.runsOrder {
+		if evict[id] {
+			glog.V(model.DEBUG).Infof("Evicting finished run id=%s", id)
+			ss.runs[id].stop()
+			delete(ss.runs, id)
+			continue
+		}
+		order = append(order, id)
+	}
+	ss.runsOrder = order
+}
+
+func (ss *StreamerServer) removeRun(id string) *testRun {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	run := ss.runs[id]
+	if run == nil {
+		return nil
+	}
+	delete(ss.runs, id)
+	for i, rid := range ss.runsOrder {
+		if rid == id {
+			ss.runsOrder = append(ss.runsOrder[:i], ss.runsOrder[i+1:]...)
+			break
+		}
+	}
+	return run
+}
+
+func (ss *StreamerServer) listRuns() []*testRun {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	ss.pruneRuns()
+	runs := make([]*testRun, 0, len(ss.runsOrder))
+	for _, id := range ss.runsOrder {
+		runs = append(runs, ss.runs[id])
+	}
+	return runs
+}
+
+// createRun creates test run and starts it
+func (ss *StreamerServer) createRun(req *CreateRunReq) (*testRun, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &testRun{
+		id:      newRunID(),
+		name:    req.Name,
+		kind:    req.Type,
+		created: time.Now(),
+		cancel:  cancel,
+	}
+	var err error
+	switch req.Type {
+	case RunTypeStreamer:
+		err = ss.startStreamerRun(ctx, cancel, run, req.Streams)
+	case RunTypeStreamer2:
+		err = startStreamer2Run(ctx, run, req.Stream)
+	case RunTypeLoad:
+		err = startLoadRun(ctx, run, req.Load)
+	default:
+		err = fmt.Errorf("unknown run type %q", req.Type)
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	ss.addRun(run)
+	glog.Infof("Created %s run id=%s name=%q", run.kind, run.id, run.name)
+	return run, nil
+}
+
+func (ss *StreamerServer) startStreamerRun(ctx context.Context, cancel context.CancelFunc, run *testRun, ssr *model.StartStreamsReq) error {
+	if ssr == nil {
+		return errors.New("should specify 'streams' field")
+	}
+	streamDuration, err := ss.prepareStartStreams(ssr)
+	if err != nil {
+		return err
+	}
+	run.profilesNum = profilesNum(ssr)
+	run.streamer = ss.newStreamer(ctx, cancel, ssr, run.profilesNum)
+	baseManifestID, err := startStreams(run.streamer, ssr, streamDuration)
+	if err != nil {
+		return err
+	}
+	run.setBaseManifestID(baseManifestID)
+	return nil
+}
+
+func startStreamer2Run(ctx context.Context, run *testRun, req *Streamer2Req) error {
+	if req == nil {
+		return errors.New("should specify 'stream' field")
+	}
+	if req.RTMPURL == "" || req.MediaURL == "" {
+		return errors.New("should specify 'rtmp_url' and 'media_url' fields")
+	}
+	if _, err := os.Stat(req.FileName); os.IsNotExist(err) {
+		return errors.New(`File ` + req.FileName + ` does not exists`)
+	}
+	var streamDuration time.Duration
+	var err error
+	if req.Time != "" {
+		if streamDuration, err = ParseStreamDurationArgument(req.Time); err != nil {
+			return err
+		}
+	}
+	waitForTarget := 30 * time.Second
+	if req.WaitForTarget != "" {
+		if waitForTarget, err = time.ParseDuration(req.WaitForTarget); err != nil {
+			return err
+		}
+	}
+	run.streamer2 = testers.NewStreamer2(ctx, testers.Streamer2Options{WowzaMode: req.WowzaMode, MistMode: req.MistMode})
+	go run.streamer2.StartStreaming(req.FileName, req.RTMPURL, req.MediaURL, waitForTarget, streamDuration)
+	return nil
+}
+
+func startLoadRun(ctx context.Context, run *testRun, req *distributed.StartReq) error {
+	if req == nil {
+		return errors.New("should specify 'load' field")
+	}
+	if req.BaseName == "" {
+		req.BaseName = run.id
+	}
+	lt, err := distributed.NewLoadTest(ctx, req)
+	if err != nil {
+		return err
+	}
+	run.loadTester = lt
+	go func() {
+		if err := lt.Run(); err != nil {
+			glog.Errorf("Load test run id=%s error: %v", run.id, err)
+			run.setErr(err)
+		}
+	}()
+	return nil
+}
+
+// handleRuns lists runs (GET) or creates new run (POST)
+func (ss *StreamerServer) handleRuns(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		_, withStats := r.URL.Query()["stats"]
+		runs := ss.listRuns()
+		infos := make([]*RunInfo, 0, len(runs))
+		for _, run := range runs {
+			infos = append(infos, run.info(withStats, false))
+		}
+		writeJSON(w, http.StatusOK, infos)
+	case "POST":
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		glog.Infof("Got create run request: '%s'", string(b))
+		req := &CreateRunReq{}
+		if err = json.Unmarshal(b, req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		run, err := ss.createRun(req)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		writeJSON(w, http.StatusCreated, run.info(false, false))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// handleRun handles requests for one run:
+// GET /v2/runs/{id} returns run with stats
+// POST /v2/runs/{id}/stop stops run
+// DELETE /v2/runs/{id} stops run and deletes it
+func (ss *StreamerServer) handleRun(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/v2/runs/"), "/"), "/")
+	id := parts[0]
+	var action string
+	if len(parts) > 1 {
+		action = parts[1]
+	}
+	if len(parts) > 2 || (action != "" && action != "stop") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	run := ss.getRun(id)
+	if run == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(fmt.Sprintf("run %s not found", id)))
+		return
+	}
+	switch {
+	case r.Method == "GET" && action == "":
+		_, rawLatencies := r.URL.Query()["latencies"]
+		writeJSON(w, http.StatusOK, run.info(true, rawLatencies))
+	case r.Method == "POST" && action == "stop":
+		glog.Infof("Stopping run id=%s", id)
+		run.stop()
+		writeJSON(w, http.StatusOK, run.info(false, false))
+	case r.Method == "DELETE" && action == "":
+		glog.Infof("Deleting run id=%s", id)
+		run.stop()
+		ss.removeRun(id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+func newRunID() string {
+	x := make([]byte, 8)
+	for i := range x {
+		x[i] = byte(rand.Uint32())
+	}
+	return fmt.Sprintf("%x", x)
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/livepeer/stream-tester/model"
+)
+
+// fakeStreamer streamer that is finished once its context is cancelled
+type fakeStreamer struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newFakeRun(id string) *testRun {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &testRun{id: id, kind: RunTypeStreamer, created: time.Now(), cancel: cancel, streamer: &fakeStreamer{ctx: ctx, cancel: cancel}}
+}
+
+func (fs *fakeStreamer) Done() <-chan struct{} { return fs.ctx.Done() }
+func (fs *fakeStreamer) Cancel()               { fs.cancel() }
+func (fs *fakeStreamer) Finished() bool        { return fs.ctx.Err() != nil }
+func (fs *fakeStreamer) StartStreams(sourceFileName, bhost, rtmpPort, mhost, mediaPort string, simStreams, repeat uint, streamDuration time.Duration,
+	notFinal, measureLatency, noBar bool, groupStartBy int, startDelayBetweenGroups, waitForTarget time.Duration) (string, error) {
+	return "", nil
+}
+func (fs *fakeStreamer) Stats(basedManifestID string) (*model.Stats, error) {
+	return &model.Stats{}, nil
+}
+
+func TestAddRunReturnsReplacedRun(t *testing.T) {
+	ss := NewStreamerServer(false, "", "", 0)
+	first := newFakeRun(defaultRunID)
+	if prev := ss.addRun(first); prev != nil {
+		t.Fatalf("got replaced run %+v", prev)
+	}
+	if prev := ss.addRun(newFakeRun(defaultRunID)); prev != first {
+		t.Fatalf("got replaced run %+v instead of the first one", prev)
+	}
+	if len(ss.listRuns()) != 1 {
+		t.Errorf("got %d runs instead of 1", len(ss.listRuns()))
+	}
+}
+
+func TestPruneRuns(t *testing.T) {
+	ss := NewStreamerServer(false, "", "", 0)
+	running := newFakeRun("running")
+	ss.addRun(running)
+	def := newFakeRun(defaultRunID)
+	def.stop()
+	def.finishedSince(time.Now().Add(-2 * finishedRunsTTL))
+	ss.addRun(def)
+	expired := newFakeRun("expired")
+	expired.stop()
+	expired.finishedSince(time.Now().Add(-2 * finishedRunsTTL))
+	ss.addRun(expired)
+	for i := 0; i < maxFinishedRuns+5; i++ {
+		run := newFakeRun(fmt.Sprintf("finished%d", i))
+		run.stop()
+		ss.addRun(run)
+	}
+	runs := ss.listRuns()
+	if len(runs) != maxFinishedRuns+2 {
+		t.Fatalf("got %d runs, want %d", len(runs), maxFinishedRuns+2)
+	}
+	if runs[0] != running {
+		t.Errorf("running run was evicted")
+	}
+	if ss.getRun(defaultRunID) != def {
+		t.Errorf("default run was evicted")
+	}
+	if ss.getRun("expired") != nil {
+		t.Errorf("expired run was not evicted")
+	}
+	for i := 0; i < 5; i++ {
+		if ss.getRun(fmt.Sprintf("finished%d", i)) != nil {
+			t.Errorf("oldest finished run %d was not evicted", i)
+		}
+	}
+	if ss.getRun(fmt.Sprintf("finished%d", maxFinishedRuns+4)) == nil {
+		t.Errorf("newest finished run was evicted")
+	}
+}
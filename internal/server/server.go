@@ -3,6 +3,7 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -17,6 +18,7 @@ import (
 	"github.com/golang/glog"
 	"github.com/livepeer/stream-tester/apis/livepeer"
 	mistapi "github.com/livepeer/stream-tester/apis/mist"
+	"github.com/livepeer/stream-tester/internal/distributed"
 	"github.com/livepeer/stream-tester/internal/metrics"
 	"github.com/livepeer/stream-tester/internal/testers"
 	"github.com/livepeer/stream-tester/internal/utils"
@@ -27,13 +29,15 @@ import (
 // provides REST endpoints to start streaming, stop and get statistics
 type StreamerServer struct {
 	// HTTPMux  *http.ServeMux
-	streamer  model.Streamer
-	lock      sync.RWMutex
+	lock      sync.RWMutex // protects orchs and runs
 	wowzaMode bool
 	lapiToken string
 	mistCreds []string
 	mistPort  uint
 	orchs     []string
+	agent     *distributed.Agent
+	runs      map[string]*testRun
+	runsOrder []string
 }
 
 // NewStreamerServer creates new StreamerServer
@@ -45,8 +49,11 @@ func NewStreamerServer(wowzaMode bool, lapiToken, mistCreds string, mistPort uin
 			glog.Fatal("Mist server's credentials should be in form 'login:password'")
 		}
 	}
+	hostName, _ := os.Hostname()
 	return &StreamerServer{
 		// streamer:  testers.NewStreamer(wowzaMode),
+		agent:     distributed.NewAgent(hostName),
+		runs:      make(map[string]*testRun),
 		wowzaMode: wowzaMode,
 		lapiToken: lapiToken,
 		mistCreds: mcreds,
@@ -83,6 +90,11 @@ func (ss *StreamerServer) webServerHandlers(bindAddr string) *http.ServeMux {
 	mux.HandleFunc("/stats", ss.handleStats)
 	mux.HandleFunc("/stop", ss.handleStop)
 	mux.HandleFunc("/orchestrators", ss.handleOrchestrators)
+	// endpoints used by distributed load test coordinator
+	ss.agent.AddHandlers(mux)
+	mux.HandleFunc("/v2/runs", ss.handleRuns)
+	mux.HandleFunc("/v2/runs/", ss.handleRun)
+	mux.HandleFunc("/v2/events", ss.handleEvents)
 	return mux
 }
 
@@ -93,8 +105,8 @@ func (ss *StreamerServer) handleStop(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	glog.Info("Got stop request.")
-	if ss.streamer != nil {
-		ss.streamer.Cancel()
+	if run := ss.getRun(defaultRunID); run != nil {
+		run.stop()
 	}
 	w.WriteHeader(http.StatusOK)
 }
@@ -119,8 +131,8 @@ func (ss *StreamerServer) handleStats(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	stats := &model.Stats{}
-	if ss.streamer != nil {
-		stats, err = ss.streamer.Stats(baseManifestID)
+	if run := ss.getRun(defaultRunID); run != nil {
+		stats, err = run.streamer.Stats(baseManifestID)
 	} else {
 		w.WriteHeader(http.StatusNotFound)
 		emsg := "No streamer exists"
@@ -132,6 +144,7 @@ func (ss *StreamerServer) handleStats(w http.ResponseWriter, r *http.Request) {
 		stats.RawSourceLatencies = nil
 		stats.RawTranscodedLatencies = nil
 		stats.RawTranscodeLatenciesPerStream = nil
+		stats.RawTranscodeLatenciesNames = nil
 	}
 	glog.Infof("baseManifestID=%s", baseManifestID)
 	if baseManifestID != "" && err == model.ErroNotFound {
@@ -181,11 +194,64 @@ func (ss *StreamerServer) handleStartStreams(w http.ResponseWriter, r *http.Requ
 	}
 
 	glog.Infof("Start streams request %+v", *ssr)
-	if ssr.Host == "" {
+	streamDuration, err := ss.prepareStartStreams(ssr)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	glog.Infof("Get request: %+v", ssr)
+	// old endpoints work with the default run
+	run := ss.getRun(defaultRunID)
+	cancel := func() {}
+	if !ssr.DoNotClearStats || run == nil {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		run = &testRun{
+			id:          defaultRunID,
+			kind:        RunTypeStreamer,
+			created:     time.Now(),
+			cancel:      cancel,
+			profilesNum: profilesNum(ssr),
+		}
+		run.streamer = ss.newStreamer(ctx, cancel, ssr, run.profilesNum)
+		if prev := ss.addRun(run); prev != nil {
+			glog.Infof("Stopping previous default run")
+			prev.stop()
+		}
+	}
+
+	baseManifestID, err := startStreams(run.streamer, ssr, streamDuration)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		cancel()
+		return
+	}
+	run.setBaseManifestID(baseManifestID)
+
+	w.Header().Set("Content-Type", "application/json")
+	res, err := json.Marshal(
+		&model.StartStreamsRes{
+			Success:        true,
+			BaseManifestID: baseManifestID,
+		},
+	)
+	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Should specify 'host' field"))
+		w.Write([]byte(err.Error()))
+		cancel()
 		return
 	}
+	w.Write(res)
+}
+
+// prepareStartStreams validates start streams request, fills defaults
+// and returns duration of the streams
+func (ss *StreamerServer) prepareStartStreams(ssr *model.StartStreamsReq) (time.Duration, error) {
+	if ssr.Host == "" {
+		return 0, errors.New("Should specify 'host' field")
+	}
 	if ssr.MHost == "" {
 		ssr.MHost = ssr.Host
 	}
@@ -204,69 +270,57 @@ func (ss *StreamerServer) handleStartStreams(w http.ResponseWriter, r *http.Requ
 	if ssr.Media == 0 {
 		ssr.Media = 8935
 	}
-	if ssr.ProfilesNum != 0 {
-		model.ProfilesNum = ssr.ProfilesNum
-	}
 	if _, err := os.Stat(ssr.FileName); os.IsNotExist(err) {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(`File ` + ssr.FileName + ` does not exists`))
-		return
+		return 0, errors.New(`File ` + ssr.FileName + ` does not exists`)
 	}
-	glog.Infof("Get request: %+v", ssr)
-	ctx, cancel := context.WithCancel(context.Background())
-	if !ssr.DoNotClearStats || ss.streamer == nil {
-		if ssr.HTTPIngest {
-			var lapi *livepeer.API
-			if ssr.Lapi {
-				presetsParts := strings.Split(ssr.Presets, ",")
-				model.ProfilesNum = len(presetsParts)
-				lapi = livepeer.NewLivepeer(ss.lapiToken, livepeer.ACServer, presetsParts) // hardcode AC server for now
-				lapi.Init()
-			}
-			ss.streamer = testers.NewHTTPLoadTester(ctx, cancel, lapi, 0)
-		} else {
-			var mapi *mistapi.API
-			if ssr.Mist {
-				mapi = mistapi.NewMist(ssr.Host, ss.mistCreds[0], ss.mistCreds[1], ss.lapiToken, ss.mistPort)
-				mapi.Login()
-			}
-			ss.streamer = testers.NewStreamer(ctx, cancel, ss.wowzaMode, ssr.Mist, mapi, nil)
-		}
+	if ssr.Mist && len(ss.mistCreds) != 2 {
+		return 0, errors.New("Mist server's credentials are not configured")
 	}
 	var streamDuration time.Duration
 	if ssr.Time != "" {
+		var err error
 		if streamDuration, err = ParseStreamDurationArgument(ssr.Time); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(err.Error()))
-			cancel()
-			return
+			return 0, err
 		}
 	}
+	return streamDuration, nil
+}
 
-	baseManifestID, err := ss.streamer.StartStreams(ssr.FileName, ssr.Host, strconv.Itoa(int(ssr.RTMP)), ssr.MHost, strconv.Itoa(int(ssr.Media)), ssr.Simultaneous,
-		ssr.Repeat, streamDuration, true, ssr.MeasureLatency, true, 3, 5*time.Second, 0)
-
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(err.Error()))
-		cancel()
-		return
+// profilesNum returns number of transcoding profiles streams of the start
+// streams request should have. It is kept per run, so concurrent runs do
+// not change each other's success rate
+func profilesNum(ssr *model.StartStreamsReq) int {
+	if ssr.HTTPIngest && ssr.Lapi {
+		return len(strings.Split(ssr.Presets, ","))
 	}
+	if ssr.ProfilesNum != 0 {
+		return ssr.ProfilesNum
+	}
+	return model.ProfilesNum
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	res, err := json.Marshal(
-		&model.StartStreamsRes{
-			Success:        true,
-			BaseManifestID: baseManifestID,
-		},
-	)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(err.Error()))
-		cancel()
-		return
+// newStreamer creates streamer suitable for start streams request
+func (ss *StreamerServer) newStreamer(ctx context.Context, cancel context.CancelFunc, ssr *model.StartStreamsReq, profilesNum int) model.Streamer {
+	if ssr.HTTPIngest {
+		var lapi *livepeer.API
+		if ssr.Lapi {
+			presetsParts := strings.Split(ssr.Presets, ",")
+			lapi = livepeer.NewLivepeer(ss.lapiToken, livepeer.ACServer, presetsParts) // hardcode AC server for now
+			lapi.Init()
+		}
+		return testers.NewHTTPLoadTester(ctx, cancel, lapi, 0, profilesNum)
 	}
-	w.Write(res)
+	var mapi *mistapi.API
+	if ssr.Mist {
+		mapi = mistapi.NewMist(ssr.Host, ss.mistCreds[0], ss.mistCreds[1], ss.lapiToken, ss.mistPort)
+		mapi.Login()
+	}
+	return testers.NewStreamer(ctx, cancel, ss.wowzaMode, ssr.Mist, mapi, nil, profilesNum)
+}
+
+func startStreams(streamer model.Streamer, ssr *model.StartStreamsReq, streamDuration time.Duration) (string, error) {
+	return streamer.StartStreams(ssr.FileName, ssr.Host, strconv.Itoa(int(ssr.RTMP)), ssr.MHost, strconv.Itoa(int(ssr.Media)), ssr.Simultaneous,
+		ssr.Repeat, streamDuration, true, ssr.MeasureLatency, true, 3, 5*time.Second, 0)
 }
 
 // Set the orchestators discoverable by the broadcaster using the orchWebhook
@@ -0,0 +1,275 @@
+package testers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// number of last segments of every rendition kept for the failure bundle
+const bundleSegments = 4
+
+var (
+	// FailureBundles enables collecting of the failure bundles: last playlists
+	// of every rendition, last segments, stats and configuration of the test
+	FailureBundles bool
+	// BundleDir directory failure bundles are saved to if external storage
+	// (Google Storage or Azure) is not configured
+	BundleDir = "failure-bundles"
+	// BundleConfig configuration of the test included in every bundle
+	BundleConfig interface{}
+
+	bundleNameRE = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+)
+
+type (
+	// Bundle artifacts of one failure, saved as zip archive
+	Bundle struct {
+		name    string
+		reason  error
+		created time.Time
+		mu      sync.Mutex
+		files   []bundleFile
+	}
+
+	bundleFile struct {
+		name string
+		data []byte
+	}
+
+	// BundleError error the failure bundle was saved for
+	BundleError struct {
+		Err error
+		// Location URL or path of the saved bundle
+		Location string
+	}
+
+	// BundleCollector is implemented by testers able to add their artifacts
+	// (playlists, segments, stats) to the failure bundle
+	BundleCollector interface {
+		CollectBundle(b *Bundle)
+	}
+
+	// artifacts last playlists and segments of the stream, shared by the
+	// m3utester2 and its media streams
+	artifacts struct {
+		mu        sync.Mutex
+		playlists map[string]bundleFile // by resolution, "master" for the master playlist
+		segments  map[string][]bundleFile
+		stats     func() interface{}
+		saveMu    sync.Mutex
+		location  string // of the bundle saved already
+	}
+)
+
+// NewBundle returns new bundle of the failure of the test
+func NewBundle(name string, reason error) *Bundle {
+	return &Bundle{name: name, reason: reason, created: time.Now()}
+}
+
+// AddFile adds file to the bundle
+func (b *Bundle) AddFile(name string, data []byte) {
+	b.mu.Lock()
+	b.files = append(b.files, bundleFile{name: name, data: data})
+	b.mu.Unlock()
+}
+
+// AddJSON adds value marshalled as JSON to the bundle
+func (b *Bundle) AddJSON(name string, v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		data = []byte(err.Error())
+	}
+	b.AddFile(name, data)
+}
+
+// Zip returns bundle as zip archive. Besides added files it contains
+// failure.json with the reason of the failure and config.json with
+// BundleConfig
+func (b *Bundle) Zip() ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	failure, _ := json.MarshalIndent(map[string]interface{}{
+		"name":  b.name,
+		"time":  b.created,
+		"error": fmt.Sprint(b.reason),
+	}, "", "  ")
+	files := []bundleFile{{name: "failure.json", data: failure}}
+	if BundleConfig != nil {
+		config, _ := json.MarshalIndent(BundleConfig, "", "  ")
+		files = append(files, bundleFile{name: "config.json", data: config})
+	}
+	for _, f := range append(files, b.files...) {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: b.created})
+		if err != nil {
+			return nil, err
+		}
+		if _, err = w.Write(f.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Save saves bundle to the external storage, or to the BundleDir if
+// storage is not configured. Returns location of the saved bundle
+func (b *Bundle) Save() (string, error) {
+	data, err := b.Zip()
+	if err != nil {
+		return "", err
+	}
+	fileName := fmt.Sprintf("bundle_%s_%s.zip", b.created.UTC().Format("20060102T150405Z"), bundleNameRE.ReplaceAllString(b.name, "_"))
+	location, service, err := SaveToExternalStorage(fileName, data)
+	if err != nil {
+		return "", fmt.Errorf("error saving failure bundle to %s: %w", service, err)
+	}
+	if location != "" {
+		return location, nil
+	}
+	if err = os.MkdirAll(BundleDir, 0755); err != nil {
+		return "", err
+	}
+	fullName := filepath.Join(BundleDir, fileName)
+	if err = ioutil.WriteFile(fullName, data, 0644); err != nil {
+		return "", err
+	}
+	if abs, aerr := filepath.Abs(fullName); aerr == nil {
+		fullName = abs
+	}
+	return fullName, nil
+}
+
+// SaveBundle saves bundle if failure bundles are enabled. Returned error
+// carries location of the bundle
+func SaveBundle(b *Bundle) error {
+	if !FailureBundles {
+		return b.reason
+	}
+	location, err := b.Save()
+	if err != nil {
+		glog.Errorf("Error saving failure bundle for %s: %v", b.name, err)
+		return b.reason
+	}
+	glog.Infof("Failure bundle for %s saved to %s", b.name, location)
+	return &BundleError{Err: b.reason, Location: location}
+}
+
+func (be *BundleError) Error() string {
+	return be.Err.Error()
+}
+
+func (be *BundleError) Unwrap() error {
+	return be.Err
+}
+
+// BundleLocation returns location of the failure bundle saved for the error,
+// empty if there is no bundle
+func BundleLocation(err error) string {
+	var be *BundleError
+	if errors.As(err, &be) {
+		return be.Location
+	}
+	return ""
+}
+
+// WithBundleLocation appends location of the failure bundle (if any) to the
+// message
+func WithBundleLocation(msg string, err error) string {
+	if location := BundleLocation(err); location != "" {
+		return msg + "\nFailure bundle: " + location
+	}
+	return msg
+}
+
+func newArtifacts() *artifacts {
+	if !FailureBundles {
+		return nil
+	}
+	return &artifacts{
+		playlists: make(map[string]bundleFile),
+		segments:  make(map[string][]bundleFile),
+	}
+}
+
+func (a *artifacts) playlist(resolution, uri string, data []byte) {
+	if a == nil {
+		return
+	}
+	_, name := path.Split(uri)
+	if i := strings.IndexByte(name, '?'); i >= 0 {
+		name = name[:i]
+	}
+	a.mu.Lock()
+	a.playlists[resolution] = bundleFile{name: name, data: data}
+	a.mu.Unlock()
+}
+
+func (a *artifacts) segment(resolution, uri string, data []byte) {
+	if a == nil || data == nil {
+		return
+	}
+	_, name := path.Split(uri)
+	if i := strings.IndexByte(name, '?'); i >= 0 {
+		name = name[:i]
+	}
+	a.mu.Lock()
+	segs := append(a.segments[resolution], bundleFile{name: name, data: data})
+	if len(segs) > bundleSegments {
+		segs = segs[1:]
+	}
+	a.segments[resolution] = segs
+	a.mu.Unlock()
+}
+
+// save saves failure bundle once, later failures of the same stream get
+// location of the same bundle
+func (a *artifacts) save(name string, err error) error {
+	a.saveMu.Lock()
+	defer a.saveMu.Unlock()
+	if a.location != "" {
+		return &BundleError{Err: err, Location: a.location}
+	}
+	b := NewBundle(name, err)
+	a.collect(b)
+	err = SaveBundle(b)
+	a.location = BundleLocation(err)
+	return err
+}
+
+// collect adds playlists, segments and stats to the bundle
+func (a *artifacts) collect(b *Bundle) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	for resolution, pl := range a.playlists {
+		b.AddFile(path.Join("playlists", bundleNameRE.ReplaceAllString(resolution, "_"), pl.name), pl.data)
+	}
+	for resolution, segs := range a.segments {
+		for _, seg := range segs {
+			b.AddFile(path.Join("segments", bundleNameRE.ReplaceAllString(resolution, "_"), seg.name), seg.data)
+		}
+	}
+	stats := a.stats
+	a.mu.Unlock()
+	if stats != nil {
+		b.AddJSON("stats.json", stats())
+	}
+}
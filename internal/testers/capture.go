@@ -0,0 +1,40 @@
+package testers
+
+import (
+	"net/http"
+
+	"github.com/livepeer/stream-tester/internal/hlsarchive"
+)
+
+var (
+	hlsRecorder *hlsarchive.Recorder
+	// transport of the httpClient before the recording started
+	captureTransport http.RoundTripper
+)
+
+// CaptureInit starts recording all the playlists and segments downloaded by the
+// testers into archive in the dir, to be replayed later by hls-replay
+func CaptureInit(dir string) error {
+	if dir == "" {
+		return nil
+	}
+	rec, err := hlsarchive.NewRecorder(dir, httpClient.Transport)
+	if err != nil {
+		return err
+	}
+	hlsRecorder = rec
+	captureTransport = httpClient.Transport
+	httpClient.Transport = rec
+	return nil
+}
+
+// CaptureClose finishes recording of the archive and restores transport of
+// the HTTP client. It is safe to call it more than once
+func CaptureClose() {
+	if hlsRecorder != nil {
+		httpClient.Transport = captureTransport
+		hlsRecorder.Close()
+		hlsRecorder = nil
+		captureTransport = nil
+	}
+}
@@ -0,0 +1,27 @@
+package testers
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestCaptureRestoresTransport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	orig := httpClient.Transport
+	if err := CaptureInit(dir); err != nil {
+		t.Fatal(err)
+	}
+	if httpClient.Transport == orig {
+		t.Fatal("transport is not replaced by the recorder")
+	}
+	CaptureClose()
+	CaptureClose()
+	if httpClient.Transport != orig {
+		t.Errorf("transport is %v after the capture is closed", httpClient.Transport)
+	}
+}
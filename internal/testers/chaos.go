@@ -0,0 +1,315 @@
+package testers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/stream-tester/model"
+)
+
+// ChaosAction disruption applied to the stream by the chaos controller
+type ChaosAction string
+
+// Chaos actions
+const (
+	// ChaosKill abruptly drops ingest connection, load tester replaces the stream with new one
+	ChaosKill ChaosAction = "kill"
+	// ChaosPause stops sending media for a while, keeping connection open
+	ChaosPause ChaosAction = "pause"
+	// ChaosRestart drops ingest connection and streams again with the same stream key
+	ChaosRestart ChaosAction = "restart"
+)
+
+// restartSettle time after restart segments downloaded are not counted as
+// recovery, as they could be produced before the connection was dropped
+const restartSettle = 5 * time.Second
+
+type (
+	// ChaosOptions configuration of the chaos controller
+	ChaosOptions struct {
+		// Fraction of the active streams disrupted every round
+		Fraction float64
+		// MinInterval, MaxInterval bounds of the random interval between rounds
+		MinInterval, MaxInterval time.Duration
+		// Actions to choose from randomly
+		Actions []ChaosAction
+		// PauseDuration how long paused streams do not send media
+		PauseDuration time.Duration
+		// RecoverWithin time paused and restarted streams should deliver new
+		// segments in (after the pause ends)
+		RecoverWithin time.Duration
+		// Tolerance how much lower than baseline success rate of the untouched
+		// streams can be
+		Tolerance float64
+		Seed      int64
+	}
+
+	// Disruptable stream chaos controller can disrupt in other ways than
+	// cancelling it
+	Disruptable interface {
+		model.OneTestStream
+		// DropConnection abruptly closes ingest connection, ending the stream
+		DropConnection()
+		// PauseUpload stops sending media for the duration, keeping connection open
+		PauseUpload(d time.Duration)
+		// Restart abruptly closes ingest connection and starts streaming again with the same stream key
+		Restart()
+		// LastDownloaded returns time segment of the stream was downloaded last
+		LastDownloaded() time.Time
+	}
+
+	chaosController struct {
+		opts      ChaosOptions
+		rnd       *rand.Rand
+		mu        sync.Mutex
+		disrupted map[model.OneTestStream]bool
+		counts    map[string]int
+		pending   []*chaosRecovery
+		baseline  float64
+		started   bool
+		recovered int
+		failed    int
+	}
+
+	// chaosRecovery disrupted stream expected to deliver segments again
+	chaosRecovery struct {
+		id       int
+		stream   Disruptable
+		since    time.Time
+		deadline time.Time
+	}
+)
+
+// ParseChaos parses chaos controller configuration in form
+//
+//	fraction=0.2,every=30s-2m,actions=kill+pause+restart,pause=10s,recover=30s,tolerance=0.05
+func ParseChaos(spec string, seed int64) (*ChaosOptions, error) {
+	_, params, err := parseProfileSpec("chaos:" + spec)
+	if err != nil {
+		return nil, err
+	}
+	opts := &ChaosOptions{
+		Fraction:      params.float("fraction", 0.1),
+		PauseDuration: params.duration("pause", 10*time.Second),
+		RecoverWithin: params.duration("recover", 30*time.Second),
+		Tolerance:     params.float("tolerance", 0.05),
+		Seed:          seed,
+	}
+	every := strings.SplitN(params.string("every", "30s-2m"), "-", 2)
+	opts.MinInterval, err = time.ParseDuration(every[0])
+	if err == nil {
+		opts.MaxInterval = opts.MinInterval
+		if len(every) == 2 {
+			opts.MaxInterval, err = time.ParseDuration(every[1])
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing chaos interval: %w", err)
+	}
+	for _, a := range strings.Split(params.string("actions", "kill+pause+restart"), "+") {
+		switch action := ChaosAction(strings.TrimSpace(a)); action {
+		case ChaosKill, ChaosPause, ChaosRestart:
+			opts.Actions = append(opts.Actions, action)
+		default:
+			return nil, fmt.Errorf("unknown chaos action %q", a)
+		}
+	}
+	if params.err != nil {
+		return nil, fmt.Errorf("error parsing chaos %q: %w", spec, params.err)
+	}
+	if opts.Fraction <= 0 || opts.Fraction > 1 {
+		return nil, fmt.Errorf("chaos fraction should be in (0, 1], got %v", opts.Fraction)
+	}
+	if opts.MinInterval <= 0 || opts.MaxInterval < opts.MinInterval {
+		return nil, fmt.Errorf("invalid chaos interval %s-%s", opts.MinInterval, opts.MaxInterval)
+	}
+	return opts, nil
+}
+
+// VerifyChaos checks that chaos didn't affect untouched streams and disrupted
+// streams recovered
+func VerifyChaos(cs *model.ChaosStats, tolerance float64) error {
+	if cs == nil {
+		return nil
+	}
+	var problems []string
+	if cs.NotRecovered > 0 {
+		problems = append(problems, fmt.Sprintf("%d disrupted streams did not recover", cs.NotRecovered))
+	}
+	if cs.UntouchedStreams > 0 && cs.UntouchedSuccessRate < cs.BaselineSuccessRate-tolerance {
+		problems = append(problems, fmt.Sprintf("success rate of untouched streams %.4f is lower than baseline %.4f", cs.UntouchedSuccessRate,
+			cs.BaselineSuccessRate))
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("chaos: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func newChaosController(opts ChaosOptions) *chaosController {
+	if len(opts.Actions) == 0 {
+		opts.Actions = []ChaosAction{ChaosKill}
+	}
+	return &chaosController{
+		opts:      opts,
+		rnd:       rand.New(rand.NewSource(opts.Seed)),
+		disrupted: make(map[model.OneTestStream]bool),
+		counts:    make(map[string]int),
+	}
+}
+
+func (cc *chaosController) interval() time.Duration {
+	spread := cc.opts.MaxInterval - cc.opts.MinInterval
+	if spread <= 0 {
+		return cc.opts.MinInterval
+	}
+	return cc.opts.MinInterval + time.Duration(cc.rnd.Int63n(int64(spread)))
+}
+
+// run disrupts streams of the load tester until context is done
+func (cc *chaosController) run(ctx context.Context, lt *loadTester) {
+	timer := time.NewTimer(cc.interval())
+	defer timer.Stop()
+	check := time.NewTicker(time.Second)
+	defer check.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-check.C:
+			cc.checkRecoveries(time.Now())
+		case <-timer.C:
+			cc.round(lt)
+			timer.Reset(cc.interval())
+		}
+	}
+}
+
+// round disrupts randomly chosen fraction of active streams
+func (cc *chaosController) round(lt *loadTester) {
+	lt.mu.Lock()
+	var ids []int
+	for id, stream := range lt.streams {
+		if !stream.Finished() {
+			ids = append(ids, id)
+		}
+	}
+	streams := make(map[int]model.OneTestStream, len(ids))
+	for _, id := range ids {
+		streams[id] = lt.streams[id]
+	}
+	lt.mu.Unlock()
+	if len(ids) == 0 {
+		return
+	}
+	cc.mu.Lock()
+	if !cc.started {
+		cc.started = true
+		cc.baseline = averageSuccessRate(streams)
+		glog.Infof("Chaos: starting disruptions, baseline success rate %v", cc.baseline)
+	}
+	cc.mu.Unlock()
+	num := int(math.Ceil(cc.opts.Fraction * float64(len(ids))))
+	for _, i := range cc.rnd.Perm(len(ids))[:num] {
+		id := ids[i]
+		action := cc.opts.Actions[cc.rnd.Intn(len(cc.opts.Actions))]
+		cc.disrupt(id, streams[id], action)
+	}
+}
+
+func (cc *chaosController) disrupt(id int, stream model.OneTestStream, action ChaosAction) {
+	ds, ok := stream.(Disruptable)
+	if !ok {
+		// only cancelling is supported
+		action = ChaosKill
+	}
+	glog.Infof("Chaos: %s stream id=%d", action, id)
+	now := time.Now()
+	var since time.Time
+	switch action {
+	case ChaosKill:
+		if ok {
+			ds.DropConnection()
+		} else {
+			stream.Cancel()
+		}
+	case ChaosPause:
+		ds.PauseUpload(cc.opts.PauseDuration)
+		since = now.Add(cc.opts.PauseDuration)
+	case ChaosRestart:
+		ds.Restart()
+		since = now.Add(restartSettle)
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.disrupted[stream] = true
+	cc.counts[string(action)]++
+	if !since.IsZero() {
+		cc.pending = append(cc.pending, &chaosRecovery{id: id, stream: ds, since: since, deadline: since.Add(cc.opts.RecoverWithin)})
+	}
+}
+
+// checkRecoveries checks if disrupted streams delivered new segments since
+// disruption ended
+func (cc *chaosController) checkRecoveries(now time.Time) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	pending := cc.pending[:0]
+	for _, pr := range cc.pending {
+		if last := pr.stream.LastDownloaded(); last.After(pr.since) {
+			glog.Infof("Chaos: stream id=%d recovered after %s", pr.id, last.Sub(pr.since))
+			cc.recovered++
+		} else if now.After(pr.deadline) || pr.stream.Finished() {
+			glog.Errorf("Chaos: stream id=%d did not recover in %s", pr.id, cc.opts.RecoverWithin)
+			cc.failed++
+		} else {
+			pending = append(pending, pr)
+		}
+	}
+	cc.pending = pending
+}
+
+// stats returns chaos stats, streams are the active streams of the load tester
+func (cc *chaosController) stats(streams map[int]model.OneTestStream) *model.ChaosStats {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cs := &model.ChaosStats{
+		Disruptions:         make(map[string]int, len(cc.counts)),
+		BaselineSuccessRate: cc.baseline,
+		Recovered:           cc.recovered,
+		NotRecovered:        cc.failed,
+		Pending:             len(cc.pending),
+	}
+	for action, num := range cc.counts {
+		cs.Disruptions[action] = num
+	}
+	untouched := make(map[int]model.OneTestStream)
+	for id, stream := range streams {
+		if !cc.disrupted[stream] {
+			untouched[id] = stream
+		}
+	}
+	cs.UntouchedStreams = len(untouched)
+	cs.UntouchedSuccessRate = averageSuccessRate(untouched)
+	return cs
+}
+
+func averageSuccessRate(streams map[int]model.OneTestStream) float64 {
+	var sum, num float64
+	for _, stream := range streams {
+		if stats, err := stream.Stats(); err == nil {
+			sum += stats.SuccessRate
+			num++
+		}
+	}
+	if num == 0 {
+		return 0
+	}
+	return sum / num
+}
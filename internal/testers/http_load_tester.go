@@ -12,6 +12,7 @@ import (
 
 	"github.com/golang/glog"
 	"github.com/livepeer/stream-tester/apis/livepeer"
+	"github.com/livepeer/stream-tester/internal/selfmon"
 	"github.com/livepeer/stream-tester/internal/utils"
 	"github.com/livepeer/stream-tester/messenger"
 	"github.com/livepeer/stream-tester/model"
@@ -22,16 +23,18 @@ import (
 // streams N streams simultaneously into B using HTTP ingest,
 // repeats M times, calculates success rate and latecies
 type HTTPLoadTester struct {
-	ctx       context.Context
-	cancel    func()
-	streamers []*httpStreamer
-	lapi      *livepeer.API
-	skipFirst time.Duration
+	ctx         context.Context
+	cancel      func()
+	streamers   []*httpStreamer
+	lapi        *livepeer.API
+	skipFirst   time.Duration
+	profilesNum int
 }
 
-// NewHTTPLoadTester returns new HTTPLoadTester
-func NewHTTPLoadTester(ctx context.Context, cancel context.CancelFunc, lapi *livepeer.API, skipFirst time.Duration) model.Streamer {
-	return &HTTPLoadTester{ctx: ctx, cancel: cancel, lapi: lapi, skipFirst: skipFirst}
+// NewHTTPLoadTester returns new HTTPLoadTester. profilesNum is number of
+// transcoding profiles streams are expected to have
+func NewHTTPLoadTester(ctx context.Context, cancel context.CancelFunc, lapi *livepeer.API, skipFirst time.Duration, profilesNum int) model.Streamer {
+	return &HTTPLoadTester{ctx: ctx, cancel: cancel, lapi: lapi, skipFirst: skipFirst, profilesNum: profilesNum}
 }
 
 // Done returns channel that will be closed once streaming is done
@@ -205,8 +208,9 @@ func (hlt *HTTPLoadTester) Stats(basedManifestID string) (*model.Stats, error) {
 		TotalSegmentsToSend: 0,
 		Finished:            true,
 		WowzaMode:           false,
+		TesterLoad:          selfmon.Report(),
 	}
-	transcodedLatencies := utils.LatenciesCalculator{}
+	transcodedLatencies := &model.Histogram{}
 	found := false
 	for _, st := range hlt.streamers {
 		if basedManifestID != "" && st.baseManifestID != basedManifestID {
@@ -223,7 +227,7 @@ func (hlt *HTTPLoadTester) Stats(basedManifestID string) (*model.Stats, error) {
 		stats.DownloadedSegments += ds.success
 		stats.FailedToDownloadSegments += ds.downloadFailures
 		stats.BytesDownloaded += ds.bytes
-		transcodedLatencies.Add(ds.latencies)
+		utils.RecordLatencies(transcodedLatencies, ds.latencies)
 		if ds.errors != nil {
 			if stats.Errors == nil {
 				stats.Errors = make(map[string]int)
@@ -239,14 +243,13 @@ func (hlt *HTTPLoadTester) Stats(basedManifestID string) (*model.Stats, error) {
 	if !found {
 		return stats, model.ErroNotFound
 	}
-	transcodedLatencies.Prepare()
-	avg, p50, p95, p99 := transcodedLatencies.Calc()
-	stats.TranscodedLatencies = model.Latencies{Avg: avg, P50: p50, P95: p95, P99: p99}
+	stats.TranscodedLatencies = transcodedLatencies.Latencies()
+	stats.TranscodedLatencyHistogram = transcodedLatencies
+	stats.RawTranscodedLatencies = transcodedLatencies.Values(model.RawLatenciesMax)
 	if stats.SentSegments > 0 {
-		stats.SuccessRate = float64(stats.DownloadedSegments) / (float64(model.ProfilesNum) * float64(stats.SentSegments)) * 100
+		stats.SuccessRate = float64(stats.DownloadedSegments) / (float64(hlt.profilesNum) * float64(stats.SentSegments)) * 100
 	}
-	stats.ShouldHaveDownloadedSegments = model.ProfilesNum * stats.SentSegments
-	stats.ProfilesNum = model.ProfilesNum
-	stats.RawTranscodedLatencies = transcodedLatencies.Raw()
+	stats.ShouldHaveDownloadedSegments = hlt.profilesNum * stats.SentSegments
+	stats.ProfilesNum = hlt.profilesNum
 	return stats, nil
 }
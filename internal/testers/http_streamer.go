@@ -20,7 +20,9 @@ import (
 
 	"github.com/golang/glog"
 	"github.com/livepeer/m3u8"
+	"github.com/livepeer/stream-tester/internal/events"
 	"github.com/livepeer/stream-tester/internal/metrics"
+	"github.com/livepeer/stream-tester/internal/tracing"
 	"github.com/livepeer/stream-tester/internal/utils"
 	"github.com/livepeer/stream-tester/internal/utils/uhttp"
 	"github.com/livepeer/stream-tester/messenger"
@@ -172,6 +174,7 @@ outloop:
 	hs.dstats.finished = true
 	metrics.StopStream(hs.dstats.failedToSend == 0)
 	hs.mu.Unlock()
+	events.Publish(&events.Event{Type: events.StreamFinished, Stream: httpURL})
 }
 
 func (hs *httpStreamer) pushSegment(httpURL, manifestID string, seg *model.HlsSegment) {
@@ -213,7 +216,10 @@ func (hs *httpStreamer) pushSegment(httpURL, manifestID string, seg *model.HlsSe
 	}
 	glog.V(model.DEBUG).Infof("Post segment manifest=%s seqNo=%d pts=%s dur=%s took=%s timed_out=%v status='%v' err=%v",
 		manifestID, seg.SeqNo, seg.Pts, seg.Duration, postTook, timedout, status, err)
+	tracing.Segment(manifestID, uint64(seg.SeqNo), tracing.SpanPush, postStarted, postStarted.Add(postTook), err, tracing.KeyURL.String(urlToUp),
+		tracing.KeyBytes.Int(len(seg.Data)), tracing.KeyStatus.String(status))
 	if err != nil {
+		events.Publish(&events.Event{Type: events.Error, Stream: httpURL, SeqNo: int64(seg.SeqNo), Took: postTook, Error: err.Error()})
 		hs.mu.Lock()
 		hs.dstats.triedToSend++
 		hs.dstats.failedToSend++
@@ -252,8 +258,10 @@ func (hs *httpStreamer) pushSegment(httpURL, manifestID string, seg *model.HlsSe
 		hs.dstats.errors[string(b)] = hs.dstats.errors[string(b)] + 1
 		hs.mu.Unlock()
 		glog.V(model.DEBUG).Infof("Got manifest=%s seqNo=%d resp status=%s error in body $%s", manifestID, seg.SeqNo, resp.Status, string(b))
+		events.Publish(&events.Event{Type: events.Error, Stream: httpURL, SeqNo: int64(seg.SeqNo), Took: postTook, Error: statusStr})
 		return
 	}
+	events.Publish(&events.Event{Type: events.SegmentSent, Stream: httpURL, SeqNo: int64(seg.SeqNo), Bytes: len(seg.Data), Took: postTook})
 	if firstOne {
 		metrics.StartupLatency(postTook)
 	}
@@ -423,11 +431,13 @@ func (hs *httpStreamer) Stats() (model.Stats1, error) {
 	stats, _ := s.Stats()
 	glog.V(model.VERBOSE).Infof("====> stats is\n===> %+v", stats)
 	stats1 := model.Stats1{
-		SuccessRate:         stats.SuccessRate / 100.0,
-		Finished:            stats.Finished,
-		Started:             hs.started,
-		SourceLatencies:     stats.SourceLatencies,
-		TranscodedLatencies: stats.TranscodedLatencies,
+		SuccessRate:                stats.SuccessRate / 100.0,
+		Finished:                   stats.Finished,
+		Started:                    hs.started,
+		SourceLatencies:            stats.SourceLatencies,
+		TranscodedLatencies:        stats.TranscodedLatencies,
+		SourceLatencyHistogram:     stats.SourceLatencyHistogram,
+		TranscodedLatencyHistogram: stats.TranscodedLatencyHistogram,
 	}
 	return stats1, nil
 }
@@ -459,7 +469,7 @@ func (hs *httpStats) Stats() (*model.Stats, error) {
 		TotalSegmentsToSend: 0,
 		Finished:            true,
 	}
-	transcodedLatencies := utils.LatenciesCalculator{}
+	transcodedLatencies := &model.Histogram{}
 	if stats.StartTime.IsZero() {
 		stats.StartTime = hs.started
 	} else if !hs.started.IsZero() && stats.StartTime.After(hs.started) {
@@ -469,7 +479,7 @@ func (hs *httpStats) Stats() (*model.Stats, error) {
 	stats.DownloadedSegments += hs.success
 	stats.FailedToDownloadSegments += hs.downloadFailures
 	stats.BytesDownloaded += hs.bytes
-	transcodedLatencies.Add(hs.latencies)
+	utils.RecordLatencies(transcodedLatencies, hs.latencies)
 	if hs.errors != nil {
 		if stats.Errors == nil {
 			stats.Errors = make(map[string]int)
@@ -481,15 +491,14 @@ func (hs *httpStats) Stats() (*model.Stats, error) {
 	if !hs.finished {
 		stats.Finished = false
 	}
-	transcodedLatencies.Prepare()
-	avg, p50, p95, p99 := transcodedLatencies.Calc()
-	stats.TranscodedLatencies = model.Latencies{Avg: avg, P50: p50, P95: p95, P99: p99}
+	stats.TranscodedLatencies = transcodedLatencies.Latencies()
+	stats.TranscodedLatencyHistogram = transcodedLatencies
+	stats.RawTranscodedLatencies = transcodedLatencies.Values(model.RawLatenciesMax)
 	if stats.SentSegments > 0 {
 		// stats.SuccessRate = float64(stats.DownloadedSegments) / (float64(model.ProfilesNum) * float64(stats.SentSegments)) * 100
 		stats.SuccessRate = float64(hs.success1) / float64(hs.triedToSend) * 100
 	}
 	stats.ShouldHaveDownloadedSegments = model.ProfilesNum * stats.SentSegments
 	stats.ProfilesNum = model.ProfilesNum
-	stats.RawTranscodedLatencies = transcodedLatencies.Raw()
 	return stats, nil
 }
@@ -0,0 +1,377 @@
+package testers
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/livepeer/stream-tester/model"
+)
+
+type (
+	// constantProfile keeps the same number of streams during whole test
+	constantProfile struct {
+		streams  int
+		duration time.Duration
+	}
+
+	// rampProfile linearly changes number of streams from `from` to `to`
+	// during `over` time, then holds `to` streams till the end of the test
+	rampProfile struct {
+		from, to int
+		over     time.Duration
+		duration time.Duration
+	}
+
+	// stepProfile starts with `start` streams and adds `step` streams
+	// every `every` interval, until `max` is reached
+	stepProfile struct {
+		start, step, max int
+		every            time.Duration
+		duration         time.Duration
+	}
+
+	// spikeProfile holds `base` streams, jumps to `peak` streams at `at`
+	// and keeps them for `length` time, then returns to `base`
+	spikeProfile struct {
+		base, peak int
+		at, length time.Duration
+		duration   time.Duration
+	}
+
+	// poissonProfile models sessions arriving as Poisson process with
+	// session lengths taken from distribution. Sessions are generated
+	// upfront, so Target is deterministic for the same seed.
+	poissonProfile struct {
+		sessions []session
+		duration time.Duration
+	}
+
+	session struct {
+		start, end time.Duration
+	}
+
+	// sequenceProfile runs profiles one after another
+	sequenceProfile struct {
+		profiles []model.LoadProfile
+	}
+
+	// shareProfile is `part`-th of `parts` equal shares of the profile.
+	// Streams that can't be split equally go to the first parts
+	shareProfile struct {
+		profile     model.LoadProfile
+		parts, part int
+	}
+
+	// SessionLength returns length of the new session
+	SessionLength func(r *rand.Rand) time.Duration
+)
+
+// NewConstantProfile returns profile with constant number of streams
+func NewConstantProfile(streams int, duration time.Duration) model.LoadProfile {
+	return &constantProfile{streams: streams, duration: duration}
+}
+
+// NewRampProfile returns profile that linearly changes number of streams
+// from `from` to `to` during `over` time
+func NewRampProfile(from, to int, over, duration time.Duration) model.LoadProfile {
+	return &rampProfile{from: from, to: to, over: over, duration: duration}
+}
+
+// NewStepProfile returns staircase profile
+func NewStepProfile(start, step, max int, every, duration time.Duration) model.LoadProfile {
+	return &stepProfile{start: start, step: step, max: max, every: every, duration: duration}
+}
+
+// NewSpikeProfile returns profile with sudden spike of streams
+func NewSpikeProfile(base, peak int, at, length, duration time.Duration) model.LoadProfile {
+	return &spikeProfile{base: base, peak: peak, at: at, length: length, duration: duration}
+}
+
+// NewPoissonProfile returns profile where new streams arrive as Poisson process
+// with `rate` arrivals per second, each lasting for time returned by `length`
+func NewPoissonProfile(rate float64, length SessionLength, duration time.Duration, seed int64) model.LoadProfile {
+	r := rand.New(rand.NewSource(seed))
+	pp := &poissonProfile{duration: duration}
+	var at time.Duration
+	for rate > 0 {
+		at += time.Duration(r.ExpFloat64() / rate * float64(time.Second))
+		if at >= duration {
+			break
+		}
+		pp.sessions = append(pp.sessions, session{start: at, end: at + length(r)})
+	}
+	return pp
+}
+
+// NewSequenceProfile returns profile that runs profiles one after another
+func NewSequenceProfile(profiles ...model.LoadProfile) model.LoadProfile {
+	return &sequenceProfile{profiles: profiles}
+}
+
+// NewShareProfile returns share of the profile for one of `parts` workers
+// (`part` is zero based). Targets of all the shares sum up to the profile's
+func NewShareProfile(profile model.LoadProfile, parts, part int) model.LoadProfile {
+	return &shareProfile{profile: profile, parts: parts, part: part}
+}
+
+// FixedSessions all sessions have the same length
+func FixedSessions(length time.Duration) SessionLength {
+	return func(r *rand.Rand) time.Duration {
+		return length
+	}
+}
+
+// ExponentialSessions sessions lengths are exponentially distributed
+func ExponentialSessions(mean time.Duration) SessionLength {
+	return func(r *rand.Rand) time.Duration {
+		return time.Duration(r.ExpFloat64() * float64(mean))
+	}
+}
+
+// UniformSessions sessions lengths are uniformly distributed between min and max
+func UniformSessions(min, max time.Duration) SessionLength {
+	return func(r *rand.Rand) time.Duration {
+		if max <= min {
+			return min
+		}
+		return min + time.Duration(r.Int63n(int64(max-min)))
+	}
+}
+
+func (cp *constantProfile) Target(elapsed time.Duration) int {
+	return cp.streams
+}
+
+func (cp *constantProfile) Duration() time.Duration {
+	return cp.duration
+}
+
+func (rp *rampProfile) Target(elapsed time.Duration) int {
+	if elapsed >= rp.over || rp.over <= 0 {
+		return rp.to
+	}
+	part := float64(elapsed) / float64(rp.over)
+	return rp.from + int(math.Round(part*float64(rp.to-rp.from)))
+}
+
+func (rp *rampProfile) Duration() time.Duration {
+	return rp.duration
+}
+
+func (sp *stepProfile) Target(elapsed time.Duration) int {
+	target := sp.start
+	if sp.every > 0 {
+		target += sp.step * int(elapsed/sp.every)
+	}
+	if sp.max > 0 && target > sp.max {
+		target = sp.max
+	}
+	return target
+}
+
+func (sp *stepProfile) Duration() time.Duration {
+	return sp.duration
+}
+
+func (sp *spikeProfile) Target(elapsed time.Duration) int {
+	if elapsed >= sp.at && elapsed < sp.at+sp.length {
+		return sp.peak
+	}
+	return sp.base
+}
+
+func (sp *spikeProfile) Duration() time.Duration {
+	return sp.duration
+}
+
+func (pp *poissonProfile) Target(elapsed time.Duration) int {
+	var active int
+	for _, s := range pp.sessions {
+		if s.start > elapsed {
+			break
+		}
+		if s.end > elapsed {
+			active++
+		}
+	}
+	return active
+}
+
+func (pp *poissonProfile) Duration() time.Duration {
+	return pp.duration
+}
+
+func (sp *sequenceProfile) Target(elapsed time.Duration) int {
+	for _, p := range sp.profiles {
+		if elapsed < p.Duration() {
+			return p.Target(elapsed)
+		}
+		elapsed -= p.Duration()
+	}
+	if len(sp.profiles) > 0 {
+		last := sp.profiles[len(sp.profiles)-1]
+		return last.Target(last.Duration())
+	}
+	return 0
+}
+
+func (sp *sequenceProfile) Duration() time.Duration {
+	var total time.Duration
+	for _, p := range sp.profiles {
+		total += p.Duration()
+	}
+	return total
+}
+
+func (sp *shareProfile) Target(elapsed time.Duration) int {
+	target := sp.profile.Target(elapsed)
+	share := target / sp.parts
+	if sp.part < target%sp.parts {
+		share++
+	}
+	return share
+}
+
+func (sp *shareProfile) Duration() time.Duration {
+	return sp.profile.Duration()
+}
+
+// ParseLoadProfile parses profile specification in form of `kind:key=value,key=value`.
+// Supported kinds:
+//
+//	constant:streams=10
+//	ramp:from=0,to=50,over=10m
+//	step:start=5,step=5,every=2m,max=50
+//	spike:base=10,peak=100,at=5m,for=1m
+//	poisson:rate=0.5,session=3m,dist=exp (dist is one of exp, fixed, uniform; uniform uses min and max)
+func ParseLoadProfile(spec string, duration time.Duration, seed int64) (model.LoadProfile, error) {
+	kind, params, err := parseProfileSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	var p model.LoadProfile
+	switch kind {
+	case "constant":
+		p = NewConstantProfile(params.int("streams", 1), duration)
+	case "ramp":
+		p = NewRampProfile(params.int("from", 0), params.int("to", 1), params.duration("over", duration), duration)
+	case "step":
+		p = NewStepProfile(params.int("start", 1), params.int("step", 1), params.int("max", 0), params.duration("every", time.Minute), duration)
+	case "spike":
+		p = NewSpikeProfile(params.int("base", 1), params.int("peak", 10), params.duration("at", duration/2), params.duration("for", time.Minute), duration)
+	case "poisson":
+		var length SessionLength
+		switch dist := params.string("dist", "exp"); dist {
+		case "exp":
+			length = ExponentialSessions(params.duration("session", 5*time.Minute))
+		case "fixed":
+			length = FixedSessions(params.duration("session", 5*time.Minute))
+		case "uniform":
+			length = UniformSessions(params.duration("min", time.Minute), params.duration("max", 10*time.Minute))
+		default:
+			return nil, fmt.Errorf("unknown session length distribution %q", dist)
+		}
+		p = NewPoissonProfile(params.float("rate", 0.1), length, duration, seed)
+	default:
+		return nil, fmt.Errorf("unknown load profile %q", kind)
+	}
+	if params.err == nil {
+		params.err = params.checkUnknown()
+	}
+	if params.err != nil {
+		return nil, fmt.Errorf("error parsing load profile %q: %w", spec, params.err)
+	}
+	return p, nil
+}
+
+type profileParams struct {
+	values map[string]string
+	// used parameters read by the profile, the rest are unknown
+	used map[string]bool
+	err  error
+}
+
+func parseProfileSpec(spec string) (string, *profileParams, error) {
+	params := &profileParams{values: make(map[string]string), used: make(map[string]bool)}
+	parts := strings.SplitN(spec, ":", 2)
+	kind := strings.TrimSpace(parts[0])
+	if len(parts) == 1 || strings.TrimSpace(parts[1]) == "" {
+		return kind, params, nil
+	}
+	for _, kv := range strings.Split(parts[1], ",") {
+		kvp := strings.SplitN(kv, "=", 2)
+		if len(kvp) != 2 {
+			return "", nil, fmt.Errorf("invalid load profile parameter %q", kv)
+		}
+		params.values[strings.TrimSpace(kvp[0])] = strings.TrimSpace(kvp[1])
+	}
+	return kind, params, nil
+}
+
+// checkUnknown returns error if there are parameters the profile doesn't
+// use, so misspelled parameter doesn't silently fall back to the default
+func (pp *profileParams) checkUnknown() error {
+	var unknown []string
+	for name := range pp.values {
+		if !pp.used[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	return fmt.Errorf("unknown parameters %s", strings.Join(unknown, ","))
+}
+
+func (pp *profileParams) string(name, def string) string {
+	pp.used[name] = true
+	if v, ok := pp.values[name]; ok {
+		return v
+	}
+	return def
+}
+
+func (pp *profileParams) int(name string, def int) int {
+	pp.used[name] = true
+	v, ok := pp.values[name]
+	if !ok {
+		return def
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil && pp.err == nil {
+		pp.err = fmt.Errorf("parameter %s: %w", name, err)
+	}
+	return i
+}
+
+func (pp *profileParams) float(name string, def float64) float64 {
+	pp.used[name] = true
+	v, ok := pp.values[name]
+	if !ok {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil && pp.err == nil {
+		pp.err = fmt.Errorf("parameter %s: %w", name, err)
+	}
+	return f
+}
+
+func (pp *profileParams) duration(name string, def time.Duration) time.Duration {
+	pp.used[name] = true
+	v, ok := pp.values[name]
+	if !ok {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil && pp.err == nil {
+		pp.err = fmt.Errorf("parameter %s: %w", name, err)
+	}
+	return d
+}
@@ -0,0 +1,43 @@
+package testers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestShareProfile(t *testing.T) {
+	for _, spec := range []string{"ramp:from=0,to=50,over=10m", "step:start=5,step=5,every=2m,max=50", "poisson:rate=0.5,session=3m"} {
+		profile, err := ParseLoadProfile(spec, 10*time.Minute, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for elapsed := time.Duration(0); elapsed < profile.Duration(); elapsed += 7 * time.Second {
+			var sum int
+			for part := 0; part < 3; part++ {
+				share := NewShareProfile(profile, 3, part)
+				if share.Duration() != profile.Duration() {
+					t.Fatalf("share of %s lasts %s", spec, share.Duration())
+				}
+				sum += share.Target(elapsed)
+			}
+			if want := profile.Target(elapsed); sum != want {
+				t.Fatalf("shares of %s at %s sum up to %d streams instead of %d", spec, elapsed, sum, want)
+			}
+		}
+	}
+}
+
+func TestParseLoadProfile(t *testing.T) {
+	for _, spec := range []string{"constant", "constant:streams=10", "ramp:from=0, to=50, over=10m", "spike:base=10,peak=100,at=5m,for=1m",
+		"poisson:rate=0.5,dist=uniform,min=1m,max=5m"} {
+		if _, err := ParseLoadProfile(spec, 10*time.Minute, 1); err != nil {
+			t.Errorf("error parsing %q: %v", spec, err)
+		}
+	}
+	for _, spec := range []string{"linear:streams=10", "constant:stream=10", "ramp:from=0,to=50,duration=10m", "step:start=a",
+		"poisson:rate=0.5,dist=uniform,session=3m", "poisson:dist=normal", "spike:base"} {
+		if _, err := ParseLoadProfile(spec, 10*time.Minute, 1); err == nil {
+			t.Errorf("no error parsing %q", spec)
+		}
+	}
+}
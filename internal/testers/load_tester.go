@@ -4,13 +4,19 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/livepeer/stream-tester/internal/selfmon"
+	"github.com/livepeer/stream-tester/internal/utils"
 	"github.com/livepeer/stream-tester/model"
 )
 
+// concurrencySampleInterval how often requested and achieved concurrency is recorded
+const concurrencySampleInterval = 10 * time.Second
+
 type (
 	// loadTester general load tester
 	loadTester struct {
@@ -19,6 +25,8 @@ type (
 		streams             map[int]model.OneTestStream
 		id                  int
 		delayBetweenStreams time.Duration
+		concurrency         []model.ConcurrencySample
+		chaos               *chaosController
 		mu                  sync.Mutex
 	}
 )
@@ -38,75 +46,127 @@ func NewLoadTester(pctx context.Context, streamStarter model.StreamStarter, dela
 	return lt
 }
 
+// NewChaosLoadTester creates new loadTester object, which also disrupts
+// randomly chosen streams while the test is running
+func NewChaosLoadTester(pctx context.Context, streamStarter model.StreamStarter, delayBetweenStreams time.Duration, chaos ChaosOptions) model.ILoadTester {
+	lt := NewLoadTester(pctx, streamStarter, delayBetweenStreams).(*loadTester)
+	lt.chaos = newChaosController(chaos)
+	return lt
+}
+
 func (lt *loadTester) Start(sourceFileName string, waitForTarget, oneStreamTime, overallTestTime time.Duration, sim int) error {
-	if lt.Finished() {
-		panic("shouldn't be reused")
-	}
 	if sim <= 0 {
 		panic("Should start more than zero streams")
 	}
-	started := time.Now()
-	glog.Infof("Starting first stream")
-	stream, err := lt.streamStarter(lt.ctx, sourceFileName, waitForTarget, oneStreamTime)
-	if err != nil {
-		glog.Errorf("Error starting stream: %v", err)
-		return err
+	return lt.StartProfile(sourceFileName, waitForTarget, oneStreamTime, NewConstantProfile(sim, overallTestTime))
+}
+
+func (lt *loadTester) StartProfile(sourceFileName string, waitForTarget, oneStreamTime time.Duration, profile model.LoadProfile) error {
+	if lt.Finished() {
+		panic("shouldn't be reused")
 	}
-	lt.streams[lt.id] = stream
-	lt.id++
-	glog.Infof("Starting %d streams", sim-1)
+	started := time.Now()
+	glog.Infof("Starting load test for %s", profile.Duration())
 	lastStatsPrint := time.Now()
+	var lastSample time.Time
+	startedAny := false
+	if lt.chaos != nil {
+		cctx, ccancel := context.WithCancel(lt.ctx)
+		defer ccancel()
+		go lt.chaos.run(cctx, lt)
+	}
 	for {
 		if lt.Finished() {
 			break
 		}
-		if len(lt.streams) < sim {
-			if lt.delayBetweenStreams > 0 {
-				delay := lt.delayBetweenStreams + time.Duration(rand.Intn(int(lt.delayBetweenStreams.Milliseconds())))*time.Millisecond
-				glog.V(model.VERBOSE).Infof("Waiting for %s before starting stream id=%d (current concurrent streams is %d)", delay, lt.id, len(lt.streams))
+		elapsed := time.Since(started)
+		if elapsed > profile.Duration() {
+			break
+		}
+		target := profile.Target(elapsed)
+		// check if stream is finished and needs to be removed
+		lt.mu.Lock()
+		for id, stream := range lt.streams {
+			if stream.Finished() {
+				delete(lt.streams, id)
+			}
+		}
+		active := len(lt.streams)
+		lt.mu.Unlock()
+		if time.Since(lastSample) >= concurrencySampleInterval {
+			lt.mu.Lock()
+			lt.concurrency = append(lt.concurrency, model.ConcurrencySample{At: elapsed, Requested: target, Achieved: active})
+			lt.mu.Unlock()
+			lastSample = time.Now()
+		}
+		if active < target {
+			if startedAny && lt.delayBetweenStreams > 0 {
+				delay := lt.delayBetweenStreams + time.Duration(rand.Intn(int(lt.delayBetweenStreams.Milliseconds())+1))*time.Millisecond
+				glog.V(model.VERBOSE).Infof("Waiting for %s before starting stream id=%d (current concurrent streams is %d)", delay, lt.id, active)
 				time.Sleep(delay)
 			}
 			stream, err := lt.streamStarter(lt.ctx, sourceFileName, waitForTarget, oneStreamTime)
 			if err != nil {
 				glog.Errorf("Error starting stream: %v", err)
+				if !startedAny {
+					return err
+				}
 			} else {
+				lt.mu.Lock()
 				lt.streams[lt.id] = stream
 				lt.id++
+				lt.mu.Unlock()
 			}
+			startedAny = true
+		} else if active > target {
+			lt.stopStreams(active - target)
 		}
 		// print stats
 		if time.Since(lastStatsPrint) > 30*time.Second {
-			lt.printStats()
+			lt.printStats(false)
 			lastStatsPrint = time.Now()
 		}
-		// check if stream is finished and needs to be removed
-		for id, stream := range lt.streams {
-			if stream.Finished() {
-				delete(lt.streams, id)
-			}
-		}
-		if time.Since(started) > overallTestTime {
-			break
-		}
-		delay := 10 * time.Second
-		if len(lt.streams) < sim {
+		delay := time.Second
+		if active < target {
 			delay = 100 * time.Millisecond
 		}
 		time.Sleep(delay)
 	}
 	glog.Infof("Load test finished after %s", time.Since(started))
-	lt.printStats()
+	lt.printStats(true)
 	lt.Cancel()
 
 	return nil
 }
 
-func (lt *loadTester) printStats() {
+// stopStreams stops most recently started streams
+func (lt *loadTester) stopStreams(num int) {
+	lt.mu.Lock()
+	ids := make([]int, 0, len(lt.streams))
+	for id := range lt.streams {
+		ids = append(ids, id)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(ids)))
+	if num > len(ids) {
+		num = len(ids)
+	}
+	for _, id := range ids[:num] {
+		glog.V(model.VERBOSE).Infof("Stopping stream id=%d", id)
+		lt.streams[id].Cancel()
+		delete(lt.streams, id)
+	}
+	lt.mu.Unlock()
+}
+
+func (lt *loadTester) printStats(withConcurrency bool) {
 	stats, err := lt.Stats()
 	if err != nil {
 		glog.Errorf("Error getting stats: %v", err)
 		return
 	}
+	if !withConcurrency {
+		stats.Concurrency = nil
+	}
 	msg := fmt.Sprintf("\n%s\n", stats.FormatForConsole())
 	glog.V(model.SHORT).Info(msg)
 }
@@ -116,17 +176,71 @@ func (lt *loadTester) Stats() (model.StatsMany, error) {
 	var stats model.StatsMany
 	stats.ActiveStreams = len(lt.streams)
 	var num float64
+	var source, transcoded []model.Latencies
+	sourceHist, transcodedHist := &model.Histogram{}, &model.Histogram{}
+	sourceHistOK, transcodedHistOK := true, true
 	for _, stream := range lt.streams {
 		stats1, err := stream.Stats()
 		if err == nil {
 			stats.SuccessRate += stats1.SuccessRate
 			num++
+			if stats1.SourceLatencies.P50 > 0 {
+				source = append(source, stats1.SourceLatencies)
+				sourceHist.Merge(stats1.SourceLatencyHistogram)
+				sourceHistOK = sourceHistOK && stats1.SourceLatencyHistogram != nil
+			}
+			if stats1.TranscodedLatencies.P50 > 0 {
+				transcoded = append(transcoded, stats1.TranscodedLatencies)
+				transcodedHist.Merge(stats1.TranscodedLatencyHistogram)
+				transcodedHistOK = transcodedHistOK && stats1.TranscodedLatencyHistogram != nil
+			}
 		}
 	}
 	if num > 0 {
 		stats.SuccessRate = stats.SuccessRate / num
 	}
+	// percentiles of all the segments of all the streams, if streams keep
+	// histograms
+	stats.SourceLatencies = model.AverageLatencies(source...)
+	if sourceHistOK && sourceHist.Count() > 0 {
+		stats.SourceLatencies = sourceHist.Latencies()
+		stats.SourceLatencyHistogram = sourceHist
+	}
+	stats.TranscodedLatencies = model.AverageLatencies(transcoded...)
+	if transcodedHistOK && transcodedHist.Count() > 0 {
+		stats.TranscodedLatencies = transcodedHist.Latencies()
+		stats.TranscodedLatencyHistogram = transcodedHist
+	}
+	stats.Concurrency = append([]model.ConcurrencySample(nil), lt.concurrency...)
+	if lt.chaos != nil {
+		stats.Chaos = lt.chaos.stats(lt.streams)
+	}
+	stats.TesterLoad = selfmon.Report()
 	lt.mu.Unlock()
 	stats.Finished = lt.Finished()
 	return stats, nil
 }
+
+// NewTemplateStreamStarter returns stream starter that streams into RTMP URL
+// made from rtmpTemplate and reads stream back from URL made from hlsTemplate.
+// Templates should have one %s which is replaced by generated manifest id.
+func NewTemplateStreamStarter(rtmpTemplate, hlsTemplate, baseName string, opts Streamer2Options) model.StreamStarter {
+	var id int
+	var mu sync.Mutex
+	return func(ctx context.Context, sourceFileName string, waitForTarget, timeToStream time.Duration) (model.OneTestStream, error) {
+		mu.Lock()
+		manifestID := fmt.Sprintf("%s_%d", baseName, id)
+		id++
+		mu.Unlock()
+		rtmpURL := fmt.Sprintf(rtmpTemplate, manifestID)
+		mediaURL := fmt.Sprintf(hlsTemplate, manifestID)
+		glog.V(model.SHORT).Infof("RTMP: %s", rtmpURL)
+		glog.V(model.SHORT).Infof("MEDIA: %s", mediaURL)
+		if err := utils.WaitForTCP(waitForTarget, rtmpURL); err != nil {
+			return nil, err
+		}
+		sr2 := NewStreamer2(ctx, opts)
+		go sr2.StartStreaming(sourceFileName, rtmpURL, mediaURL, waitForTarget, timeToStream)
+		return sr2, nil
+	}
+}
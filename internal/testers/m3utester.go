@@ -87,6 +87,7 @@ type m3utester struct {
 	saveDirName      string
 	cancel           context.CancelFunc
 	shouldSkip       [][]string
+	profilesNum      int // number of transcoding profiles expected in wowza mode
 }
 
 type fullDownloadResult struct {
@@ -103,6 +104,7 @@ type downloadResult struct {
 	videoParseError    error
 	startTime          time.Duration
 	duration           time.Duration
+	sentAt             time.Time // wall-clock time embedded into the first key frame of the segment
 	appTime            time.Time
 	timeAtFirstPlace   time.Time
 	downloadStartedAt  time.Time
@@ -146,6 +148,7 @@ func newM3UTester(ctx context.Context, sentTimesMap *utils.SyncedTimesMap, wowza
 		name:            name,
 		fullResultsCh:   make(chan *fullDownloadResult, 32),
 		downSegs:        make(map[string]map[string]*fullDownloadResult),
+		profilesNum:     model.ProfilesNum,
 	}
 	ct, cancel := context.WithCancel(ctx)
 	t.ctx = ct
@@ -522,7 +525,7 @@ func (mt *m3utester) manifestDownloadLoop() {
 			mt.Stop()
 			return
 		}
-		if !mt.wowzaMode || len(mpl.Variants) > model.ProfilesNum {
+		if !mt.wowzaMode || len(mpl.Variants) > mt.profilesNum {
 			if mt.infiniteMode {
 				if !gotPlaylist {
 					glog.Infof("Got playlist for %s with %d variants", surl, len(mpl.Variants))
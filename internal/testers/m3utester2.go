@@ -23,7 +23,11 @@ import (
 	"github.com/golang/glog"
 	"github.com/livepeer/joy4/jerrors"
 	"github.com/livepeer/m3u8"
+	"github.com/livepeer/stream-tester/internal/events"
 	"github.com/livepeer/stream-tester/internal/metrics"
+	"github.com/livepeer/stream-tester/internal/sei"
+	"github.com/livepeer/stream-tester/internal/selfmon"
+	"github.com/livepeer/stream-tester/internal/tracing"
 	"github.com/livepeer/stream-tester/internal/utils"
 	"github.com/livepeer/stream-tester/internal/utils/uhttp"
 	"github.com/livepeer/stream-tester/messenger"
@@ -61,6 +65,9 @@ type (
 		ctx         context.Context
 		cancel      context.CancelFunc
 		globalError error
+		// artifacts collected for the failure bundle (nil if disabled)
+		artifacts  *artifacts
+		bundleName string
 	}
 	// m3utester2 tests one stream, reading all the media streams
 	// was designed for use with non-go-livepeer node RTMP ingesters
@@ -86,6 +93,9 @@ type (
 		mu                     sync.Mutex
 		allResults             map[string][]*downloadResult
 		statsOnly              bool
+		lastDownloadedAt       int64 // unix nano, updated atomically
+		streamMetrics          *metrics.StreamMetrics
+		streamName             string
 	}
 
 	// m3uMediaStream downloads media stream. Hadle stream changes
@@ -108,6 +118,9 @@ type (
 		savePlayListName       string
 		saveDirName            string
 		statsOnly              bool
+		lastDownloadedAt       *int64 // of the m3utester2
+		streamMetrics          *metrics.StreamMetrics
+		streamName             string // of the m3utester2
 	}
 
 	nameAndURI struct {
@@ -161,6 +174,20 @@ func newM3utester2(pctx context.Context, u string, wowzaMode, mistMode, failIfTr
 		statsOnly:              statsOnly,
 	}
 	mut.stats.Started = true
+	mut.streamName, err = parseStreamURL(u)
+	if err != nil {
+		mut.streamName = u
+	}
+	mut.streamMetrics = metrics.RegisterStream(mut.streamName)
+	mut.artifacts = newArtifacts()
+	mut.bundleName = mut.streamName
+	if mut.artifacts != nil {
+		mut.artifacts.stats = func() interface{} { return mut.Stats() }
+	}
+	go func() {
+		<-ctx.Done()
+		mut.streamMetrics.Close()
+	}()
 	go mut.workerLoop()
 	go mut.manifestPullerLoop(waitForTarget)
 	return mut
@@ -187,6 +214,12 @@ func (mut *m3utester2) VODStats() model.VODStats {
 	return vs
 }
 
+// CollectBundle adds last playlists and segments of every rendition and
+// stats to the failure bundle
+func (mut *m3utester2) CollectBundle(b *Bundle) {
+	mut.artifacts.collect(b)
+}
+
 func (mut *m3utester2) doSavePlaylist() error {
 	if mut.savePlayList != nil {
 		return ioutil.WriteFile(mut.savePlayListName, mut.savePlayList.Encode().Bytes(), 0644)
@@ -216,12 +249,15 @@ func (mut *m3utester2) initSave(streamURL, mediaURL string) {
 }
 
 func newM3uMediaStream(ctx context.Context, cancel context.CancelFunc, name, resolution string, u *url.URL, wowzaMode bool, masterDR chan *downloadResult,
-	sm *segmentsMatcher, latencyResults chan *latencyResult, save, failIfTranscodingStops, statsOnly bool) (*m3uMediaStream, error) {
+	sm *segmentsMatcher, latencyResults chan *latencyResult, save, failIfTranscodingStops, statsOnly bool, lastDownloadedAt *int64, streamMetrics *metrics.StreamMetrics, streamName string,
+	arts *artifacts) (*m3uMediaStream, error) {
 
 	ms := &m3uMediaStream{
 		finite: finite{
-			ctx:    ctx,
-			cancel: cancel,
+			ctx:        ctx,
+			cancel:     cancel,
+			artifacts:  arts,
+			bundleName: streamName + "_" + resolution,
 		},
 		name:                   name,
 		u:                      u,
@@ -233,6 +269,9 @@ func newM3uMediaStream(ctx context.Context, cancel context.CancelFunc, name, res
 		segmentsMatcher:        sm,
 		downTasks:              make(chan downloadTask, 256),
 		statsOnly:              statsOnly,
+		lastDownloadedAt:       lastDownloadedAt,
+		streamMetrics:          streamMetrics,
+		streamName:             streamName,
 	}
 	go ms.workerLoop(masterDR, latencyResults)
 	go ms.manifestPullerLoop(wowzaMode)
@@ -264,10 +303,20 @@ func newM3uMediaStream(ctx context.Context, cancel context.CancelFunc, name, res
 func (mut *m3utester2) Stats() model.Stats1 {
 	mut.mu.Lock()
 	stats := mut.stats
+	stats.SourceLatencyHistogram = stats.SourceLatencyHistogram.Clone()
+	stats.TranscodedLatencyHistogram = stats.TranscodedLatencyHistogram.Clone()
 	mut.mu.Unlock()
 	return stats
 }
 
+// lastDownloaded returns time segment of any media stream was downloaded last
+func (mut *m3utester2) lastDownloaded() time.Time {
+	if ns := atomic.LoadInt64(&mut.lastDownloadedAt); ns > 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
+
 func (mut *m3utester2) workerLoop() {
 	seenResolutions := make(sort.StringSlice, 0, 16)
 	results := make(map[string][]*downloadResult)
@@ -276,20 +325,21 @@ func (mut *m3utester2) workerLoop() {
 	defer timer.Stop()
 	finishCheckTimer := time.NewTicker(5 * time.Second)
 	defer finishCheckTimer.Stop()
-	// transcodedLatencies := utils.LatenciesCalculator{}
-	latencies := make(map[string]*utils.DurationsCapped)
-	sourceLatencies := utils.NewDurations(4096)
-	transcodeLatencies := utils.NewDurations(4 * 4096)
+	latencies := make(map[string]*model.Histogram)
+	// speed ratios are kept as durations of ratio seconds
+	speedRatios := make(map[string]*model.Histogram)
+	sourceLatencies := &model.Histogram{}
+	transcodeLatencies := &model.Histogram{}
 	succRates := make(map[string]float64)
 	printStats := func() {
 		msg := fmt.Sprintf("Stream %s is running for %s already\n", mut.initialURL, time.Since(started))
 		if len(latencies) > 0 {
 			msg += "```"
 			for res, lat := range latencies {
-				clat := lat.GetPercentile(50, 95, 99)
-				flat := lat.GetPercentileFloat(50, 95, 99)
+				clat := lat.Percentiles(50, 95, 99)
+				flat := speedRatios[res].Percentiles(50, 95, 99)
 				msg += fmt.Sprintf("Latencies    for %12s is p50 %s p95 %s p99 %s\n", res, clat[0], clat[1], clat[2])
-				msg += fmt.Sprintf("Speed ratios for %12s is p50 %v p95 %v p99 %v\n", res, flat[0], flat[1], flat[2])
+				msg += fmt.Sprintf("Speed ratios for %12s is p50 %v p95 %v p99 %v\n", res, flat[0].Seconds(), flat[1].Seconds(), flat[2].Seconds())
 			}
 			msg += "```"
 		}
@@ -331,10 +381,12 @@ func (mut *m3utester2) workerLoop() {
 			}
 		case lres := <-mut.latencyResults:
 			if _, has := latencies[lres.resolution]; !has {
-				latencies[lres.resolution] = utils.NewDurations(256)
+				latencies[lres.resolution] = &model.Histogram{}
+				speedRatios[lres.resolution] = &model.Histogram{}
 			}
-			latencies[lres.resolution].Add(lres.latency)
-			latencies[lres.resolution].AddFloat(lres.speedRatio)
+			latencies[lres.resolution].Record(lres.latency)
+			speedRatios[lres.resolution].Record(time.Duration(lres.speedRatio * float64(time.Second)))
+			mut.streamMetrics.Segment(lres.resolution, lres.latency, lres.speedRatio, lres.successRate)
 			if lres.successRate > 0 {
 				mut.mu.Lock()
 				succRates[lres.resolution] = lres.successRate
@@ -344,14 +396,14 @@ func (mut *m3utester2) workerLoop() {
 				}
 				mut.stats.SuccessRate = sum / float64(len(succRates))
 				if mut.sourceRes == lres.resolution {
-					sourceLatencies.Add(lres.latency)
+					sourceLatencies.Record(lres.latency)
 				} else {
-					transcodeLatencies.Add(lres.latency)
+					transcodeLatencies.Record(lres.latency)
 				}
-				avg, p50, p95, p99 := sourceLatencies.Calc()
-				mut.stats.SourceLatencies = model.Latencies{Avg: avg, P50: p50, P95: p95, P99: p99}
-				avg, p50, p95, p99 = transcodeLatencies.Calc()
-				mut.stats.TranscodedLatencies = model.Latencies{Avg: avg, P50: p50, P95: p95, P99: p99}
+				mut.stats.SourceLatencies = sourceLatencies.Latencies()
+				mut.stats.TranscodedLatencies = transcodeLatencies.Latencies()
+				mut.stats.SourceLatencyHistogram = sourceLatencies
+				mut.stats.TranscodedLatencyHistogram = transcodeLatencies
 				mut.mu.Unlock()
 			}
 
@@ -423,6 +475,9 @@ func (mut *m3utester2) workerLoop() {
 								lastTimeDriftReportTime = time.Now()
 							}
 							problems++
+							mut.streamMetrics.TimeDrift()
+							events.Publish(&events.Event{Type: events.Drift, Stream: mut.initialURL.String(), SeqNo: int64(seg1.seqNo),
+								Resolution: res1 + "/" + res2, PTS: seg1.startTime, Took: diff, Error: msg})
 						}
 					}
 				}
@@ -432,9 +487,12 @@ func (mut *m3utester2) workerLoop() {
 }
 
 func (f *finite) fatalEnd(err error) {
+	if f.artifacts != nil {
+		err = f.artifacts.save(f.bundleName, err)
+	}
 	f.globalError = err
-	model.ExitCode = 127
-	messenger.SendFatalMessage(err.Error())
+	model.ExitCode = model.ExitCodeFailure
+	messenger.SendFatalMessage(WithBundleLocation(err.Error(), err))
 	f.cancel()
 }
 
@@ -558,6 +616,7 @@ func (mut *m3utester2) manifestPullerLoop(waitForTarget time.Duration) {
 			time.Sleep(2 * time.Second)
 			continue
 		}
+		mut.artifacts.playlist("master", surl, b)
 		gpl, plt, err := m3u8.Decode(*bytes.NewBuffer(b), true)
 		if err != nil {
 			glog.Error("===== error parsing master playlist: ", err)
@@ -583,7 +642,8 @@ func (mut *m3utester2) manifestPullerLoop(waitForTarget time.Duration) {
 				}
 			}
 			stream, err := newM3uMediaStream(mut.ctx, mut.cancel, mediaName, mres, mut.initialURL, mut.wowzaMode, mut.driftCheckResults, mut.segmentsMatcher, mut.latencyResults,
-				mut.save, mut.failIfTranscodingStops, mut.statsOnly)
+				mut.save, mut.failIfTranscodingStops, mut.statsOnly, &mut.lastDownloadedAt,
+				mut.streamMetrics, mut.streamName, mut.artifacts)
 			if err != nil {
 				mut.fatalEnd(err)
 				return
@@ -677,7 +737,8 @@ func (mut *m3utester2) manifestPullerLoop(waitForTarget time.Duration) {
 				mut.sourceRes = ress
 			}
 			stream, err := newM3uMediaStream(mut.ctx, mut.cancel, variant.URI, ress, pvrui, mut.wowzaMode, mut.driftCheckResults,
-				mut.segmentsMatcher, mut.latencyResults, mut.save, mut.failIfTranscodingStops, mut.statsOnly)
+				mut.segmentsMatcher, mut.latencyResults, mut.save, mut.failIfTranscodingStops, mut.statsOnly, &mut.lastDownloadedAt,
+				mut.streamMetrics, mut.streamName, mut.artifacts)
 			if err != nil {
 				mut.fatalEnd(err)
 				return
@@ -710,13 +771,31 @@ func (ms *m3uMediaStream) segmentDownloadWorker(num int) {
 		case <-ms.ctx.Done():
 			return
 		case task := <-ms.downTasks:
+			started := time.Now()
+			selfmon.DownloadQueueLag.Observe(started.Sub(task.appTime))
+			tracing.Segment(ms.streamName, task.seqNo, tracing.SpanQueued, task.appTime, started, nil, tracing.KeyRendition.String(ms.resolution))
 			segmentsDownloading := metrics.Census.IncSegmentsDownloading()
 			glog.V(model.INSANE).Infof("Worker %d got task to download: seqNo=%d downloading=%d url=%s", num, task.seqNo, segmentsDownloading, task.url)
-			started := time.Now()
 			go downloadSegment(&task, resultsChan)
 			res := <-resultsChan
 			segmentsDownloading = metrics.Census.SegmentDownloaded()
 			glog.V(model.INSANE).Infof("Worker %d FINISHED download task: seqNo=%d downloading=%d url=%s took=%s", num, task.seqNo, segmentsDownloading, task.url, time.Since(started))
+			if events.Enabled() {
+				ev := &events.Event{Type: events.SegmentDownloaded, Stream: ms.u.String(), SeqNo: int64(task.seqNo), Resolution: ms.resolution,
+					Bytes: res.bytes, Took: time.Since(started), PTS: res.startTime, Duration: res.duration}
+				if res.status != "200 OK" {
+					ev.Type = events.Error
+					ev.Error = res.status
+				}
+				events.Publish(ev)
+			}
+			var derr error
+			if res.status != "200 OK" {
+				ms.streamMetrics.DownloadError(ms.resolution)
+				derr = errors.New(res.status)
+			}
+			tracing.Segment(ms.streamName, task.seqNo, tracing.SpanDownload, started, time.Now(), derr, tracing.KeyRendition.String(ms.resolution),
+				tracing.KeyURL.String(task.url.String()), tracing.KeyBytes.Int(res.bytes), tracing.KeyStatus.String(res.status))
 			ms.downloadResults <- res
 			atomic.AddInt32(&ms.segmentsDownloaded, 1)
 		}
@@ -734,6 +813,7 @@ func (ms *m3uMediaStream) workerLoop(masterDR chan *downloadResult, latencyResul
 	var successRate float64
 	var downloadedSegmentsTotalDuration time.Duration
 	var firstSegmentPTS time.Duration = -1
+	var lastGapSeqNo uint64
 
 	for {
 		select {
@@ -755,6 +835,7 @@ func (ms *m3uMediaStream) workerLoop(masterDR chan *downloadResult, latencyResul
 				}
 				continue
 			}
+			atomic.StoreInt64(ms.lastDownloadedAt, time.Now().UnixNano())
 			dres.resolution = ms.resolution
 			if dres.duration > 0 {
 				downloadedSegmentsTotalDuration += dres.duration
@@ -805,6 +886,7 @@ func (ms *m3uMediaStream) workerLoop(masterDR chan *downloadResult, latencyResul
 					glog.V(model.VVERBOSE).Infof("Segment %s saved to %s", segFileName, fullpath)
 				}(segFileName, fullSegFileName, dres.data)
 			}
+			ms.artifacts.segment(ms.resolution, dres.name, dres.data)
 			dres.data = nil
 			dres.task = nil
 
@@ -821,10 +903,28 @@ func (ms *m3uMediaStream) workerLoop(masterDR chan *downloadResult, latencyResul
 			var latency time.Duration
 			var speedRatio float64
 			var merr error
-			if ms.segmentsMatcher != nil {
+			// timestamp embedded into the video gives true latency, matching
+			// by PTS is used for renditions not keeping it
+			matched := ms.segmentsMatcher != nil || !dres.sentAt.IsZero()
+			if !dres.sentAt.IsZero() {
+				latency, speedRatio = embeddedLatency(dres.sentAt, dres.duration, dres.downloadCompetedAt)
+			} else if ms.segmentsMatcher != nil {
 				latency, speedRatio, merr = ms.segmentsMatcher.matchSegment(dres.startTime, dres.duration, dres.downloadCompetedAt)
 			}
-			glog.V(model.DEBUG).Infof(`%s seqNo %4d name=%s latency is %s speedRatio is %v`, dres.resolution, dres.seqNo, dres.name, latency, speedRatio)
+			glog.V(model.DEBUG).Infof(`%s seqNo %4d name=%s latency is %s speedRatio is %v embedded=%v`, dres.resolution, dres.seqNo, dres.name, latency, speedRatio,
+				!dres.sentAt.IsZero())
+			if matched {
+				matchedFrom := dres.downloadCompetedAt.Add(-latency)
+				if merr != nil {
+					matchedFrom = dres.downloadCompetedAt
+				}
+				tracing.Segment(ms.streamName, dres.seqNo, tracing.SpanMatch, matchedFrom, dres.downloadCompetedAt, merr,
+					tracing.KeyRendition.String(ms.resolution), tracing.KeyLatency.Int64(latency.Milliseconds()), tracing.KeySpeedRatio.Float64(speedRatio))
+			}
+			if matched && merr == nil && events.Enabled() {
+				events.Publish(&events.Event{Type: events.LatencyMatched, Stream: ms.u.String(), SeqNo: int64(dres.seqNo), Resolution: ms.resolution,
+					PTS: dres.startTime, Duration: dres.duration, Latency: latency, SpeedRatio: speedRatio})
+			}
 			if merr != nil {
 				glog.Infof("downloaded: %+v, segment matching error %v", dres, merr)
 				// TODO investigate why getting this with Mist server
@@ -832,7 +932,7 @@ func (ms *m3uMediaStream) workerLoop(masterDR chan *downloadResult, latencyResul
 				// panic(merr)
 				continue
 			}
-			if ms.segmentsMatcher != nil {
+			if matched {
 				latencyResults <- &latencyResult{name: dres.name, resolution: ms.resolution, seqNo: dres.seqNo, latency: latency,
 					speedRatio: speedRatio, successRate: successRate}
 			}
@@ -872,6 +972,12 @@ func (ms *m3uMediaStream) workerLoop(masterDR chan *downloadResult, latencyResul
 				// }
 				if problem != "" && i < len(results)-6 {
 					fatalProblem = msg
+					if r.seqNo > lastGapSeqNo {
+						lastGapSeqNo = r.seqNo
+						ms.streamMetrics.Gap(ms.resolution)
+						events.Publish(&events.Event{Type: events.Gap, Stream: ms.u.String(), SeqNo: int64(r.seqNo), Resolution: ms.resolution,
+							PTS: r.startTime, Duration: r.duration, Took: tillNext - r.duration})
+					}
 					// break
 				}
 			}
@@ -927,6 +1033,7 @@ func (ms *m3uMediaStream) manifestPullerLoop(wowzaMode bool) {
 				return
 			}
 		}
+		fetchStarted := time.Now()
 		resp, err := httpClient.Do(uhttp.GetRequest(surl))
 		if err != nil {
 			if isRetryable(err) {
@@ -965,6 +1072,7 @@ func (ms *m3uMediaStream) manifestPullerLoop(wowzaMode bool) {
 			return
 		}
 		countResets = 0
+		ms.artifacts.playlist(ms.resolution, surl, b)
 
 		gpl, plt, err := m3u8.Decode(*bytes.NewBuffer(b), true)
 		if err != nil {
@@ -983,6 +1091,10 @@ func (ms *m3uMediaStream) manifestPullerLoop(wowzaMode bool) {
 		}
 		glog.V(model.VVERBOSE).Infof("Got media playlist %s with %d (really %d (%d)) segments of url %s:", ms.resolution, len(pl.Segments), countSegments(pl), pl.Len(), surl)
 		glog.V(model.INSANE2).Info(string(b))
+		if events.Enabled() {
+			events.Publish(&events.Event{Type: events.PlaylistFetched, Stream: surl, Resolution: ms.resolution, SeqNo: int64(pl.SeqNo),
+				Bytes: len(b), Segments: countSegments(pl), Took: time.Since(fetchStarted)})
+		}
 		now := time.Now()
 		var lastTimeDownloadStarted time.Time
 		for i, segment := range pl.Segments {
@@ -1112,6 +1224,7 @@ func downloadSegment(task *downloadTask, res chan *downloadResult) {
 		}
 		// glog.Infof("Download %s result: %s len %d", fsurl, resp.Status, len(b))
 		fsttim, dur, verr := utils.GetVideoStartTimeAndDur(b)
+		sentAt, _ := sei.FromSegment(b)
 		if verr != nil {
 			msg := fmt.Sprintf("Error parsing video data %s result status %s video data len %d err %v",
 				fsurl, resp.Status, len(b), verr)
@@ -1134,7 +1247,7 @@ func downloadSegment(task *downloadTask, res chan *downloadResult) {
 		// glog.V(model.DEBUG).Infof("Download %s result: %s len %d timeStart %s segment duration %s", fsurl, resp.Status, len(b), fsttim, dur)
 		glog.V(model.DEBUG).Infof("Download %s result: %s len %d timeStart %s segment duration %s took=%s", fsurl, resp.Status, len(b), fsttim, dur, time.Since(start))
 		res <- &downloadResult{status: resp.Status, bytes: len(b), try: try, name: task.url.String(), seqNo: task.seqNo,
-			videoParseError: verr, startTime: fsttim, duration: dur, mySeqNo: task.mySeqNo, appTime: task.appTime, downloadCompetedAt: completedAt,
+			videoParseError: verr, startTime: fsttim, duration: dur, sentAt: sentAt, mySeqNo: task.mySeqNo, appTime: task.appTime, downloadCompetedAt: completedAt,
 			downloadStartedAt: start, data: b, task: task,
 		}
 		// glog.Infof("Download %s result: %s len %d timeStart %s segment duration %s sent to channel", fsurl, resp.Status, len(b), fsttim, dur)
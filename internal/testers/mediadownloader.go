@@ -19,6 +19,9 @@ import (
 
 	"github.com/golang/glog"
 	"github.com/livepeer/m3u8"
+	"github.com/livepeer/stream-tester/internal/events"
+	"github.com/livepeer/stream-tester/internal/sei"
+	"github.com/livepeer/stream-tester/internal/selfmon"
 	"github.com/livepeer/stream-tester/internal/utils"
 	"github.com/livepeer/stream-tester/internal/utils/uhttp"
 	"github.com/livepeer/stream-tester/messenger"
@@ -274,7 +277,14 @@ func (md *mediaDownloader) downloadSegment(task *downloadTask, res chan download
 		}
 		if md.segmentsMatcher != nil {
 			if verr == nil {
-				latency, speedRatio, merr := md.segmentsMatcher.matchSegment(fsttim, dur, now)
+				var latency time.Duration
+				var speedRatio float64
+				var merr error
+				if sentAt, ok := sei.FromSegment(b); ok {
+					latency, speedRatio = embeddedLatency(sentAt, dur, now)
+				} else {
+					latency, speedRatio, merr = md.segmentsMatcher.matchSegment(fsttim, dur, now)
+				}
 				src := "    source"
 				if !md.source {
 					src = "transcoded"
@@ -387,6 +397,7 @@ func (md *mediaDownloader) segmentDownloadWorker(num int, overallResultsChan cha
 		case <-md.ctx.Done():
 			return
 		case task := <-md.downTasks:
+			selfmon.DownloadQueueLag.Observe(time.Since(task.appTime))
 			glog.V(model.VERBOSE).Infof("Worker %d got task to download: seqNo=%d url=%s", num, task.seqNo, task.url)
 			go md.downloadSegment(&task, resultsChan)
 			res := <-resultsChan
@@ -427,6 +438,15 @@ func (md *mediaDownloader) workerLoop(resultsChan chan downloadResult) {
 				md.stats.errors[res.status] = md.stats.errors[res.status] + 1
 			}
 			md.mu.Unlock()
+			if events.Enabled() {
+				ev := &events.Event{Type: events.SegmentDownloaded, Stream: md.suri, SeqNo: int64(res.seqNo), Resolution: md.resolution,
+					Bytes: res.bytes}
+				if res.status != "200 OK" {
+					ev.Type = events.Error
+					ev.Error = res.status
+				}
+				events.Publish(ev)
+			}
 		}
 	}
 }
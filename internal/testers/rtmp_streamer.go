@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"sync"
 	"time"
 
 	"net"
@@ -14,7 +15,10 @@ import (
 	"github.com/livepeer/joy4/av/avutil"
 	"github.com/livepeer/joy4/av/pktque"
 	"github.com/livepeer/joy4/format/rtmp"
+	"github.com/livepeer/stream-tester/internal/events"
 	"github.com/livepeer/stream-tester/internal/metrics"
+	"github.com/livepeer/stream-tester/internal/sei"
+	"github.com/livepeer/stream-tester/internal/selfmon"
 	"github.com/livepeer/stream-tester/internal/utils"
 	"github.com/livepeer/stream-tester/messenger"
 	"github.com/livepeer/stream-tester/model"
@@ -31,6 +35,13 @@ func (re *RTMPError) Error() string {
 
 var segLen = 2 * time.Second
 
+// EmbedTimestamps puts wall-clock time of sending into every video key frame
+// as SEI message, so latency can be measured on any rendition keeping it,
+// regardless of the timestamps rewritten by the media server. Off unless
+// enabled by the tester (`-sei-timestamps` flag), so other binaries send
+// the video unmodified
+var EmbedTimestamps = false
+
 // rtmpStreamer streams one video file to RTMP server
 type rtmpStreamer struct {
 	finite
@@ -46,6 +57,10 @@ type rtmpStreamer struct {
 	hasBar          bool
 	started         time.Time
 	err             error
+	mu              sync.Mutex
+	conn            *rtmp.Conn
+	dropped         bool
+	pausedUntil     time.Time
 }
 
 // IRTMPStreamer public interface
@@ -190,6 +205,11 @@ func (rs *rtmpStreamer) StartUpload(fn, rtmpURL string, streamDuration, waitForT
 	defer func() {
 		rs.active = false
 		rs.cancel()
+		fe := &events.Event{Type: events.StreamFinished, Stream: rtmpURL, SeqNo: int64(rs.counter.segments)}
+		if rs.err != nil {
+			fe.Error = rs.err.Error()
+		}
+		events.Publish(fe)
 	}()
 
 	started := time.Now()
@@ -201,6 +221,7 @@ func (rs *rtmpStreamer) StartUpload(fn, rtmpURL string, streamDuration, waitForT
 				if time.Since(started) > waitForTarget {
 					msg := fmt.Sprintf(`Can't connect to %s for %s`, rtmpURL, waitForTarget)
 					rs.err = &RTMPError{Msg: msg, Err: err}
+					events.Publish(&events.Event{Type: events.Error, Stream: rtmpURL, Error: msg})
 
 					fmt.Println(msg)
 					messenger.SendFatalMessage(msg)
@@ -217,12 +238,22 @@ func (rs *rtmpStreamer) StartUpload(fn, rtmpURL string, streamDuration, waitForT
 		}
 		break
 	}
+	rs.mu.Lock()
+	rs.conn = conn
+	dropped := rs.dropped
+	rs.mu.Unlock()
+	if dropped {
+		conn.NetConn().Close()
+	}
 
 	var onError = func(err error) {
 		msg := fmt.Sprintf("onError finishing upload to %s after %s: %v", rtmpURL, time.Since(started), err)
 		rs.err = &RTMPError{Msg: msg, Err: err}
+		events.Publish(&events.Event{Type: events.Error, Stream: rtmpURL, Error: msg})
 
-		messenger.SendFatalMessage(msg)
+		if !rs.isDropped() {
+			messenger.SendFatalMessage(msg)
+		}
 		glog.Error(msg)
 		rs.connectionLost = true
 		rs.file.Close()
@@ -249,6 +280,9 @@ func (rs *rtmpStreamer) StartUpload(fn, rtmpURL string, streamDuration, waitForT
 		return
 	}
 	metrics.StartStream()
+	// to measure how far sending falls behind real time
+	var sendStarted time.Time
+	var firstPacketTime time.Duration
 outloop:
 	for {
 		lastSegments := 0
@@ -264,6 +298,16 @@ outloop:
 				break outloop
 			default:
 			}
+			if pause := rs.pausedFor(); pause > 0 {
+				glog.V(model.DEBUG).Infof("Pausing upload to %s for %s", rtmpURL, pause)
+				select {
+				case <-rs.ctx.Done():
+					break outloop
+				case <-time.After(pause):
+				}
+				// lag caused by the pause is not tester's
+				sendStarted = sendStarted.Add(pause)
+			}
 			var pkt av.Packet
 			// glog.Infof("Reading packet %d", packetIdx)
 			if pkt, err = demuxer.ReadPacket(); err != nil {
@@ -297,12 +341,20 @@ outloop:
 				break outloop
 			}
 			start := time.Now()
+			if EmbedTimestamps && pkt.Idx == videoidx && pkt.IsKeyFrame {
+				pkt.Data = sei.Inject(pkt.Data, start)
+			}
 			if err = conn.WritePacket(pkt); err != nil {
 				onError(err)
 				metrics.StopStream(false)
 				return
 			}
 			took := time.Since(start)
+			if sendStarted.IsZero() {
+				sendStarted, firstPacketTime = start, pkt.Time
+			} else {
+				selfmon.SendLag.Observe(time.Since(sendStarted.Add(pkt.Time - firstPacketTime)))
+			}
 			if rs.segmentsMatcher != nil && pkt.Idx == videoidx {
 				if pkt.IsKeyFrame {
 					glog.V(model.INSANE).Infof("video key frame %s", pkt.Time)
@@ -322,6 +374,7 @@ outloop:
 				// glog.Infof("packet %d rs.counter.segments: %d currentSegments: %d rs.skippedSegments: %d segmentsToStream: %d", packetIdx, rs.counter.segments, rs.counter.currentSegments, rs.skippedSegments, segmentsToStream)
 				// fmt.Printf("rs.counter.segments: %d currentSegments: %d rs.skippedSegments: %d segmentsToStream: %d\n\n", rs.counter.segments, rs.counter.currentSegments, rs.skippedSegments, segmentsToStream)
 				lastSegments = rs.counter.segments
+				events.Publish(&events.Event{Type: events.SegmentSent, Stream: rtmpURL, SeqNo: int64(lastSegments - 1), PTS: pkt.Time})
 			}
 			packetIdx++
 		}
@@ -371,3 +424,36 @@ outloop:
 func (rs *rtmpStreamer) closeDone() {
 	rs.cancel()
 }
+
+// dropConnection abruptly closes RTMP connection, as if network failed.
+// Upload ends with error, but no fatal message is sent about it
+func (rs *rtmpStreamer) dropConnection() {
+	rs.mu.Lock()
+	rs.dropped = true
+	conn := rs.conn
+	rs.mu.Unlock()
+	if conn != nil {
+		conn.NetConn().Close()
+	}
+}
+
+func (rs *rtmpStreamer) isDropped() bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.dropped
+}
+
+// pause stops sending media for the duration, keeping connection open.
+// Media is sent as fast as possible after the pause, until it catches up
+// with the wall time
+func (rs *rtmpStreamer) pause(d time.Duration) {
+	rs.mu.Lock()
+	rs.pausedUntil = time.Now().Add(d)
+	rs.mu.Unlock()
+}
+
+func (rs *rtmpStreamer) pausedFor() time.Duration {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return time.Until(rs.pausedUntil)
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/livepeer/joy4/av/pktque"
 	"github.com/livepeer/joy4/format/ts"
 	"github.com/livepeer/joy4/jerrors"
+	"github.com/livepeer/stream-tester/internal/sei"
 	"github.com/livepeer/stream-tester/model"
 )
 
@@ -183,6 +184,9 @@ func segmentingLoop(ctx context.Context, fileName string, inFileReal av.DemuxClo
 				}
 				return rerr
 			}
+			if EmbedTimestamps && pkt.IsKeyFrame && streamTypes[pkt.Idx] == "video" {
+				pkt.Data = sei.Inject(pkt.Data, time.Now())
+			}
 			lastPacket = pkt
 
 			// fmt.Printf("Packet Is Keyframe %v Is Audio %v Is Video %v PTS %s\n", pkt.IsKeyFrame, pkt.Idx == audioidx, pkt.Idx == videoidx, pkt.Time)
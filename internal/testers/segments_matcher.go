@@ -126,6 +126,18 @@ func (sm *segmentsMatcher) matchSegment(firstPaketsPTS time.Duration, segmentDur
 	return latency, float64(latency) / float64(segmentDuration), nil
 }
 
+// embeddedLatency returns latency and speed ratio of the received segment
+// from the wall-clock time embedded into its first key frame, counting from
+// the moment the last frame of the segment was sent
+func embeddedLatency(sentAt time.Time, segmentDuration time.Duration, receivedAt time.Time) (time.Duration, float64) {
+	latency := receivedAt.Sub(sentAt.Add(segmentDuration))
+	var speedRatio float64
+	if segmentDuration > 0 {
+		speedRatio = float64(latency) / float64(segmentDuration)
+	}
+	return latency, speedRatio
+}
+
 func (sm *segmentsMatcher) cleanup() {
 	glog.V(model.VVERBOSE).Infof(`segments matcher cleanup start len %d`, len(sm.sentFrames))
 	// sm.mu.Lock()
@@ -15,6 +15,7 @@ import (
 
 	"github.com/livepeer/stream-tester/apis/livepeer"
 	"github.com/livepeer/stream-tester/apis/mist"
+	"github.com/livepeer/stream-tester/internal/selfmon"
 	"github.com/livepeer/stream-tester/internal/utils"
 	"github.com/livepeer/stream-tester/messenger"
 	"github.com/livepeer/stream-tester/model"
@@ -44,17 +45,20 @@ type streamer struct {
 	lapiPlayback        string
 	createdMistStreams  []string
 	createdLAPIStreams  []string
+	profilesNum         int
 }
 
-// NewStreamer returns new streamer
-func NewStreamer(ctx context.Context, cancel context.CancelFunc, wowzaMode, mistMode bool, mapi *mist.API, lapi *livepeer.API) model.Streamer {
+// NewStreamer returns new streamer. profilesNum is number of transcoding
+// profiles streams are expected to have
+func NewStreamer(ctx context.Context, cancel context.CancelFunc, wowzaMode, mistMode bool, mapi *mist.API, lapi *livepeer.API, profilesNum int) model.Streamer {
 	str := &streamer{
-		ctx:       ctx,
-		cancel:    cancel,
-		wowzaMode: wowzaMode,
-		mistMode:  mistMode,
-		mapi:      mapi,
-		lapi:      lapi,
+		ctx:         ctx,
+		cancel:      cancel,
+		wowzaMode:   wowzaMode,
+		mistMode:    mistMode,
+		mapi:        mapi,
+		lapi:        lapi,
+		profilesNum: profilesNum,
 	}
 	if lapi != nil {
 		ingests, err := lapi.Ingest(false)
@@ -275,6 +279,7 @@ func (sr *streamer) startStreams(baseManfistID, sourceFileName string, repeatNum
 			}()
 			sr.uploaders = append(sr.uploaders, up)
 			down := newM3UTester(ctx, sentTimesMap, sr.wowzaMode, sr.mistMode, false, false, saveDownloaded, segmentsMatcher, nil, "")
+			down.profilesNum = sr.profilesNum
 			go findSkippedSegmentsNumber(up, down)
 			sr.downloaders = append(sr.downloaders, down)
 			down.Start(mediaURL)
@@ -382,9 +387,10 @@ func (sr *streamer) Stats(basedManifestID string) (*model.Stats, error) {
 		TotalSegmentsToSend: sr.totalSegmentsToSend,
 		Finished:            true,
 		WowzaMode:           sr.wowzaMode,
+		TesterLoad:          selfmon.Report(),
 	}
-	sourceLatencies := utils.LatenciesCalculator{}
-	transcodedLatencies := utils.LatenciesCalculator{}
+	sourceLatencies := &model.Histogram{}
+	transcodedLatencies := &model.Histogram{}
 	found := false
 	for i, rs := range sr.uploaders {
 		if basedManifestID != "" && rs.baseManifestID != basedManifestID {
@@ -417,15 +423,14 @@ func (sr *streamer) Stats(basedManifestID string) (*model.Stats, error) {
 		if mt.segmentsMatcher != nil {
 			for _, md := range mt.downloads {
 				md.mu.Lock()
-				lcp := make([]time.Duration, len(md.latencies), len(md.latencies))
-				copy(lcp, md.latencies)
 				if md.source {
-					sourceLatencies.Add(lcp)
+					utils.RecordLatencies(sourceLatencies, md.latencies)
 				} else {
-					transcodedLatencies.Add(lcp)
+					utils.RecordLatencies(transcodedLatencies, md.latencies)
 					lcp := make([]time.Duration, len(md.latenciesPerStream))
 					copy(lcp, md.latenciesPerStream)
 					stats.RawTranscodeLatenciesPerStream = append(stats.RawTranscodeLatenciesPerStream, lcp)
+					stats.RawTranscodeLatenciesNames = append(stats.RawTranscodeLatenciesNames, fmt.Sprintf("stream %d %s", i, md.resolution))
 				}
 				md.mu.Unlock()
 			}
@@ -492,26 +497,24 @@ func (sr *streamer) Stats(basedManifestID string) (*model.Stats, error) {
 	}
 	// glog.Infof("=== source latencies: %+v", sourceLatencies)
 	// glog.Infof("=== transcoded latencies: %+v", transcodedLatencies)
-	sourceLatencies.Prepare()
-	transcodedLatencies.Prepare()
-	avg, p50, p95, p99 := sourceLatencies.Calc()
-	stats.SourceLatencies = model.Latencies{Avg: avg, P50: p50, P95: p95, P99: p99}
-	avg, p50, p95, p99 = transcodedLatencies.Calc()
-	stats.TranscodedLatencies = model.Latencies{Avg: avg, P50: p50, P95: p95, P99: p99}
+	stats.SourceLatencies = sourceLatencies.Latencies()
+	stats.TranscodedLatencies = transcodedLatencies.Latencies()
+	stats.SourceLatencyHistogram = sourceLatencies
+	stats.TranscodedLatencyHistogram = transcodedLatencies
+	stats.RawSourceLatencies = sourceLatencies.Values(model.RawLatenciesMax)
+	stats.RawTranscodedLatencies = transcodedLatencies.Values(model.RawLatenciesMax)
 	if stats.SentSegments > 0 {
-		stats.SuccessRate = float64(stats.DownloadedSegments) / ((float64(model.ProfilesNum) + 1) * float64(stats.SentSegments)) * 100
+		stats.SuccessRate = float64(stats.DownloadedSegments) / ((float64(sr.profilesNum) + 1) * float64(stats.SentSegments)) * 100
 	}
 	if stats.SentKeyFrames > 0 && stats.DownloadedSourceSegments > 0 {
-		// stats.SuccessRate2 = float64(stats.DownloadedKeyFrames) / ((float64(model.ProfilesNum) + 1) * float64(stats.SentKeyFrames)) * 100
-		stats.SuccessRate2 = float64(stats.DownloadedKeyFrames) / float64(stats.SentKeyFrames) * (float64(stats.DownloadedTranscodedSegments+stats.DownloadedSourceSegments) / float64(stats.DownloadedSourceSegments*(model.ProfilesNum+1))) * 100
+		// stats.SuccessRate2 = float64(stats.DownloadedKeyFrames) / ((float64(sr.profilesNum) + 1) * float64(stats.SentKeyFrames)) * 100
+		stats.SuccessRate2 = float64(stats.DownloadedKeyFrames) / float64(stats.SentKeyFrames) * (float64(stats.DownloadedTranscodedSegments+stats.DownloadedSourceSegments) / float64(stats.DownloadedSourceSegments*(sr.profilesNum+1))) * 100
 		if sr.mistMode {
 			stats.SuccessRate = stats.SuccessRate2
 		}
 	}
-	stats.ShouldHaveDownloadedSegments = (model.ProfilesNum + 1) * stats.SentSegments
-	stats.ProfilesNum = model.ProfilesNum
-	stats.RawSourceLatencies = sourceLatencies.Raw()
-	stats.RawTranscodedLatencies = transcodedLatencies.Raw()
+	stats.ShouldHaveDownloadedSegments = (sr.profilesNum + 1) * stats.SentSegments
+	stats.ProfilesNum = sr.profilesNum
 	return stats, nil
 }
 
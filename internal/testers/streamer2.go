@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
@@ -39,6 +40,11 @@ type (
 		downloader      *m3utester2
 		additionalTests []StartTestFunc
 		err             error
+		// used to restart upload
+		mu                          sync.Mutex
+		sourceFileName, ingestURL   string
+		waitForTarget, timeToStream time.Duration
+		sm                          *segmentsMatcher
 	}
 )
 
@@ -83,12 +89,17 @@ func (sr *streamer2) StartStreaming(sourceFileName, rtmpIngestURL, mediaURL stri
 
 	sm := newSegmentsMatcher()
 	// sr.uploader = newRtmpStreamer(rtmpIngestURL, sourceFileName, nil, nil, sr.eof, sr.wowzaMode)
+	sr.mu.Lock()
 	sr.uploader = newRtmpStreamer(sr.ctx, rtmpIngestURL, sourceFileName, sourceFileName, nil, nil, false, sm)
+	sr.sourceFileName, sr.ingestURL = sourceFileName, rtmpIngestURL
+	sr.waitForTarget, sr.timeToStream, sr.sm = waitForTarget, timeToStream, sm
+	uploader := sr.uploader
+	sr.mu.Unlock()
 	// if timeToStream == 0 {
 	// 	timeToStream = -1
 	// }
 	go func() {
-		sr.uploader.StartUpload(sourceFileName, rtmpIngestURL, timeToStream, waitForTarget)
+		uploader.StartUpload(sourceFileName, rtmpIngestURL, timeToStream, waitForTarget)
 	}()
 	sr.downloader = newM3utester2(sr.ctx, mediaURL, sr.WowzaMode, sr.MistMode,
 		sr.FailIfTranscodingStops, sr.Save, sr.PrintStats, waitForTarget, sm, false) // starts to download at creation
@@ -122,9 +133,19 @@ func (sr *streamer2) StartStreaming(sourceFileName, rtmpIngestURL, mediaURL stri
 		}
 	}()
 	started := time.Now()
-	<-sr.uploader.Done()
-	if sr.uploader.Err() != nil {
-		sr.err = sr.uploader.Err()
+	for {
+		<-uploader.Done()
+		sr.mu.Lock()
+		next := sr.uploader
+		sr.mu.Unlock()
+		if next == uploader {
+			break
+		}
+		// upload was restarted, keep going with the new one
+		uploader = next
+	}
+	if uploader.Err() != nil {
+		sr.err = uploader.Err()
 	}
 	msg := fmt.Sprintf(`Streaming stopped after %s err=%v`, time.Since(started), sr.err)
 	if messengerStats {
@@ -133,6 +154,51 @@ func (sr *streamer2) StartStreaming(sourceFileName, rtmpIngestURL, mediaURL stri
 	sr.cancel()
 }
 
+// DropConnection abruptly closes RTMP connection, ending the stream
+func (sr *streamer2) DropConnection() {
+	sr.mu.Lock()
+	uploader := sr.uploader
+	sr.mu.Unlock()
+	if uploader != nil {
+		uploader.dropConnection()
+	}
+}
+
+// PauseUpload stops sending media for the duration, keeping RTMP connection open
+func (sr *streamer2) PauseUpload(d time.Duration) {
+	sr.mu.Lock()
+	uploader := sr.uploader
+	sr.mu.Unlock()
+	if uploader != nil {
+		uploader.pause(d)
+	}
+}
+
+// Restart abruptly closes RTMP connection and starts streaming again to the
+// same ingest URL (so with the same stream key). Stream is kept being read
+// back from the same media URL
+func (sr *streamer2) Restart() {
+	sr.mu.Lock()
+	old := sr.uploader
+	if old == nil || sr.Finished() {
+		sr.mu.Unlock()
+		return
+	}
+	sr.uploader = newRtmpStreamer(sr.ctx, sr.ingestURL, sr.sourceFileName, sr.sourceFileName, nil, nil, false, sr.sm)
+	uploader := sr.uploader
+	sr.mu.Unlock()
+	old.dropConnection()
+	go uploader.StartUpload(sr.sourceFileName, sr.ingestURL, sr.timeToStream, sr.waitForTarget)
+}
+
+// LastDownloaded returns time segment of the stream was downloaded last
+func (sr *streamer2) LastDownloaded() time.Time {
+	if sr.downloader == nil {
+		return time.Time{}
+	}
+	return sr.downloader.lastDownloaded()
+}
+
 func onAnyDone(ctx context.Context, finites []Finite) <-chan Finite {
 	finished := make(chan Finite, len(finites))
 	for _, f := range finites {
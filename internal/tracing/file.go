@@ -0,0 +1,92 @@
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+type (
+	// fileExporter writes spans as JSON, one span per line
+	fileExporter struct {
+		mu     sync.Mutex
+		w      *bufio.Writer
+		closer io.Closer
+	}
+
+	jsonSpan struct {
+		TraceID    string                 `json:"trace_id"`
+		SpanID     string                 `json:"span_id"`
+		ParentID   string                 `json:"parent_span_id,omitempty"`
+		Name       string                 `json:"name"`
+		Start      time.Time              `json:"start"`
+		End        time.Time              `json:"end"`
+		DurationMs float64                `json:"duration_ms"`
+		Attributes map[string]interface{} `json:"attributes,omitempty"`
+		Error      string                 `json:"error,omitempty"`
+	}
+)
+
+// newFileExporter creates exporter appending spans to the file
+func newFileExporter(fileName string) (sdktrace.SpanExporter, error) {
+	if fileName == "" {
+		fileName = "spans.jsonl"
+	}
+	f, err := os.OpenFile(fileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileExporter{w: bufio.NewWriter(f), closer: f}, nil
+}
+
+func newStdoutExporter(string) (sdktrace.SpanExporter, error) {
+	return &fileExporter{w: bufio.NewWriter(os.Stdout)}, nil
+}
+
+func (fe *fileExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	enc := json.NewEncoder(fe.w)
+	for _, span := range spans {
+		js := jsonSpan{
+			TraceID:    span.SpanContext().TraceID().String(),
+			SpanID:     span.SpanContext().SpanID().String(),
+			Name:       span.Name(),
+			Start:      span.StartTime(),
+			End:        span.EndTime(),
+			DurationMs: float64(span.EndTime().Sub(span.StartTime())) / float64(time.Millisecond),
+			Error:      span.Status().Description,
+		}
+		if parent := span.Parent(); parent.IsValid() {
+			js.ParentID = parent.SpanID().String()
+		}
+		if attrs := span.Attributes(); len(attrs) > 0 {
+			js.Attributes = make(map[string]interface{}, len(attrs))
+			for _, kv := range attrs {
+				js.Attributes[string(kv.Key)] = kv.Value.AsInterface()
+			}
+		}
+		if err := enc.Encode(&js); err != nil {
+			return err
+		}
+	}
+	return fe.w.Flush()
+}
+
+func (fe *fileExporter) Shutdown(ctx context.Context) error {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	if err := fe.w.Flush(); err != nil {
+		return err
+	}
+	if fe.closer != nil {
+		return fe.closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+// Package tracing emits OpenTelemetry spans for the lifecycle of the media
+// segments: HTTP push, appearance in the playlist, download and matching
+// against the sent frames. All the spans of the segment belong to the same
+// trace, identified by the stream name and the segment's sequence number, so
+// they can be viewed as end-to-end latency waterfall even if they are emitted
+// by different parts of the tester.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Span names
+const (
+	// SpanPush segment pushed to the HTTP ingest
+	SpanPush = "segment.push"
+	// SpanQueued segment appeared in the playlist and waits for download
+	SpanQueued = "segment.queued"
+	// SpanDownload segment is being downloaded
+	SpanDownload = "segment.download"
+	// SpanMatch from the last frame of the segment sent by the RTMP streamer
+	// till the segment is downloaded back
+	SpanMatch = "segment.match"
+)
+
+// Attribute keys
+const (
+	KeyStream     = attribute.Key("stream")
+	KeySeqNo      = attribute.Key("seq_no")
+	KeyRendition  = attribute.Key("rendition")
+	KeyURL        = attribute.Key("url")
+	KeyBytes      = attribute.Key("bytes")
+	KeyStatus     = attribute.Key("status")
+	KeyLatency    = attribute.Key("latency_ms")
+	KeySpeedRatio = attribute.Key("speed_ratio")
+)
+
+// ExporterFactory creates span exporter. Arg is the part of the exporter spec
+// after the colon
+type ExporterFactory func(arg string) (sdktrace.SpanExporter, error)
+
+type segmentKey struct{}
+
+// segmentIDs generates trace ID from the stream name and segment's sequence
+// number put into context by Segment, and random span IDs
+type segmentIDs struct{}
+
+var (
+	exportersMu sync.Mutex
+	exporters   = map[string]ExporterFactory{
+		"file":   newFileExporter,
+		"stdout": newStdoutExporter,
+	}
+
+	provider *sdktrace.TracerProvider
+	tracer   trace.Tracer = trace.NewNoopTracerProvider().Tracer("")
+)
+
+// RegisterExporter makes exporter available to Init under the name
+func RegisterExporter(name string, factory ExporterFactory) {
+	exportersMu.Lock()
+	exporters[name] = factory
+	exportersMu.Unlock()
+}
+
+// Exporters returns names of the registered exporters
+func Exporters() []string {
+	exportersMu.Lock()
+	defer exportersMu.Unlock()
+	var names []string
+	for name := range exporters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Init starts exporting spans to the exporter specified as `name[:arg]`, for
+// example `file:spans.jsonl` or `stdout`. Spans are not recorded if Init is
+// not called
+func Init(spec, service string) error {
+	name, arg := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		name, arg = spec[:i], spec[i+1:]
+	}
+	exportersMu.Lock()
+	factory := exporters[name]
+	exportersMu.Unlock()
+	if factory == nil {
+		return fmt.Errorf("unknown trace exporter %q, supported are %s", name, strings.Join(Exporters(), ", "))
+	}
+	exp, err := factory(arg)
+	if err != nil {
+		return fmt.Errorf("error creating trace exporter %q: %w", spec, err)
+	}
+	provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp, sdktrace.WithBatchTimeout(time.Second)),
+		sdktrace.WithIDGenerator(segmentIDs{}),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", service))),
+	)
+	tracer = provider.Tracer("github.com/livepeer/stream-tester")
+	glog.Infof("Exporting segment traces to %s", spec)
+	return nil
+}
+
+// Shutdown flushes recorded spans and stops exporting
+func Shutdown(ctx context.Context) error {
+	if provider == nil {
+		return nil
+	}
+	return provider.Shutdown(ctx)
+}
+
+// Enabled returns true if spans are exported
+func Enabled() bool {
+	return provider != nil
+}
+
+// Segment records span of the segment's lifecycle which took place between
+// start and end. Non-nil err marks span as failed
+func Segment(stream string, seqNo uint64, name string, start, end time.Time, err error, attrs ...attribute.KeyValue) {
+	if provider == nil {
+		return
+	}
+	ctx := context.WithValue(context.Background(), segmentKey{}, SegmentTraceID(stream, seqNo))
+	attrs = append(attrs, KeyStream.String(stream), KeySeqNo.Int64(int64(seqNo)))
+	_, span := tracer.Start(ctx, name, trace.WithTimestamp(start), trace.WithAttributes(attrs...))
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End(trace.WithTimestamp(end))
+}
+
+// SegmentTraceID returns ID of the trace of the segment
+func SegmentTraceID(stream string, seqNo uint64) trace.TraceID {
+	h := sha256.New()
+	h.Write([]byte(stream))
+	binary.Write(h, binary.BigEndian, seqNo)
+	var id trace.TraceID
+	copy(id[:], h.Sum(nil))
+	return id
+}
+
+func (segmentIDs) NewIDs(ctx context.Context) (trace.TraceID, trace.SpanID) {
+	traceID, ok := ctx.Value(segmentKey{}).(trace.TraceID)
+	if !ok {
+		rand.Read(traceID[:])
+	}
+	return traceID, newSpanID()
+}
+
+func (segmentIDs) NewSpanID(ctx context.Context, traceID trace.TraceID) trace.SpanID {
+	return newSpanID()
+}
+
+func newSpanID() trace.SpanID {
+	var id trace.SpanID
+	rand.Read(id[:])
+	return id
+}
@@ -3,6 +3,7 @@ package messenger
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -62,7 +63,44 @@ func AddBotCommand(name string, handler exrouter.HandlerFunc) *exrouter.Route {
 	return nil
 }
 
-func startBot(ctx context.Context, botToken, channelID, lapiToken string) {
+// AddPrivilegedBotCommand registers a handler of the command that changes
+// state of the testers or alerting. Such commands are accepted only in the
+// configured bot's channels (not in direct messages or other channels) and,
+// if admin roles are configured, only from members having one of them
+func AddPrivilegedBotCommand(name string, handler exrouter.HandlerFunc) *exrouter.Route {
+	return AddBotCommand(name, func(ctx *exrouter.Context) {
+		if err := commandAllowed(ctx.Msg, gbot.channelID, botAdminRoles); err != nil {
+			glog.Warningf("Rejected bot command %q from %s: %v", name, ctx.Msg.Author.Username, err)
+			ctx.Reply(err.Error())
+			return
+		}
+		handler(ctx)
+	})
+}
+
+// commandAllowed checks if privileged command can be run from the message
+func commandAllowed(msg *discordgo.Message, channels, roles []string) error {
+	if msg.GuildID == "" {
+		return errors.New("this command can't be used in direct messages")
+	}
+	if !utils.StringsSliceContains(channels, msg.ChannelID) {
+		return errors.New("this command can't be used in this channel")
+	}
+	if len(roles) == 0 {
+		return nil
+	}
+	if msg.Member != nil {
+		for _, role := range msg.Member.Roles {
+			if utils.StringsSliceContains(roles, role) {
+				return nil
+			}
+		}
+	}
+	return errors.New("you don't have a role allowed to use this command")
+}
+
+// newBot creates the bot, so commands can be added before it is started
+func newBot(ctx context.Context, botToken, channelID, lapiToken string) *discordBot {
 	sess, err := discordgo.New("Bot " + botToken)
 	glog.Infof("bog token is '%s'", botToken)
 	if err != nil {
@@ -72,12 +110,12 @@ func startBot(ctx context.Context, botToken, channelID, lapiToken string) {
 	bot := discordBot{
 		ctx:       ctx,
 		sess:      sess,
-		channelID: strings.Split(channelID, ","),
+		channelID: splitList(channelID),
 		lapiToken: lapiToken,
 	}
 	gbot = &bot
 	bot.init()
-	bot.start()
+	return gbot
 }
 
 func (bot *discordBot) init() {
@@ -389,6 +427,17 @@ func (bot *discordBot) bstreams(ctx *exrouter.Context) {
 	}
 }
 
+// splitList splits comma separated list, skipping empty items
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func burlExt2Int(uri string) string {
 	pu, _ := url.Parse(uri)
 	hp := strings.Split(pu.Hostname(), ".")
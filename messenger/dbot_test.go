@@ -0,0 +1,46 @@
+package messenger
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestCommandAllowed(t *testing.T) {
+	channels, roles := []string{"chan1"}, []string{"admin"}
+	member := func(roles ...string) *discordgo.Member {
+		return &discordgo.Member{Roles: roles}
+	}
+	cases := []struct {
+		name     string
+		msg      *discordgo.Message
+		channels []string
+		roles    []string
+		allowed  bool
+	}{
+		{"direct message", &discordgo.Message{ChannelID: "chan1"}, channels, nil, false},
+		{"direct message without channels", &discordgo.Message{ChannelID: "dm"}, nil, nil, false},
+		{"bot channel", &discordgo.Message{GuildID: "g", ChannelID: "chan1"}, channels, nil, true},
+		{"other channel", &discordgo.Message{GuildID: "g", ChannelID: "chan2"}, channels, nil, false},
+		{"no channels configured", &discordgo.Message{GuildID: "g", ChannelID: "chan2"}, nil, nil, false},
+		{"admin", &discordgo.Message{GuildID: "g", ChannelID: "chan1", Member: member("user", "admin")}, channels, roles, true},
+		{"not admin", &discordgo.Message{GuildID: "g", ChannelID: "chan1", Member: member("user")}, channels, roles, false},
+		{"no member", &discordgo.Message{GuildID: "g", ChannelID: "chan1"}, channels, roles, false},
+		{"admin in other channel", &discordgo.Message{GuildID: "g", ChannelID: "chan2", Member: member("admin")}, channels, roles, false},
+	}
+	for _, c := range cases {
+		err := commandAllowed(c.msg, c.channels, c.roles)
+		if (err == nil) != c.allowed {
+			t.Errorf("%s: allowed=%v, error %v", c.name, c.allowed, err)
+		}
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	if l := splitList(""); l != nil {
+		t.Errorf("empty list split to %q", l)
+	}
+	if l := splitList(" a,,b "); len(l) != 2 || l[0] != "a" || l[1] != "b" {
+		t.Errorf("list split to %q", l)
+	}
+}
@@ -0,0 +1,88 @@
+package messenger
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// maximum number of embeds in one Discord message
+const maxDiscordEmbeds = 10
+
+type (
+	discordNotifier struct {
+		q             *webhookQueue
+		userName      string
+		usersToNotify string
+	}
+
+	discordMessage struct {
+		Content  string          `json:"content,omitempty"`
+		UserName string          `json:"username,omitempty"`
+		Embeds   []*DiscordEmbed `json:"embeds,omitempty"`
+	}
+)
+
+// NewDiscordNotifier returns notifier sending messages to Discord channel
+// through the webhook. usersToNotify are mentioned in fatal messages
+func NewDiscordNotifier(webhookURL, userName, usersToNotify string) Notifier {
+	header := http.Header{}
+	header.Add("X-RateLimit-Precision", "millisecond")
+	return &discordNotifier{
+		q:             newWebhookQueue("Discord", webhookURL, header, time.Millisecond),
+		userName:      userName,
+		usersToNotify: usersToNotify,
+	}
+}
+
+func (dn *discordNotifier) Name() string {
+	return "Discord"
+}
+
+func (dn *discordNotifier) Send(msg string, fatal bool) {
+	if fatal && dn.usersToNotify != "" {
+		msg = dn.usersToNotify + ": " + msg
+	}
+	parts := []string{msg}
+	if len(msg) > maxMessageLen {
+		parts = splitMessage(msg, maxMessageLen-10)
+	}
+	for _, part := range parts {
+		dn.send(&discordMessage{Content: fmt.Sprintf("`[%s]` %s", hostname, part), UserName: dn.userName})
+	}
+}
+
+func (dn *discordNotifier) SendRich(embeds []*DiscordEmbed, fatal bool) {
+	if fatal && dn.usersToNotify != "" {
+		mentioned := make([]*DiscordEmbed, len(embeds))
+		for i, em := range embeds {
+			emc := *em
+			if emc.Description != "" {
+				emc.Description = dn.usersToNotify + ": " + emc.Description
+			} else {
+				emc.Description = dn.usersToNotify
+			}
+			mentioned[i] = &emc
+		}
+		embeds = mentioned
+	}
+	for len(embeds) > 0 {
+		n := len(embeds)
+		if n > maxDiscordEmbeds {
+			n = maxDiscordEmbeds
+		}
+		dn.send(&discordMessage{Embeds: embeds[:n], UserName: dn.userName})
+		embeds = embeds[n:]
+	}
+}
+
+func (dn *discordNotifier) send(dm *discordMessage) {
+	data, _ := json.Marshal(dm)
+	if len(dm.Embeds) > 0 {
+		glog.Infof("raw message: %s", data)
+	}
+	dn.q.send(data)
+}
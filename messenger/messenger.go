@@ -1,22 +1,14 @@
 /*
-Package messenger sends messages to Discord channel
+Package messenger sends messages to Discord, Slack and generic JSON webhooks
 */
 package messenger
 
 import (
-	"bytes"
 	"context"
-	"fmt"
-	"io/ioutil"
-	"net/http"
 	"os"
-	"strconv"
 	"time"
 
-	"encoding/json"
-
 	"github.com/golang/glog"
-	"github.com/livepeer/stream-tester/model"
 	"github.com/patrickmn/go-cache"
 	"golang.org/x/text/message"
 )
@@ -24,21 +16,12 @@ import (
 const maxMessageLen = 2000
 
 var (
-	webhookURL    string
-	userName      string
-	usersToNotify string
-	debounceCache *cache.Cache = cache.New(5*time.Minute, 30*time.Minute)
-	msgCh         chan []byte
+	debounceCache = cache.New(5*time.Minute, 30*time.Minute)
 	mp            = message.NewPrinter(message.MatchLanguage("en"))
 )
 
-type discordMessage struct {
-	Content  string          `json:"content,omitempty"`
-	UserName string          `json:"username,omitempty"`
-	Embeds   []*DiscordEmbed `json:"embeds,omitempty"`
-}
-
-// DiscordEmbed rich Discord message
+// DiscordEmbed rich message. Sent as is to Discord, mapped to the format of
+// other notifiers
 type DiscordEmbed struct {
 	Title       string `json:"title,omitempty"`
 	Description string `json:"description,omitempty"`
@@ -98,33 +81,26 @@ type discordField struct {
 	Inline bool   `json:"inline,omitempty"`
 }
 
-// Init ...
+// Init starts Discord bot (if token is specified) and sends messages to
+// Discord channel through the webhook (if URL is specified)
 func Init(ctx context.Context, WebhookURL, UserName, UsersToNotify, botToken, channelID, lapiToken string) {
 	// not tested yet
 	if botToken != "" {
-		go startBot(ctx, botToken, channelID, lapiToken)
+		go newBot(ctx, botToken, channelID, lapiToken).start()
 	}
-
-	webhookURL = WebhookURL
-	userName = UserName
-	usersToNotify = UsersToNotify
 	if WebhookURL != "" {
-		msgCh = make(chan []byte, 64)
-		go sendLoop()
+		AddNotifier(NewDiscordNotifier(WebhookURL, UserName, UsersToNotify))
 	}
 }
 
-// SendFatalMessage send message to Discord channel
-// and automatically mentiones UsersToNotify in the message
+// SendFatalMessage send message to all the notifiers
+// and automatically mentiones users to notify in the message
 func SendFatalMessage(msg string) {
 	glog.Error(msg)
-	if usersToNotify != "" {
-		msg = usersToNotify + ": " + msg
-	}
-	sendMessage(msg)
+	sendMessage(msg, true)
 }
 
-// SendMessageSlice send message to Discord channel
+// SendMessageSlice send message to all the notifiers
 func SendMessageSlice(msgs []string) {
 	if len(msgs) == 0 {
 		return
@@ -143,212 +119,59 @@ func SendMessageSlice(msgs []string) {
 	SendMessage(cmsg)
 }
 
-// SendMessage send message to Discord channel
+// SendMessage send message to all the notifiers
 func SendMessage(msg string) {
 	if msg == "" {
 		return
 	}
 	glog.Info(msg)
-	sendMessage(msg)
+	sendMessage(msg, false)
 }
 
-// SendCodeMessage send message to Discord channel, wrapping it as three ticks
+// SendCodeMessage send message to all the notifiers, wrapping it as three ticks
 func SendCodeMessage(msg string) {
 	if msg == "" {
 		return
 	}
 	glog.Info(msg)
-	sendMessage("```\n" + msg + "```")
+	sendMessage("```\n"+msg+"```", false)
 }
 
-// SendMessageDebounced send message to Discord channel
+// SendMessageDebounced send message to all the notifiers, unless same message
+// was sent recently
 func SendMessageDebounced(msg string) {
 	glog.Info(msg)
 	if _, has := debounceCache.Get(msg); !has {
-		sendMessage(msg)
+		sendMessage(msg, false)
 		debounceCache.SetDefault(msg, true)
 	}
 }
 
 // SendRichMessage sends rich message
 func SendRichMessage(embeds ...*DiscordEmbed) {
-	for len(embeds) > 10 {
-		SendRichMessage(embeds[:10]...)
-		embeds = embeds[10:]
-	}
-	raw := encodeEmbedsMessage(embeds)
-	glog.Infof("raw message: %s", raw)
-	sendRawMessage(raw)
+	sendRichMessage(embeds, false)
 }
 
 // SendFatalRichMessage sends rich message mentioning configured users
 func SendFatalRichMessage(embeds ...*DiscordEmbed) {
-	if usersToNotify == "" {
-		SendRichMessage(embeds...)
-		return
-	}
-	for _, em := range embeds {
-		if em.Description != "" {
-			em.Description = usersToNotify + ": " + em.Description
-		} else {
-			em.Description = usersToNotify
-		}
-	}
-	SendRichMessage(embeds...)
-}
-
-func sendLoop() {
-	var msgQueue [][]byte
-	var goodAfter time.Time
-	var headers http.Header
-	var status int
-	timer := time.NewTimer(2 * time.Second)
-	var step int
-	for {
-		glog.V(model.INSANE).Infof("====> sendLoop step %d queue len %d", step, len(msgQueue))
-		step++
-		select {
-		case <-timer.C:
-			if len(msgQueue) == 0 {
-				continue
-			}
-			msg := msgQueue[0]
-			status, headers = postMessage(msg)
-			if headers == nil || (status != http.StatusNoContent && status != http.StatusOK) {
-				// error possibly
-				// timer.Reset(2 * time.Second)
-				if headers != nil && status == http.StatusTooManyRequests {
-					rafters := headers.Get("Retry-After")
-					if rafters != "" {
-						rafter, _ := strconv.ParseInt(rafters, 10, 64)
-						timer = time.NewTimer(time.Duration(rafter) * time.Millisecond)
-						continue
-					}
-				}
-				if status == http.StatusBadRequest {
-					msgQueue = msgQueue[1:]
-				}
-				timer = time.NewTimer(2 * time.Second)
-				continue
-			}
-			msgQueue = msgQueue[1:]
-
-		case msg := <-msgCh:
-			if len(msgQueue) > 0 || time.Now().Before(goodAfter) {
-				msgQueue = append(msgQueue, msg)
-				if len(msgQueue) > 128 {
-					msgQueue = msgQueue[1:]
-				}
-				continue
-			}
-			status, headers = postMessage(msg)
-			if headers == nil || (status != http.StatusNoContent && status != http.StatusOK) {
-				// error possibly
-				msgQueue = append(msgQueue, msg)
-				if headers != nil && status == http.StatusTooManyRequests {
-					rafters := headers.Get("Retry-After")
-					if rafters != "" {
-						rafter, _ := strconv.ParseInt(rafters, 10, 64)
-						timer = time.NewTimer(time.Duration(rafter) * time.Millisecond)
-						continue
-					}
-				}
-				timer = time.NewTimer(2 * time.Second)
-				continue
-			}
-		}
-		rlrem := headers.Get("X-Ratelimit-Remaining")
-		if rlrem == "" { // shoudn't happen
-			continue
-		}
-		if rlrem == "0" {
-			rlreset := headers.Get("X-Ratelimit-Reset-After")
-			frlreset, err := strconv.ParseFloat(rlreset, 64)
-			if err != nil {
-				panic(err)
-			}
-			wait := time.Duration(frlreset*1000.0+100.0) * time.Millisecond
-			glog.V(model.VVERBOSE).Infof("Need wait %s", wait)
-			goodAfter = time.Now().Add(wait)
-			timer = time.NewTimer(wait)
-
-		} else if len(msgQueue) > 0 {
-			timer = time.NewTimer(50 * time.Millisecond)
-		}
-	}
+	sendRichMessage(embeds, true)
 }
 
 var hostname, _ = os.Hostname()
 
-func sendMessage(msg string) {
-	if len(msg) > maxMessageLen {
-		for {
-			l := maxMessageLen - 10
-			if l > len(msg) {
-				l = len(msg)
-			}
-			msg1 := msg[:l]
-			msg = msg[l:]
-			sendMessage(msg1)
-			if len(msg) == 0 {
-				break
-			}
-		}
-		return
+func sendMessage(msg string, fatal bool) {
+	for _, n := range Notifiers() {
+		n.Send(msg, fatal)
 	}
-	msg = fmt.Sprintf("`[%s]` %s", hostname, msg)
-	sendRawMessage(encodeMessage(msg))
 }
 
-func sendRawMessage(msg []byte) {
-	if webhookURL == "" || msgCh == nil {
+func sendRichMessage(embeds []*DiscordEmbed, fatal bool) {
+	if len(embeds) == 0 {
 		return
 	}
-	msgCh <- msg
-}
-
-func encodeMessage(msg string) []byte {
-	dm := &discordMessage{
-		Content:  msg,
-		UserName: userName,
-	}
-	data, _ := json.Marshal(dm)
-	return data
-}
-
-func encodeEmbedsMessage(embeds []*DiscordEmbed) []byte {
-	dm := &discordMessage{
-		Embeds:   embeds,
-		UserName: userName,
-	}
-	data, _ := json.Marshal(dm)
-	return data
-}
-
-func postMessage(msg []byte) (int, http.Header) {
-	if webhookURL == "" {
-		return 0, nil
-	}
-	body := bytes.NewReader(msg)
-	req, _ := http.NewRequest(http.MethodPost, webhookURL, body)
-	req.Header.Add("User-Agent", "stream-tester/"+model.Version)
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("X-RateLimit-Precision", "millisecond")
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		glog.Errorf("error posting to Discord err=%v", err)
-		return 0, nil
-	}
-	b, _ := ioutil.ReadAll(resp.Body)
-	resp.Body.Close()
-	glog.V(model.INSANE).Infof("Discord response headers")
-	for k, v := range resp.Header {
-		glog.V(model.INSANE).Infof("%s: %+v", k, v)
-	}
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
-		glog.Errorf("status error posting to Discord status=%s body: %s", resp.Status, string(b))
+	for _, n := range Notifiers() {
+		n.SendRich(embeds, fatal)
 	}
-	return resp.StatusCode, resp.Header
 }
 
 func successRate2Color(rate float64) uint32 {
@@ -0,0 +1,244 @@
+package messenger
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/stream-tester/model"
+)
+
+type (
+	// Notifier delivers messages to the chat or alerting system. Messages are
+	// queued, so methods do not block on network
+	Notifier interface {
+		// Name of the notifier's backend
+		Name() string
+		// Send sends text message. Fatal messages mention users configured
+		// to be notified
+		Send(msg string, fatal bool)
+		// SendRich sends rich messages, mapped to the backend's format
+		SendRich(embeds []*DiscordEmbed, fatal bool)
+	}
+
+	// Config configuration of the notifiers besides Discord, which is
+	// configured by Init
+	Config struct {
+		SlackURL           string
+		SlackUserName      string
+		SlackUsersToNotify string
+		WebhookURL         string
+		WebhookAuth        string
+		// BotAdminRoles comma separated IDs of Discord roles allowed to use
+		// bot commands that change state of the testers
+		BotAdminRoles string
+	}
+
+	// webhookQueue posts messages to the webhook one by one, retrying failed
+	// ones and respecting rate limits
+	webhookQueue struct {
+		name   string
+		url    string
+		header http.Header
+		// unit of Retry-After header returned with 429 status
+		retryAfterUnit time.Duration
+		msgCh          chan []byte
+	}
+)
+
+var (
+	notifiersMu sync.RWMutex
+	notifiers   []Notifier
+	// botAdminRoles roles allowed to use privileged bot commands, any
+	// member of the bot's channels can use them if empty
+	botAdminRoles []string
+)
+
+// AddNotifier makes messages to be sent to the notifier
+func AddNotifier(n Notifier) {
+	notifiersMu.Lock()
+	notifiers = append(notifiers, n)
+	notifiersMu.Unlock()
+	glog.Infof("Sending messages to %s", n.Name())
+}
+
+// Notifiers returns configured notifiers
+func Notifiers() []Notifier {
+	notifiersMu.RLock()
+	defer notifiersMu.RUnlock()
+	return append([]Notifier(nil), notifiers...)
+}
+
+// AddFlags adds flags configuring Slack and generic webhook notifiers
+func AddFlags(fs *flag.FlagSet) *Config {
+	cfg := &Config{}
+	fs.StringVar(&cfg.SlackURL, "slack-url", "", "URL of Slack's incoming webhook to send messages to Slack channel")
+	fs.StringVar(&cfg.SlackUserName, "slack-user-name", "", "User name to use when sending messages to Slack")
+	fs.StringVar(&cfg.SlackUsersToNotify, "slack-users", "", "Users to notify in Slack in case of failure (<@U012AB3CD>)")
+	fs.StringVar(&cfg.WebhookURL, "webhook-url", "", "URL to post messages to as JSON (for alert routers)")
+	fs.StringVar(&cfg.WebhookAuth, "webhook-auth", "", "Value of Authorization header sent to the webhook")
+	fs.StringVar(&cfg.BotAdminRoles, "bot-admin-roles", "", "Comma separated IDs of Discord roles allowed to stop, silence and run testers through the bot")
+	return cfg
+}
+
+// Init adds configured notifiers
+func (cfg *Config) Init() {
+	botAdminRoles = splitList(cfg.BotAdminRoles)
+	if cfg.SlackURL != "" {
+		AddNotifier(NewSlackNotifier(cfg.SlackURL, cfg.SlackUserName, cfg.SlackUsersToNotify))
+	}
+	if cfg.WebhookURL != "" {
+		AddNotifier(NewWebhookNotifier(cfg.WebhookURL, cfg.WebhookAuth))
+	}
+}
+
+func newWebhookQueue(name, url string, header http.Header, retryAfterUnit time.Duration) *webhookQueue {
+	q := &webhookQueue{
+		name:           name,
+		url:            url,
+		header:         header,
+		retryAfterUnit: retryAfterUnit,
+		msgCh:          make(chan []byte, 64),
+	}
+	go q.sendLoop()
+	return q
+}
+
+func (q *webhookQueue) send(msg []byte) {
+	q.msgCh <- msg
+}
+
+func (q *webhookQueue) retryAfter(headers http.Header) (time.Duration, bool) {
+	rafters := headers.Get("Retry-After")
+	if rafters == "" {
+		return 0, false
+	}
+	rafter, _ := strconv.ParseFloat(rafters, 64)
+	return time.Duration(rafter * float64(q.retryAfterUnit)), true
+}
+
+func (q *webhookQueue) sendLoop() {
+	var msgQueue [][]byte
+	var goodAfter time.Time
+	var headers http.Header
+	var status int
+	timer := time.NewTimer(2 * time.Second)
+	var step int
+	for {
+		glog.V(model.INSANE).Infof("====> %s sendLoop step %d queue len %d", q.name, step, len(msgQueue))
+		step++
+		select {
+		case <-timer.C:
+			if len(msgQueue) == 0 {
+				continue
+			}
+			msg := msgQueue[0]
+			status, headers = q.post(msg)
+			if headers == nil || !isSuccess(status) {
+				// error possibly
+				if headers != nil && status == http.StatusTooManyRequests {
+					if rafter, ok := q.retryAfter(headers); ok {
+						timer = time.NewTimer(rafter)
+						continue
+					}
+				}
+				if status == http.StatusBadRequest {
+					msgQueue = msgQueue[1:]
+				}
+				timer = time.NewTimer(2 * time.Second)
+				continue
+			}
+			msgQueue = msgQueue[1:]
+
+		case msg := <-q.msgCh:
+			if len(msgQueue) > 0 || time.Now().Before(goodAfter) {
+				msgQueue = append(msgQueue, msg)
+				if len(msgQueue) > 128 {
+					msgQueue = msgQueue[1:]
+				}
+				continue
+			}
+			status, headers = q.post(msg)
+			if headers == nil || !isSuccess(status) {
+				// error possibly
+				msgQueue = append(msgQueue, msg)
+				if headers != nil && status == http.StatusTooManyRequests {
+					if rafter, ok := q.retryAfter(headers); ok {
+						timer = time.NewTimer(rafter)
+						continue
+					}
+				}
+				timer = time.NewTimer(2 * time.Second)
+				continue
+			}
+		}
+		// only Discord tells about remaining requests
+		rlrem := headers.Get("X-Ratelimit-Remaining")
+		if rlrem == "" {
+			if len(msgQueue) > 0 {
+				timer = time.NewTimer(50 * time.Millisecond)
+			}
+			continue
+		}
+		if rlrem == "0" {
+			rlreset := headers.Get("X-Ratelimit-Reset-After")
+			frlreset, err := strconv.ParseFloat(rlreset, 64)
+			if err != nil {
+				panic(err)
+			}
+			wait := time.Duration(frlreset*1000.0+100.0) * time.Millisecond
+			glog.V(model.VVERBOSE).Infof("Need wait %s", wait)
+			goodAfter = time.Now().Add(wait)
+			timer = time.NewTimer(wait)
+
+		} else if len(msgQueue) > 0 {
+			timer = time.NewTimer(50 * time.Millisecond)
+		}
+	}
+}
+
+func (q *webhookQueue) post(msg []byte) (int, http.Header) {
+	body := bytes.NewReader(msg)
+	req, _ := http.NewRequest(http.MethodPost, q.url, body)
+	req.Header.Add("User-Agent", "stream-tester/"+model.Version)
+	req.Header.Add("Content-Type", "application/json")
+	for k, vs := range q.header {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		glog.Errorf("error posting to %s err=%v", q.name, err)
+		return 0, nil
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	glog.V(model.INSANE).Infof("%s response headers", q.name)
+	for k, v := range resp.Header {
+		glog.V(model.INSANE).Infof("%s: %+v", k, v)
+	}
+	if !isSuccess(resp.StatusCode) {
+		glog.Errorf("status error posting to %s status=%s body: %s", q.name, resp.Status, string(b))
+	}
+	return resp.StatusCode, resp.Header
+}
+
+func isSuccess(status int) bool {
+	return status >= 200 && status < 300
+}
+
+// splitMessage splits message into parts no longer than maxLen
+func splitMessage(msg string, maxLen int) []string {
+	var parts []string
+	for len(msg) > maxLen {
+		parts = append(parts, msg[:maxLen])
+		msg = msg[maxLen:]
+	}
+	return append(parts, msg)
+}
@@ -0,0 +1,119 @@
+package messenger
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Slack truncates longer texts
+const maxSlackMessageLen = 4000
+
+type (
+	slackNotifier struct {
+		q             *webhookQueue
+		userName      string
+		usersToNotify string
+	}
+
+	slackMessage struct {
+		Text        string             `json:"text,omitempty"`
+		UserName    string             `json:"username,omitempty"`
+		Attachments []*slackAttachment `json:"attachments,omitempty"`
+	}
+
+	slackAttachment struct {
+		Color      string       `json:"color,omitempty"`
+		AuthorName string       `json:"author_name,omitempty"`
+		AuthorLink string       `json:"author_link,omitempty"`
+		AuthorIcon string       `json:"author_icon,omitempty"`
+		Title      string       `json:"title,omitempty"`
+		TitleLink  string       `json:"title_link,omitempty"`
+		Text       string       `json:"text,omitempty"`
+		Fields     []slackField `json:"fields,omitempty"`
+		ImageURL   string       `json:"image_url,omitempty"`
+		ThumbURL   string       `json:"thumb_url,omitempty"`
+		Footer     string       `json:"footer,omitempty"`
+		FooterIcon string       `json:"footer_icon,omitempty"`
+		MrkdwnIn   []string     `json:"mrkdwn_in,omitempty"`
+	}
+
+	slackField struct {
+		Title string `json:"title,omitempty"`
+		Value string `json:"value,omitempty"`
+		Short bool   `json:"short,omitempty"`
+	}
+)
+
+// Discord's markdown to Slack's mrkdwn: **bold** and __underline__ become
+// *bold* and _italic_
+var slackReplacer = strings.NewReplacer("**", "*", "__", "_")
+
+// NewSlackNotifier returns notifier sending messages to Slack channel through
+// the incoming webhook. usersToNotify (as <@U012AB3CD>) are mentioned in
+// fatal messages
+func NewSlackNotifier(webhookURL, userName, usersToNotify string) Notifier {
+	return &slackNotifier{
+		q:             newWebhookQueue("Slack", webhookURL, nil, time.Second),
+		userName:      userName,
+		usersToNotify: usersToNotify,
+	}
+}
+
+func (sn *slackNotifier) Name() string {
+	return "Slack"
+}
+
+func (sn *slackNotifier) Send(msg string, fatal bool) {
+	if fatal && sn.usersToNotify != "" {
+		msg = sn.usersToNotify + ": " + msg
+	}
+	for _, part := range splitMessage(slackReplacer.Replace(msg), maxSlackMessageLen) {
+		sn.send(&slackMessage{Text: fmt.Sprintf("`[%s]` %s", hostname, part), UserName: sn.userName})
+	}
+}
+
+func (sn *slackNotifier) SendRich(embeds []*DiscordEmbed, fatal bool) {
+	sm := &slackMessage{UserName: sn.userName}
+	if fatal && sn.usersToNotify != "" {
+		sm.Text = sn.usersToNotify
+	}
+	for _, em := range embeds {
+		sm.Attachments = append(sm.Attachments, slackAttachmentFromEmbed(em))
+	}
+	sn.send(sm)
+}
+
+func (sn *slackNotifier) send(sm *slackMessage) {
+	data, _ := json.Marshal(sm)
+	sn.q.send(data)
+}
+
+func slackAttachmentFromEmbed(em *DiscordEmbed) *slackAttachment {
+	sa := &slackAttachment{
+		Title:     slackReplacer.Replace(em.Title),
+		TitleLink: em.URL,
+		Text:      slackReplacer.Replace(em.Description),
+		MrkdwnIn:  []string{"text", "fields"},
+	}
+	if em.Color != 0 {
+		sa.Color = fmt.Sprintf("#%06x", em.Color)
+	}
+	if em.Author != nil {
+		sa.AuthorName, sa.AuthorLink, sa.AuthorIcon = em.Author.Name, em.Author.URL, em.Author.IconURL
+	}
+	if em.Image != nil {
+		sa.ImageURL = em.Image.URL
+	}
+	if em.Thumbnail != nil {
+		sa.ThumbURL = em.Thumbnail.URL
+	}
+	if em.Footer != nil {
+		sa.Footer, sa.FooterIcon = em.Footer.Text, em.Footer.IconURL
+	}
+	for _, f := range em.Fields {
+		sa.Fields = append(sa.Fields, slackField{Title: slackReplacer.Replace(f.Name), Value: slackReplacer.Replace(f.Value), Short: f.Inline})
+	}
+	return sa
+}
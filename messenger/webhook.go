@@ -0,0 +1,100 @@
+package messenger
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/livepeer/stream-tester/model"
+)
+
+type (
+	webhookNotifier struct {
+		q *webhookQueue
+	}
+
+	// WebhookMessage is the JSON posted by the generic webhook notifier
+	WebhookMessage struct {
+		App      string          `json:"app"`
+		Host     string          `json:"host"`
+		Severity string          `json:"severity"` // info or fatal
+		Text     string          `json:"text,omitempty"`
+		Embeds   []*WebhookEmbed `json:"embeds,omitempty"`
+		Time     time.Time       `json:"time"`
+	}
+
+	// WebhookEmbed rich message posted to the generic webhook
+	WebhookEmbed struct {
+		Title       string         `json:"title,omitempty"`
+		Description string         `json:"description,omitempty"`
+		URL         string         `json:"url,omitempty"`
+		Color       string         `json:"color,omitempty"` // #rrggbb
+		ImageURL    string         `json:"image_url,omitempty"`
+		Fields      []WebhookField `json:"fields,omitempty"`
+	}
+
+	// WebhookField field of the rich message
+	WebhookField struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+)
+
+// Severities of the webhook messages
+const (
+	SeverityInfo  = "info"
+	SeverityFatal = "fatal"
+)
+
+// NewWebhookNotifier returns notifier posting messages as JSON
+// (WebhookMessage) to the URL. If authorization is not empty it is sent as
+// Authorization header
+func NewWebhookNotifier(url, authorization string) Notifier {
+	header := http.Header{}
+	if authorization != "" {
+		header.Add("Authorization", authorization)
+	}
+	return &webhookNotifier{q: newWebhookQueue("webhook", url, header, time.Second)}
+}
+
+func (wn *webhookNotifier) Name() string {
+	return "webhook"
+}
+
+func (wn *webhookNotifier) Send(msg string, fatal bool) {
+	wm := newWebhookMessage(fatal)
+	wm.Text = msg
+	wn.send(wm)
+}
+
+func (wn *webhookNotifier) SendRich(embeds []*DiscordEmbed, fatal bool) {
+	wm := newWebhookMessage(fatal)
+	for _, em := range embeds {
+		we := &WebhookEmbed{Title: em.Title, Description: em.Description, URL: em.URL}
+		if em.Color != 0 {
+			we.Color = fmt.Sprintf("#%06x", em.Color)
+		}
+		if em.Image != nil {
+			we.ImageURL = em.Image.URL
+		}
+		for _, f := range em.Fields {
+			we.Fields = append(we.Fields, WebhookField{Name: f.Name, Value: f.Value})
+		}
+		wm.Embeds = append(wm.Embeds, we)
+	}
+	wn.send(wm)
+}
+
+func (wn *webhookNotifier) send(wm *WebhookMessage) {
+	data, _ := json.Marshal(wm)
+	wn.q.send(data)
+}
+
+func newWebhookMessage(fatal bool) *WebhookMessage {
+	wm := &WebhookMessage{App: model.AppName, Host: hostname, Severity: SeverityInfo, Time: time.Now()}
+	if fatal {
+		wm.Severity = SeverityFatal
+	}
+	return wm
+}
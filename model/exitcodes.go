@@ -0,0 +1,44 @@
+package model
+
+// Exit codes of the testers. Values are part of the interface (CI jobs and
+// alerts check them), so existing codes should never be changed.
+const (
+	// ExitCodeOK test passed
+	ExitCodeOK = 0
+	// ExitCodeRTMPError error streaming RTMP to the ingest
+	ExitCodeRTMPError = 2
+	// ExitCodeStreamingFailed streaming (or downloading back) failed
+	ExitCodeStreamingFailed = 3
+	// ExitCodeAssertionsFailed test finished, but one of the assertions failed
+	ExitCodeAssertionsFailed = 10
+	// ExitCodeTesterOverloaded tester itself was the bottleneck, so failures can't be blamed on the service
+	ExitCodeTesterOverloaded = 11
+	// ExitCodeStatsError error getting stats of the streaming
+	ExitCodeStatsError = 21
+	// ExitCodeRenditionsMismatch number of the renditions in the recording is wrong
+	ExitCodeRenditionsMismatch = 35
+	// ExitCodeVODCheckFailed downloaded recording is not ok (segments or durations mismatch)
+	ExitCodeVODCheckFailed = 36
+	// ExitCodeFailure general failure of the test (success rate is not 100%, gaps, ...)
+	ExitCodeFailure = 127
+	// ExitCodeMP4Invalid MP4 recording can't be parsed or downloaded
+	ExitCodeMP4Invalid = 203
+	// ExitCodeMP4Duration duration of the MP4 recording is wrong
+	ExitCodeMP4Duration = 204
+	// ExitCodeRecordingNotReady recording session is not in the expected state after streaming
+	ExitCodeRecordingNotReady = 240
+	// ExitCodeNoRecordingURL recording URL did not appear
+	ExitCodeNoRecordingURL = 249
+	// ExitCodeRecordingStatus recording session is not in the waiting state right after streaming
+	ExitCodeRecordingStatus = 250
+	// ExitCodeSessionsMismatch wrong number of sessions or profiles
+	ExitCodeSessionsMismatch = 251
+	// ExitCodeGetSessions error getting sessions from the Livepeer API
+	ExitCodeGetSessions = 252
+	// ExitCodeCreateStream error creating stream using the Livepeer API
+	ExitCodeCreateStream = 253
+	// ExitCodeNoIngest empty list of ingests or broadcasters
+	ExitCodeNoIngest = 254
+	// ExitCodeAPIError error calling the API or starting the test
+	ExitCodeAPIError = 255
+)
@@ -0,0 +1,358 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/bits"
+	"time"
+)
+
+const (
+	// histogramSubBits number of bits of the value kept exactly, defines
+	// relative error of the histogram (1/64, reported values are middles of
+	// the buckets so actual error is under 1%)
+	histogramSubBits = 6
+	histogramSub     = 1 << histogramSubBits
+	// histogramUnit values are recorded with microsecond resolution
+	histogramUnit = time.Microsecond
+)
+
+type (
+	// Histogram is high dynamic range histogram of durations. It takes fixed
+	// amount of memory regardless of the number of recorded values, keeps
+	// exact count, sum, min and max, and calculates percentiles with relative
+	// error under 1%. Histograms of different streams (and agents) can be
+	// merged to get percentiles of the whole run.
+	// Zero value is empty histogram ready to use. Histogram is not safe for
+	// concurrent use
+	Histogram struct {
+		counts []int64
+		count  int64
+		sum    time.Duration
+		min    time.Duration
+		max    time.Duration
+	}
+
+	// histogramJSON is compact representation of the histogram: only non-empty
+	// buckets are serialized, as [index, count] pairs
+	histogramJSON struct {
+		SubBucketBits int           `json:"sub_bucket_bits"`
+		Unit          time.Duration `json:"unit"`
+		Count         int64         `json:"count"`
+		Sum           time.Duration `json:"sum"`
+		Min           time.Duration `json:"min"`
+		Max           time.Duration `json:"max"`
+		Buckets       [][2]int64    `json:"buckets"`
+	}
+)
+
+// NewHistogram returns histogram with the durations recorded
+func NewHistogram(ds ...time.Duration) *Histogram {
+	h := &Histogram{}
+	for _, d := range ds {
+		h.Record(d)
+	}
+	return h
+}
+
+// Record adds duration to the histogram. Negative durations are recorded as 0
+func (h *Histogram) Record(d time.Duration) {
+	h.RecordN(d, 1)
+}
+
+// RecordN adds duration to the histogram n times
+func (h *Histogram) RecordN(d time.Duration, n int64) {
+	if n <= 0 {
+		return
+	}
+	if d < 0 {
+		d = 0
+	}
+	if h.count == 0 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	h.count += n
+	h.sum += d * time.Duration(n)
+	i := histogramIndex(uint64(d / histogramUnit))
+	h.grow(i)
+	h.counts[i] += n
+}
+
+// Merge adds all the values recorded by another histogram
+func (h *Histogram) Merge(o *Histogram) {
+	if o == nil || o.count == 0 {
+		return
+	}
+	if h.count == 0 || o.min < h.min {
+		h.min = o.min
+	}
+	if o.max > h.max {
+		h.max = o.max
+	}
+	h.count += o.count
+	h.sum += o.sum
+	h.grow(len(o.counts) - 1)
+	for i, c := range o.counts {
+		h.counts[i] += c
+	}
+}
+
+// Clone returns copy of the histogram
+func (h *Histogram) Clone() *Histogram {
+	if h == nil {
+		return nil
+	}
+	c := *h
+	c.counts = append([]int64(nil), h.counts...)
+	return &c
+}
+
+// Diff returns histogram of the values recorded after prev was cloned from
+// this histogram. Count and sum are exact, min and max are precise to the
+// bucket
+func (h *Histogram) Diff(prev *Histogram) *Histogram {
+	d := &Histogram{}
+	if h.Count() == 0 {
+		return d
+	}
+	if prev.Count() == 0 {
+		return h.Clone()
+	}
+	for i, c := range h.counts {
+		if i < len(prev.counts) {
+			c -= prev.counts[i]
+		}
+		if c > 0 {
+			d.RecordN(h.clamp(histogramValue(i)), c)
+		}
+	}
+	if d.count > 0 {
+		d.sum = h.sum - prev.sum
+	}
+	return d
+}
+
+// Reset removes all the recorded values
+func (h *Histogram) Reset() {
+	for i := range h.counts {
+		h.counts[i] = 0
+	}
+	*h = Histogram{counts: h.counts[:0]}
+}
+
+// Count returns number of recorded values
+func (h *Histogram) Count() int64 {
+	if h == nil {
+		return 0
+	}
+	return h.count
+}
+
+// Min returns smallest recorded value
+func (h *Histogram) Min() time.Duration {
+	if h == nil {
+		return 0
+	}
+	return h.min
+}
+
+// Max returns largest recorded value
+func (h *Histogram) Max() time.Duration {
+	if h == nil {
+		return 0
+	}
+	return h.max
+}
+
+// Mean returns average of the recorded values
+func (h *Histogram) Mean() time.Duration {
+	if h.Count() == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.count)
+}
+
+// Percentile returns value below which falls given percentage (0..100) of
+// the recorded values
+func (h *Histogram) Percentile(p float64) time.Duration {
+	if h.Count() == 0 {
+		return 0
+	}
+	if p <= 0 {
+		return h.min
+	}
+	if p >= 100 {
+		return h.max
+	}
+	rank := int64(math.Ceil(p / 100 * float64(h.count)))
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			return h.clamp(histogramValue(i))
+		}
+	}
+	return h.max
+}
+
+// Percentiles returns values of the given percentiles
+func (h *Histogram) Percentiles(ps ...float64) []time.Duration {
+	res := make([]time.Duration, len(ps))
+	for i, p := range ps {
+		res[i] = h.Percentile(p)
+	}
+	return res
+}
+
+// Latencies returns average, 50th, 95th and 99th percentiles
+func (h *Histogram) Latencies() Latencies {
+	return Latencies{
+		Avg: h.Mean(),
+		P50: h.Percentile(50),
+		P95: h.Percentile(95),
+		P99: h.Percentile(99),
+	}
+}
+
+// Buckets calls fn for every non-empty bucket of the histogram in order of
+// values. Value is the middle of the bucket
+func (h *Histogram) Buckets(fn func(value time.Duration, count int64)) {
+	if h == nil {
+		return
+	}
+	for i, c := range h.counts {
+		if c > 0 {
+			fn(h.clamp(histogramValue(i)), c)
+		}
+	}
+}
+
+// Values returns recorded values, precise to the bucket, in ascending order.
+// If more than max values were recorded, evenly spaced subset of max values
+// is returned (max <= 0 means no limit)
+func (h *Histogram) Values(max int) []time.Duration {
+	n := h.Count()
+	if n == 0 {
+		return nil
+	}
+	size := n
+	if max > 0 && size > int64(max) {
+		size = int64(max)
+	}
+	res := make([]time.Duration, 0, size)
+	rank := func(j int) int64 {
+		return int64(float64(j) * float64(n) / float64(size))
+	}
+	var seen int64
+	for i, c := range h.counts {
+		if c == 0 {
+			continue
+		}
+		seen += c
+		v := h.clamp(histogramValue(i))
+		for int64(len(res)) < size && rank(len(res)) < seen {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+func (h *Histogram) String() string {
+	if h.Count() == 0 {
+		return "{Count: 0}"
+	}
+	return fmt.Sprintf("{Count: %d, Min: %s, Average: %s, P50: %s, P95: %s, P99: %s, Max: %s}", h.count, h.min, h.Mean(),
+		h.Percentile(50), h.Percentile(95), h.Percentile(99), h.max)
+}
+
+// MarshalJSON implements json.Marshaler
+func (h *Histogram) MarshalJSON() ([]byte, error) {
+	hj := histogramJSON{
+		SubBucketBits: histogramSubBits,
+		Unit:          histogramUnit,
+		Count:         h.count,
+		Sum:           h.sum,
+		Min:           h.min,
+		Max:           h.max,
+		Buckets:       [][2]int64{},
+	}
+	for i, c := range h.counts {
+		if c > 0 {
+			hj.Buckets = append(hj.Buckets, [2]int64{int64(i), c})
+		}
+	}
+	return json.Marshal(&hj)
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (h *Histogram) UnmarshalJSON(data []byte) error {
+	var hj histogramJSON
+	if err := json.Unmarshal(data, &hj); err != nil {
+		return err
+	}
+	if hj.SubBucketBits != histogramSubBits || hj.Unit != histogramUnit {
+		return fmt.Errorf("unsupported histogram layout sub_bucket_bits=%d unit=%s", hj.SubBucketBits, hj.Unit)
+	}
+	*h = Histogram{count: hj.Count, sum: hj.Sum, min: hj.Min, max: hj.Max}
+	for _, b := range hj.Buckets {
+		i := int(b[0])
+		if i < 0 || i >= histogramIndex(math.MaxUint64)+1 {
+			return fmt.Errorf("invalid histogram bucket index %d", i)
+		}
+		h.grow(i)
+		h.counts[i] += b[1]
+	}
+	return nil
+}
+
+func (h *Histogram) grow(i int) {
+	if i < len(h.counts) {
+		return
+	}
+	if i < cap(h.counts) {
+		h.counts = h.counts[:i+1]
+		return
+	}
+	counts := make([]int64, i+1, i+1+histogramSub)
+	copy(counts, h.counts)
+	h.counts = counts
+}
+
+// clamp keeps value reported for the bucket within the recorded range
+func (h *Histogram) clamp(d time.Duration) time.Duration {
+	if d < h.min {
+		return h.min
+	}
+	if d > h.max {
+		return h.max
+	}
+	return d
+}
+
+// histogramIndex returns index of the bucket of the value. Values below
+// 2*histogramSub have their own buckets, above that every power of two range
+// is split into histogramSub buckets
+func histogramIndex(v uint64) int {
+	if v < 2*histogramSub {
+		return int(v)
+	}
+	shift := bits.Len64(v) - histogramSubBits - 1
+	return shift*histogramSub + int(v>>uint(shift))
+}
+
+// histogramValue returns middle of the bucket
+func histogramValue(i int) time.Duration {
+	if i < 2*histogramSub {
+		return time.Duration(i) * histogramUnit
+	}
+	shift := i/histogramSub - 1
+	low := uint64(i-shift*histogramSub) << uint(shift)
+	return time.Duration(low+(uint64(1)<<uint(shift))/2) * histogramUnit
+}
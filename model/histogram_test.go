@@ -0,0 +1,88 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestHistogramValues(t *testing.T) {
+	h := &Histogram{}
+	if vs := h.Values(10); vs != nil {
+		t.Errorf("empty histogram returned %v", vs)
+	}
+	for i := 1; i <= 1000; i++ {
+		h.Record(time.Duration(i) * time.Millisecond)
+	}
+	all := h.Values(0)
+	if len(all) != 1000 {
+		t.Fatalf("got %d values instead of 1000", len(all))
+	}
+	if all[0] < time.Millisecond || all[0] > 1010*time.Microsecond || all[999] != time.Second {
+		t.Errorf("values range is %s..%s, want 1ms..1s", all[0], all[999])
+	}
+	sample := h.Values(100)
+	if len(sample) != 100 {
+		t.Fatalf("got %d values instead of 100", len(sample))
+	}
+	for i, v := range sample {
+		if i > 0 && v < sample[i-1] {
+			t.Fatalf("values are not sorted: %v", sample)
+		}
+		// every 10th value, within bucket precision
+		want := time.Duration(i*10+1) * time.Millisecond
+		if v < want*98/100 || v > want*102/100 {
+			t.Errorf("value %d is %s, want %s", i, v, want)
+		}
+	}
+}
+
+func TestHistogramDiff(t *testing.T) {
+	h := NewHistogram(time.Second, 2*time.Second)
+	prev := h.Clone()
+	h.Record(3 * time.Second)
+	h.Record(2 * time.Second)
+	d := h.Diff(prev)
+	if d.Count() != 2 || d.Mean() != 2500*time.Millisecond {
+		t.Errorf("diff is %s, want 2s and 3s", d)
+	}
+	if d.Min() < 1980*time.Millisecond || d.Max() > 3*time.Second {
+		t.Errorf("diff range is %s..%s, want 2s..3s", d.Min(), d.Max())
+	}
+	if d := h.Diff(nil); d.Count() != 4 {
+		t.Errorf("diff with nil has %d values instead of 4", d.Count())
+	}
+	if d := h.Diff(h); d.Count() != 0 {
+		t.Errorf("diff with itself has %d values", d.Count())
+	}
+}
+
+func TestHistogramJSON(t *testing.T) {
+	h := NewHistogram(79210696, 87020114)
+	b, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2 := &Histogram{}
+	if err := json.Unmarshal(b, h2); err != nil {
+		t.Fatal(err)
+	}
+	if h2.String() != h.String() {
+		t.Errorf("got %s after round trip, want %s", h2, h)
+	}
+}
+
+func TestStatsRawLatenciesJSON(t *testing.T) {
+	st := &Stats{RawSourceLatencies: []time.Duration{time.Second}}
+	b, err := json.Marshal(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	json.Unmarshal(b, &m)
+	for _, key := range []string{"raw_source_latencies", "raw_transcoded_latencies"} {
+		if _, has := m[key]; !has {
+			t.Errorf("stats do not have %q field", key)
+		}
+	}
+}
@@ -70,9 +70,20 @@ type IVODTester interface {
 type ILoadTester interface {
 	IFinite
 	Start(sourceFileName string, waitForTarget, oneStreamTime, overallTestTime time.Duration, sim int) error
+	// StartProfile runs test following number of concurrent streams requested by profile
+	StartProfile(sourceFileName string, waitForTarget, oneStreamTime time.Duration, profile LoadProfile) error
 	Stats() (StatsMany, error)
 }
 
+// LoadProfile describes how number of concurrent streams changes over time
+type LoadProfile interface {
+	// Target returns number of streams that should be active after
+	// elapsed time since the start of the test
+	Target(elapsed time.Duration) int
+	// Duration returns overall duration of the test
+	Duration() time.Duration
+}
+
 // Streamer2 interface for
 type Streamer2 interface {
 	IFinite
@@ -192,23 +203,121 @@ type StatsMany struct {
 	// SuccessRate average success rate
 	SuccessRate float64 `json:"success_rate,omitempty"` // 0..1
 	Finished    bool    `json:"finished,omitempty"`
+	// Latencies of all the segments of all active streams (averaged over
+	// streams if streams do not report histograms)
+	SourceLatencies     Latencies `json:"source_latencies"`
+	TranscodedLatencies Latencies `json:"transcoded_latencies"`
+	// Latency histograms merged over all active streams
+	SourceLatencyHistogram     *Histogram `json:"source_latency_histogram,omitempty"`
+	TranscodedLatencyHistogram *Histogram `json:"transcoded_latency_histogram,omitempty"`
+	// Concurrency requested and achieved number of concurrent streams over time
+	Concurrency []ConcurrencySample `json:"concurrency,omitempty"`
+	// Chaos results of the chaos controller, if it was enabled
+	Chaos *ChaosStats `json:"chaos,omitempty"`
+	// TesterLoad resources used by the tester itself
+	TesterLoad *TesterLoad `json:"tester_load,omitempty"`
+}
+
+// TesterLoad resources used by the tester itself. If tester was overloaded,
+// results of the run measure the tester rather than the service under test
+type TesterLoad struct {
+	// MaxCPU, AvgCPU CPU usage, percent of all the cores
+	MaxCPU        float64 `json:"max_cpu"`
+	AvgCPU        float64 `json:"avg_cpu"`
+	MaxGoroutines int     `json:"max_goroutines"`
+	// MaxSendLag how far sending of media fell behind real time
+	MaxSendLag time.Duration `json:"max_send_lag"`
+	// MaxDownloadQueueLag how long segments waited for download worker
+	MaxDownloadQueueLag time.Duration `json:"max_download_queue_lag"`
+	MaxTxMbps           float64       `json:"max_tx_mbps"`
+	MaxRxMbps           float64       `json:"max_rx_mbps"`
+	// Overloaded tester was the bottleneck, results of the run are not valid
+	Overloaded bool     `json:"overloaded"`
+	Reasons    []string `json:"reasons,omitempty"`
+}
+
+// ChaosStats results of the chaos controller of the load test
+type ChaosStats struct {
+	// Disruptions number of disruptions by action
+	Disruptions map[string]int `json:"disruptions,omitempty"`
+	// BaselineSuccessRate average success rate right before the first disruption
+	BaselineSuccessRate float64 `json:"baseline_success_rate"`
+	// UntouchedSuccessRate average success rate of active streams never disrupted
+	UntouchedSuccessRate float64 `json:"untouched_success_rate"`
+	UntouchedStreams     int     `json:"untouched_streams"`
+	// Recovered paused or restarted streams that delivered new segments in time
+	Recovered    int `json:"recovered"`
+	NotRecovered int `json:"not_recovered"`
+	// Pending disrupted streams still waiting to recover
+	Pending int `json:"pending,omitempty"`
+}
+
+// ConcurrencySample number of concurrent streams at the moment of the test
+type ConcurrencySample struct {
+	At        time.Duration `json:"at"` // since start of the test
+	Requested int           `json:"requested"`
+	Achieved  int           `json:"achieved"`
 }
 
 // FormatForConsole ...
 func (sm *StatsMany) FormatForConsole() string {
 	msg := fmt.Sprintf("Stats: number of active streams %d Success rate %v Finished %v", sm.ActiveStreams, sm.SuccessRate, sm.Finished)
+	if len(sm.Concurrency) > 0 {
+		msg += "\nConcurrency (time: achieved/requested):"
+		for _, cs := range sm.Concurrency {
+			msg += fmt.Sprintf("\n  %10s: %4d/%d", cs.At.Round(time.Second), cs.Achieved, cs.Requested)
+		}
+	}
+	if sm.Chaos != nil {
+		msg += "\n" + sm.Chaos.FormatForConsole()
+	}
+	if sm.TesterLoad != nil {
+		msg += "\n" + sm.TesterLoad.FormatForConsole()
+	}
+	return msg
+}
+
+// FormatForConsole ...
+func (tl *TesterLoad) FormatForConsole() string {
+	msg := fmt.Sprintf("Tester load: CPU max %.0f%% avg %.0f%% goroutines max %d send lag max %s download queue lag max %s tx max %.1f Mbps rx max %.1f Mbps",
+		tl.MaxCPU, tl.AvgCPU, tl.MaxGoroutines, tl.MaxSendLag.Round(time.Millisecond), tl.MaxDownloadQueueLag.Round(time.Millisecond), tl.MaxTxMbps, tl.MaxRxMbps)
+	if tl.Overloaded {
+		msg += "\nTESTER OVERLOADED, results are not valid: " + strings.Join(tl.Reasons, "; ")
+	}
+	return msg
+}
+
+// FormatForConsole ...
+func (cs *ChaosStats) FormatForConsole() string {
+	var actions []string
+	for action := range cs.Disruptions {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+	msg := "Chaos: disruptions"
+	for _, action := range actions {
+		msg += fmt.Sprintf(" %s=%d", action, cs.Disruptions[action])
+	}
+	msg += fmt.Sprintf(" recovered %d not recovered %d pending %d", cs.Recovered, cs.NotRecovered, cs.Pending)
+	msg += fmt.Sprintf("\nChaos: success rate of %d untouched streams %v (baseline %v)", cs.UntouchedStreams, cs.UntouchedSuccessRate,
+		cs.BaselineSuccessRate)
 	return msg
 }
 
 // Stats1 stats for one stream
 type Stats1 struct {
-	SuccessRate         float64   `json:"success_rate"` // 0..1
-	SourceLatencies     Latencies `json:"source_latencies"`
-	TranscodedLatencies Latencies `json:"transcoded_latencies"`
-	Started             bool      `json:"started"`
-	Finished            bool      `json:"finished"`
+	SuccessRate                float64    `json:"success_rate"` // 0..1
+	SourceLatencies            Latencies  `json:"source_latencies"`
+	TranscodedLatencies        Latencies  `json:"transcoded_latencies"`
+	SourceLatencyHistogram     *Histogram `json:"source_latency_histogram,omitempty"`
+	TranscodedLatencyHistogram *Histogram `json:"transcoded_latency_histogram,omitempty"`
+	Started                    bool       `json:"started"`
+	Finished                   bool       `json:"finished"`
 }
 
+// RawLatenciesMax maximum number of values in the raw latencies of the stats
+const RawLatenciesMax = 10000
+
 // Stats represents global test statistics
 type Stats struct {
 	RTMPActiveStreams              int               `json:"rtmp_active_streams"` // number of active RTMP streams
@@ -232,12 +341,16 @@ type Stats struct {
 	ProfilesNum                    int               `json:"profiles_num"`
 	SourceLatencies                Latencies         `json:"source_latencies"`
 	TranscodedLatencies            Latencies         `json:"transcoded_latencies"`
-	RawSourceLatencies             []time.Duration   `json:"raw_source_latencies"`
-	RawTranscodedLatencies         []time.Duration   `json:"raw_transcoded_latencies"`
+	SourceLatencyHistogram         *Histogram        `json:"source_latency_histogram,omitempty"`
+	TranscodedLatencyHistogram     *Histogram        `json:"transcoded_latency_histogram,omitempty"`
+	RawSourceLatencies             []time.Duration   `json:"raw_source_latencies"`     // filled from histogram, at most RawLatenciesMax values
+	RawTranscodedLatencies         []time.Duration   `json:"raw_transcoded_latencies"` // filled from histogram, at most RawLatenciesMax values
 	RawTranscodeLatenciesPerStream [][]time.Duration `json:"raw_transcode_latencies_per_stream"`
+	RawTranscodeLatenciesNames     []string          `json:"raw_transcode_latencies_names,omitempty"` // stream and rendition of each of RawTranscodeLatenciesPerStream
 	WowzaMode                      bool              `json:"wowza_mode"`
 	StartTime                      time.Time         `json:"start_time"`
 	Errors                         map[string]int    `json:"errors"`
+	TesterLoad                     *TesterLoad       `json:"tester_load,omitempty"`
 }
 
 // REST requests
@@ -292,6 +405,9 @@ Success rate 2:                                   %9.5f%%
 Bytes dowloaded:                         %12d`, st.RTMPstreams, st.MediaStreams, time.Now().Sub(st.StartTime), st.TotalSegmentsToSend, st.SentSegments, st.DownloadedSegments,
 		st.ShouldHaveDownloadedSegments, st.Retries, st.SuccessRate, st.ConnectionLost, st.SourceLatencies.String(), st.TranscodedLatencies.String(),
 		st.SentKeyFrames, st.DownloadedKeyFrames, st.DownloadedSourceSegments, st.DownloadedTranscodedSegments, st.SuccessRate2, st.BytesDownloaded)
+	if st.TesterLoad != nil {
+		r += "\n" + st.TesterLoad.FormatForConsole()
+	}
 	if len(st.Errors) > 0 {
 		r += "\n"
 	}
@@ -315,6 +431,26 @@ func (st *Stats) FormatErrorsForConsole() string {
 	return "Errors:\n" + strings.Join(r, "\n")
 }
 
+// AverageLatencies averages latencies of number of streams
+func AverageLatencies(ls ...Latencies) Latencies {
+	var avg Latencies
+	if len(ls) == 0 {
+		return avg
+	}
+	for _, l := range ls {
+		avg.Avg += l.Avg
+		avg.P50 += l.P50
+		avg.P95 += l.P95
+		avg.P99 += l.P99
+	}
+	n := time.Duration(len(ls))
+	avg.Avg /= n
+	avg.P50 /= n
+	avg.P95 /= n
+	avg.P99 /= n
+	return avg
+}
+
 func (ls *Latencies) String() string {
 	r := fmt.Sprintf(`{Average: %s, P50: %s, P95: %s, P99: %s}`, ls.Avg, ls.P50, ls.P95, ls.P99)
 	return r